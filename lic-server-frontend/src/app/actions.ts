@@ -47,13 +47,16 @@ export async function createLicense(formData: FormData) {
 export async function createToken(formData: FormData) {
 	const inn = formData.get('inn')
 	const ttl = parseInt(formData.get('ttl') as string, 10)
+	const maxUses = parseInt(formData.get('maxUses') as string, 10)
 
-	await fetchAPI('/tokens', {
+	// The plaintext token is only returned here, the server keeps its hash
+	const res = await fetchAPI('/tokens', {
 		method: 'POST',
-		body: JSON.stringify({ inn, ttl_hours: ttl })
+		body: JSON.stringify({ inn, ttl_hours: ttl, max_uses: maxUses })
 	})
 
 	revalidatePath('/')
+	return res.token as string
 }
 
 export async function updateLicenseStatus(inn: string, status: string) {
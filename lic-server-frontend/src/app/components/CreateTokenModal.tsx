@@ -7,13 +7,13 @@ import { createToken } from '../actions'
 export function CreateTokenModal() {
 	const [isOpen, setIsOpen] = useState(false)
 	const [isSubmitting, setIsSubmitting] = useState(false)
+	const [createdToken, setCreatedToken] = useState<string | null>(null)
 
 	const handleSubmit = async (e: React.FormEvent<HTMLFormElement>) => {
 		e.preventDefault()
 		setIsSubmitting(true)
 		try {
-			await createToken(new FormData(e.currentTarget))
-			setIsOpen(false)
+			setCreatedToken(await createToken(new FormData(e.currentTarget)))
 		} catch (err) {
 			alert('Failed to create token')
 			console.error(err)
@@ -37,49 +37,85 @@ export function CreateTokenModal() {
 						<h3 className='mb-4 text-lg font-medium'>
 							Create Enrollment Token
 						</h3>
-						<form onSubmit={handleSubmit} className='space-y-4'>
-							<div>
-								<label className='block text-sm font-medium text-gray-700'>
-									INN
-								</label>
-								<input
-									required
-									name='inn'
-									type='text'
-									className='mt-1 block w-full rounded-md border border-gray-300 p-2 shadow-sm focus:border-blue-500 focus:ring-blue-500 sm:text-sm'
-								/>
+						{createdToken ? (
+							<div className='space-y-4'>
+								<p className='text-sm text-gray-700'>
+									Copy the token now, it will not be shown again.
+								</p>
+								<p className='break-all rounded-md bg-gray-100 p-2 font-mono text-sm'>
+									{createdToken}
+								</p>
+								<div className='mt-6 flex justify-end'>
+									<button
+										type='button'
+										onClick={() => {
+											setCreatedToken(null)
+											setIsOpen(false)
+										}}
+										className='rounded-md border border-transparent bg-blue-600 px-4 py-2 text-sm font-medium text-white shadow-sm hover:bg-blue-700'
+									>
+										Done
+									</button>
+								</div>
 							</div>
-							<div>
-								<label className='block text-sm font-medium text-gray-700'>
-									TTL (Hours)
-								</label>
-								<input
-									required
-									name='ttl'
-									type='number'
-									min='1'
-									defaultValue='24'
-									className='mt-1 block w-full rounded-md border border-gray-300 p-2 shadow-sm focus:border-blue-500 focus:ring-blue-500 sm:text-sm'
-								/>
-							</div>
-
-							<div className='mt-6 flex justify-end space-x-3'>
-								<button
-									type='button'
-									onClick={() => setIsOpen(false)}
-									className='rounded-md border border-gray-300 px-4 py-2 text-sm font-medium text-gray-700 hover:bg-gray-50'
-								>
-									Cancel
-								</button>
-								<button
-									type='submit'
-									disabled={isSubmitting}
-									className='rounded-md border border-transparent bg-blue-600 px-4 py-2 text-sm font-medium text-white shadow-sm hover:bg-blue-700 disabled:opacity-50'
-								>
-									{isSubmitting ? 'Creating...' : 'Create'}
-								</button>
-							</div>
-						</form>
+						) : (
+							<form onSubmit={handleSubmit} className='space-y-4'>
+								<div>
+									<label className='block text-sm font-medium text-gray-700'>
+										INN
+									</label>
+									<input
+										required
+										name='inn'
+										type='text'
+										className='mt-1 block w-full rounded-md border border-gray-300 p-2 shadow-sm focus:border-blue-500 focus:ring-blue-500 sm:text-sm'
+									/>
+								</div>
+								<div>
+									<label className='block text-sm font-medium text-gray-700'>
+										TTL (Hours)
+									</label>
+									<input
+										required
+										name='ttl'
+										type='number'
+										min='1'
+										defaultValue='24'
+										className='mt-1 block w-full rounded-md border border-gray-300 p-2 shadow-sm focus:border-blue-500 focus:ring-blue-500 sm:text-sm'
+									/>
+								</div>
+								<div>
+									<label className='block text-sm font-medium text-gray-700'>
+										Max Uses
+									</label>
+									<input
+										required
+										name='maxUses'
+										type='number'
+										min='1'
+										defaultValue='1'
+										className='mt-1 block w-full rounded-md border border-gray-300 p-2 shadow-sm focus:border-blue-500 focus:ring-blue-500 sm:text-sm'
+									/>
+								</div>
+	
+								<div className='mt-6 flex justify-end space-x-3'>
+									<button
+										type='button'
+										onClick={() => setIsOpen(false)}
+										className='rounded-md border border-gray-300 px-4 py-2 text-sm font-medium text-gray-700 hover:bg-gray-50'
+									>
+										Cancel
+									</button>
+									<button
+										type='submit'
+										disabled={isSubmitting}
+										className='rounded-md border border-transparent bg-blue-600 px-4 py-2 text-sm font-medium text-white shadow-sm hover:bg-blue-700 disabled:opacity-50'
+									>
+										{isSubmitting ? 'Creating...' : 'Create'}
+									</button>
+								</div>
+							</form>
+						)}
 					</div>
 				</div>
 			)}
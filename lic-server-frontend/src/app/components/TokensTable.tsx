@@ -16,6 +16,9 @@ export function TokensTable({ tokens }: { tokens: Record<string, any>[] }) {
 						<th className='px-6 py-3 text-left text-xs font-medium uppercase tracking-wider text-gray-500'>
 							INN
 						</th>
+						<th className='px-6 py-3 text-left text-xs font-medium uppercase tracking-wider text-gray-500'>
+							Uses
+						</th>
 						<th className='px-6 py-3 text-left text-xs font-medium uppercase tracking-wider text-gray-500'>
 							Status
 						</th>
@@ -27,17 +30,16 @@ export function TokensTable({ tokens }: { tokens: Record<string, any>[] }) {
 				<tbody className='divide-y divide-gray-200 bg-white'>
 					{tokens.map((token, index) => {
 						const expiresAt = token.ExpiresAt || token.expires_at
-						const usedAt = token.UsedAt || token.used_at
+						const useCount = token.UseCount ?? 0
+						const maxUses = token.MaxUses ?? 0
 						const isExpired = expiresAt
 							? new Date(expiresAt) < new Date()
 							: false
-						const isUsed =
-							usedAt !== null &&
-							usedAt !== undefined &&
-							usedAt !== '0001-01-01T00:00:00Z'
+						const isUsed = maxUses > 0 && useCount >= maxUses
 
 						let status = 'Active'
-						if (isUsed) status = 'Used'
+						if (token.RevokedAt) status = 'Revoked'
+						else if (isUsed) status = 'Used'
 						else if (isExpired) status = 'Expired'
 
 						// Fix for hydration mismatch: render dates consistently or avoid client-side locale strings in SSR
@@ -46,12 +48,15 @@ export function TokensTable({ tokens }: { tokens: Record<string, any>[] }) {
 							: 'N/A'
 
 						return (
-							<tr key={token.ID || token.token || `tok-${index}`}>
+							<tr key={token.ID || `tok-${index}`}>
 								<td className='whitespace-nowrap px-6 py-4 font-mono text-sm text-gray-500'>
-									{token.Token || token.token}
+									{token.TokenPrefix ? `${token.TokenPrefix}…` : '—'}
 								</td>
 								<td className='whitespace-nowrap px-6 py-4 text-sm text-gray-900'>
-									{token.INN || token.inn}
+									{token.INN || 'Any (static)'}
+								</td>
+								<td className='whitespace-nowrap px-6 py-4 text-sm text-gray-500'>
+									{useCount} / {maxUses > 0 ? maxUses : '∞'}
 								</td>
 								<td className='whitespace-nowrap px-6 py-4 text-sm'>
 									<span
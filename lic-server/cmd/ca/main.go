@@ -0,0 +1,160 @@
+// Command ca manages the CA hierarchy of lic-server on an offline machine: the root CA,
+// whose certificate is embedded in licd, signs intermediates that lic-server issues from.
+//
+//	ca root -cert ca.crt -key ca.key
+//	ca csr  -key intermediate.key -out intermediate.csr           (on the license server)
+//	ca sign -cert ca.crt -key ca.key -csr intermediate.csr -out intermediate.crt
+//
+// Keys in a PKCS#11 token are used with -signer pkcs11 and the -pkcs11-* flags instead
+// of -key; the PIN may also be passed in CA_PKCS11_PIN.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/infrastructure/crypto"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "root":
+		err = runRoot(os.Args[2:])
+	case "csr":
+		err = runCSR(os.Args[2:])
+	case "sign":
+		err = runSign(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ca %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: ca root|csr|sign [flags]; ca <command> -h for details")
+	os.Exit(2)
+}
+
+// signerFlags registers the flags that locate a private key
+func signerFlags(fs *flag.FlagSet, cfg *crypto.SignerConfig, keyPath string) {
+	fs.StringVar(&cfg.Backend, "signer", crypto.SignerFile, "key backend: file or pkcs11")
+	fs.StringVar(&cfg.KeyPath, "key", keyPath, "PEM private key (file backend)")
+	fs.StringVar(&cfg.PKCS11Module, "pkcs11-module", "", "PKCS#11 module library")
+	fs.StringVar(&cfg.PKCS11TokenLabel, "pkcs11-token", "", "PKCS#11 token label")
+	fs.StringVar(&cfg.PKCS11KeyLabel, "pkcs11-key", "", "PKCS#11 key label")
+	fs.StringVar(&cfg.PKCS11PIN, "pkcs11-pin", os.Getenv("CA_PKCS11_PIN"), "PKCS#11 user PIN")
+}
+
+func runRoot(args []string) error {
+	fs := flag.NewFlagSet("root", flag.ExitOnError)
+	certPath := fs.String("cert", "ca.crt", "root certificate to create")
+	keyPath := fs.String("key", "ca.key", "root key to create")
+	_ = fs.Parse(args)
+
+	for _, path := range []string{*certPath, *keyPath} {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("%s already exists", path)
+		}
+	}
+	if err := crypto.GenerateCA(*certPath, *keyPath); err != nil {
+		return err
+	}
+	fmt.Printf("Root CA written to %s; embed it in licd and keep %s offline\n", *certPath, *keyPath)
+	return nil
+}
+
+func runCSR(args []string) error {
+	fs := flag.NewFlagSet("csr", flag.ExitOnError)
+	var cfg crypto.SignerConfig
+	signerFlags(fs, &cfg, "intermediate.key")
+	out := fs.String("out", "intermediate.csr", "CSR to write")
+	cn := fs.String("cn", "HW-Monitor Issuing CA", "common name of the intermediate")
+	_ = fs.Parse(args)
+
+	// A file key is created on first use, token keys must exist already
+	if cfg.Backend == crypto.SignerFile {
+		if _, err := os.Stat(cfg.KeyPath); os.IsNotExist(err) {
+			if _, err := crypto.GenerateKeyFile(cfg.KeyPath); err != nil {
+				return err
+			}
+			fmt.Printf("Generated intermediate key %s\n", cfg.KeyPath)
+		}
+	}
+	signer, err := crypto.OpenSigner(cfg)
+	if err != nil {
+		return err
+	}
+	defer signer.Close()
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: *cn, Organization: []string{"HW Monitor"}},
+	}, signer)
+	if err != nil {
+		return fmt.Errorf("failed to create CSR: %w", err)
+	}
+	if err := os.WriteFile(*out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to write CSR: %w", err)
+	}
+	fmt.Printf("CSR written to %s; sign it with the root on the offline machine\n", *out)
+	return nil
+}
+
+func runSign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ExitOnError)
+	var cfg crypto.SignerConfig
+	signerFlags(fs, &cfg, "ca.key")
+	certPath := fs.String("cert", "ca.crt", "root certificate")
+	csrPath := fs.String("csr", "intermediate.csr", "CSR of the intermediate")
+	out := fs.String("out", "intermediate.crt", "intermediate certificate to write")
+	days := fs.Int("days", 5*365, "validity of the intermediate, at most until the root expires")
+	_ = fs.Parse(args)
+
+	root, err := crypto.LoadCertificate(*certPath)
+	if err != nil {
+		return fmt.Errorf("failed to load root certificate: %w", err)
+	}
+	csrPEM, err := os.ReadFile(*csrPath)
+	if err != nil {
+		return fmt.Errorf("failed to read CSR: %w", err)
+	}
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		return fmt.Errorf("failed to decode CSR PEM")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse CSR: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return fmt.Errorf("invalid CSR signature: %w", err)
+	}
+
+	rootKey, err := crypto.OpenSigner(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open root key: %w", err)
+	}
+	defer rootKey.Close()
+
+	der, err := crypto.SignIntermediate(root, rootKey, csr.PublicKey, csr.Subject, time.Duration(*days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return fmt.Errorf("failed to write certificate: %w", err)
+	}
+	fmt.Printf("Intermediate written to %s; install it as CA_INTERMEDIATE_PATH on the license server\n", *out)
+	return nil
+}
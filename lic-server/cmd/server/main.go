@@ -4,33 +4,168 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/deymonster/lic-server/internal/api/router"
 	"github.com/deymonster/lic-server/internal/config"
+	"github.com/deymonster/lic-server/internal/core/audit"
+	"github.com/deymonster/lic-server/internal/core/backup"
 	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/core/webhook"
 	"github.com/deymonster/lic-server/internal/infrastructure/crypto"
+	"github.com/deymonster/lic-server/internal/infrastructure/metrics"
+	"github.com/deymonster/lic-server/internal/storage"
+	"github.com/deymonster/lic-server/internal/storage/postgres"
 	"github.com/deymonster/lic-server/internal/storage/sqlite"
 )
 
+// store is what the server needs from a storage backend
+type store interface {
+	license.Repository
+	webhook.Store
+	SchemaVersion() uint
+	Close() error
+}
+
+// openStorage opens the backend selected by DB_DRIVER
+func openStorage(cfg *config.Config) (store, error) {
+	switch cfg.DBDriver {
+	case "sqlite":
+		log.Printf("Using SQLite storage at %s", cfg.DBPath)
+		return sqlite.NewStorage(cfg.DBPath)
+	case "postgres":
+		if cfg.DatabaseURL == "" {
+			return nil, fmt.Errorf("DATABASE_URL is required for the postgres driver")
+		}
+		log.Println("Using PostgreSQL storage")
+		return postgres.NewStorage(cfg.DatabaseURL)
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q (expected sqlite or postgres)", cfg.DBDriver)
+	}
+}
+
+// loadRateLimits parses the RATE_LIMIT_* settings
+func loadRateLimits(cfg *config.Config) (router.RateLimits, error) {
+	var limits router.RateLimits
+	settings := []struct {
+		env   string
+		value string
+		limit *router.Limit
+	}{
+		{"RATE_LIMIT_REGISTER", cfg.RateLimitRegister, &limits.Register.IP},
+		{"RATE_LIMIT_REGISTER_INN", cfg.RateLimitRegisterINN, &limits.Register.INN},
+		{"RATE_LIMIT_ACTIVATE", cfg.RateLimitActivate, &limits.Activate.IP},
+		{"RATE_LIMIT_ACTIVATE_INN", cfg.RateLimitActivateINN, &limits.Activate.INN},
+		{"RATE_LIMIT_HEARTBEAT", cfg.RateLimitHeartbeat, &limits.Heartbeat.IP},
+		{"RATE_LIMIT_HEARTBEAT_INN", cfg.RateLimitHeartbeatINN, &limits.Heartbeat.INN},
+	}
+	for _, s := range settings {
+		limit, err := router.ParseLimit(s.value)
+		if err != nil {
+			return limits, fmt.Errorf("%s: %w", s.env, err)
+		}
+		*s.limit = limit
+	}
+
+	idle, err := time.ParseDuration(cfg.RateLimitIdleTimeout)
+	if err != nil || idle <= 0 {
+		return limits, fmt.Errorf("RATE_LIMIT_IDLE_TIMEOUT: invalid duration %q", cfg.RateLimitIdleTimeout)
+	}
+	limits.IdleTimeout = idle
+	return limits, nil
+}
+
+// ensureStaticToken registers STATIC_ENROLLMENT_TOKEN with its configured limits
+func ensureStaticToken(svc *license.Service, cfg *config.Config) (*storage.EnrollmentToken, error) {
+	ttl, err := time.ParseDuration(cfg.StaticTokenTTL)
+	if err != nil || ttl <= 0 {
+		return nil, fmt.Errorf("STATIC_ENROLLMENT_TOKEN_TTL: invalid duration %q", cfg.StaticTokenTTL)
+	}
+	var expiresAt time.Time
+	if cfg.StaticTokenExpiresAt != "" {
+		expiresAt, err = time.Parse(time.RFC3339, cfg.StaticTokenExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("STATIC_ENROLLMENT_TOKEN_EXPIRES_AT: %w", err)
+		}
+	}
+	maxUses, err := strconv.Atoi(cfg.StaticTokenMaxUses)
+	if err != nil {
+		return nil, fmt.Errorf("STATIC_ENROLLMENT_TOKEN_MAX_USES: %w", err)
+	}
+	return svc.EnsureStaticEnrollmentToken(context.Background(), cfg.StaticEnrollmentToken, expiresAt, ttl, maxUses)
+}
+
+// adminTLSConfig returns the TLS settings of the admin listener, or nil to serve plain HTTP.
+// With ADMIN_CLIENT_CA_PATH, clients must present a certificate issued by that CA.
+func adminTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.AdminTLSCertPath == "" && cfg.AdminTLSKeyPath == "" {
+		if cfg.AdminClientCAPath != "" {
+			return nil, fmt.Errorf("ADMIN_CLIENT_CA_PATH requires ADMIN_TLS_CERT_PATH and ADMIN_TLS_KEY_PATH")
+		}
+		return nil, nil
+	}
+	if cfg.AdminTLSCertPath == "" || cfg.AdminTLSKeyPath == "" {
+		return nil, fmt.Errorf("ADMIN_TLS_CERT_PATH and ADMIN_TLS_KEY_PATH must be set together")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.AdminTLSCertPath, cfg.AdminTLSKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load admin TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.AdminClientCAPath != "" {
+		caPEM, err := os.ReadFile(cfg.AdminClientCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ADMIN_CLIENT_CA_PATH: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("ADMIN_CLIENT_CA_PATH contains no certificates")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
 func main() {
 	// 1. Load Config
 	cfg := config.Load()
 	log.Printf("Starting lic-server on %s (TLS enabled)", cfg.ServerAddress)
 
 	// 2. Initialize Crypto (CA)
-	log.Printf("Initializing CA service... (CertPath: %s, KeyPath: %s)", cfg.CAPath, cfg.CAKeyPath)
-	ca, err := crypto.NewCAService(cfg.CAPath, cfg.CAKeyPath)
+	log.Printf("Initializing CA service... (Root: %s, Intermediate: %s, Signer: %s)", cfg.CAPath, cfg.CAIntermediatePath, cfg.CASigner)
+	ca, err := crypto.NewCAService(crypto.CAConfig{
+		RootCertPath:         cfg.CAPath,
+		RootKeyPath:          cfg.CAKeyPath,
+		IntermediateCertPath: cfg.CAIntermediatePath,
+		Signer: crypto.SignerConfig{
+			Backend:          cfg.CASigner,
+			KeyPath:          cfg.CAIntermediateKeyPath,
+			PKCS11Module:     cfg.CAPKCS11Module,
+			PKCS11TokenLabel: cfg.CAPKCS11TokenLabel,
+			PKCS11PIN:        cfg.CAPKCS11PIN,
+			PKCS11KeyLabel:   cfg.CAPKCS11KeyLabel,
+		},
+	})
 	if err != nil {
 		log.Fatalf("Failed to initialize CA service: %v", err)
 	}
+	defer ca.Close()
 	log.Println("CA Service initialized successfully")
+	if _, statErr := os.Stat(cfg.CAKeyPath); statErr == nil {
+		log.Printf("WARN: root CA key %s is on the server; keep it offline once the intermediate is signed", cfg.CAKeyPath)
+	}
 
 	// 2.1 Ensure Server Certs
 	if _, statErr := os.Stat(cfg.ServerCertPath); os.IsNotExist(statErr) {
@@ -58,14 +193,22 @@ func main() {
 	}
 
 	// 3. Initialize Storage
-	db, err := sqlite.NewStorage(cfg.DBPath)
+	db, err := openStorage(cfg)
 	if err != nil {
 		log.Fatalf("Failed to initialize storage: %v", err)
 	}
 	defer db.Close()
+	log.Printf("Storage schema at version %d", db.SchemaVersion())
 
 	// 4. Initialize Core Service
-	svc := license.NewService(db, ca, tokenService, cfg.StaticEnrollmentToken)
+	svc := license.NewService(db, ca, tokenService)
+	m := metrics.New(db)
+	svc.SetMetrics(m)
+	transferQuota, err := strconv.Atoi(cfg.HardwareTransferQuota)
+	if err != nil || transferQuota < 0 {
+		log.Fatalf("HARDWARE_TRANSFER_QUOTA: invalid number %q", cfg.HardwareTransferQuota)
+	}
+	svc.SetTransferQuota(transferQuota)
 
 	// 4.1 Seed Test Data (DEV ONLY)
 	// TODO: Remove in production or move to admin API
@@ -79,7 +222,7 @@ func main() {
 	// 4.2 Generate Enrollment Token for Test INN (DEV ONLY)
 	// This helps with local verification without manual DB insertion
 	if cfg.StaticEnrollmentToken == "" {
-		token, tokenErr := db.CreateEnrollmentToken(context.Background(), testINN, 24*time.Hour)
+		token, _, tokenErr := svc.CreateEnrollmentToken(audit.WithActor(context.Background(), "system"), testINN, 24*time.Hour, 1, "")
 		if tokenErr != nil {
 			log.Printf("Failed to create enrollment token: %v", tokenErr)
 		} else {
@@ -87,14 +230,98 @@ func main() {
 			log.Printf("Use this token to start licd: ENROLLMENT_TOKEN=%s go run ./cmd/licd", token)
 		}
 	} else {
-		log.Printf("Using STATIC ENROLLMENT TOKEN from config: %s", cfg.StaticEnrollmentToken)
-		log.Printf("Use this token to start licd: ENROLLMENT_TOKEN=%s go run ./cmd/licd", cfg.StaticEnrollmentToken)
+		static, staticErr := ensureStaticToken(svc, cfg)
+		if staticErr != nil {
+			log.Fatalf("Invalid STATIC_ENROLLMENT_TOKEN settings: %v", staticErr)
+		}
+		switch {
+		case static.RevokedAt != nil:
+			log.Printf("WARN: STATIC_ENROLLMENT_TOKEN was revoked and no longer registers instances")
+		case static.MaxUses == 0:
+			log.Printf("STATIC_ENROLLMENT_TOKEN accepted for any INN until %s (used %d times)", static.ExpiresAt.Format(time.RFC3339), static.UseCount)
+		default:
+			log.Printf("STATIC_ENROLLMENT_TOKEN accepted for any INN until %s (used %d of %d times)", static.ExpiresAt.Format(time.RFC3339), static.UseCount, static.MaxUses)
+		}
+	}
+
+	// 4.3 Admin access: without a static ADMIN_API_KEY, make sure at least one named key exists
+	if cfg.AdminAPIKey == "" {
+		bootstrapKey, keyErr := svc.EnsureBootstrapAdminKey(context.Background())
+		if keyErr != nil {
+			log.Fatalf("Failed to create bootstrap admin key: %v", keyErr)
+		}
+		if bootstrapKey != "" {
+			log.Printf("Created bootstrap admin key (shown only once, store it safely): %s", bootstrapKey)
+		}
+	} else {
+		log.Println("WARN: ADMIN_API_KEY grants full admin rights; prefer named admin keys (POST /api/admin/api-keys)")
+	}
+
+	// 4.4 Webhook delivery
+	webhookInterval, err := time.ParseDuration(cfg.WebhookPollInterval)
+	if err != nil || webhookInterval <= 0 {
+		log.Fatalf("Invalid WEBHOOK_POLL_INTERVAL %q", cfg.WebhookPollInterval)
+	}
+	webhookCtx, stopWebhooks := context.WithCancel(context.Background())
+	defer stopWebhooks()
+	go webhook.NewDispatcher(db, webhookInterval).Run(webhookCtx)
+
+	// 4.4.1 Signing key ring. Replicas of one deployment (DB_DRIVER=postgres) must share the
+	// directory of LICENSE_KEY_PATH, e.g. on a shared volume: a key rotated or retired on one
+	// replica is then picked up by the others on their next reload.
+	if cfg.LicenseKeyReload != "off" {
+		keyReload, parseErr := time.ParseDuration(cfg.LicenseKeyReload)
+		if parseErr != nil || keyReload <= 0 {
+			log.Fatalf("Invalid LICENSE_KEY_RELOAD_INTERVAL %q", cfg.LicenseKeyReload)
+		}
+		keyCtx, stopKeyReload := context.WithCancel(context.Background())
+		defer stopKeyReload()
+		go tokenService.ReloadEvery(keyCtx, keyReload)
+	}
+
+	// 4.5 Scheduled backups, SQLite only: postgres is backed up with its own tools
+	svc.SetBackupDir(cfg.BackupDir)
+	backupCtx, stopBackups := context.WithCancel(context.Background())
+	defer stopBackups()
+	if cfg.BackupInterval != "off" {
+		backupInterval, parseErr := time.ParseDuration(cfg.BackupInterval)
+		if parseErr != nil || backupInterval <= 0 {
+			log.Fatalf("Invalid BACKUP_INTERVAL %q", cfg.BackupInterval)
+		}
+		backupKeep, parseErr := strconv.Atoi(cfg.BackupKeep)
+		if parseErr != nil || backupKeep < 1 {
+			log.Fatalf("BACKUP_KEEP: invalid number %q", cfg.BackupKeep)
+		}
+		if source, ok := db.(backup.Source); ok {
+			log.Printf("Backing up the database to %s every %s, keeping %d", cfg.BackupDir, backupInterval, backupKeep)
+			go backup.NewScheduler(source, cfg.BackupDir, backupInterval, backupKeep).Run(backupCtx)
+		} else {
+			log.Println("BACKUP_INTERVAL ignored: scheduled backups need the sqlite driver, use pg_dump for postgres")
+		}
 	}
 
-	// 5. Initialize Router
-	r := router.NewRouter(svc, cfg.AdminAPIKey)
+	// 5. Initialize Routers: the licd API and the admin API are served on separate listeners
+	limits, err := loadRateLimits(cfg)
+	if err != nil {
+		log.Fatalf("Invalid rate limit config: %v", err)
+	}
+	proxies, err := router.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+	if len(proxies) > 0 {
+		log.Printf("Trusting forwarding headers from %s", cfg.TrustedProxies)
+	}
+	adminOnPublic := cfg.AdminAPIOnPublic == "true"
+	var r http.Handler
+	if adminOnPublic {
+		log.Println("WARN: ADMIN_API_ON_PUBLIC serves the admin API on the public listener")
+		r = router.NewRouter(svc, cfg.AdminAPIKey, limits, m)
+	} else {
+		r = router.NewPublicRouter(svc, limits, m)
+	}
 
-	// 6. Configure TLS
+	// 6. Configure TLS: licd presents its certificate with the intermediate, which chains to the root
 	caCertPEM, err := os.ReadFile(cfg.CAPath)
 	if err != nil {
 		log.Fatalf("Failed to read CA cert: %v", err)
@@ -111,7 +338,7 @@ func main() {
 	// 7. Start Server
 	srv := &http.Server{
 		Addr:      cfg.ServerAddress,
-		Handler:   r,
+		Handler:   proxies.RealIP(r),
 		TLSConfig: tlsConfig,
 	}
 
@@ -122,18 +349,44 @@ func main() {
 		}
 	}()
 
-	// 7.1 Start Admin Server (Plain HTTP for internal use)
-	adminSrv := &http.Server{
-		Addr:    cfg.AdminAddress,
-		Handler: r, // Can use the same router, as routes are segregated by path
-	}
+	// 7.1 Start Admin Server, for internal use only.
+	// Prometheus metrics are served here only, they name every customer INN.
+	var adminSrv *http.Server
+	if cfg.AdminAddress != "" {
+		adminTLS, tlsErr := adminTLSConfig(cfg)
+		if tlsErr != nil {
+			log.Fatalf("Invalid admin TLS config: %v", tlsErr)
+		}
+		adminRouter := router.NewAdminRouter(svc, cfg.AdminAPIKey, m)
+		adminRouter.Handle("/metrics", m.Handler())
+		adminSrv = &http.Server{
+			Addr:      cfg.AdminAddress,
+			Handler:   proxies.RealIP(adminRouter),
+			TLSConfig: adminTLS,
+		}
 
-	go func() {
-		log.Printf("Starting Admin server on %s (HTTP)", cfg.AdminAddress)
-		if adminErr := adminSrv.ListenAndServe(); adminErr != nil && adminErr != http.ErrServerClosed {
-			log.Fatalf("Admin server failed: %v", adminErr)
+		mode := "HTTP"
+		if adminTLS != nil {
+			mode = "TLS"
+			if adminTLS.ClientCAs != nil {
+				mode = "mTLS"
+			}
 		}
-	}()
+		go func() {
+			log.Printf("Starting Admin server on %s (%s, metrics at /metrics)", cfg.AdminAddress, mode)
+			var adminErr error
+			if adminTLS == nil {
+				adminErr = adminSrv.ListenAndServe()
+			} else {
+				adminErr = adminSrv.ListenAndServeTLS("", "")
+			}
+			if adminErr != nil && adminErr != http.ErrServerClosed {
+				log.Fatalf("Admin server failed: %v", adminErr)
+			}
+		}()
+	} else if !adminOnPublic {
+		log.Println("WARN: ADMIN_ADDRESS is empty, the admin API is disabled")
+	}
 
 	// 8. Graceful Shutdown
 	quit := make(chan os.Signal, 1)
@@ -141,14 +394,18 @@ func main() {
 	<-quit
 
 	log.Println("Shutting down servers...")
+	stopWebhooks()
+	stopBackups()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if shutdownErr := srv.Shutdown(ctx); shutdownErr != nil {
 		log.Fatalf("Main server forced to shutdown: %v", shutdownErr)
 	}
-	if shutdownErr := adminSrv.Shutdown(ctx); shutdownErr != nil {
-		log.Fatalf("Admin server forced to shutdown: %v", shutdownErr)
+	if adminSrv != nil {
+		if shutdownErr := adminSrv.Shutdown(ctx); shutdownErr != nil {
+			log.Fatalf("Admin server forced to shutdown: %v", shutdownErr)
+		}
 	}
 
 	log.Println("Servers exited properly")
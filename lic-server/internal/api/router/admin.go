@@ -1,11 +1,17 @@
 package router
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/deymonster/lic-server/internal/storage/sqlite"
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/storage"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -16,13 +22,84 @@ func respondJSON(w http.ResponseWriter, status int, payload interface{}) {
 }
 
 func (api *Router) registerAdminRoutes(r chi.Router) {
-	r.Get("/licenses", api.handleGetAllLicenses)
-	r.Post("/licenses", api.handleCreateLicense)
-	r.Put("/licenses/{inn}/details", api.handleUpdateLicenseDetails)
-	r.Put("/licenses/{inn}/status", api.handleUpdateLicenseStatus)
-	r.Get("/tokens", api.handleGetAllTokens)
-	r.Post("/tokens", api.handleCreateToken)
-	r.Get("/audit", api.handleGetAuditEvents)
+	r.Group(func(r chi.Router) {
+		r.Use(api.requireScope(license.ScopeReadOnly))
+		r.Use(api.requireLicenseAccess)
+		r.Get("/licenses", api.handleGetAllLicenses)
+		r.Get("/licenses/{inn}/instances", api.handleGetInstanceUsage)
+		r.Get("/licenses/{inn}/offline-activations", api.handleGetOfflineActivations)
+		r.Get("/licenses/{inn}/tokens", api.handleGetAllTokens)
+		r.Get("/licenses/{inn}/entitlements", api.handleGetEntitlements)
+		r.Get("/licenses/{inn}/transfers", api.handleGetTransfers)
+		r.Get("/licenses/{inn}/certificates", api.handleGetCertificates)
+		r.Get("/licenses/{inn}/certificates/{serial}", api.handleGetCertificate)
+		r.Get("/transfers", api.handleGetTransfers)
+		r.Get("/tokens", api.handleGetAllTokens)
+		r.Get("/tokens/{id}/uses", api.handleGetTokenUses)
+		r.Get("/keys", api.handleGetSigningKeys)
+		r.Get("/partner", api.handleGetOwnPartner)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(api.requireScope(license.ScopeLicenseWrite))
+		r.Use(api.requireLicenseAccess)
+		r.Post("/licenses", api.handleCreateLicense)
+		r.Put("/licenses/{inn}/details", api.handleUpdateLicenseDetails)
+		r.Put("/licenses/{inn}/status", api.handleUpdateLicenseStatus)
+		r.Post("/licenses/{inn}/extend", api.handleExtendLicense)
+		r.Post("/licenses/{inn}/renew", api.handleRenewLicense)
+		// Entitlements unlock paid features, so partners can read but not grant them
+		r.With(api.operatorOnly).Put("/licenses/{inn}/entitlements", api.handleReplaceEntitlements)
+		r.With(api.operatorOnly).Put("/licenses/{inn}/entitlements/{key}", api.handleSetEntitlement)
+		r.With(api.operatorOnly).Delete("/licenses/{inn}/entitlements/{key}", api.handleDeleteEntitlement)
+		r.Post("/transfers/{id}/approve", api.handleApproveTransfer)
+		r.Post("/transfers/{id}/reject", api.handleRejectTransfer)
+		r.With(api.operatorOnly).Post("/certificates/revoke", api.handleRevokeCertificate)
+		r.Post("/licenses/{inn}/certificates/{serial}/suspend", api.handleSuspendCertificate)
+		r.Post("/licenses/{inn}/certificates/{serial}/reactivate", api.handleReactivateCertificate)
+		r.Post("/licenses/{inn}/certificates/{serial}/revoke", api.handleRevokeLicenseCertificate)
+		r.With(api.operatorOnly).Post("/offline/activate", api.handleOfflineActivate)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(api.requireScope(license.ScopeTokenIssue))
+		r.Post("/tokens", api.handleCreateToken)
+		r.Delete("/tokens/{id}", api.handleRevokeToken)
+	})
+	r.Group(func(r chi.Router) {
+		r.Use(api.requireScope(license.ScopeAuditRead))
+		r.Use(api.requireLicenseAccess)
+		r.Get("/audit", api.handleGetAuditEvents)
+		r.Get("/audit/export", api.handleExportAuditEvents)
+		// The hash chain spans every event, so only the operator can check it
+		r.With(api.operatorOnly).Get("/audit/verify", api.handleVerifyAuditLog)
+		r.With(api.operatorOnly).Get("/audit/checkpoint", api.handleExportAuditCheckpoint)
+		r.Get("/licenses/{inn}/audit", api.handleGetAuditEvents)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(api.requireScope(license.ScopeAdmin))
+		r.Post("/keys/rotate", api.handleRotateSigningKey)
+		r.Delete("/keys/{version}", api.handleRetireSigningKey)
+		r.Get("/api-keys", api.handleGetAdminKeys)
+		r.Post("/api-keys", api.handleCreateAdminKey)
+		r.Delete("/api-keys/{id}", api.handleRevokeAdminKey)
+		r.Get("/webhooks", api.handleGetWebhooks)
+		r.Post("/webhooks", api.handleCreateWebhook)
+		r.Put("/webhooks/{id}", api.handleUpdateWebhook)
+		r.Delete("/webhooks/{id}", api.handleDeleteWebhook)
+		r.Get("/webhooks/{id}/deliveries", api.handleGetWebhookDeliveries)
+		r.Get("/webhooks/deliveries/{id}/attempts", api.handleGetWebhookAttempts)
+		r.Post("/webhooks/deliveries/{id}/redeliver", api.handleRedeliverWebhook)
+		r.Get("/backup", api.handleBackupDatabase)
+		r.Post("/restore", api.handleRestoreDatabase)
+		r.Get("/partners", api.handleGetPartners)
+		r.Post("/partners", api.handleCreatePartner)
+		r.Get("/partners/{id}", api.handleGetPartner)
+		r.Put("/partners/{id}", api.handleUpdatePartner)
+		r.Post("/partners/{id}/api-keys", api.handleCreatePartnerAdminKey)
+		r.Put("/licenses/{inn}/partner", api.handleSetLicensePartner)
+	})
 }
 
 func (api *Router) handleGetAllLicenses(w http.ResponseWriter, r *http.Request) {
@@ -32,15 +109,23 @@ func (api *Router) handleGetAllLicenses(w http.ResponseWriter, r *http.Request)
 		return
 	}
 	if licenses == nil {
-		licenses = make([]*sqlite.License, 0)
+		licenses = make([]*storage.License, 0)
 	}
 	respondJSON(w, http.StatusOK, licenses)
 }
 
 type createLicenseReq struct {
-	INN          string `json:"inn"`
-	Organization string `json:"organization"`
-	MaxSlots     int    `json:"max_slots"`
+	INN          string     `json:"inn"`
+	Organization string     `json:"organization"`
+	MaxSlots     int        `json:"max_slots"`
+	ExpiresAt    *time.Time `json:"expires_at"`    // optional, takes precedence over duration_days
+	DurationDays int        `json:"duration_days"` // optional, defaults to 365 (30 for trials)
+	Trial        bool       `json:"trial"`
+	GraceDays    int        `json:"grace_days"`
+
+	// Optional enrollment token issued together with the license (needs the token-issue scope)
+	TokenTTL     int `json:"token_ttl_hours"`
+	TokenMaxUses int `json:"token_max_uses"` // defaults to 1
 }
 
 func (api *Router) handleCreateLicense(w http.ResponseWriter, r *http.Request) {
@@ -53,15 +138,44 @@ func (api *Router) handleCreateLicense(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "Missing required fields")
 		return
 	}
+	if req.DurationDays < 0 || req.GraceDays < 0 {
+		respondError(w, http.StatusBadRequest, "duration_days and grace_days must not be negative")
+		return
+	}
+	if req.TokenTTL < 0 || req.TokenMaxUses < 0 {
+		respondError(w, http.StatusBadRequest, "token_ttl_hours and token_max_uses must not be negative")
+		return
+	}
+	if req.TokenTTL > 0 && !license.HasScope(adminKeyFromContext(r.Context()), license.ScopeTokenIssue) {
+		respondError(w, http.StatusForbidden, "Admin key lacks required scope: "+license.ScopeTokenIssue)
+		return
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			respondError(w, http.StatusBadRequest, "expires_at must be in the future")
+			return
+		}
+		expiresAt = *req.ExpiresAt
+	} else if req.DurationDays > 0 {
+		expiresAt = time.Now().AddDate(0, 0, req.DurationDays)
+	}
 
-	err := api.svc.CreateLicense(r.Context(), req.INN, req.Organization, req.MaxSlots)
+	err := api.svc.CreateLicense(r.Context(), req.INN, req.Organization, req.MaxSlots, expiresAt, req.Trial, req.GraceDays, getClientIP(r))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to create license")
+		respondServiceError(w, err, "Failed to create license")
 		return
 	}
 
-	// Auto-generate a 1-year enrollment token for the new license
-	token, tokenErr := api.svc.CreateEnrollmentToken(r.Context(), req.INN, 8760*time.Hour)
+	if req.TokenTTL == 0 {
+		respondJSON(w, http.StatusCreated, map[string]string{"message": "License created successfully"})
+		return
+	}
+	if req.TokenMaxUses == 0 {
+		req.TokenMaxUses = 1
+	}
+	token, _, tokenErr := api.svc.CreateEnrollmentToken(r.Context(), req.INN, time.Duration(req.TokenTTL)*time.Hour, req.TokenMaxUses, getClientIP(r))
 	if tokenErr != nil {
 		// Log but don't fail the license creation request
 		respondJSON(w, http.StatusCreated, map[string]string{
@@ -98,9 +212,9 @@ func (api *Router) handleUpdateLicenseDetails(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	err := api.svc.UpdateLicenseDetails(r.Context(), inn, req.Organization, req.MaxSlots)
+	err := api.svc.UpdateLicenseDetails(r.Context(), inn, req.Organization, req.MaxSlots, getClientIP(r))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to update license details")
+		respondServiceError(w, err, "Failed to update license details")
 		return
 	}
 
@@ -124,30 +238,129 @@ func (api *Router) handleUpdateLicenseStatus(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	err := api.svc.UpdateLicenseStatus(r.Context(), inn, req.Status)
+	err := api.svc.UpdateLicenseStatus(r.Context(), inn, req.Status, getClientIP(r))
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to update status")
+		respondServiceError(w, err, "Failed to update status")
 		return
 	}
 
 	respondJSON(w, http.StatusOK, map[string]string{"message": "Status updated successfully"})
 }
 
+func (api *Router) handleGetInstanceUsage(w http.ResponseWriter, r *http.Request) {
+	inn := chi.URLParam(r, "inn")
+	if inn == "" {
+		respondError(w, http.StatusBadRequest, "Missing INN")
+		return
+	}
+
+	usage, err := api.svc.GetInstanceUsage(r.Context(), inn)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get instance usage")
+		return
+	}
+	if usage == nil {
+		usage = make([]*storage.InstanceUsage, 0)
+	}
+	respondJSON(w, http.StatusOK, usage)
+}
+
+type extendLicenseReq struct {
+	Days int `json:"days"`
+}
+
+func (api *Router) handleExtendLicense(w http.ResponseWriter, r *http.Request) {
+	inn := chi.URLParam(r, "inn")
+	if inn == "" {
+		respondError(w, http.StatusBadRequest, "Missing INN")
+		return
+	}
+
+	var req extendLicenseReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Days <= 0 {
+		respondError(w, http.StatusBadRequest, "days must be positive")
+		return
+	}
+
+	lic, err := api.svc.ExtendLicense(r.Context(), inn, req.Days, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to extend license")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, lic)
+}
+
+type renewLicenseReq struct {
+	ExpiresAt    *time.Time `json:"expires_at"`    // optional, takes precedence over duration_days
+	DurationDays int        `json:"duration_days"` // optional, defaults to 365
+	GraceDays    *int       `json:"grace_days"`    // optional, keeps the current value when omitted
+}
+
+func (api *Router) handleRenewLicense(w http.ResponseWriter, r *http.Request) {
+	inn := chi.URLParam(r, "inn")
+	if inn == "" {
+		respondError(w, http.StatusBadRequest, "Missing INN")
+		return
+	}
+
+	var req renewLicenseReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.DurationDays < 0 || (req.GraceDays != nil && *req.GraceDays < 0) {
+		respondError(w, http.StatusBadRequest, "duration_days and grace_days must not be negative")
+		return
+	}
+
+	var expiresAt time.Time
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			respondError(w, http.StatusBadRequest, "expires_at must be in the future")
+			return
+		}
+		expiresAt = *req.ExpiresAt
+	}
+	graceDays := -1
+	if req.GraceDays != nil {
+		graceDays = *req.GraceDays
+	}
+
+	lic, err := api.svc.RenewLicense(r.Context(), inn, expiresAt, req.DurationDays, graceDays, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to renew license")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, lic)
+}
+
+// handleGetAllTokens lists all enrollment tokens, or those of one INN (path or ?inn=)
 func (api *Router) handleGetAllTokens(w http.ResponseWriter, r *http.Request) {
-	tokens, err := api.svc.GetAllEnrollmentTokens(r.Context())
+	inn := chi.URLParam(r, "inn")
+	if inn == "" {
+		inn = r.URL.Query().Get("inn")
+	}
+	tokens, err := api.svc.GetAllEnrollmentTokens(r.Context(), inn)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to get tokens")
 		return
 	}
 	if tokens == nil {
-		tokens = make([]*sqlite.EnrollmentToken, 0)
+		tokens = make([]*storage.EnrollmentToken, 0)
 	}
 	respondJSON(w, http.StatusOK, tokens)
 }
 
 type createTokenReq struct {
-	INN string `json:"inn"`
-	TTL int    `json:"ttl_hours"`
+	INN     string `json:"inn"`
+	TTL     int    `json:"ttl_hours"`
+	MaxUses int    `json:"max_uses"` // registrations the token allows, defaults to 1
 }
 
 func (api *Router) handleCreateToken(w http.ResponseWriter, r *http.Request) {
@@ -160,25 +373,409 @@ func (api *Router) handleCreateToken(w http.ResponseWriter, r *http.Request) {
 		respondError(w, http.StatusBadRequest, "Missing required fields")
 		return
 	}
+	if req.MaxUses < 0 {
+		respondError(w, http.StatusBadRequest, "max_uses must not be negative")
+		return
+	}
+	if req.MaxUses == 0 {
+		req.MaxUses = 1
+	}
 
 	ttl := time.Duration(req.TTL) * time.Hour
-	token, err := api.svc.CreateEnrollmentToken(r.Context(), req.INN, ttl)
+	token, record, err := api.svc.CreateEnrollmentToken(r.Context(), req.INN, ttl, req.MaxUses, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to create token")
+		return
+	}
+
+	// The plaintext token is only ever shown here; the server keeps its hash
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"token":            token,
+		"enrollment_token": record,
+	})
+}
+
+func (api *Router) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	if err := api.svc.RevokeEnrollmentToken(r.Context(), id, getClientIP(r)); err != nil {
+		respondServiceError(w, err, "Failed to revoke token")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Enrollment token revoked successfully"})
+}
+
+// handleGetTokenUses lists the registrations made with an enrollment token
+func (api *Router) handleGetTokenUses(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	uses, err := api.svc.GetEnrollmentTokenUses(r.Context(), id)
 	if err != nil {
-		respondError(w, http.StatusInternalServerError, "Failed to create token")
+		respondServiceError(w, err, "Failed to get token uses")
+		return
+	}
+	if uses == nil {
+		uses = make([]*storage.EnrollmentTokenUse, 0)
+	}
+	respondJSON(w, http.StatusOK, uses)
+}
+
+// handleOfflineActivate redeems an offline activation request file produced by licd
+// and returns the license response file to carry back to the air-gapped site.
+func (api *Router) handleOfflineActivate(w http.ResponseWriter, r *http.Request) {
+	var req license.OfflineActivationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request file")
 		return
 	}
 
-	respondJSON(w, http.StatusCreated, map[string]string{"token": token})
+	resp, err := api.svc.ActivateOffline(r.Context(), &req, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to activate offline")
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="license-%s.json"`, resp.INN))
+	respondJSON(w, http.StatusOK, resp)
 }
 
+func (api *Router) handleGetOfflineActivations(w http.ResponseWriter, r *http.Request) {
+	activations, err := api.svc.GetOfflineActivations(r.Context(), chi.URLParam(r, "inn"))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get offline activations")
+		return
+	}
+	if activations == nil {
+		activations = make([]*storage.OfflineActivation, 0)
+	}
+	respondJSON(w, http.StatusOK, activations)
+}
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// parseAuditFilter reads audit filters from the query string:
+// inn, action, ip, actor, from, to (RFC 3339), cursor and limit.
+// An {inn} URL parameter takes precedence over the inn query parameter.
+func parseAuditFilter(r *http.Request) (storage.AuditFilter, error) {
+	q := r.URL.Query()
+	f := storage.AuditFilter{
+		INN:    q.Get("inn"),
+		Action: q.Get("action"),
+		IP:     q.Get("ip"),
+		Actor:  q.Get("actor"),
+	}
+	if inn := chi.URLParam(r, "inn"); inn != "" {
+		f.INN = inn
+	}
+
+	var err error
+	if v := q.Get("from"); v != "" {
+		if f.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("invalid from: expected RFC 3339 time")
+		}
+	}
+	if v := q.Get("to"); v != "" {
+		if f.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return f, fmt.Errorf("invalid to: expected RFC 3339 time")
+		}
+	}
+	if v := q.Get("cursor"); v != "" {
+		if f.Before, err = strconv.ParseInt(v, 10, 64); err != nil || f.Before <= 0 {
+			return f, fmt.Errorf("invalid cursor")
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if f.Limit, err = strconv.Atoi(v); err != nil || f.Limit <= 0 {
+			return f, fmt.Errorf("invalid limit")
+		}
+	}
+	return f, nil
+}
+
+// handleGetAuditEvents returns one page of audit events, newest first.
+// When more events follow, the X-Next-Cursor header carries the cursor for the next page.
 func (api *Router) handleGetAuditEvents(w http.ResponseWriter, r *http.Request) {
-	events, err := api.svc.GetAllAuditEvents(r.Context(), 100) // Limit to 100 for now
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultAuditPageSize
+	}
+	if filter.Limit > maxAuditPageSize {
+		filter.Limit = maxAuditPageSize
+	}
+
+	// Fetch one extra event to know whether another page follows
+	pageSize := filter.Limit
+	filter.Limit++
+	events, err := api.svc.QueryAuditEvents(r.Context(), filter)
 	if err != nil {
 		respondError(w, http.StatusInternalServerError, "Failed to get audit events")
 		return
 	}
 	if events == nil {
-		events = make([]*sqlite.AuditEvent, 0)
+		events = make([]*storage.AuditEvent, 0)
+	}
+	if len(events) > pageSize {
+		events = events[:pageSize]
+		w.Header().Set("X-Next-Cursor", strconv.FormatInt(events[len(events)-1].ID, 10))
 	}
 	respondJSON(w, http.StatusOK, events)
 }
+
+// csvCell defuses spreadsheet formula injection: details and actors come from clients,
+// and a cell starting with = + - @ (or a tab/CR) would be evaluated when the export is opened.
+func csvCell(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+// handleExportAuditEvents streams every matching audit event as CSV or NDJSON (?format=csv|ndjson).
+// It accepts the same filters as handleGetAuditEvents; limit is optional here.
+func (api *Router) handleExportAuditEvents(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "ndjson"
+	}
+
+	var write func(*storage.AuditEvent) error
+	var flush func()
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+		_ = cw.Write([]string{"id", "created_at", "action", "inn", "ip_address", "actor", "details"})
+		write = func(e *storage.AuditEvent) error {
+			return cw.Write([]string{
+				strconv.FormatInt(e.ID, 10),
+				e.CreatedAt.UTC().Format(time.RFC3339),
+				csvCell(e.Action), csvCell(e.INN), csvCell(e.IPAddress), csvCell(e.Actor), csvCell(e.Details),
+			})
+		}
+		flush = cw.Flush
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+		write = func(e *storage.AuditEvent) error { return enc.Encode(e) }
+		flush = func() {}
+	default:
+		respondError(w, http.StatusBadRequest, "format must be csv or ndjson")
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	n := 0
+	err = api.svc.ExportAuditEvents(r.Context(), filter, func(e *storage.AuditEvent) error {
+		if err := write(e); err != nil {
+			return err
+		}
+		// Push data out regularly so large exports stream instead of buffering
+		if n++; n%500 == 0 {
+			flush()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		return nil
+	})
+	flush()
+	if err != nil {
+		// Headers are already sent; the truncated body is all we can signal
+		log.Printf("audit export aborted after %d events: %v", n, err)
+	}
+}
+
+// handleVerifyAuditLog walks the audit hash chain and reports the first broken link.
+// A previously exported checkpoint can be passed as ?checkpoint_id=&checkpoint_hash=
+// to also detect events removed from the end of the log.
+func (api *Router) handleVerifyAuditLog(w http.ResponseWriter, r *http.Request) {
+	var checkpoint *license.AuditCheckpoint
+	if v := r.URL.Query().Get("checkpoint_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			respondError(w, http.StatusBadRequest, "Invalid checkpoint_id")
+			return
+		}
+		hash := r.URL.Query().Get("checkpoint_hash")
+		if hash == "" {
+			respondError(w, http.StatusBadRequest, "checkpoint_hash is required with checkpoint_id")
+			return
+		}
+		checkpoint = &license.AuditCheckpoint{LastEventID: id, HeadHash: hash}
+	}
+
+	status, err := api.svc.VerifyAuditLog(r.Context(), checkpoint)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to verify audit log")
+		return
+	}
+
+	resp := map[string]interface{}{
+		"valid":     status.Valid,
+		"checked":   status.Checked,
+		"head_id":   status.HeadID,
+		"head_hash": status.HeadHash,
+	}
+	if !status.Valid {
+		resp["broken_id"] = status.BrokenID
+		resp["reason"] = status.Reason
+	}
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// handleExportAuditCheckpoint returns a signed checkpoint of the current audit chain head.
+// The token is an EdDSA JWT verifiable against /v1/jwks.
+func (api *Router) handleExportAuditCheckpoint(w http.ResponseWriter, r *http.Request) {
+	claims, token, err := api.svc.ExportAuditCheckpoint(r.Context(), getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to export audit checkpoint")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"checkpoint": claims,
+		"token":      token,
+	})
+}
+
+type revokeCertificateReq struct {
+	Serial      string `json:"serial"`
+	Fingerprint string `json:"fingerprint"`
+	Reason      string `json:"reason"` // "key_compromise", "cessation_of_operation", ...
+}
+
+func (api *Router) handleRevokeCertificate(w http.ResponseWriter, r *http.Request) {
+	var req revokeCertificateReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	if req.Serial == "" && req.Fingerprint == "" {
+		respondError(w, http.StatusBadRequest, "Serial or fingerprint is required")
+		return
+	}
+
+	binding, err := api.svc.RevokeClientCert(r.Context(), req.Serial, req.Fingerprint, req.Reason, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to revoke certificate")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, binding)
+}
+
+func (api *Router) handleGetSigningKeys(w http.ResponseWriter, r *http.Request) {
+	jwks, err := api.svc.GetJWKS()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get signing keys")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jwks)
+}
+
+func (api *Router) handleRotateSigningKey(w http.ResponseWriter, r *http.Request) {
+	version, kid, err := api.svc.RotateSigningKey(r.Context(), getClientIP(r))
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to rotate signing key")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"message": "Signing key rotated successfully",
+		"version": version,
+		"kid":     kid,
+	})
+}
+
+func (api *Router) handleRetireSigningKey(w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(chi.URLParam(r, "version"))
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid key version")
+		return
+	}
+
+	if err := api.svc.RetireSigningKey(r.Context(), version, getClientIP(r)); err != nil {
+		respondServiceError(w, err, "Failed to retire signing key")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Signing key retired successfully"})
+}
+
+func (api *Router) handleGetAdminKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := api.svc.GetAllAdminKeys(r.Context())
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get admin keys")
+		return
+	}
+	if keys == nil {
+		keys = make([]*storage.AdminAPIKey, 0)
+	}
+	respondJSON(w, http.StatusOK, keys)
+}
+
+type createAdminKeyReq struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+func (api *Router) handleCreateAdminKey(w http.ResponseWriter, r *http.Request) {
+	var req createAdminKeyReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	plaintext, key, err := api.svc.CreateAdminKey(r.Context(), req.Name, req.Scopes, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to create admin key")
+		return
+	}
+
+	// The plaintext key is only ever returned here
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"key":     plaintext,
+		"api_key": key,
+	})
+}
+
+func (api *Router) handleRevokeAdminKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid key ID")
+		return
+	}
+
+	if err := api.svc.RevokeAdminKey(r.Context(), id, getClientIP(r)); err != nil {
+		respondServiceError(w, err, "Failed to revoke admin key")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Admin key revoked successfully"})
+}
@@ -0,0 +1,77 @@
+package router
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/backup"
+)
+
+// ChecksumHeader carries the hex SHA-256 of a database snapshot, on downloads and uploads
+const ChecksumHeader = "X-Checksum-SHA256"
+
+// maxRestoreSize bounds uploaded snapshots
+const maxRestoreSize = 1 << 30
+
+// handleBackupDatabase streams a consistent snapshot of the database
+func (api *Router) handleBackupDatabase(w http.ResponseWriter, r *http.Request) {
+	dir, err := os.MkdirTemp("", "lic-server-backup-")
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to create backup")
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	snapshot, err := api.svc.BackupDatabase(r.Context(), filepath.Join(dir, backup.Name(time.Now())), getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to create backup")
+		return
+	}
+	f, err := os.Open(snapshot.Path)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to read backup")
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, snapshot.Name))
+	w.Header().Set("Content-Length", strconv.FormatInt(snapshot.Size, 10))
+	w.Header().Set(ChecksumHeader, snapshot.SHA256)
+	_, _ = io.Copy(w, f)
+}
+
+// handleRestoreDatabase replaces the database with the uploaded snapshot. The raw snapshot
+// is the request body; its checksum, if sent in ChecksumHeader, is verified first.
+func (api *Router) handleRestoreDatabase(w http.ResponseWriter, r *http.Request) {
+	tmp, err := os.CreateTemp("", "lic-server-restore-*.db")
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to store snapshot")
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, http.MaxBytesReader(w, r.Body, maxRestoreSize))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Sprintf("Failed to read snapshot: %v", err))
+		return
+	}
+
+	previous, err := api.svc.RestoreDatabase(r.Context(), tmp.Name(), r.Header.Get(ChecksumHeader), getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to restore database")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]interface{}{
+		"status":   "restored",
+		"previous": previous, // copy of the replaced database, null without BACKUP_DIR
+	})
+}
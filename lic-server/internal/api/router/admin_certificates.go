@@ -0,0 +1,69 @@
+package router
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/deymonster/lic-server/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type certificateRevokeReq struct {
+	Reason string `json:"reason"` // "key_compromise", "cessation_of_operation", ...
+}
+
+// handleGetCertificates lists the client certificate bindings of a license
+func (api *Router) handleGetCertificates(w http.ResponseWriter, r *http.Request) {
+	bindings, err := api.svc.GetLicenseCertificates(r.Context(), chi.URLParam(r, "inn"))
+	if err != nil {
+		respondServiceError(w, err, "Failed to get certificates")
+		return
+	}
+	if bindings == nil {
+		bindings = make([]*storage.ClientCertBinding, 0)
+	}
+	respondJSON(w, http.StatusOK, bindings)
+}
+
+func (api *Router) handleGetCertificate(w http.ResponseWriter, r *http.Request) {
+	binding, err := api.svc.GetLicenseCertificate(r.Context(), chi.URLParam(r, "inn"), chi.URLParam(r, "serial"))
+	if err != nil {
+		respondServiceError(w, err, "Failed to get certificate")
+		return
+	}
+	respondJSON(w, http.StatusOK, binding)
+}
+
+func (api *Router) handleSuspendCertificate(w http.ResponseWriter, r *http.Request) {
+	binding, err := api.svc.SuspendClientCert(r.Context(), chi.URLParam(r, "inn"), chi.URLParam(r, "serial"), getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to suspend certificate")
+		return
+	}
+	respondJSON(w, http.StatusOK, binding)
+}
+
+func (api *Router) handleReactivateCertificate(w http.ResponseWriter, r *http.Request) {
+	binding, err := api.svc.ReactivateClientCert(r.Context(), chi.URLParam(r, "inn"), chi.URLParam(r, "serial"), getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to reactivate certificate")
+		return
+	}
+	respondJSON(w, http.StatusOK, binding)
+}
+
+func (api *Router) handleRevokeLicenseCertificate(w http.ResponseWriter, r *http.Request) {
+	var req certificateRevokeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	binding, err := api.svc.RevokeLicenseCertificate(r.Context(), chi.URLParam(r, "inn"), chi.URLParam(r, "serial"), req.Reason, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to revoke certificate")
+		return
+	}
+	respondJSON(w, http.StatusOK, binding)
+}
@@ -0,0 +1,62 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/go-chi/chi/v5"
+)
+
+type entitlementReq struct {
+	Value interface{} `json:"value"` // true/false for a flag, an integer for a limit
+}
+
+func (api *Router) handleGetEntitlements(w http.ResponseWriter, r *http.Request) {
+	ents, err := api.svc.GetEntitlements(r.Context(), chi.URLParam(r, "inn"))
+	if err != nil {
+		respondServiceError(w, err, "Failed to get entitlements")
+		return
+	}
+	respondJSON(w, http.StatusOK, ents)
+}
+
+// handleReplaceEntitlements sets the complete entitlements of a license from a JSON object
+func (api *Router) handleReplaceEntitlements(w http.ResponseWriter, r *http.Request) {
+	var ents license.Entitlements
+	if err := json.NewDecoder(r.Body).Decode(&ents); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	inn := chi.URLParam(r, "inn")
+	if err := api.svc.SetEntitlements(r.Context(), inn, ents, getClientIP(r)); err != nil {
+		respondServiceError(w, err, "Failed to update entitlements")
+		return
+	}
+	api.handleGetEntitlements(w, r)
+}
+
+func (api *Router) handleSetEntitlement(w http.ResponseWriter, r *http.Request) {
+	var req entitlementReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Value == nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	inn := chi.URLParam(r, "inn")
+	if err := api.svc.SetEntitlement(r.Context(), inn, chi.URLParam(r, "key"), req.Value, getClientIP(r)); err != nil {
+		respondServiceError(w, err, "Failed to update entitlement")
+		return
+	}
+	api.handleGetEntitlements(w, r)
+}
+
+func (api *Router) handleDeleteEntitlement(w http.ResponseWriter, r *http.Request) {
+	inn := chi.URLParam(r, "inn")
+	if err := api.svc.DeleteEntitlement(r.Context(), inn, chi.URLParam(r, "key"), getClientIP(r)); err != nil {
+		respondServiceError(w, err, "Failed to delete entitlement")
+		return
+	}
+	api.handleGetEntitlements(w, r)
+}
@@ -0,0 +1,139 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type partnerReq struct {
+	Name     string `json:"name"`
+	SlotPool int    `json:"slot_pool"` // slots the partner may allocate over all of its licenses
+}
+
+func (api *Router) handleGetPartners(w http.ResponseWriter, r *http.Request) {
+	partners, err := api.svc.GetPartners(r.Context())
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get partners")
+		return
+	}
+	if partners == nil {
+		partners = make([]*storage.Partner, 0)
+	}
+	respondJSON(w, http.StatusOK, partners)
+}
+
+func (api *Router) handleCreatePartner(w http.ResponseWriter, r *http.Request) {
+	var req partnerReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	partner, err := api.svc.CreatePartner(r.Context(), req.Name, req.SlotPool, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to create partner")
+		return
+	}
+	respondJSON(w, http.StatusCreated, partner)
+}
+
+func (api *Router) handleGetPartner(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid partner ID")
+		return
+	}
+
+	partner, err := api.svc.GetPartner(r.Context(), id)
+	if err != nil {
+		respondServiceError(w, err, "Failed to get partner")
+		return
+	}
+	respondJSON(w, http.StatusOK, partner)
+}
+
+func (api *Router) handleUpdatePartner(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid partner ID")
+		return
+	}
+	var req partnerReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	partner, err := api.svc.UpdatePartner(r.Context(), id, req.Name, req.SlotPool, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to update partner")
+		return
+	}
+	respondJSON(w, http.StatusOK, partner)
+}
+
+// handleCreatePartnerAdminKey issues an admin key confined to the licenses of a partner
+func (api *Router) handleCreatePartnerAdminKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid partner ID")
+		return
+	}
+	var req createAdminKeyReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	plaintext, key, err := api.svc.CreatePartnerAdminKey(r.Context(), id, req.Name, req.Scopes, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to create admin key")
+		return
+	}
+
+	// The plaintext key is only ever returned here
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"key":     plaintext,
+		"api_key": key,
+	})
+}
+
+type licensePartnerReq struct {
+	PartnerID int64 `json:"partner_id"` // 0 gives the license back to the operator
+}
+
+func (api *Router) handleSetLicensePartner(w http.ResponseWriter, r *http.Request) {
+	var req licensePartnerReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.PartnerID < 0 {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	lic, err := api.svc.AssignLicensePartner(r.Context(), chi.URLParam(r, "inn"), req.PartnerID, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to assign license")
+		return
+	}
+	respondJSON(w, http.StatusOK, lic)
+}
+
+// handleGetOwnPartner returns the partner of the calling key, with the slots left in its pool
+func (api *Router) handleGetOwnPartner(w http.ResponseWriter, r *http.Request) {
+	id := license.PartnerFromContext(r.Context())
+	if id == 0 {
+		respondError(w, http.StatusNotFound, "Admin key does not belong to a partner")
+		return
+	}
+
+	partner, err := api.svc.GetPartner(r.Context(), id)
+	if err != nil {
+		respondServiceError(w, err, "Failed to get partner")
+		return
+	}
+	respondJSON(w, http.StatusOK, partner)
+}
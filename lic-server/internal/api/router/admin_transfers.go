@@ -0,0 +1,74 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+type transferDecisionReq struct {
+	Note string `json:"note"`
+}
+
+// handleGetTransfers lists hardware transfers, optionally filtered by ?inn= and ?status=
+func (api *Router) handleGetTransfers(w http.ResponseWriter, r *http.Request) {
+	filter := storage.HardwareTransferFilter{
+		INN:    r.URL.Query().Get("inn"),
+		Status: r.URL.Query().Get("status"),
+	}
+	if inn := chi.URLParam(r, "inn"); inn != "" {
+		filter.INN = inn
+	}
+	switch filter.Status {
+	case "", license.TransferPending, license.TransferApproved, license.TransferRejected:
+	default:
+		respondError(w, http.StatusBadRequest, "Invalid status")
+		return
+	}
+
+	transfers, err := api.svc.GetHardwareTransfers(r.Context(), filter)
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get hardware transfers")
+		return
+	}
+	if transfers == nil {
+		transfers = make([]*storage.HardwareTransfer, 0)
+	}
+	respondJSON(w, http.StatusOK, transfers)
+}
+
+func (api *Router) handleApproveTransfer(w http.ResponseWriter, r *http.Request) {
+	api.decideTransfer(w, r, api.svc.ApproveHardwareTransfer, "Failed to approve hardware transfer")
+}
+
+func (api *Router) handleRejectTransfer(w http.ResponseWriter, r *http.Request) {
+	api.decideTransfer(w, r, api.svc.RejectHardwareTransfer, "Failed to reject hardware transfer")
+}
+
+type transferDecision func(ctx context.Context, id int64, note, ip string) (*storage.HardwareTransfer, error)
+
+func (api *Router) decideTransfer(w http.ResponseWriter, r *http.Request, decide transferDecision, fallback string) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid transfer ID")
+		return
+	}
+	var req transferDecisionReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	transfer, err := decide(r.Context(), id, req.Note, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, fallback)
+		return
+	}
+	respondJSON(w, http.StatusOK, transfer)
+}
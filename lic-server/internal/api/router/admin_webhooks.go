@@ -0,0 +1,143 @@
+package router
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/deymonster/lic-server/internal/storage"
+	"github.com/go-chi/chi/v5"
+)
+
+const defaultWebhookDeliveries = 50
+
+type webhookReq struct {
+	Name       string   `json:"name"`
+	URL        *string  `json:"url"`
+	EventTypes []string `json:"event_types"` // audit actions, "prefix_*" or "*"
+	Active     *bool    `json:"active"`      // update only
+}
+
+func (api *Router) handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := api.svc.GetAllWebhooks(r.Context())
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "Failed to get webhooks")
+		return
+	}
+	if webhooks == nil {
+		webhooks = make([]*storage.Webhook, 0)
+	}
+	respondJSON(w, http.StatusOK, webhooks)
+}
+
+func (api *Router) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req webhookReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	secret, webhook, err := api.svc.CreateWebhook(r.Context(), req.Name, *req.URL, req.EventTypes, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to create webhook")
+		return
+	}
+
+	// The signing secret is only ever returned here
+	respondJSON(w, http.StatusCreated, map[string]interface{}{
+		"secret":  secret,
+		"webhook": webhook,
+	})
+}
+
+func (api *Router) handleUpdateWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+	var req webhookReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	webhook, err := api.svc.UpdateWebhook(r.Context(), id, req.URL, req.EventTypes, req.Active, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "Failed to update webhook")
+		return
+	}
+	respondJSON(w, http.StatusOK, webhook)
+}
+
+func (api *Router) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	if err := api.svc.DeleteWebhook(r.Context(), id, getClientIP(r)); err != nil {
+		respondServiceError(w, err, "Failed to delete webhook")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Webhook deleted successfully"})
+}
+
+// handleGetWebhookDeliveries returns the most recent deliveries of a webhook (?limit=, default 50)
+func (api *Router) handleGetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+	limit := defaultWebhookDeliveries
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxAuditPageSize {
+			respondError(w, http.StatusBadRequest, "Invalid limit")
+			return
+		}
+	}
+
+	deliveries, err := api.svc.GetWebhookDeliveries(r.Context(), id, limit)
+	if err != nil {
+		respondServiceError(w, err, "Failed to get webhook deliveries")
+		return
+	}
+	if deliveries == nil {
+		deliveries = make([]*storage.WebhookDelivery, 0)
+	}
+	respondJSON(w, http.StatusOK, deliveries)
+}
+
+func (api *Router) handleGetWebhookAttempts(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	attempts, err := api.svc.GetWebhookAttempts(r.Context(), id)
+	if err != nil {
+		respondServiceError(w, err, "Failed to get delivery attempts")
+		return
+	}
+	if attempts == nil {
+		attempts = make([]*storage.WebhookAttempt, 0)
+	}
+	respondJSON(w, http.StatusOK, attempts)
+}
+
+func (api *Router) handleRedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+	if err != nil {
+		respondError(w, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	if err := api.svc.RedeliverWebhook(r.Context(), id, getClientIP(r)); err != nil {
+		respondServiceError(w, err, "Failed to redeliver webhook")
+		return
+	}
+	respondJSON(w, http.StatusOK, map[string]string{"message": "Delivery queued"})
+}
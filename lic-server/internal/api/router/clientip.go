@@ -0,0 +1,192 @@
+package router
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the networks of reverse proxies whose forwarding headers are believed.
+// The zero value trusts nobody: the client IP is always the direct peer.
+type TrustedProxies []netip.Prefix
+
+// ParseTrustedProxies parses a comma separated list of CIDRs or single IPs,
+// e.g. "10.0.0.0/8, 192.168.1.10". "" trusts nobody.
+func ParseTrustedProxies(s string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: expected a CIDR or an IP", field)
+			}
+			addr = addr.Unmap()
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: expected a CIDR or an IP", field)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func (p TrustedProxies) trusts(addr netip.Addr) bool {
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP resolves the address of the client behind r. Forwarding headers are only read
+// when the direct peer is a trusted proxy, and then from the right: every hop added by a
+// trusted proxy is followed until the first untrusted address, which is the client. A
+// client can prepend whatever it likes to the headers, but not past its own address.
+// The standard Forwarded header wins over X-Forwarded-For, X-Real-IP is the last resort.
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	addr = addr.Unmap()
+	if !p.trusts(addr) {
+		return addr.String()
+	}
+
+	hops := forwardedFor(r.Header.Values("Forwarded"))
+	if len(hops) == 0 {
+		hops = splitList(r.Header.Values("X-Forwarded-For"))
+	}
+	if len(hops) == 0 && r.Header.Get("X-Real-IP") != "" {
+		hops = []string{r.Header.Get("X-Real-IP")}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseNode(hops[i])
+		if !ok {
+			// "unknown", an obfuscated node or garbage: the chain cannot be followed any further
+			break
+		}
+		addr = hop
+		if !p.trusts(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+// RealIP replaces r.RemoteAddr with the resolved client IP, so that logging, rate limiting
+// and auditing all see the same address. Unlike chi's middleware.RealIP it ignores
+// forwarding headers from peers that are not trusted proxies.
+func (p TrustedProxies) RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = p.ClientIP(r)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedFor returns the for= node of every element of RFC 7239 Forwarded headers,
+// oldest hop first. An element without for= yields "" so it still counts as a hop.
+func forwardedFor(values []string) []string {
+	var nodes []string
+	for _, element := range splitQuoted(strings.Join(values, ","), ',') {
+		if strings.TrimSpace(element) == "" {
+			continue
+		}
+		node := ""
+		for _, pair := range splitQuoted(element, ';') {
+			name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "for") {
+				node = unquote(strings.TrimSpace(value))
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// splitQuoted splits s at sep outside of quoted strings
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, escaped, start := false, false, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote strips the quotes and escapes of an RFC 7230 quoted-string
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	var b strings.Builder
+	escaped := false
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && !escaped {
+			escaped = true
+			continue
+		}
+		escaped = false
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// splitList splits comma separated header values, e.g. X-Forwarded-For, oldest hop first
+func splitList(values []string) []string {
+	var items []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+	}
+	return items
+}
+
+// parseNode parses a hop address: "192.0.2.1", "192.0.2.1:4711", "2001:db8::1" or "[2001:db8::1]:4711"
+func parseNode(node string) (netip.Addr, bool) {
+	node = strings.TrimSpace(node)
+	if strings.HasPrefix(node, "[") {
+		end := strings.IndexByte(node, ']')
+		if end < 0 {
+			return netip.Addr{}, false
+		}
+		node = node[1:end]
+	} else if strings.Count(node, ":") == 1 {
+		node, _, _ = strings.Cut(node, ":")
+	}
+	addr, err := netip.ParseAddr(node)
+	if err != nil || addr.Zone() != "" {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
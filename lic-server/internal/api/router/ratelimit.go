@@ -0,0 +1,266 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Limit is a token bucket: Rate requests per second on average, bursts of up to Burst.
+// The zero Limit disables limiting.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit parses "<rate>:<burst>", e.g. "1:3" or "0.5:10". "off" or "" disables the limit.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "off" || s == "0" {
+		return Limit{}, nil
+	}
+	rateStr, burstStr, ok := strings.Cut(s, ":")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: expected <rate>:<burst>", s)
+	}
+	r, err := strconv.ParseFloat(rateStr, 64)
+	if err != nil || r <= 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: rate must be a positive number", s)
+	}
+	burst, err := strconv.Atoi(burstStr)
+	if err != nil || burst < 1 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: burst must be a positive integer", s)
+	}
+	return Limit{Rate: r, Burst: burst}, nil
+}
+
+// RouteLimits are the limits of one route group, per client IP and per license INN
+type RouteLimits struct {
+	IP  Limit
+	INN Limit
+}
+
+// RateLimits configures throttling of the licd-facing API
+type RateLimits struct {
+	Register  RouteLimits // /v1/register
+	Activate  RouteLimits // /v1/activate and /v1/renew
+	Heartbeat RouteLimits // /v1/heartbeat
+
+	// IdleTimeout is how long a client is remembered after its last request
+	IdleTimeout time.Duration
+}
+
+// Route groups with their own limits
+const (
+	routeRegister  = "register"
+	routeActivate  = "activate"
+	routeHeartbeat = "heartbeat"
+)
+
+// throttleAuditInterval limits "rate_limited" audit events to one per client and interval,
+// so a client hammering the API does not flood the audit log as well
+const throttleAuditInterval = time.Minute
+
+const defaultIdleTimeout = 10 * time.Minute
+
+// rateLimiter keeps one token bucket per key (client IP or INN).
+// Visitors idle for longer than ttl are evicted by a janitor that runs while there are any.
+type rateLimiter struct {
+	limit Limit
+	ttl   time.Duration
+
+	mu       sync.Mutex
+	visitors map[string]*visitor
+	sweeping bool
+}
+
+type visitor struct {
+	limiter       *rate.Limiter
+	lastSeen      time.Time
+	lastThrottled time.Time // last throttle that was audited
+}
+
+// newRateLimiter returns nil for a disabled limit; a nil limiter allows everything
+func newRateLimiter(limit Limit, ttl time.Duration) *rateLimiter {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	if ttl <= 0 {
+		ttl = defaultIdleTimeout
+	}
+	return &rateLimiter{
+		limit:    limit,
+		ttl:      ttl,
+		visitors: make(map[string]*visitor),
+	}
+}
+
+// allow takes a token for key. When the bucket is empty it reports how long until the
+// next request would be allowed, and whether this throttle should be audited.
+func (rl *rateLimiter) allow(key string, now time.Time) (ok bool, retryAfter time.Duration, audit bool) {
+	if rl == nil {
+		return true, 0, false
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	v, exists := rl.visitors[key]
+	if !exists {
+		v = &visitor{limiter: rate.NewLimiter(rate.Limit(rl.limit.Rate), rl.limit.Burst)}
+		rl.visitors[key] = v
+		if !rl.sweeping {
+			rl.sweeping = true
+			go rl.sweep()
+		}
+	}
+	v.lastSeen = now
+
+	res := v.limiter.ReserveN(now, 1)
+	delay := res.DelayFrom(now)
+	if delay == 0 {
+		return true, 0, false
+	}
+	res.CancelAt(now)
+
+	if now.Sub(v.lastThrottled) >= throttleAuditInterval {
+		v.lastThrottled = now
+		audit = true
+	}
+	return false, delay, audit
+}
+
+// sweep evicts idle visitors until none are left
+func (rl *rateLimiter) sweep() {
+	ticker := time.NewTicker(rl.ttl / 2)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if rl.evict(now) == 0 {
+			return
+		}
+	}
+}
+
+// evict removes visitors idle for longer than ttl and returns how many remain.
+// With none left it also stops the janitor; the next new visitor starts it again.
+func (rl *rateLimiter) evict(now time.Time) int {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	for key, v := range rl.visitors {
+		if now.Sub(v.lastSeen) > rl.ttl {
+			delete(rl.visitors, key)
+		}
+	}
+	if len(rl.visitors) == 0 {
+		rl.sweeping = false
+	}
+	return len(rl.visitors)
+}
+
+// routeLimiters are the limiters of one route group
+type routeLimiters struct {
+	ip  *rateLimiter
+	inn *rateLimiter
+}
+
+func newRouteLimiters(limits RateLimits) map[string]*routeLimiters {
+	group := func(l RouteLimits) *routeLimiters {
+		return &routeLimiters{
+			ip:  newRateLimiter(l.IP, limits.IdleTimeout),
+			inn: newRateLimiter(l.INN, limits.IdleTimeout),
+		}
+	}
+	return map[string]*routeLimiters{
+		routeRegister:  group(limits.Register),
+		routeActivate:  group(limits.Activate),
+		routeHeartbeat: group(limits.Heartbeat),
+	}
+}
+
+// limitByIP throttles a route group per client IP. It runs before mTLS checks,
+// so unauthenticated floods are cut off before they reach the database.
+func (api *Router) limitByIP(route string) func(http.Handler) http.Handler {
+	rl := api.limiters[route].ip
+	return func(next http.Handler) http.Handler {
+		if rl == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := getClientIP(r)
+			if ok, retryAfter, audit := rl.allow(ip, time.Now()); !ok {
+				api.throttled(w, r, route, "ip", "unknown", retryAfter, audit)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// limitByINN throttles a route group per license. The INN is taken from the client
+// certificate binding (see RequireMTLS) or, before registration, from a request body whose
+// enrollment token is valid for it, so nobody can drain the bucket of someone else's INN.
+func (api *Router) limitByINN(route string) func(http.Handler) http.Handler {
+	rl := api.limiters[route].inn
+	return func(next http.Handler) http.Handler {
+		if rl == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inn := certINNFromContext(r.Context())
+			if inn == "" {
+				if req := peekRegister(r); api.svc.EnrollmentTokenValid(r.Context(), req.Token, req.INN) {
+					inn = req.INN
+				}
+			}
+			if inn != "" {
+				if ok, retryAfter, audit := rl.allow(inn, time.Now()); !ok {
+					api.throttled(w, r, route, "inn", inn, retryAfter, audit)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// throttled answers 429 with Retry-After and audits the first throttle per interval
+func (api *Router) throttled(w http.ResponseWriter, r *http.Request, route, by, inn string, retryAfter time.Duration, audit bool) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	if audit {
+		_ = api.svc.LogAudit(r.Context(), "rate_limited", inn, getClientIP(r),
+			fmt.Sprintf("route=%s, by=%s, retry_after=%ds", route, by, seconds))
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondError(w, http.StatusTooManyRequests, "rate limit exceeded")
+}
+
+// maxPeekBody bounds how much of a request body is buffered to find its INN
+const maxPeekBody = 1 << 20
+
+// peekRegister reads the "inn" and "token" fields of a JSON body and restores the body for the handler
+func peekRegister(r *http.Request) RegisterRequest {
+	var req RegisterRequest
+	if r.Body == nil {
+		return req
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekBody))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return req
+	}
+	_ = json.Unmarshal(body, &req)
+	return req
+}
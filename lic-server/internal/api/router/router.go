@@ -1,68 +1,122 @@
 package router
 
 import (
+	"context"
 	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/deymonster/lic-server/internal/core/audit"
 	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/infrastructure/metrics"
+	"github.com/deymonster/lic-server/internal/storage"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"golang.org/x/time/rate"
 )
 
 type Router struct {
 	svc      *license.Service
-	rl       *rateLimiter
+	limiters map[string]*routeLimiters
+	metrics  *metrics.Metrics
 	adminKey string
 }
 
-func NewRouter(svc *license.Service, adminKey string) *chi.Mux {
-	r := chi.NewRouter()
-	r.Use(middleware.RealIP)
-	r.Use(middleware.Logger)
-	r.Use(middleware.Recoverer)
+// NewRouter serves the licd API and the admin API from one handler, for deployments
+// that expose both on the same listener. m may be nil to run without metrics.
+func NewRouter(svc *license.Service, adminKey string, limits RateLimits, m *metrics.Metrics) *chi.Mux {
+	api := &Router{
+		svc:      svc,
+		limiters: newRouteLimiters(limits),
+		metrics:  m,
+		adminKey: adminKey,
+	}
+	r := api.newMux()
+	api.mountPublic(r)
+	api.mountAdmin(r)
+	return r
+}
 
+// NewPublicRouter builds the licd API (/v1) served on the mTLS listener.
+// m may be nil to run without metrics.
+func NewPublicRouter(svc *license.Service, limits RateLimits, m *metrics.Metrics) *chi.Mux {
 	api := &Router{
 		svc:      svc,
-		rl:       newRateLimiter(),
+		limiters: newRouteLimiters(limits),
+		metrics:  m,
+	}
+	r := api.newMux()
+	api.mountPublic(r)
+	return r
+}
+
+// NewAdminRouter builds the admin API (/api/admin) served on the admin listener.
+// m may be nil to run without metrics.
+func NewAdminRouter(svc *license.Service, adminKey string, m *metrics.Metrics) *chi.Mux {
+	api := &Router{
+		svc:      svc,
+		metrics:  m,
 		adminKey: adminKey,
 	}
+	r := api.newMux()
+	api.mountAdmin(r)
+	return r
+}
 
-	// API v1 (Client)
+func (api *Router) newMux() *chi.Mux {
+	r := chi.NewRouter()
+	r.Use(middleware.Logger)
+	r.Use(middleware.Recoverer)
+	if api.metrics != nil {
+		r.Use(api.measureLatency)
+	}
+	return r
+}
+
+// mountPublic registers the licd API
+func (api *Router) mountPublic(r chi.Router) {
 	r.Route("/v1", func(r chi.Router) {
-		r.With(api.RateLimit).Post("/register", api.HandleRegister)
+		r.With(api.limitByIP(routeRegister), api.limitByINN(routeRegister)).Post("/register", api.HandleRegister)
+		r.Get("/crl", api.HandleCRL)
+		r.Get("/jwks", api.HandleJWKS)
 
 		// Protected endpoints requiring mTLS
 		r.Group(func(r chi.Router) {
-			r.Use(api.RequireMTLS)
+			r.Use(api.limitByIP(routeActivate), api.RequireMTLS, api.limitByINN(routeActivate))
 			r.Post("/activate", api.HandleActivate)
+			r.Post("/renew", api.HandleRenew)
+			r.Post("/transfer", api.HandleTransfer)
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(api.limitByIP(routeHeartbeat), api.RequireMTLS, api.limitByINN(routeHeartbeat))
 			r.Get("/heartbeat", api.HandleHeartbeat)
+			r.Post("/heartbeat", api.HandleHeartbeat)
 		})
 	})
+}
 
-	// Admin API
+// mountAdmin registers the admin API
+func (api *Router) mountAdmin(r chi.Router) {
 	r.Route("/api/admin", func(r chi.Router) {
 		r.Use(api.corsMiddleware)
 		r.Use(api.adminAuthMiddleware)
 		api.registerAdminRoutes(r)
 	})
-
-	return r
 }
 
 func (api *Router) corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*") // In production, restrict this to your frontend domain
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, "+ChecksumHeader)
+		w.Header().Set("Access-Control-Expose-Headers", "Content-Disposition, "+ChecksumHeader)
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
 			return
@@ -71,93 +125,102 @@ func (api *Router) corsMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// configAdminKeyName is the audit actor for requests authenticated with the ADMIN_API_KEY from config
+const configAdminKeyName = "config"
+
+type adminKeyCtxKey struct{}
+
+// adminKeyFromContext returns the admin key that authenticated the request
+func adminKeyFromContext(ctx context.Context) *storage.AdminAPIKey {
+	key, _ := ctx.Value(adminKeyCtxKey{}).(*storage.AdminAPIKey)
+	if key == nil {
+		return &storage.AdminAPIKey{}
+	}
+	return key
+}
+
 func (api *Router) adminAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Expecting "Bearer <AdminAPIKey>" or simply checking a custom header like X-Admin-Key
+		// Expecting "Bearer <key>": either a named admin key or the key from config
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
 			respondError(w, http.StatusUnauthorized, "Missing Authorization header")
 			return
 		}
-
-		// Simple token check (e.g. "Bearer admin-secret-key-change-me")
-		// In a real app, you might compare this with a config value.
-		// For now, let's hardcode a check or inject it via Service/Config.
-		// Since Config isn't directly in Router, we could pass the admin key when creating Router.
-		// But let's assume we can fetch it or pass it.
-		// Actually, I should update NewRouter to accept the admin key.
-		// For now, I'll put a placeholder or get it from env.
-		expectedKey := api.adminKey
-		if expectedKey == "" {
-			expectedKey = "admin-secret-key-change-me" // fallback
+		presented, ok := strings.CutPrefix(authHeader, "Bearer ")
+		if !ok || presented == "" {
+			respondError(w, http.StatusUnauthorized, "Invalid admin key")
+			return
 		}
 
-		if authHeader != "Bearer "+expectedKey {
+		var key *storage.AdminAPIKey
+		if api.adminKey != "" && subtle.ConstantTimeCompare([]byte(presented), []byte(api.adminKey)) == 1 {
+			// The static key from config acts with full rights
+			key = &storage.AdminAPIKey{Name: configAdminKeyName, Scopes: []string{license.ScopeAdmin}}
+		} else {
+			var err error
+			key, err = api.svc.AuthenticateAdminKey(r.Context(), presented)
+			if err != nil {
+				respondError(w, http.StatusInternalServerError, "Failed to verify admin key")
+				return
+			}
+		}
+		if key == nil {
 			respondError(w, http.StatusUnauthorized, "Invalid admin key")
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		ctx := context.WithValue(r.Context(), adminKeyCtxKey{}, key)
+		ctx = audit.WithActor(ctx, key.Name)
+		if key.PartnerID != nil {
+			ctx = license.WithPartner(ctx, *key.PartnerID)
+		}
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// Rate Limiter Implementation
-type rateLimiter struct {
-	mu       sync.Mutex
-	visitors map[string]*visitor
-}
-
-type visitor struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
-}
-
-func newRateLimiter() *rateLimiter {
-	return &rateLimiter{
-		visitors: make(map[string]*visitor),
+// requireScope rejects admin requests whose key lacks scope
+func (api *Router) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !license.HasScope(adminKeyFromContext(r.Context()), scope) {
+				respondError(w, http.StatusForbidden, "Admin key lacks required scope: "+scope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
 	}
 }
 
-func (rl *rateLimiter) getVisitor(ip string) *rate.Limiter {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
-
-	v, exists := rl.visitors[ip]
-	if !exists {
-		// 1 request per second, burst of 3
-		limiter := rate.NewLimiter(1, 3)
-		rl.visitors[ip] = &visitor{limiter, time.Now()}
-		return limiter
-	}
-	v.lastSeen = time.Now()
-	return v.limiter
+// requireLicenseAccess answers requests of a partner key for a license of someone else
+// as if the license did not exist
+func (api *Router) requireLicenseAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if inn := chi.URLParam(r, "inn"); inn != "" {
+			if err := api.svc.CheckLicenseAccess(r.Context(), inn); err != nil {
+				respondServiceError(w, err, "Failed to check license access")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
-func (api *Router) RateLimit(next http.Handler) http.Handler {
+// operatorOnly rejects partner keys from endpoints that are not confined to their licenses
+// or that only the operator may use, like granting entitlements
+func (api *Router) operatorOnly(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := getClientIP(r)
-		limiter := api.rl.getVisitor(ip)
-		if !limiter.Allow() {
-			respondError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		if license.PartnerFromContext(r.Context()) != 0 {
+			respondError(w, http.StatusForbidden, "Not available to partner keys")
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// getClientIP returns the client address. Forwarding headers are resolved once, for
+// trusted proxies only, by TrustedProxies.RealIP in front of the router.
 func getClientIP(r *http.Request) string {
-	// Check X-Forwarded-For
-	xff := r.Header.Get("X-Forwarded-For")
-	if xff != "" {
-		parts := strings.Split(xff, ",")
-		return strings.TrimSpace(parts[0])
-	}
-	// Check X-Real-IP
-	xrip := r.Header.Get("X-Real-IP")
-	if xrip != "" {
-		return xrip
-	}
-	// Fallback to RemoteAddr
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		return r.RemoteAddr
@@ -165,6 +228,14 @@ func getClientIP(r *http.Request) string {
 	return ip
 }
 
+type certINNCtxKey struct{}
+
+// certINNFromContext returns the INN the client certificate is bound to, or "" before registration
+func certINNFromContext(ctx context.Context) string {
+	inn, _ := ctx.Value(certINNCtxKey{}).(string)
+	return inn
+}
+
 // RequireMTLS enforces mTLS authentication
 func (api *Router) RequireMTLS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -173,20 +244,23 @@ func (api *Router) RequireMTLS(next http.Handler) http.Handler {
 		// 1. Check if TLS is present
 		if r.TLS == nil {
 			_ = api.svc.LogAudit(r.Context(), "access_denied_mtls", "unknown", ip, "missing_tls")
-			respondError(w, http.StatusForbidden, "TLS required")
+			api.metrics.MTLSRejected("missing_tls")
+			respondErrorCode(w, http.StatusForbidden, license.CodeCertificateRequired, "TLS required")
 			return
 		}
 
 		// 2. Check if peer certificates are present
 		if len(r.TLS.PeerCertificates) == 0 {
 			_ = api.svc.LogAudit(r.Context(), "access_denied_mtls", "unknown", ip, "missing_client_cert")
-			respondError(w, http.StatusForbidden, "client certificate required")
+			api.metrics.MTLSRejected("missing_client_cert")
+			respondErrorCode(w, http.StatusForbidden, license.CodeCertificateRequired, "client certificate required")
 			return
 		}
 
 		// 3. Check if certificate chain is verified
 		if len(r.TLS.VerifiedChains) == 0 {
 			_ = api.svc.LogAudit(r.Context(), "access_denied_mtls", "unknown", ip, "cert_verification_failed")
+			api.metrics.MTLSRejected("cert_verification_failed")
 			respondError(w, http.StatusForbidden, "client certificate verification failed")
 			return
 		}
@@ -196,6 +270,7 @@ func (api *Router) RequireMTLS(next http.Handler) http.Handler {
 		// 4. Check Common Name
 		if cert.Subject.CommonName != "licd-client" {
 			_ = api.svc.LogAudit(r.Context(), "access_denied_mtls", "unknown", ip, fmt.Sprintf("invalid_cn: %s", cert.Subject.CommonName))
+			api.metrics.MTLSRejected("invalid_cn")
 			respondError(w, http.StatusForbidden, "invalid client certificate common name")
 			return
 		}
@@ -210,19 +285,138 @@ func (api *Router) RequireMTLS(next http.Handler) http.Handler {
 		}
 		if !hasClientAuth {
 			_ = api.svc.LogAudit(r.Context(), "access_denied_mtls", "unknown", ip, "missing_client_auth_usage")
+			api.metrics.MTLSRejected("missing_client_auth_usage")
 			respondError(w, http.StatusForbidden, "client certificate missing ClientAuth usage")
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		// 6. Check revocation status of the certificate binding
+		certFingerprint := fmt.Sprintf("%x", sha256.Sum256(cert.Raw))
+		binding, err := api.svc.GetClientCertBinding(r.Context(), certFingerprint)
+		if err != nil {
+			log.Printf("certificate binding lookup failed: %v", err)
+			_ = api.svc.LogAudit(r.Context(), "access_denied_mtls", "unknown", ip, fmt.Sprintf("binding_lookup_error: serial=%s", cert.SerialNumber))
+			api.metrics.MTLSRejected("binding_lookup_error")
+			respondError(w, http.StatusInternalServerError, "failed to check certificate status")
+			return
+		}
+		// A CA-signed certificate that was never bound (e.g. issued outside /register) is not trusted
+		if binding == nil {
+			_ = api.svc.LogAudit(r.Context(), "access_denied_mtls", "unknown", ip, fmt.Sprintf("cert_not_bound: serial=%s", cert.SerialNumber))
+			api.metrics.MTLSRejected("cert_not_bound")
+			respondErrorCode(w, http.StatusForbidden, license.CodeCertificateNotBound, "client certificate is not bound to a license")
+			return
+		}
+		if binding.Status != "active" {
+			_ = api.svc.LogAudit(r.Context(), "access_denied_mtls", "unknown", ip, fmt.Sprintf("cert_revoked: serial=%s", cert.SerialNumber))
+			api.metrics.MTLSRejected("cert_revoked")
+			respondErrorCode(w, http.StatusForbidden, license.CodeCertificateInactive, "client certificate revoked")
+			return
+		}
+		// The first request with a renewed certificate retires the one it replaces;
+		// on failure the old certificate simply stays valid a little longer
+		if err := api.svc.ConfirmRenewal(r.Context(), binding, ip); err != nil {
+			log.Printf("renewal confirmation failed: %v", err)
+		}
+
+		ctx := context.WithValue(r.Context(), certINNCtxKey{}, binding.INN)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
-// respondError sends a JSON error response
+// measureLatency records the duration of every request under its chi route pattern,
+// so /api/admin/licenses/{inn} is one series rather than one per INN
+func (api *Router) measureLatency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		api.metrics.ObserveRequest(r.Method, route, status, time.Since(start))
+	})
+}
+
+// ErrorResponse is the body of every error response. Clients branch on Code,
+// one of the license.Code* constants; Error is for people and may change.
+type ErrorResponse struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+// codeStatus maps service error codes to HTTP status codes
+var codeStatus = map[string]int{
+	license.CodeInvalidRequest:         http.StatusBadRequest,
+	license.CodeUnauthorized:           http.StatusUnauthorized,
+	license.CodeForbidden:              http.StatusForbidden,
+	license.CodeNotFound:               http.StatusNotFound,
+	license.CodeConflict:               http.StatusConflict,
+	license.CodeRateLimited:            http.StatusTooManyRequests,
+	license.CodeNotSupported:           http.StatusNotImplemented,
+	license.CodeLicenseNotFound:        http.StatusNotFound,
+	license.CodeLicenseInactive:        http.StatusForbidden,
+	license.CodeLicenseExpired:         http.StatusForbidden,
+	license.CodeSlotLimitExceeded:      http.StatusForbidden,
+	license.CodeSlotPoolExceeded:       http.StatusConflict,
+	license.CodeEnrollmentTokenInvalid: http.StatusForbidden,
+	license.CodeCertificateRequired:    http.StatusForbidden,
+	license.CodeCertificateNotBound:    http.StatusForbidden,
+	license.CodeCertificateINNMismatch: http.StatusForbidden,
+	license.CodeCertificateInactive:    http.StatusForbidden,
+	license.CodeHardwareMismatch:       http.StatusForbidden,
+}
+
+// genericCode returns the error code of a plain HTTP status
+func genericCode(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return license.CodeInvalidRequest
+	case http.StatusUnauthorized:
+		return license.CodeUnauthorized
+	case http.StatusForbidden:
+		return license.CodeForbidden
+	case http.StatusNotFound:
+		return license.CodeNotFound
+	case http.StatusConflict:
+		return license.CodeConflict
+	case http.StatusTooManyRequests:
+		return license.CodeRateLimited
+	case http.StatusNotImplemented:
+		return license.CodeNotSupported
+	}
+	return license.CodeInternal
+}
+
+// respondError sends a JSON error response with the generic code of the status
 func respondError(w http.ResponseWriter, statusCode int, message string) {
+	respondErrorCode(w, statusCode, genericCode(statusCode), message)
+}
+
+// respondErrorCode sends a JSON error response with an explicit code
+func respondErrorCode(w http.ResponseWriter, status int, code, message string) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(statusCode)
-	json.NewEncoder(w).Encode(map[string]string{"error": message})
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: message, Code: code})
+}
+
+// respondServiceError answers a service error with the status of its code.
+// Internal errors are logged and answered with the constant fallback so storage details do not leak.
+func respondServiceError(w http.ResponseWriter, err error, fallback string) {
+	code := license.ErrorCode(err)
+	status, ok := codeStatus[code]
+	if !ok {
+		log.Printf("%s: %v", fallback, err)
+		respondErrorCode(w, http.StatusInternalServerError, license.CodeInternal, fallback)
+		return
+	}
+	respondErrorCode(w, status, code, err.Error())
 }
 
 type RegisterRequest struct {
@@ -232,8 +426,8 @@ type RegisterRequest struct {
 }
 
 type RegisterResponse struct {
-	Certificate   string `json:"certificate"`
-	CACertificate string `json:"ca_certificate"`
+	Certificate   string `json:"certificate"`    // client certificate followed by the intermediate CA
+	CACertificate string `json:"ca_certificate"` // root CA
 	PublicKey     string `json:"public_key"`
 }
 
@@ -260,11 +454,7 @@ func (api *Router) HandleRegister(w http.ResponseWriter, r *http.Request) {
 	ip := getClientIP(r)
 	certPEM, caPEM, pubKeyPEM, err := api.svc.RegisterInstance(r.Context(), req.INN, req.Token, []byte(req.CSR), ip)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			respondError(w, http.StatusNotFound, "license not found for this INN")
-		} else {
-			respondError(w, http.StatusInternalServerError, fmt.Sprintf("registration failed: %v", err))
-		}
+		respondServiceError(w, err, "registration failed")
 		return
 	}
 
@@ -281,32 +471,162 @@ func (api *Router) HandleRegister(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
+type RenewRequest struct {
+	CSR string `json:"csr"`
+}
+
+// HandleRenew exchanges a new CSR for a fresh client certificate.
+// The caller authenticates with its current certificate (mTLS); the response has the same shape as /register.
+func (api *Router) HandleRenew(w http.ResponseWriter, r *http.Request) {
+	var req RenewRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if req.CSR == "" {
+		respondError(w, http.StatusBadRequest, "csr is required")
+		return
+	}
+
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		// Should be caught by RequireMTLS, but safe check
+		respondErrorCode(w, http.StatusForbidden, license.CodeCertificateRequired, "client certificate required")
+		return
+	}
+	certFingerprint := fmt.Sprintf("%x", sha256.Sum256(r.TLS.PeerCertificates[0].Raw))
+
+	ip := getClientIP(r)
+	certPEM, caPEM, pubKeyPEM, err := api.svc.RenewInstance(r.Context(), certFingerprint, []byte(req.CSR), ip)
+	if err != nil {
+		respondServiceError(w, err, "renewal failed")
+		return
+	}
+
+	resp := RegisterResponse{
+		Certificate:   string(certPEM),
+		CACertificate: string(caPEM),
+		PublicKey:     string(pubKeyPEM),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// HandleCRL serves the current certificate revocation list (DER, signed by the CA)
+func (api *Router) HandleCRL(w http.ResponseWriter, r *http.Request) {
+	crl, err := api.svc.GetCRL(r.Context())
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to generate CRL")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(crl)
+}
+
+// HandleJWKS serves the public token signing keys so licd can verify tokens signed by any of them
+func (api *Router) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	jwks, err := api.svc.GetJWKS()
+	if err != nil {
+		respondError(w, http.StatusInternalServerError, "failed to build key set")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jwks)
+}
+
+// HeartbeatRequest is the optional body of POST /v1/heartbeat used to report agent usage.
+// With jti set the server compares the caller's token with the current license state.
+type HeartbeatRequest struct {
+	Fingerprint string `json:"fingerprint"`
+	UsedSlots   *int   `json:"used_slots,omitempty"`
+	JTI         string `json:"jti,omitempty"`     // jti of the current license token
+	Version     int    `json:"version,omitempty"` // ver claim (signing key version) of the current token
+}
+
+// HeartbeatResponse answers a heartbeat that carried a token ID
+type HeartbeatResponse struct {
+	Status        string `json:"status"` // unchanged, updated or revoked
+	LicenseStatus string `json:"license_status,omitempty"`
+	Token         string `json:"token,omitempty"`  // new token when status is updated
+	Reason        string `json:"reason,omitempty"` // why the license was revoked
+}
+
 func (api *Router) HandleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	// 0. Optional usage report and token state (POST only; GET heartbeats carry no body)
+	var req HeartbeatRequest
+	var usage *license.UsageReport
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			respondError(w, http.StatusBadRequest, "invalid json")
+			return
+		}
+		if req.UsedSlots != nil {
+			if req.Fingerprint == "" {
+				respondError(w, http.StatusBadRequest, "fingerprint is required with used_slots")
+				return
+			}
+			usage = &license.UsageReport{InstanceID: req.Fingerprint, UsedSlots: *req.UsedSlots}
+		}
+	}
+
 	// 1. Get Cert Fingerprint
 	certFingerprint := ""
 	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
 		certFingerprint = fmt.Sprintf("%x", sha256.Sum256(r.TLS.PeerCertificates[0].Raw))
 	} else {
 		// Should be caught by RequireMTLS, but safe check
-		respondError(w, http.StatusForbidden, "client certificate required")
+		respondErrorCode(w, http.StatusForbidden, license.CodeCertificateRequired, "client certificate required")
 		return
 	}
-
-	// 2. Verify License
 	ip := getClientIP(r)
-	if err := api.svc.VerifyLicenseByCert(r.Context(), certFingerprint, ip); err != nil {
-		respondError(w, http.StatusForbidden, err.Error())
+
+	// 2. Token-aware heartbeat: unchanged, new token or revocation notice
+	if req.JTI != "" {
+		result, err := api.svc.Heartbeat(r.Context(), certFingerprint, ip, license.HeartbeatRequest{
+			TokenID:     req.JTI,
+			KeyVersion:  req.Version,
+			Fingerprint: req.Fingerprint,
+			Usage:       usage,
+		})
+		if err != nil {
+			respondServiceError(w, err, "heartbeat failed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(HeartbeatResponse{
+			Status:        result.Status,
+			LicenseStatus: result.LicenseStatus,
+			Token:         result.Token,
+			Reason:        result.Reason,
+		})
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"ok"}`))
+	// 3. Plain liveness check: verify License
+	licenseStatus, err := api.svc.VerifyLicenseByCert(r.Context(), certFingerprint, ip, usage)
+	if err != nil {
+		respondServiceError(w, err, "heartbeat failed")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":         "ok",
+		"license_status": licenseStatus,
+	})
 }
 
 type ActivateRequest struct {
 	INN         string `json:"inn"`
 	Fingerprint string `json:"fingerprint"`
 	Version     string `json:"version"`
+	UsedSlots   *int   `json:"used_slots,omitempty"` // agents currently registered on this instance
 }
 
 type ActivateResponse struct {
@@ -338,17 +658,16 @@ func (api *Router) HandleActivate(w http.ResponseWriter, r *http.Request) {
 		certFingerprint = fmt.Sprintf("%x", sha256.Sum256(r.TLS.PeerCertificates[0].Raw))
 	}
 
+	var usage *license.UsageReport
+	if req.UsedSlots != nil {
+		usage = &license.UsageReport{InstanceID: req.Fingerprint, UsedSlots: *req.UsedSlots}
+	}
+
 	// 2. Call Service
 	ip := getClientIP(r)
-	token, err := api.svc.ActivateInstance(r.Context(), req.INN, req.Fingerprint, req.Version, certFingerprint, ip)
+	token, err := api.svc.ActivateInstance(r.Context(), req.INN, req.Fingerprint, req.Version, certFingerprint, ip, usage)
 	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			respondError(w, http.StatusNotFound, "license not found for this INN")
-		} else if strings.Contains(err.Error(), "client certificate") && (strings.Contains(err.Error(), "bound") || strings.Contains(err.Error(), "required")) {
-			respondError(w, http.StatusForbidden, err.Error())
-		} else {
-			respondError(w, http.StatusInternalServerError, fmt.Sprintf("activation failed: %v", err))
-		}
+		respondServiceError(w, err, "activation failed")
 		return
 	}
 
@@ -360,3 +679,44 @@ func (api *Router) HandleActivate(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(resp)
 }
+
+type TransferRequest struct {
+	OldFingerprint string `json:"old_fingerprint"` // machine the instance is bound to
+	NewFingerprint string `json:"new_fingerprint"` // machine it runs on now
+	Reason         string `json:"reason,omitempty"`
+}
+
+type TransferResponse struct {
+	ID           int64  `json:"id"`
+	Status       string `json:"status"` // pending, approved or rejected
+	AutoApproved bool   `json:"auto_approved"`
+	Note         string `json:"note,omitempty"`
+}
+
+// HandleTransfer asks to move the calling instance to new hardware.
+// Posting the same request again reports the current decision.
+func (api *Router) HandleTransfer(w http.ResponseWriter, r *http.Request) {
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, "invalid json")
+		return
+	}
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		respondErrorCode(w, http.StatusForbidden, license.CodeCertificateRequired, "client certificate required")
+		return
+	}
+	certFingerprint := fmt.Sprintf("%x", sha256.Sum256(r.TLS.PeerCertificates[0].Raw))
+
+	transfer, err := api.svc.RequestHardwareTransfer(r.Context(), certFingerprint, req.OldFingerprint, req.NewFingerprint, req.Reason, getClientIP(r))
+	if err != nil {
+		respondServiceError(w, err, "transfer request failed")
+		return
+	}
+
+	respondJSON(w, http.StatusOK, TransferResponse{
+		ID:           transfer.ID,
+		Status:       transfer.Status,
+		AutoApproved: transfer.AutoApproved,
+		Note:         transfer.DecisionNote,
+	})
+}
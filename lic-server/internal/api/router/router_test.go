@@ -14,7 +14,7 @@ import (
 func TestHandleRegister_Validation(t *testing.T) {
 	// Setup (mock service is nil for now, we only test validation before service call)
 	svc := &license.Service{}
-	r := router.NewRouter(svc, "test-admin-key")
+	r := router.NewRouter(svc, "test-admin-key", router.RateLimits{}, nil)
 
 	tests := []struct {
 		name       string
@@ -63,3 +63,150 @@ func TestHandleRegister_Validation(t *testing.T) {
 		})
 	}
 }
+
+func TestRoutersServeOnlyTheirSurface(t *testing.T) {
+	svc := &license.Service{}
+	public := router.NewPublicRouter(svc, router.RateLimits{}, nil)
+	admin := router.NewAdminRouter(svc, "test-admin-key", nil)
+	combined := router.NewRouter(svc, "test-admin-key", router.RateLimits{}, nil)
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		method  string
+		path    string
+		served  bool
+	}{
+		{"Public serves licd API", public, "POST", "/v1/register", true},
+		{"Public hides admin API", public, "GET", "/api/admin/licenses", false},
+		{"Admin serves admin API", admin, "GET", "/api/admin/licenses", true},
+		{"Admin hides licd API", admin, "POST", "/v1/register", false},
+		{"Combined serves licd API", combined, "POST", "/v1/register", true},
+		{"Combined serves admin API", combined, "GET", "/api/admin/licenses", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Requests without a body or credentials are refused by the handlers, not the mux
+			w := httptest.NewRecorder()
+			tt.handler.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if served := w.Code != http.StatusNotFound; served != tt.served {
+				t.Errorf("%s %s: got status %d, served=%v, want served=%v", tt.method, tt.path, w.Code, served, tt.served)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesClientIP(t *testing.T) {
+	proxies, err := router.ParseTrustedProxies("10.0.0.0/8, 192.168.1.10, fd00::/8")
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies failed: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		proxies    router.TrustedProxies
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "Direct client",
+			proxies:    proxies,
+			remoteAddr: "203.0.113.7:5000",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Headers from an untrusted peer are ignored",
+			proxies:    proxies,
+			remoteAddr: "203.0.113.7:5000",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4", "Forwarded": "for=1.2.3.4", "X-Real-IP": "1.2.3.4"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "Nobody is trusted by default",
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "X-Forwarded-For from a trusted proxy",
+			proxies:    proxies,
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "Spoofed entries left of the client are skipped",
+			proxies:    proxies,
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.0.0.2"},
+			want:       "198.51.100.1",
+		},
+		{
+			name:       "Chain of trusted proxies ends at the oldest hop",
+			proxies:    proxies,
+			remoteAddr: "192.168.1.10:5000",
+			headers:    map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+			want:       "10.0.0.3",
+		},
+		{
+			name:       "Forwarded with quoted IPv6 and port",
+			proxies:    proxies,
+			remoteAddr: "[fd00::1]:5000",
+			headers:    map[string]string{"Forwarded": `for=192.0.2.60;proto=http, for="[2001:db8:cafe::17]:4711";by=10.0.0.1`},
+			want:       "2001:db8:cafe::17",
+		},
+		{
+			name:       "Forwarded wins over X-Forwarded-For",
+			proxies:    proxies,
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string]string{"Forwarded": "For=192.0.2.43:47011", "X-Forwarded-For": "198.51.100.1"},
+			want:       "192.0.2.43",
+		},
+		{
+			name:       "Unknown node stops at the last proxy",
+			proxies:    proxies,
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string]string{"Forwarded": "for=192.0.2.1, for=unknown"},
+			want:       "10.0.0.1",
+		},
+		{
+			name:       "X-Real-IP as the last resort",
+			proxies:    proxies,
+			remoteAddr: "10.0.0.1:5000",
+			headers:    map[string]string{"X-Real-IP": "198.51.100.9"},
+			want:       "198.51.100.9",
+		},
+		{
+			name:       "IPv4-mapped peer",
+			proxies:    proxies,
+			remoteAddr: "[::ffff:10.0.0.1]:5000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1"},
+			want:       "198.51.100.1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/crl", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if got := tt.proxies.ClientIP(req); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if proxies, err := router.ParseTrustedProxies(""); err != nil || len(proxies) != 0 {
+		t.Errorf("Expected an empty list, got %v, %v", proxies, err)
+	}
+	for _, s := range []string{"10.0.0.0/33", "proxy.local", "10.0.0.0/8,,nope"} {
+		if _, err := router.ParseTrustedProxies(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
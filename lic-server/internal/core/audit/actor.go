@@ -0,0 +1,19 @@
+// Package audit carries the identity of whoever triggered an audited action
+// from the HTTP layer down to the audit log, and defines how audit events are chained.
+package audit
+
+import "context"
+
+type actorKey struct{}
+
+// WithActor returns a context whose audit events are attributed to actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored in ctx, or "" when the action
+// was not triggered by an admin (e.g. requests from licd)
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
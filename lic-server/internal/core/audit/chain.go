@@ -0,0 +1,28 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// TimeFormat is the resolution at which event times enter the chain hash
+const TimeFormat = "2006-01-02 15:04:05"
+
+// ChainHash hashes the contents of an audit event together with the hash of the
+// event before it. The fields are JSON encoded so no separator can be smuggled in
+// through details. Every storage backend must produce the same hash for the same event.
+func ChainHash(prevHash, action, inn, ip, details, actor string, createdAt time.Time) string {
+	payload, _ := json.Marshal([]string{
+		prevHash,
+		action,
+		inn,
+		ip,
+		details,
+		actor,
+		createdAt.UTC().Format(TimeFormat),
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,205 @@
+// Package backup writes checksummed snapshots of the lic-server database and keeps
+// a rotating set of them in a local directory.
+//
+// Every snapshot "<name>.db" comes with "<name>.db.sha256" in sha256sum format, so a
+// copy can be checked with `sha256sum -c` before it is restored.
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// Prefix of scheduled snapshots; other files in the directory are never deleted
+	scheduledPrefix = "lic-server-"
+	extension       = ".db"
+	checksumExt     = ".sha256"
+	timeFormat      = "20060102T150405Z"
+)
+
+// Source is a database that can copy itself to a file while in use
+type Source interface {
+	Backup(ctx context.Context, path string) error
+}
+
+// File describes a snapshot
+type File struct {
+	Name      string
+	Path      string `json:"-"`
+	Size      int64
+	SHA256    string
+	CreatedAt time.Time
+}
+
+// Write snapshots src to path and writes its checksum file. The snapshot is made in a
+// temporary file first, so path never holds a partial copy.
+func Write(ctx context.Context, src Source, path string) (*File, error) {
+	tmp := path + ".tmp"
+	_ = os.Remove(tmp) // left over by an interrupted backup
+	if err := src.Backup(ctx, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	sum, err := Checksum(tmp)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return nil, fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	name := filepath.Base(path)
+	if err := os.WriteFile(path+checksumExt, []byte(sum+"  "+name+"\n"), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write checksum file: %w", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat snapshot: %w", err)
+	}
+	return &File{Name: name, Path: path, Size: info.Size(), SHA256: sum, CreatedAt: info.ModTime().UTC()}, nil
+}
+
+// Checksum returns the hex SHA-256 of the file at path
+func Checksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Name returns the file name of a scheduled snapshot taken at t
+func Name(t time.Time) string {
+	return scheduledPrefix + t.UTC().Format(timeFormat) + extension
+}
+
+// List returns the scheduled snapshots in dir, newest first
+func List(dir string) ([]*File, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %w", err)
+	}
+
+	var files []*File
+	for _, e := range entries {
+		name := e.Name()
+		stamp, ok := strings.CutPrefix(name, scheduledPrefix)
+		if !ok || e.IsDir() {
+			continue
+		}
+		stamp, ok = strings.CutSuffix(stamp, extension)
+		if !ok {
+			continue
+		}
+		createdAt, err := time.Parse(timeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		f := &File{Name: name, Path: filepath.Join(dir, name), Size: info.Size(), CreatedAt: createdAt}
+		if sum, err := os.ReadFile(f.Path + checksumExt); err == nil {
+			f.SHA256, _, _ = strings.Cut(strings.TrimSpace(string(sum)), " ")
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].CreatedAt.After(files[j].CreatedAt) })
+	return files, nil
+}
+
+// Scheduler snapshots a database into a directory at a fixed interval and deletes
+// all but the newest snapshots
+type Scheduler struct {
+	src      Source
+	dir      string
+	interval time.Duration
+	keep     int
+}
+
+// NewScheduler creates a scheduler that keeps the newest keep snapshots of src in dir
+func NewScheduler(src Source, dir string, interval time.Duration, keep int) *Scheduler {
+	return &Scheduler{src: src, dir: dir, interval: interval, keep: keep}
+}
+
+// Run takes snapshots until ctx is cancelled. The first one is due an interval after
+// the newest existing snapshot, so restarting the server does not take extra ones.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		wait := time.Duration(0)
+		if files, err := List(s.dir); err == nil && len(files) > 0 {
+			wait = time.Until(files[0].CreatedAt.Add(s.interval))
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+
+		f, err := s.RunOnce(ctx)
+		if err != nil {
+			log.Printf("backup: %v", err)
+			// Try again after an interval rather than in a tight loop
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(s.interval):
+			}
+			continue
+		}
+		log.Printf("backup: wrote %s (%d bytes, sha256 %s)", f.Name, f.Size, f.SHA256)
+	}
+}
+
+// RunOnce takes a snapshot now and deletes the ones beyond the retention
+func (s *Scheduler) RunOnce(ctx context.Context) (*File, error) {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	f, err := Write(ctx, s.src, filepath.Join(s.dir, Name(time.Now())))
+	if err != nil {
+		return nil, err
+	}
+	return f, s.prune()
+}
+
+// prune deletes the scheduled snapshots beyond the newest keep
+func (s *Scheduler) prune() error {
+	files, err := List(s.dir)
+	if err != nil {
+		return err
+	}
+	for i := s.keep; i < len(files); i++ {
+		if err := os.Remove(files[i].Path); err != nil {
+			return fmt.Errorf("failed to delete old backup: %w", err)
+		}
+		_ = os.Remove(files[i].Path + checksumExt)
+	}
+	return nil
+}
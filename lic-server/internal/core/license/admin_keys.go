@@ -0,0 +1,128 @@
+package license
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/deymonster/lic-server/internal/core/audit"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// Admin API key scopes
+const (
+	ScopeReadOnly     = "read-only"     // list licenses, tokens, instances and signing keys
+	ScopeLicenseWrite = "license-write" // create and change licenses, revoke certificates
+	ScopeTokenIssue   = "token-issue"   // issue enrollment tokens
+	ScopeAuditRead    = "audit-read"    // read the audit log
+	ScopeAdmin        = "admin"         // everything, including admin keys and signing key rotation
+)
+
+var validScopes = map[string]bool{
+	ScopeReadOnly:     true,
+	ScopeLicenseWrite: true,
+	ScopeTokenIssue:   true,
+	ScopeAuditRead:    true,
+	ScopeAdmin:        true,
+}
+
+// adminKeyPrefix marks lic-server admin keys so they are easy to spot in logs and secret scanners
+const adminKeyPrefix = "lsa_"
+
+// HasScope reports whether the key grants scope. The admin scope grants everything.
+func HasScope(key *storage.AdminAPIKey, scope string) bool {
+	for _, s := range key.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+func hashAdminKey(key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(key)))
+}
+
+// CreateAdminKey issues a new named admin key. The plaintext key is returned only once.
+func (s *Service) CreateAdminKey(ctx context.Context, name string, scopes []string, ip string) (string, *storage.AdminAPIKey, error) {
+	return s.createAdminKey(ctx, name, scopes, 0, ip)
+}
+
+func (s *Service) createAdminKey(ctx context.Context, name string, scopes []string, partnerID int64, ip string) (string, *storage.AdminAPIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, Errorf(CodeInvalidRequest, "admin key name is required")
+	}
+	if len(scopes) == 0 {
+		return "", nil, Errorf(CodeInvalidRequest, "at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !validScopes[scope] {
+			return "", nil, Errorf(CodeInvalidRequest, "invalid scope: %s", scope)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	plaintext := fmt.Sprintf("%s%x", adminKeyPrefix, b)
+
+	key, err := s.db.CreateAdminAPIKey(ctx, name, hashAdminKey(plaintext), plaintext[:len(adminKeyPrefix)+8], scopes, partnerID, audit.ActorFromContext(ctx))
+	if err != nil {
+		return "", nil, err
+	}
+	details := fmt.Sprintf("name=%s, scopes=%s", name, strings.Join(scopes, ","))
+	if partnerID != 0 {
+		details += fmt.Sprintf(", partner_id=%d", partnerID)
+	}
+	_ = s.db.LogAudit(ctx, "admin_key_created", "", ip, details)
+
+	return plaintext, key, nil
+}
+
+// AuthenticateAdminKey resolves a presented admin key. It returns nil for unknown or revoked keys.
+func (s *Service) AuthenticateAdminKey(ctx context.Context, plaintext string) (*storage.AdminAPIKey, error) {
+	if !strings.HasPrefix(plaintext, adminKeyPrefix) {
+		return nil, nil
+	}
+	return s.db.GetAdminAPIKeyByHash(ctx, hashAdminKey(plaintext))
+}
+
+func (s *Service) GetAllAdminKeys(ctx context.Context) ([]*storage.AdminAPIKey, error) {
+	return s.db.GetAllAdminAPIKeys(ctx)
+}
+
+// RevokeAdminKey disables an admin key immediately
+func (s *Service) RevokeAdminKey(ctx context.Context, id int64, ip string) error {
+	key, err := s.db.GetAdminAPIKey(ctx, id)
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return Errorf(CodeNotFound, "admin key not found")
+	}
+	if err := s.db.RevokeAdminAPIKey(ctx, id); err != nil {
+		return err
+	}
+	_ = s.db.LogAudit(ctx, "admin_key_revoked", "", ip, fmt.Sprintf("name=%s", key.Name))
+	return nil
+}
+
+// EnsureBootstrapAdminKey creates an admin-scoped "bootstrap" key when no admin keys exist yet,
+// so a fresh installation can be administered without a shared static key.
+// It returns the plaintext key, or "" when keys already exist.
+func (s *Service) EnsureBootstrapAdminKey(ctx context.Context) (string, error) {
+	keys, err := s.db.GetAllAdminAPIKeys(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) > 0 {
+		return "", nil
+	}
+
+	plaintext, _, err := s.CreateAdminKey(audit.WithActor(ctx, "system"), "bootstrap", []string{ScopeAdmin}, "")
+	return plaintext, err
+}
@@ -0,0 +1,90 @@
+package license
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// AuditCheckpointClaims pins the head of the audit hash chain at a point in time.
+// The checkpoint is signed with the license signing key, so anyone holding the JWKS
+// can later prove which events existed when it was taken.
+type AuditCheckpointClaims struct {
+	jwt.RegisteredClaims
+
+	LastEventID int64  `json:"last_id"`
+	HeadHash    string `json:"head"`
+	EventCount  int    `json:"count"`
+	KeyVersion  int    `json:"ver"`
+}
+
+// SetKeyVersion records the version of the key the checkpoint is signed with
+func (c *AuditCheckpointClaims) SetKeyVersion(version int) { c.KeyVersion = version }
+
+// AuditCheckpoint identifies a previously exported chain head
+type AuditCheckpoint struct {
+	LastEventID int64
+	HeadHash    string
+}
+
+// VerifyAuditLog walks the audit hash chain. When a checkpoint is given it also
+// confirms the checkpointed event still carries the same hash, which catches
+// events deleted from the end of the log.
+func (s *Service) VerifyAuditLog(ctx context.Context, checkpoint *AuditCheckpoint) (*storage.AuditChainStatus, error) {
+	status, err := s.db.VerifyAuditChain(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !status.Valid || checkpoint == nil {
+		return status, nil
+	}
+
+	hash, err := s.db.GetAuditEventHash(ctx, checkpoint.LastEventID)
+	if err != nil {
+		return nil, err
+	}
+	if hash != checkpoint.HeadHash {
+		status.Valid = false
+		status.BrokenID = checkpoint.LastEventID
+		if hash == "" {
+			status.Reason = "checkpoint event is missing; the log was truncated"
+		} else {
+			status.Reason = "checkpoint event hash differs; the log was rewritten"
+		}
+	}
+	return status, nil
+}
+
+// ExportAuditCheckpoint verifies the chain and returns a signed checkpoint of its head
+func (s *Service) ExportAuditCheckpoint(ctx context.Context, ip string) (*AuditCheckpointClaims, string, error) {
+	status, err := s.db.VerifyAuditChain(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	if !status.Valid {
+		return nil, "", Errorf(CodeConflict, "audit chain is broken at event %d: %s", status.BrokenID, status.Reason)
+	}
+
+	now := time.Now()
+	claims := &AuditCheckpointClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       fmt.Sprintf("%d", now.UnixNano()),
+			Subject:  "audit-checkpoint",
+			Issuer:   "lic-server",
+			IssuedAt: jwt.NewNumericDate(now),
+		},
+		LastEventID: status.HeadID,
+		HeadHash:    status.HeadHash,
+		EventCount:  status.Checked,
+	}
+	token, err := s.token.SignToken(claims)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to sign checkpoint: %w", err)
+	}
+
+	_ = s.db.LogAudit(ctx, "audit_checkpoint_exported", "", ip, fmt.Sprintf("last_id=%d, head=%s", status.HeadID, status.HeadHash))
+	return claims, token, nil
+}
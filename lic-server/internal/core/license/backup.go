@@ -0,0 +1,86 @@
+package license
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/backup"
+)
+
+// Snapshotter is implemented by storage backends that can back up and restore
+// their database while the server runs
+type Snapshotter interface {
+	backup.Source
+	Restore(ctx context.Context, path string) error
+}
+
+// SetBackupDir sets the directory the current database is saved to before a restore.
+// "" restores without a safety copy.
+func (s *Service) SetBackupDir(dir string) {
+	s.backupDir = dir
+}
+
+func (s *Service) snapshotter() (Snapshotter, error) {
+	sn, ok := s.db.(Snapshotter)
+	if !ok {
+		return nil, Errorf(CodeNotSupported, "online backups are only supported by the sqlite storage driver, use pg_dump for postgres")
+	}
+	return sn, nil
+}
+
+// BackupDatabase writes a consistent snapshot of the database to path
+func (s *Service) BackupDatabase(ctx context.Context, path, ip string) (*backup.File, error) {
+	sn, err := s.snapshotter()
+	if err != nil {
+		return nil, err
+	}
+	f, err := backup.Write(ctx, sn, path)
+	if err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "database_backup", "", ip, fmt.Sprintf("size=%d, sha256=%s", f.Size, f.SHA256))
+	return f, nil
+}
+
+// RestoreDatabase replaces the database with the snapshot at path. checksum, if given,
+// must be the snapshot's SHA-256. With a backup directory, the current database is saved
+// there first, so a restore can be undone; that copy is returned.
+func (s *Service) RestoreDatabase(ctx context.Context, path, checksum, ip string) (*backup.File, error) {
+	sn, err := s.snapshotter()
+	if err != nil {
+		return nil, err
+	}
+	sum, err := backup.Checksum(path)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != "" && !strings.EqualFold(checksum, sum) {
+		return nil, Errorf(CodeInvalidRequest, "snapshot checksum mismatch: got sha256 %s", sum)
+	}
+
+	var safety *backup.File
+	if s.backupDir != "" {
+		if err := os.MkdirAll(s.backupDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create backup directory: %w", err)
+		}
+		name := "pre-restore-" + time.Now().UTC().Format("20060102T150405Z") + ".db"
+		if safety, err = backup.Write(ctx, sn, filepath.Join(s.backupDir, name)); err != nil {
+			return nil, fmt.Errorf("failed to save the current database before restoring: %w", err)
+		}
+	}
+
+	if err := sn.Restore(ctx, path); err != nil {
+		return nil, err
+	}
+	details := "sha256=" + sum
+	if safety != nil {
+		details += ", previous=" + safety.Name
+	}
+	// Logged to the restored database, which is the one in use from now on
+	_ = s.db.LogAudit(ctx, "database_restored", "", ip, details)
+	return safety, nil
+}
@@ -0,0 +1,75 @@
+package license
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// GetLicenseCertificates returns every client certificate binding of a license, newest first
+func (s *Service) GetLicenseCertificates(ctx context.Context, inn string) ([]*storage.ClientCertBinding, error) {
+	if err := s.requireLicense(ctx, inn); err != nil {
+		return nil, err
+	}
+	return s.db.GetClientCertBindingsByINN(ctx, inn)
+}
+
+// GetLicenseCertificate returns the binding of the certificate with the given serial,
+// which must belong to the license
+func (s *Service) GetLicenseCertificate(ctx context.Context, inn, serial string) (*storage.ClientCertBinding, error) {
+	binding, err := s.db.GetClientCertBindingBySerial(ctx, serial)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up certificate binding: %w", err)
+	}
+	if binding == nil || binding.INN != inn {
+		return nil, Errorf(CodeNotFound, "client certificate binding not found")
+	}
+	return binding, nil
+}
+
+// SuspendClientCert puts a certificate on hold: the instance is refused and the certificate
+// is listed in the CRL with reason certificate_hold until it is reactivated
+func (s *Service) SuspendClientCert(ctx context.Context, inn, serial, ip string) (*storage.ClientCertBinding, error) {
+	binding, err := s.GetLicenseCertificate(ctx, inn, serial)
+	if err != nil {
+		return nil, err
+	}
+	if binding.Status != "active" {
+		return nil, Errorf(CodeConflict, "client certificate is %s, only active certificates can be suspended", binding.Status)
+	}
+	if err := s.db.SuspendClientCertBinding(ctx, binding.ID); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "cert_suspended", inn, ip, fmt.Sprintf("serial=%s", serial))
+	return s.GetLicenseCertificate(ctx, inn, serial)
+}
+
+// ReactivateClientCert takes a suspended certificate off hold.
+// Revoked and superseded certificates stay revoked for good.
+func (s *Service) ReactivateClientCert(ctx context.Context, inn, serial, ip string) (*storage.ClientCertBinding, error) {
+	binding, err := s.GetLicenseCertificate(ctx, inn, serial)
+	if err != nil {
+		return nil, err
+	}
+	if binding.Status != "suspended" {
+		return nil, Errorf(CodeConflict, "client certificate is %s, only suspended certificates can be reactivated", binding.Status)
+	}
+	if binding.ExpiresAt.Before(time.Now()) {
+		return nil, Errorf(CodeConflict, "client certificate expired, the instance has to register again")
+	}
+	if err := s.db.ReactivateClientCertBinding(ctx, binding.ID); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "cert_reactivated", inn, ip, fmt.Sprintf("serial=%s", serial))
+	return s.GetLicenseCertificate(ctx, inn, serial)
+}
+
+// RevokeLicenseCertificate revokes a certificate of the license for good
+func (s *Service) RevokeLicenseCertificate(ctx context.Context, inn, serial, reason, ip string) (*storage.ClientCertBinding, error) {
+	if _, err := s.GetLicenseCertificate(ctx, inn, serial); err != nil {
+		return nil, err
+	}
+	return s.RevokeClientCert(ctx, serial, "", reason, ip)
+}
@@ -6,21 +6,34 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// License states as carried in the sts claim
+const (
+	StatusActive  = "active"
+	StatusTrial   = "trial"
+	StatusGrace   = "grace"
+	StatusExpired = "expired"
+	StatusRevoked = "revoked"
+)
+
 // LicenseClaims represents the structure of the JWT license token
 type LicenseClaims struct {
 	jwt.RegisteredClaims
 
 	// Custom claims matching the API contract
-	LicenseID       string `json:"lid"`
-	INN             string `json:"inn"`
-	OrgName         string `json:"org"` // Organization Name
-	MaxAgents       int    `json:"max"`
-	FingerprintHash string `json:"fph"`
-	ActivationDate  string `json:"act"` // ISO8601
-	KeyVersion      int    `json:"ver"`
-	Status          string `json:"sts"` // active, trial, expired, revoked
+	LicenseID       string       `json:"lid"`
+	INN             string       `json:"inn"`
+	OrgName         string       `json:"org"` // Organization Name
+	MaxAgents       int          `json:"max"`
+	FingerprintHash string       `json:"fph"`
+	ActivationDate  string       `json:"act"` // ISO8601
+	KeyVersion      int          `json:"ver"`
+	Status          string       `json:"sts"`           // active, trial, grace, expired, revoked
+	Entitlements    Entitlements `json:"ent,omitempty"` // licensed modules: flags and limits
 }
 
+// SetKeyVersion records the version of the key the token is signed with
+func (c *LicenseClaims) SetKeyVersion(version int) { c.KeyVersion = version }
+
 // IsActive checks if the license status is active
 func (c *LicenseClaims) IsActive() bool {
 	return c.Status == "active"
@@ -0,0 +1,189 @@
+package license
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/audit"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// enrollmentTokenPrefix marks enrollment tokens, like adminKeyPrefix does for admin keys
+const enrollmentTokenPrefix = "lse_"
+
+// staticTokenCreator is the created_by of the STATIC_ENROLLMENT_TOKEN row
+const staticTokenCreator = "config"
+
+func hashToken(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// CreateEnrollmentToken issues a token that allows maxUses registrations for inn until ttl passes.
+// The plaintext token is returned only once.
+func (s *Service) CreateEnrollmentToken(ctx context.Context, inn string, ttl time.Duration, maxUses int, ip string) (string, *storage.EnrollmentToken, error) {
+	if inn == "" {
+		return "", nil, Errorf(CodeInvalidRequest, "inn is required")
+	}
+	if ttl <= 0 {
+		return "", nil, Errorf(CodeInvalidRequest, "ttl must be positive")
+	}
+	if maxUses < 1 {
+		return "", nil, Errorf(CodeInvalidRequest, "max_uses must be at least 1")
+	}
+	if err := s.CheckLicenseAccess(ctx, inn); err != nil {
+		return "", nil, err
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	plaintext := fmt.Sprintf("%s%x", enrollmentTokenPrefix, b)
+
+	t, err := s.db.CreateEnrollmentToken(ctx, hashToken(plaintext), plaintext[:len(enrollmentTokenPrefix)+8], inn, maxUses, time.Now().Add(ttl), audit.ActorFromContext(ctx))
+	if err != nil {
+		return "", nil, err
+	}
+	_ = s.db.LogAudit(ctx, "enrollment_token_created", inn, ip, fmt.Sprintf("token_id=%d, ttl=%s, max_uses=%d", t.ID, ttl, maxUses))
+	return plaintext, t, nil
+}
+
+// EnsureStaticEnrollmentToken registers the STATIC_ENROLLMENT_TOKEN from config as a token valid
+// for any INN, so its uses are limited, recorded and revocable like those of any other token.
+// A zero expiresAt keeps the expiry of an already registered token, or sets ttl from now for a new one.
+// maxUses 0 allows unlimited registrations. A revoked static token stays revoked.
+func (s *Service) EnsureStaticEnrollmentToken(ctx context.Context, token string, expiresAt time.Time, ttl time.Duration, maxUses int) (*storage.EnrollmentToken, error) {
+	if maxUses < 0 {
+		return nil, Errorf(CodeInvalidRequest, "max uses must not be negative")
+	}
+	hash := hashToken(token)
+	t, err := s.db.GetEnrollmentTokenByHash(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if t == nil {
+		if expiresAt.IsZero() {
+			expiresAt = time.Now().Add(ttl)
+		}
+		// Only a few characters: a static token may be short
+		prefix := ""
+		if len(token) >= 16 {
+			prefix = token[:4]
+		}
+		t, err = s.db.CreateEnrollmentToken(ctx, hash, prefix, "", maxUses, expiresAt, staticTokenCreator)
+		if err != nil {
+			return nil, err
+		}
+		_ = s.db.LogAudit(ctx, "static_enrollment_token_registered", "", "", fmt.Sprintf("token_id=%d, expires_at=%s, max_uses=%d", t.ID, expiresAt.Format(time.RFC3339), maxUses))
+		return t, nil
+	}
+
+	if t.INN != "" {
+		return nil, Errorf(CodeConflict, "static enrollment token collides with a token issued for INN %s", t.INN)
+	}
+	if t.RevokedAt != nil {
+		return t, nil
+	}
+	if expiresAt.IsZero() {
+		expiresAt = t.ExpiresAt
+	}
+	if maxUses != t.MaxUses || !expiresAt.Equal(t.ExpiresAt) {
+		if err := s.db.UpdateEnrollmentTokenLimits(ctx, t.ID, maxUses, expiresAt); err != nil {
+			return nil, err
+		}
+		_ = s.db.LogAudit(ctx, "static_enrollment_token_updated", "", "", fmt.Sprintf("token_id=%d, expires_at=%s, max_uses=%d", t.ID, expiresAt.Format(time.RFC3339), maxUses))
+		t.MaxUses = maxUses
+		t.ExpiresAt = expiresAt
+	}
+	return t, nil
+}
+
+// EnrollmentTokenValid reports whether token would currently allow a registration for inn,
+// without consuming a use. Lookup errors count as invalid.
+func (s *Service) EnrollmentTokenValid(ctx context.Context, token, inn string) bool {
+	if token == "" || inn == "" {
+		return false
+	}
+	t, err := s.db.GetEnrollmentTokenByHash(ctx, hashToken(token))
+	if err != nil || t == nil {
+		return false
+	}
+	if t.RevokedAt != nil || time.Now().After(t.ExpiresAt) {
+		return false
+	}
+	if t.MaxUses > 0 && t.UseCount >= t.MaxUses {
+		return false
+	}
+	return t.INN == "" || t.INN == inn
+}
+
+// GetAllEnrollmentTokens returns all enrollment tokens, or those of one INN when inn is set.
+// A partner only sees the tokens of its own licenses.
+func (s *Service) GetAllEnrollmentTokens(ctx context.Context, inn string) ([]*storage.EnrollmentToken, error) {
+	var tokens []*storage.EnrollmentToken
+	var err error
+	if inn != "" {
+		tokens, err = s.db.GetEnrollmentTokensByINN(ctx, inn)
+	} else {
+		tokens, err = s.db.GetAllEnrollmentTokens(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	inns, err := s.partnerINNs(ctx)
+	if err != nil || inns == nil {
+		return tokens, err
+	}
+	var visible []*storage.EnrollmentToken
+	for _, t := range tokens {
+		if inns[t.INN] {
+			visible = append(visible, t)
+		}
+	}
+	return visible, nil
+}
+
+// getEnrollmentToken returns a token the admin call in ctx may see
+func (s *Service) getEnrollmentToken(ctx context.Context, id int64) (*storage.EnrollmentToken, error) {
+	t, err := s.db.GetEnrollmentToken(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, Errorf(CodeNotFound, "enrollment token not found")
+	}
+	ok, err := s.canAccessINN(ctx, t.INN)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, Errorf(CodeNotFound, "enrollment token not found")
+	}
+	return t, nil
+}
+
+// GetEnrollmentTokenUses returns the registrations made with a token
+func (s *Service) GetEnrollmentTokenUses(ctx context.Context, id int64) ([]*storage.EnrollmentTokenUse, error) {
+	if _, err := s.getEnrollmentToken(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.db.GetEnrollmentTokenUses(ctx, id)
+}
+
+// RevokeEnrollmentToken stops a token from being redeemed. Instances already registered with it are not affected.
+func (s *Service) RevokeEnrollmentToken(ctx context.Context, id int64, ip string) error {
+	t, err := s.getEnrollmentToken(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := s.db.RevokeEnrollmentToken(ctx, id); err != nil {
+		return err
+	}
+	_ = s.db.LogAudit(ctx, "enrollment_token_revoked", t.INN, ip, fmt.Sprintf("token_id=%d, prefix=%s, uses=%d", t.ID, t.TokenPrefix, t.UseCount))
+	return nil
+}
@@ -0,0 +1,154 @@
+package license
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Entitlements are the separately licensed modules of a license: feature flags (bool)
+// and limits (non-negative integers), keyed by name, e.g. {"network_scanner": true, "retention_days": 365}.
+type Entitlements map[string]interface{}
+
+// maxEntitlements bounds the ent claim, which travels in every license token
+const maxEntitlements = 64
+
+var entitlementKeyRe = regexp.MustCompile(`^[a-z][a-z0-9_.-]{0,63}$`)
+
+// encodeEntitlement validates an entitlement and returns its stored JSON form
+func encodeEntitlement(key string, value interface{}) (string, error) {
+	if !entitlementKeyRe.MatchString(key) {
+		return "", Errorf(CodeInvalidRequest, "invalid entitlement key %q: use lowercase letters, digits, '_', '.' or '-'", key)
+	}
+	switch v := value.(type) {
+	case bool:
+		return fmt.Sprintf("%t", v), nil
+	case float64:
+		if v < 0 || v != math.Trunc(v) || v > 1<<53 {
+			return "", Errorf(CodeInvalidRequest, "invalid value for entitlement %s: limits must be non-negative integers", key)
+		}
+		return fmt.Sprintf("%d", int64(v)), nil
+	case int:
+		if v < 0 {
+			return "", Errorf(CodeInvalidRequest, "invalid value for entitlement %s: limits must be non-negative integers", key)
+		}
+		return fmt.Sprintf("%d", v), nil
+	}
+	return "", Errorf(CodeInvalidRequest, "invalid value for entitlement %s: must be a boolean flag or an integer limit", key)
+}
+
+func decodeEntitlements(stored map[string]string) (Entitlements, error) {
+	ents := make(Entitlements, len(stored))
+	for key, raw := range stored {
+		var v interface{}
+		if err := json.Unmarshal([]byte(raw), &v); err != nil {
+			return nil, fmt.Errorf("corrupt entitlement %s: %w", key, err)
+		}
+		ents[key] = v
+	}
+	return ents, nil
+}
+
+// GetEntitlements returns the entitlements of a license
+func (s *Service) GetEntitlements(ctx context.Context, inn string) (Entitlements, error) {
+	if err := s.requireLicense(ctx, inn); err != nil {
+		return nil, err
+	}
+	return s.loadEntitlements(ctx, inn)
+}
+
+func (s *Service) loadEntitlements(ctx context.Context, inn string) (Entitlements, error) {
+	stored, err := s.db.GetEntitlements(ctx, inn)
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntitlements(stored)
+}
+
+// SetEntitlements replaces all entitlements of a license.
+// Instances pick up the change with their next heartbeat.
+func (s *Service) SetEntitlements(ctx context.Context, inn string, ents Entitlements, ip string) error {
+	if err := s.requireLicense(ctx, inn); err != nil {
+		return err
+	}
+	if len(ents) > maxEntitlements {
+		return Errorf(CodeInvalidRequest, "too many entitlements: at most %d allowed", maxEntitlements)
+	}
+	stored := make(map[string]string, len(ents))
+	for key, value := range ents {
+		raw, err := encodeEntitlement(key, value)
+		if err != nil {
+			return err
+		}
+		stored[key] = raw
+	}
+	if err := s.db.ReplaceEntitlements(ctx, inn, stored); err != nil {
+		return err
+	}
+	_ = s.db.LogAudit(ctx, "entitlements_updated", inn, ip, formatEntitlements(stored))
+	return nil
+}
+
+// SetEntitlement creates or updates one entitlement of a license
+func (s *Service) SetEntitlement(ctx context.Context, inn, key string, value interface{}, ip string) error {
+	if err := s.requireLicense(ctx, inn); err != nil {
+		return err
+	}
+	raw, err := encodeEntitlement(key, value)
+	if err != nil {
+		return err
+	}
+	stored, err := s.db.GetEntitlements(ctx, inn)
+	if err != nil {
+		return err
+	}
+	if _, exists := stored[key]; !exists && len(stored) >= maxEntitlements {
+		return Errorf(CodeInvalidRequest, "too many entitlements: at most %d allowed", maxEntitlements)
+	}
+	if err := s.db.SetEntitlement(ctx, inn, key, raw); err != nil {
+		return err
+	}
+	_ = s.db.LogAudit(ctx, "entitlement_set", inn, ip, fmt.Sprintf("%s=%s", key, raw))
+	return nil
+}
+
+// DeleteEntitlement removes one entitlement of a license
+func (s *Service) DeleteEntitlement(ctx context.Context, inn, key, ip string) error {
+	if err := s.requireLicense(ctx, inn); err != nil {
+		return err
+	}
+	if err := s.db.DeleteEntitlement(ctx, inn, key); err != nil {
+		return err
+	}
+	_ = s.db.LogAudit(ctx, "entitlement_deleted", inn, ip, "key="+key)
+	return nil
+}
+
+func (s *Service) requireLicense(ctx context.Context, inn string) error {
+	lic, err := s.db.GetLicenseByINN(ctx, inn)
+	if err != nil {
+		return err
+	}
+	if lic == nil {
+		return Errorf(CodeLicenseNotFound, "license not found for INN %s", inn)
+	}
+	return nil
+}
+
+// formatEntitlements renders stored entitlements for audit details, in key order
+func formatEntitlements(stored map[string]string) string {
+	keys := make([]string, 0, len(stored))
+	for key := range stored {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key + "=" + stored[key]
+	}
+	return "entitlements=" + strings.Join(parts, ",")
+}
@@ -0,0 +1,107 @@
+package license
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// Error codes sent to clients in the "code" field of an error response. They are part of
+// the API contract: messages may be reworded at any time, codes may not.
+const (
+	CodeInvalidRequest = "invalid_request"
+	CodeUnauthorized   = "unauthorized"
+	CodeForbidden      = "forbidden"
+	CodeNotFound       = "not_found"
+	CodeConflict       = "conflict"
+	CodeRateLimited    = "rate_limited"
+	CodeNotSupported   = "not_supported"
+	CodeInternal       = "internal_error"
+
+	CodeLicenseNotFound        = "license_not_found"
+	CodeLicenseInactive        = "license_inactive" // revoked or suspended by an admin
+	CodeLicenseExpired         = "license_expired"  // past its term and grace period
+	CodeSlotLimitExceeded      = "slot_limit_exceeded"
+	CodeSlotPoolExceeded       = "slot_pool_exceeded" // a partner has no slots left in its pool
+	CodeEnrollmentTokenInvalid = "enrollment_token_invalid"
+	CodeCertificateRequired    = "certificate_required"
+	CodeCertificateNotBound    = "certificate_not_bound"
+	CodeCertificateINNMismatch = "certificate_inn_mismatch"
+	CodeCertificateInactive    = "certificate_inactive" // revoked, suspended or superseded
+	CodeHardwareMismatch       = "hardware_mismatch"
+)
+
+// Error is a refusal of the license service. Callers branch on Code, never on the message.
+type Error struct {
+	Code   string // one of the Code* constants
+	Reason string // metrics reason, or why a license was revoked; defaults to Code
+	Msg    string
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+// Is reports errors of the same code as equal, so errors.Is(err, ErrLicenseExpired)
+// holds for every expired license whatever its message says
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// reason returns the metrics reason of the refusal
+func (e *Error) reason() string {
+	if e.Reason != "" {
+		return e.Reason
+	}
+	return e.Code
+}
+
+// Errorf returns an *Error with the given code and formatted message
+func Errorf(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Sentinels to test service errors against with errors.Is
+var (
+	ErrInvalidRequest         = &Error{Code: CodeInvalidRequest, Msg: "invalid request"}
+	ErrNotFound               = &Error{Code: CodeNotFound, Msg: "not found"}
+	ErrConflict               = &Error{Code: CodeConflict, Msg: "conflict"}
+	ErrLicenseNotFound        = &Error{Code: CodeLicenseNotFound, Msg: "license not found"}
+	ErrLicenseInactive        = &Error{Code: CodeLicenseInactive, Msg: "license is not active"}
+	ErrLicenseExpired         = &Error{Code: CodeLicenseExpired, Msg: "license expired"}
+	ErrSlotLimitExceeded      = &Error{Code: CodeSlotLimitExceeded, Msg: "slot limit exceeded"}
+	ErrSlotPoolExceeded       = &Error{Code: CodeSlotPoolExceeded, Msg: "partner slot pool exceeded"}
+	ErrEnrollmentTokenInvalid = &Error{Code: CodeEnrollmentTokenInvalid, Msg: "invalid enrollment token"}
+	ErrCertificateNotBound    = &Error{Code: CodeCertificateNotBound, Msg: "client certificate not bound to any license"}
+	ErrCertificateInactive    = &Error{Code: CodeCertificateInactive, Msg: "client certificate binding is not active"}
+	ErrHardwareMismatch       = &Error{Code: CodeHardwareMismatch, Msg: "hardware fingerprint mismatch"}
+)
+
+// ErrorCode returns the code of err: the code of a service *Error, not_found, conflict,
+// invalid_request or slot_pool_exceeded for storage refusals, and internal_error for anything else (database, signing, I/O)
+func ErrorCode(err error) string {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, storage.ErrNotFound):
+		return CodeNotFound
+	case errors.Is(err, storage.ErrConflict):
+		return CodeConflict
+	case errors.Is(err, storage.ErrInvalid):
+		return CodeInvalidRequest
+	case errors.Is(err, storage.ErrSlotPoolExceeded):
+		return CodeSlotPoolExceeded
+	}
+	return CodeInternal
+}
+
+// isRevocation tells whether a refusal means the license or certificate can no longer be used,
+// which a heartbeat reports as a revocation notice rather than an error
+func isRevocation(e *Error) bool {
+	switch e.Code {
+	case CodeLicenseInactive, CodeLicenseExpired, CodeCertificateInactive:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package license
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/audit"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// Hardware transfer statuses
+const (
+	TransferPending  = "pending"
+	TransferApproved = "approved"
+	TransferRejected = "rejected"
+)
+
+// DefaultTransferQuota is how many hardware transfers a license gets approved automatically per year
+const DefaultTransferQuota = 2
+
+// TransferQuotaEntitlement is the limit entitlement that overrides the transfer quota of a license
+const TransferQuotaEntitlement = "hardware_transfers_per_year"
+
+// transferQuotaPeriod is the rolling window the transfer quota applies to
+const transferQuotaPeriod = 365 * 24 * time.Hour
+
+// autoApprover is the decided_by of transfers approved within the quota
+const autoApprover = "auto"
+
+// SetTransferQuota sets how many hardware transfers per year are approved without an admin.
+// 0 sends every transfer to an admin.
+func (s *Service) SetTransferQuota(n int) {
+	s.transferQuota = n
+}
+
+// checkHardware binds an instance to the machine it first activates on and refuses
+// any other machine until a hardware transfer moves the binding.
+func (s *Service) checkHardware(ctx context.Context, binding *storage.ClientCertBinding, fingerprint, ip string) error {
+	if binding.HardwareFingerprint == "" {
+		if err := s.db.BindHardwareFingerprint(ctx, binding.ID, fingerprint); err == nil {
+			binding.HardwareFingerprint = fingerprint
+			_ = s.db.LogAudit(ctx, "hardware_bound", binding.INN, ip, fmt.Sprintf("serial=%s, fp=%s", binding.CertSerial, fingerprint))
+			return nil
+		}
+		// A concurrent activation bound it first
+		current, err := s.db.GetClientCertBinding(ctx, binding.CertFingerprintSHA256)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return ErrCertificateNotBound
+		}
+		binding.HardwareFingerprint = current.HardwareFingerprint
+	}
+	if binding.HardwareFingerprint != fingerprint {
+		return Errorf(CodeHardwareMismatch, "hardware fingerprint mismatch: this instance is bound to another machine, request a hardware transfer")
+	}
+	return nil
+}
+
+// RequestHardwareTransfer asks to move the instance behind certFingerprint from the machine
+// oldFP to newFP. The transfer is approved at once while the license has quota left for the
+// year, otherwise it waits for an admin. Asking again for the same move returns the same transfer,
+// so an instance can poll for the decision.
+func (s *Service) RequestHardwareTransfer(ctx context.Context, certFingerprint, oldFP, newFP, reason, ip string) (*storage.HardwareTransfer, error) {
+	if oldFP == "" || newFP == "" {
+		return nil, Errorf(CodeInvalidRequest, "invalid transfer request: old and new fingerprints are required")
+	}
+	if oldFP == newFP {
+		return nil, Errorf(CodeInvalidRequest, "invalid transfer request: old and new fingerprints are the same")
+	}
+
+	binding, err := s.db.GetClientCertBinding(ctx, certFingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check certificate binding: %w", err)
+	}
+	if binding == nil {
+		return nil, ErrCertificateNotBound
+	}
+	if binding.Status != "active" {
+		return nil, ErrCertificateInactive
+	}
+	inn := binding.INN
+	lic, err := s.db.GetLicenseByINN(ctx, inn)
+	if err != nil {
+		return nil, fmt.Errorf("license check failed: %w", err)
+	}
+	if lic == nil || lic.Status != "active" {
+		return nil, Errorf(CodeLicenseInactive, "no active license found for INN %s", inn)
+	}
+
+	transfers, err := s.db.GetHardwareTransfers(ctx, storage.HardwareTransferFilter{INN: inn})
+	if err != nil {
+		return nil, err
+	}
+	switch binding.HardwareFingerprint {
+	case "":
+		return nil, Errorf(CodeInvalidRequest, "invalid transfer request: this instance is not bound to a machine yet, activate it instead")
+	case newFP:
+		// Already moved: report the transfer that did it
+		for _, t := range transfers {
+			if t.Status == TransferApproved && t.NewFingerprint == newFP {
+				return t, nil
+			}
+		}
+		return nil, Errorf(CodeInvalidRequest, "invalid transfer request: this instance is already bound to the new machine")
+	case oldFP:
+	default:
+		return nil, Errorf(CodeHardwareMismatch, "hardware fingerprint mismatch: this instance is bound to another machine")
+	}
+
+	for _, t := range transfers {
+		if t.Status != TransferPending || t.OldFingerprint != oldFP {
+			continue
+		}
+		if t.NewFingerprint != newFP {
+			return nil, Errorf(CodeConflict, "transfer conflict: another hardware transfer is pending for this machine")
+		}
+		return t, nil
+	}
+
+	t := &storage.HardwareTransfer{
+		INN:            inn,
+		CertSerial:     binding.CertSerial,
+		OldFingerprint: oldFP,
+		NewFingerprint: newFP,
+		Reason:         reason,
+		IPAddress:      ip,
+	}
+	if err := s.db.CreateHardwareTransfer(ctx, t); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "hardware_transfer_requested", inn, ip,
+		fmt.Sprintf("transfer_id=%d, serial=%s, old_fp=%s, new_fp=%s, reason=%s", t.ID, t.CertSerial, oldFP, newFP, reason))
+
+	quota, err := s.transferQuotaOf(ctx, inn)
+	if err != nil {
+		return nil, err
+	}
+	used, err := s.db.CountApprovedHardwareTransfers(ctx, inn, time.Now().Add(-transferQuotaPeriod))
+	if err != nil {
+		return nil, err
+	}
+	if used >= quota {
+		return t, nil
+	}
+
+	note := fmt.Sprintf("within yearly quota: %d of %d used", used+1, quota)
+	if err := s.db.ApproveHardwareTransfer(ctx, t.ID, autoApprover, note, true); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "hardware_transfer_approved", inn, ip, fmt.Sprintf("transfer_id=%d, decided_by=%s, %s", t.ID, autoApprover, note))
+	return s.db.GetHardwareTransfer(ctx, t.ID)
+}
+
+// transferQuotaOf returns the yearly transfer quota of a license
+func (s *Service) transferQuotaOf(ctx context.Context, inn string) (int, error) {
+	ents, err := s.loadEntitlements(ctx, inn)
+	if err != nil {
+		return 0, err
+	}
+	if limit, ok := ents[TransferQuotaEntitlement].(float64); ok {
+		return int(limit), nil
+	}
+	return s.transferQuota, nil
+}
+
+// GetHardwareTransfers returns the matching hardware transfers, newest first.
+// A partner only sees the transfers of its own licenses.
+func (s *Service) GetHardwareTransfers(ctx context.Context, filter storage.HardwareTransferFilter) ([]*storage.HardwareTransfer, error) {
+	transfers, err := s.db.GetHardwareTransfers(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	inns, err := s.partnerINNs(ctx)
+	if err != nil || inns == nil {
+		return transfers, err
+	}
+	var visible []*storage.HardwareTransfer
+	for _, t := range transfers {
+		if inns[t.INN] {
+			visible = append(visible, t)
+		}
+	}
+	return visible, nil
+}
+
+// ApproveHardwareTransfer approves a pending transfer and moves the instance to the new machine
+func (s *Service) ApproveHardwareTransfer(ctx context.Context, id int64, note, ip string) (*storage.HardwareTransfer, error) {
+	t, err := s.getHardwareTransfer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	actor := audit.ActorFromContext(ctx)
+	if err := s.db.ApproveHardwareTransfer(ctx, id, actor, note, false); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "hardware_transfer_approved", t.INN, ip, fmt.Sprintf("transfer_id=%d, decided_by=%s, note=%s", id, actor, note))
+	return s.db.GetHardwareTransfer(ctx, id)
+}
+
+// RejectHardwareTransfer rejects a pending transfer; the instance stays bound to the old machine
+func (s *Service) RejectHardwareTransfer(ctx context.Context, id int64, note, ip string) (*storage.HardwareTransfer, error) {
+	t, err := s.getHardwareTransfer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	actor := audit.ActorFromContext(ctx)
+	if err := s.db.RejectHardwareTransfer(ctx, id, actor, note); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "hardware_transfer_rejected", t.INN, ip, fmt.Sprintf("transfer_id=%d, decided_by=%s, note=%s", id, actor, note))
+	return s.db.GetHardwareTransfer(ctx, id)
+}
+
+func (s *Service) getHardwareTransfer(ctx context.Context, id int64) (*storage.HardwareTransfer, error) {
+	t, err := s.db.GetHardwareTransfer(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, Errorf(CodeNotFound, "hardware transfer not found")
+	}
+	ok, err := s.canAccessINN(ctx, t.INN)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, Errorf(CodeNotFound, "hardware transfer not found")
+	}
+	return t, nil
+}
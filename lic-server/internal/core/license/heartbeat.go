@@ -0,0 +1,116 @@
+package license
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// Heartbeat outcomes
+const (
+	HeartbeatUnchanged = "unchanged" // the presented token still matches the license
+	HeartbeatUpdated   = "updated"   // a new token replaces the presented one
+	HeartbeatRevoked   = "revoked"   // the license or certificate can no longer be used
+)
+
+// HeartbeatRequest identifies the token an instance currently holds
+type HeartbeatRequest struct {
+	TokenID     string // jti of the current token
+	KeyVersion  int    // ver claim of the current token
+	Fingerprint string // hardware fingerprint, used when the token is not known to the server
+	Usage       *UsageReport
+}
+
+// HeartbeatResult tells an instance whether its token is still current
+type HeartbeatResult struct {
+	Status        string // one of the Heartbeat* outcomes
+	LicenseStatus string // active, trial or grace; empty when revoked
+	Token         string // set when Status is HeartbeatUpdated
+	Reason        string // set when Status is HeartbeatRevoked: revoked, suspended, expired, certificate_revoked
+}
+
+// licenseStateHash digests the license fields encoded in a token.
+// A token is stale once the hash of the current license differs from the one it was issued with.
+func licenseStateHash(lic *storage.License, termStatus string, ents Entitlements) string {
+	expiresAt := lic.ExpiresAt
+	if termStatus == StatusGrace {
+		expiresAt = GraceEndsAt(lic)
+	}
+	state := []interface{}{lic.Organization, lic.MaxSlots, termStatus, expiresAt.Unix()}
+	// Licenses without entitlements keep the hash of tokens issued before they existed
+	if len(ents) > 0 {
+		state = append(state, ents)
+	}
+	data, _ := json.Marshal(state)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Heartbeat verifies the certificate's license and compares it with the token the instance holds.
+// It answers unchanged, a freshly signed token when the license or signing key changed,
+// or a revocation notice instead of an error when the license can no longer be used.
+func (s *Service) Heartbeat(ctx context.Context, certFingerprint, ip string, req HeartbeatRequest) (*HeartbeatResult, error) {
+	binding, lic, termStatus, err := s.verifyLicenseByCert(ctx, certFingerprint, ip, req.Usage)
+	if err != nil {
+		var revoked *Error
+		if errors.As(err, &revoked) && isRevocation(revoked) {
+			s.metrics.Heartbeat(HeartbeatRevoked, revoked.reason())
+			return &HeartbeatResult{Status: HeartbeatRevoked, Reason: revoked.reason()}, nil
+		}
+		s.metrics.Heartbeat(ResultFailure, failureReason(err))
+		return nil, err
+	}
+
+	issued, err := s.db.GetIssuedToken(ctx, req.TokenID)
+	if err != nil {
+		s.metrics.Heartbeat(ResultFailure, failureReason(err))
+		return nil, err
+	}
+
+	fingerprint := req.Fingerprint
+	if issued != nil && issued.INN == lic.INN {
+		ents, err := s.loadEntitlements(ctx, lic.INN)
+		if err != nil {
+			s.metrics.Heartbeat(ResultFailure, failureReason(err))
+			return nil, err
+		}
+		// After a hardware transfer the token of the old machine is stale
+		current := issued.KeyVersion == req.KeyVersion &&
+			issued.KeyVersion == s.token.ActiveKeyVersion() &&
+			issued.StateHash == licenseStateHash(lic, termStatus, ents) &&
+			(binding.HardwareFingerprint == "" || issued.Fingerprint == binding.HardwareFingerprint)
+		if current {
+			s.metrics.Heartbeat(HeartbeatUnchanged, "")
+			return &HeartbeatResult{Status: HeartbeatUnchanged, LicenseStatus: termStatus}, nil
+		}
+		fingerprint = issued.Fingerprint
+		if binding.HardwareFingerprint != "" {
+			fingerprint = binding.HardwareFingerprint
+		}
+	} else {
+		if fingerprint == "" {
+			s.metrics.Heartbeat(ResultFailure, "fingerprint_required")
+			return nil, Errorf(CodeInvalidRequest, "fingerprint is required to refresh an unknown token")
+		}
+		if err := s.checkHardware(ctx, binding, fingerprint, ip); err != nil {
+			s.metrics.Heartbeat(ResultFailure, failureReason(err))
+			return nil, err
+		}
+	}
+
+	token, claims, err := s.issueLicenseToken(ctx, lic, termStatus, fingerprint, time.Now())
+	if err != nil {
+		s.metrics.Heartbeat(ResultFailure, failureReason(err))
+		return nil, err
+	}
+	s.metrics.Heartbeat(HeartbeatUpdated, "")
+	_ = s.db.LogAudit(ctx, "token_refreshed", lic.INN, ip, fmt.Sprintf("old_jti=%s, jti=%s, fp=%s", req.TokenID, claims.ID, fingerprint))
+
+	return &HeartbeatResult{Status: HeartbeatUpdated, LicenseStatus: termStatus, Token: token}, nil
+}
@@ -0,0 +1,52 @@
+package license
+
+import "errors"
+
+// Operation results reported to Metrics
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+	ResultOK      = "ok" // plain liveness heartbeat
+)
+
+// Enrollment token kinds reported to Metrics
+const (
+	TokenKindStatic = "static"
+	TokenKindIssued = "issued"
+)
+
+// Metrics receives the outcomes of license operations. Reasons are short,
+// fixed identifiers (e.g. license_expired) so they can be used as metric labels.
+type Metrics interface {
+	Registration(result, reason string)
+	Activation(result, reason string)
+	Heartbeat(result, reason string)
+	CertificateIssued()
+	EnrollmentTokenConsumed(kind string)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Registration(result, reason string)  {}
+func (nopMetrics) Activation(result, reason string)    {}
+func (nopMetrics) Heartbeat(result, reason string)     {}
+func (nopMetrics) CertificateIssued()                  {}
+func (nopMetrics) EnrollmentTokenConsumed(kind string) {}
+
+// SetMetrics makes the service report operation outcomes to m
+func (s *Service) SetMetrics(m Metrics) {
+	if m == nil {
+		m = nopMetrics{}
+	}
+	s.metrics = m
+}
+
+// failureReason returns the metrics reason of an operation error.
+// Errors without one (storage, signing) are internal errors.
+func failureReason(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.reason()
+	}
+	return "internal_error"
+}
@@ -0,0 +1,185 @@
+package license
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/audit"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// Offline activation file formats. licd writes the request, lic-server answers with the response.
+const (
+	OfflineRequestFormat  = "hw-offline-activation-request/v1"
+	OfflineResponseFormat = "hw-offline-activation-response/v1"
+)
+
+// offlineRequestMaxAge bounds how long a request file can travel before it is redeemed
+const offlineRequestMaxAge = 30 * 24 * time.Hour
+
+// OfflineActivationRequest is the file an air-gapped licd produces.
+// Payload is signed exactly as transported, so no canonical JSON form is needed.
+type OfflineActivationRequest struct {
+	Format    string `json:"format"`
+	Payload   string `json:"payload"`    // base64 of the JSON encoded OfflineActivationPayload
+	PublicKey string `json:"public_key"` // PEM, ECDSA P-256 key of the licd instance
+	Signature string `json:"signature"`  // base64 ASN.1 ECDSA signature over SHA-256 of the payload bytes
+}
+
+// OfflineActivationPayload describes the licd instance asking for a license
+type OfflineActivationPayload struct {
+	INN         string    `json:"inn"`
+	Fingerprint string    `json:"fingerprint"`
+	Version     string    `json:"version"`
+	UsedSlots   int       `json:"used_slots"`
+	Nonce       string    `json:"nonce"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OfflineActivationResponse is the file licd imports. The token is a regular signed license token.
+type OfflineActivationResponse struct {
+	Format   string    `json:"format"`
+	INN      string    `json:"inn"`
+	Nonce    string    `json:"nonce"` // echoes the request nonce
+	Token    string    `json:"token"`
+	IssuedAt time.Time `json:"issued_at"`
+}
+
+// verify checks the request signature and returns the payload and the SHA-256 of the signing key
+func (r *OfflineActivationRequest) verify() (*OfflineActivationPayload, string, error) {
+	if r.Format != OfflineRequestFormat {
+		return nil, "", Errorf(CodeInvalidRequest, "invalid activation request: unsupported format %q", r.Format)
+	}
+
+	block, _ := pem.Decode([]byte(r.PublicKey))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, "", Errorf(CodeInvalidRequest, "invalid activation request: malformed public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, "", Errorf(CodeInvalidRequest, "invalid activation request: %v", err)
+	}
+	ecPub, ok := pub.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, "", Errorf(CodeInvalidRequest, "invalid activation request: public key is not ECDSA")
+	}
+
+	payloadBytes, err := base64.StdEncoding.DecodeString(r.Payload)
+	if err != nil {
+		return nil, "", Errorf(CodeInvalidRequest, "invalid activation request: malformed payload")
+	}
+	sig, err := base64.StdEncoding.DecodeString(r.Signature)
+	if err != nil {
+		return nil, "", Errorf(CodeInvalidRequest, "invalid activation request: malformed signature")
+	}
+	digest := sha256.Sum256(payloadBytes)
+	if !ecdsa.VerifyASN1(ecPub, digest[:], sig) {
+		return nil, "", Errorf(CodeInvalidRequest, "invalid activation request: signature does not verify")
+	}
+
+	var payload OfflineActivationPayload
+	if err := json.Unmarshal(payloadBytes, &payload); err != nil {
+		return nil, "", Errorf(CodeInvalidRequest, "invalid activation request: malformed payload")
+	}
+	if payload.INN == "" || payload.Fingerprint == "" || payload.Nonce == "" {
+		return nil, "", Errorf(CodeInvalidRequest, "invalid activation request: inn, fingerprint and nonce are required")
+	}
+
+	return &payload, fmt.Sprintf("%x", sha256.Sum256(block.Bytes)), nil
+}
+
+// ActivateOffline redeems an offline activation request uploaded by an admin and returns
+// the response file for licd. Each request can be redeemed once.
+func (s *Service) ActivateOffline(ctx context.Context, req *OfflineActivationRequest, ip string) (resp *OfflineActivationResponse, err error) {
+	payload, keyFingerprint, err := req.verify()
+	if err != nil {
+		_ = s.db.LogAudit(ctx, "offline_activation_failed", "", ip, err.Error())
+		return nil, err
+	}
+
+	inn := payload.INN
+	defer func() {
+		if err != nil {
+			_ = s.db.LogAudit(ctx, "offline_activation_failed", inn, ip, err.Error())
+		} else {
+			_ = s.db.LogAudit(ctx, "offline_activation", inn, ip, fmt.Sprintf("fp=%s, key=%s, version=%s", payload.Fingerprint, keyFingerprint, payload.Version))
+		}
+	}()
+
+	now := time.Now()
+	if now.Sub(payload.CreatedAt) > offlineRequestMaxAge {
+		return nil, Errorf(CodeInvalidRequest, "activation request expired: created %s", payload.CreatedAt.Format(time.RFC3339))
+	}
+	if payload.CreatedAt.After(now.Add(5 * time.Minute)) {
+		return nil, Errorf(CodeInvalidRequest, "invalid activation request: created in the future")
+	}
+
+	lic, err := s.db.GetLicenseByINN(ctx, inn)
+	if err != nil {
+		return nil, fmt.Errorf("license check failed: %w", err)
+	}
+	if lic == nil {
+		return nil, Errorf(CodeLicenseNotFound, "license not found for INN %s", inn)
+	}
+	if lic.Status != "active" {
+		return nil, Errorf(CodeLicenseInactive, "no active license found for INN %s", inn)
+	}
+	termStatus := TermStatus(lic, now)
+	if termStatus == StatusExpired {
+		return nil, Errorf(CodeLicenseExpired, "license expired for INN %s", inn)
+	}
+
+	// Recording the nonce first is what stops a request file from being redeemed twice,
+	// before any usage or issued token is saved for it
+	activation := &storage.OfflineActivation{
+		INN:            inn,
+		Fingerprint:    payload.Fingerprint,
+		Nonce:          payload.Nonce,
+		KeyFingerprint: keyFingerprint,
+		LicdVersion:    payload.Version,
+		UsedSlots:      payload.UsedSlots,
+		CreatedBy:      audit.ActorFromContext(ctx),
+	}
+	if err = s.db.SaveOfflineActivation(ctx, activation); err != nil {
+		return nil, err
+	}
+	defer func() {
+		// A refused request may be uploaded again once the refusal is resolved
+		if err != nil {
+			_ = s.db.DeleteOfflineActivation(ctx, activation.ID)
+		}
+	}()
+
+	usage := &UsageReport{InstanceID: payload.Fingerprint, UsedSlots: payload.UsedSlots}
+	if _, err = s.checkSeats(ctx, lic, usage, ip); err != nil {
+		return nil, err
+	}
+
+	token, claims, err := s.issueLicenseToken(ctx, lic, termStatus, payload.Fingerprint, now)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.db.SetOfflineActivationToken(ctx, activation.ID, claims.ID); err != nil {
+		return nil, err
+	}
+
+	return &OfflineActivationResponse{
+		Format:   OfflineResponseFormat,
+		INN:      inn,
+		Nonce:    payload.Nonce,
+		Token:    token,
+		IssuedAt: now.UTC(),
+	}, nil
+}
+
+// GetOfflineActivations returns the offline activations redeemed for a license
+func (s *Service) GetOfflineActivations(ctx context.Context, inn string) ([]*storage.OfflineActivation, error) {
+	return s.db.GetOfflineActivations(ctx, inn)
+}
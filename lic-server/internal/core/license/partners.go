@@ -0,0 +1,159 @@
+package license
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/deymonster/lic-server/internal/core/audit"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// Partners are resellers: each owns a set of licenses and hands out slots from a pool the
+// operator allocates to it. Admin calls made with a partner key carry the partner in their
+// context and only see and change that partner's licenses, tokens and audit events.
+
+type partnerKey struct{}
+
+// WithPartner returns a context whose admin calls are confined to the licenses of a partner
+func WithPartner(ctx context.Context, partnerID int64) context.Context {
+	return context.WithValue(ctx, partnerKey{}, partnerID)
+}
+
+// PartnerFromContext returns the partner an admin call is confined to, or 0 for the operator
+func PartnerFromContext(ctx context.Context) int64 {
+	id, _ := ctx.Value(partnerKey{}).(int64)
+	return id
+}
+
+// CreatePartner adds a partner that may allocate up to slotPool slots over its licenses
+func (s *Service) CreatePartner(ctx context.Context, name string, slotPool int, ip string) (*storage.Partner, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, Errorf(CodeInvalidRequest, "partner name is required")
+	}
+	if slotPool < 0 {
+		return nil, Errorf(CodeInvalidRequest, "slot_pool must not be negative")
+	}
+	p, err := s.db.CreatePartner(ctx, name, slotPool, audit.ActorFromContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "partner_created", "", ip, fmt.Sprintf("partner_id=%d, name=%s, slot_pool=%d", p.ID, name, slotPool))
+	return p, nil
+}
+
+func (s *Service) GetPartners(ctx context.Context) ([]*storage.Partner, error) {
+	return s.db.GetAllPartners(ctx)
+}
+
+// GetPartner returns a partner with the slots it has allocated so far
+func (s *Service) GetPartner(ctx context.Context, id int64) (*storage.Partner, error) {
+	p, err := s.db.GetPartner(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return nil, Errorf(CodeNotFound, "partner not found")
+	}
+	return p, nil
+}
+
+// UpdatePartner renames a partner and resizes its slot pool. The pool can't shrink
+// below the slots the partner's licenses already hold.
+func (s *Service) UpdatePartner(ctx context.Context, id int64, name string, slotPool int, ip string) (*storage.Partner, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, Errorf(CodeInvalidRequest, "partner name is required")
+	}
+	if slotPool < 0 {
+		return nil, Errorf(CodeInvalidRequest, "slot_pool must not be negative")
+	}
+
+	if err := s.db.UpdatePartner(ctx, id, name, slotPool); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "partner_updated", "", ip, fmt.Sprintf("partner_id=%d, name=%s, slot_pool=%d", id, name, slotPool))
+	return s.db.GetPartner(ctx, id)
+}
+
+// AssignLicensePartner moves an existing license to a partner, or back to the operator
+// with partnerID 0. The license's slots are taken from the new partner's pool.
+func (s *Service) AssignLicensePartner(ctx context.Context, inn string, partnerID int64, ip string) (*storage.License, error) {
+	lic, err := s.db.GetLicenseByINN(ctx, inn)
+	if err != nil {
+		return nil, fmt.Errorf("license check failed: %w", err)
+	}
+	if lic == nil {
+		return nil, Errorf(CodeLicenseNotFound, "license not found for INN %s", inn)
+	}
+	if err := s.db.SetLicensePartner(ctx, inn, partnerID); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "license_partner_changed", inn, ip, fmt.Sprintf("partner_id=%d", partnerID))
+	return s.reloadLicense(ctx, inn)
+}
+
+// CreatePartnerAdminKey issues an admin key confined to the licenses of a partner.
+// Partner keys can't carry the admin scope.
+func (s *Service) CreatePartnerAdminKey(ctx context.Context, partnerID int64, name string, scopes []string, ip string) (string, *storage.AdminAPIKey, error) {
+	if _, err := s.GetPartner(ctx, partnerID); err != nil {
+		return "", nil, err
+	}
+	for _, scope := range scopes {
+		if scope == ScopeAdmin {
+			return "", nil, Errorf(CodeInvalidRequest, "partner keys can't have the %s scope", ScopeAdmin)
+		}
+	}
+	return s.createAdminKey(ctx, name, scopes, partnerID, ip)
+}
+
+// CheckLicenseAccess refuses admin calls of a partner for licenses it does not own,
+// as if the license did not exist. Operator calls are never refused.
+func (s *Service) CheckLicenseAccess(ctx context.Context, inn string) error {
+	ok, err := s.canAccessINN(ctx, inn)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return Errorf(CodeLicenseNotFound, "license not found for INN %s", inn)
+	}
+	return nil
+}
+
+// canAccessINN reports whether the admin call in ctx may see the license of inn
+func (s *Service) canAccessINN(ctx context.Context, inn string) (bool, error) {
+	partnerID := PartnerFromContext(ctx)
+	if partnerID == 0 {
+		return true, nil
+	}
+	if inn == "" {
+		return false, nil
+	}
+	lic, err := s.db.GetLicenseByINN(ctx, inn)
+	if err != nil {
+		return false, fmt.Errorf("license check failed: %w", err)
+	}
+	return lic != nil && licenseOfPartner(lic, partnerID), nil
+}
+
+// partnerINNs returns the INNs of the partner's licenses, or nil for operator calls
+func (s *Service) partnerINNs(ctx context.Context) (map[string]bool, error) {
+	partnerID := PartnerFromContext(ctx)
+	if partnerID == 0 {
+		return nil, nil
+	}
+	licenses, err := s.db.GetLicensesByPartner(ctx, partnerID)
+	if err != nil {
+		return nil, err
+	}
+	inns := make(map[string]bool, len(licenses))
+	for _, lic := range licenses {
+		inns[lic.INN] = true
+	}
+	return inns, nil
+}
+
+func licenseOfPartner(lic *storage.License, partnerID int64) bool {
+	return lic.PartnerID != nil && *lic.PartnerID == partnerID
+}
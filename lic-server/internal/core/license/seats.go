@@ -0,0 +1,74 @@
+package license
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// usageStaleAfter is how long an instance's usage report counts towards the license.
+// Instances that stop reporting (decommissioned hosts) drop out after this period.
+const usageStaleAfter = 7 * 24 * time.Hour
+
+// UsageReport is the agent count a licd instance sends with activation or heartbeat
+type UsageReport struct {
+	InstanceID string // serial of the client certificate, or the hardware fingerprint of an offline host
+	UsedSlots  int
+}
+
+// bindTo keys the report by the certificate the instance authenticated with, so one
+// registration counts as one instance whatever fingerprint it reports
+func (u *UsageReport) bindTo(binding *storage.ClientCertBinding) {
+	if u != nil && binding != nil {
+		u.InstanceID = binding.CertSerial
+	}
+}
+
+// checkSeats records an optional usage report and refuses when the summed usage of all
+// instances of the license exceeds MaxSlots. A refused report is not recorded.
+// It returns the current total.
+func (s *Service) checkSeats(ctx context.Context, lic *storage.License, usage *UsageReport, ip string) (int, error) {
+	activeSince := time.Now().Add(-usageStaleAfter)
+	if usage != nil {
+		if usage.InstanceID == "" || usage.UsedSlots < 0 {
+			return 0, &Error{Code: CodeInvalidRequest, Reason: "invalid_usage_report", Msg: "invalid usage report"}
+		}
+		reports, err := s.db.GetInstanceUsage(ctx, lic.INN)
+		if err != nil {
+			return 0, err
+		}
+		used := usage.UsedSlots
+		for _, u := range reports {
+			if u.InstanceID != usage.InstanceID && !u.ReportedAt.Before(activeSince) {
+				used += u.UsedSlots
+			}
+		}
+		if used > lic.MaxSlots {
+			return used, s.seatLimitExceeded(ctx, lic, used, ip)
+		}
+		if err := s.db.SaveInstanceUsage(ctx, lic.INN, usage.InstanceID, usage.UsedSlots); err != nil {
+			return 0, err
+		}
+	}
+
+	used, err := s.db.RecalculateUsedSlots(ctx, lic.INN, activeSince)
+	if err != nil {
+		return 0, err
+	}
+	if used > lic.MaxSlots {
+		return used, s.seatLimitExceeded(ctx, lic, used, ip)
+	}
+	return used, nil
+}
+
+func (s *Service) seatLimitExceeded(ctx context.Context, lic *storage.License, used int, ip string) error {
+	_ = s.db.LogAudit(ctx, "seat_limit_exceeded", lic.INN, ip, fmt.Sprintf("used=%d, max=%d", used, lic.MaxSlots))
+	return Errorf(CodeSlotLimitExceeded, "slot limit exceeded: %d of %d agents in use", used, lic.MaxSlots)
+}
+
+// GetInstanceUsage returns the per-instance usage reports of a license
+func (s *Service) GetInstanceUsage(ctx context.Context, inn string) ([]*storage.InstanceUsage, error) {
+	return s.db.GetInstanceUsage(ctx, inn)
+}
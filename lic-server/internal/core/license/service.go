@@ -5,56 +5,131 @@ import (
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"math/big"
 	"time"
 
-	"github.com/deymonster/lic-server/internal/storage/sqlite"
+	"github.com/deymonster/lic-server/internal/infrastructure/crypto"
+	"github.com/deymonster/lic-server/internal/storage"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 // Repository defines the interface for license storage
 type Repository interface {
-	GetLicenseByINN(ctx context.Context, inn string) (*sqlite.License, error)
+	GetLicenseByINN(ctx context.Context, inn string) (*storage.License, error)
 	CreateLicense(ctx context.Context, inn, org string, maxSlots int) error
+	CreateLicenseWithTerm(ctx context.Context, inn, org string, maxSlots int, expiresAt time.Time, isTrial bool, graceDays int) error
+	UpdateLicenseTerm(ctx context.Context, inn string, expiresAt time.Time, isTrial bool, graceDays int) error
 	UpdateLicenseDetails(ctx context.Context, inn, org string, maxSlots int) error
-	GetAllLicenses(ctx context.Context) ([]*sqlite.License, error)
+	GetAllLicenses(ctx context.Context) ([]*storage.License, error)
 	UpdateLicenseStatus(ctx context.Context, inn string, status string) error
-	SaveClientCertBinding(ctx context.Context, binding *sqlite.ClientCertBinding) error
-	GetClientCertBinding(ctx context.Context, fingerprint string) (*sqlite.ClientCertBinding, error)
-	ValidateAndConsumeEnrollmentToken(ctx context.Context, token, inn string) error
-	CreateEnrollmentToken(ctx context.Context, inn string, ttl time.Duration) (string, error)
-	GetAllEnrollmentTokens(ctx context.Context) ([]*sqlite.EnrollmentToken, error)
+	SaveClientCertBinding(ctx context.Context, binding *storage.ClientCertBinding) error
+	GetClientCertBinding(ctx context.Context, fingerprint string) (*storage.ClientCertBinding, error)
+	GetClientCertBindingBySerial(ctx context.Context, serial string) (*storage.ClientCertBinding, error)
+	RevokeClientCertBinding(ctx context.Context, id int64, reason string) error
+	ReplaceClientCertBinding(ctx context.Context, oldID int64, binding *storage.ClientCertBinding) error
+	ConfirmClientCertBinding(ctx context.Context, id int64) error
+	GetRevokedClientCertBindings(ctx context.Context) ([]*storage.ClientCertBinding, error)
+	GetClientCertBindingsByINN(ctx context.Context, inn string) ([]*storage.ClientCertBinding, error)
+	SuspendClientCertBinding(ctx context.Context, id int64) error
+	ReactivateClientCertBinding(ctx context.Context, id int64) error
+	CreateEnrollmentToken(ctx context.Context, tokenHash, tokenPrefix, inn string, maxUses int, expiresAt time.Time, createdBy string) (*storage.EnrollmentToken, error)
+	GetEnrollmentToken(ctx context.Context, id int64) (*storage.EnrollmentToken, error)
+	GetEnrollmentTokenByHash(ctx context.Context, tokenHash string) (*storage.EnrollmentToken, error)
+	ConsumeEnrollmentToken(ctx context.Context, tokenHash, inn string) (*storage.EnrollmentToken, error)
+	RecordEnrollmentTokenUse(ctx context.Context, u *storage.EnrollmentTokenUse) error
+	GetEnrollmentTokenUses(ctx context.Context, tokenID int64) ([]*storage.EnrollmentTokenUse, error)
+	GetAllEnrollmentTokens(ctx context.Context) ([]*storage.EnrollmentToken, error)
+	GetEnrollmentTokensByINN(ctx context.Context, inn string) ([]*storage.EnrollmentToken, error)
+	UpdateEnrollmentTokenLimits(ctx context.Context, id int64, maxUses int, expiresAt time.Time) error
+	RevokeEnrollmentToken(ctx context.Context, id int64) error
 	LogAudit(ctx context.Context, action, inn, ip, details string) error
-	GetAllAuditEvents(ctx context.Context, limit int) ([]*sqlite.AuditEvent, error)
+	QueryAuditEvents(ctx context.Context, filter storage.AuditFilter) ([]*storage.AuditEvent, error)
+	StreamAuditEvents(ctx context.Context, filter storage.AuditFilter, fn func(*storage.AuditEvent) error) error
+	VerifyAuditChain(ctx context.Context) (*storage.AuditChainStatus, error)
+	GetAuditEventHash(ctx context.Context, id int64) (string, error)
+	SaveInstanceUsage(ctx context.Context, inn, instanceID string, usedSlots int) error
+	DeleteInstanceUsage(ctx context.Context, inn, instanceID string) error
+	GetInstanceUsage(ctx context.Context, inn string) ([]*storage.InstanceUsage, error)
+	RecalculateUsedSlots(ctx context.Context, inn string, activeSince time.Time) (int, error)
+	CreateAdminAPIKey(ctx context.Context, name, keyHash, keyPrefix string, scopes []string, partnerID int64, createdBy string) (*storage.AdminAPIKey, error)
+	GetAdminAPIKey(ctx context.Context, id int64) (*storage.AdminAPIKey, error)
+	GetAdminAPIKeyByHash(ctx context.Context, keyHash string) (*storage.AdminAPIKey, error)
+	GetAllAdminAPIKeys(ctx context.Context) ([]*storage.AdminAPIKey, error)
+	RevokeAdminAPIKey(ctx context.Context, id int64) error
+	SaveOfflineActivation(ctx context.Context, a *storage.OfflineActivation) error
+	SetOfflineActivationToken(ctx context.Context, id int64, tokenID string) error
+	DeleteOfflineActivation(ctx context.Context, id int64) error
+	GetOfflineActivations(ctx context.Context, inn string) ([]*storage.OfflineActivation, error)
+	CreateWebhook(ctx context.Context, w *storage.Webhook) error
+	GetWebhook(ctx context.Context, id int64) (*storage.Webhook, error)
+	GetAllWebhooks(ctx context.Context) ([]*storage.Webhook, error)
+	UpdateWebhook(ctx context.Context, w *storage.Webhook) error
+	DeleteWebhook(ctx context.Context, id int64) error
+	GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]*storage.WebhookDelivery, error)
+	GetWebhookDelivery(ctx context.Context, id int64) (*storage.WebhookDelivery, error)
+	GetWebhookAttempts(ctx context.Context, deliveryID int64) ([]*storage.WebhookAttempt, error)
+	RequeueWebhookDelivery(ctx context.Context, id int64) error
+	SaveIssuedToken(ctx context.Context, t *storage.IssuedToken) error
+	GetIssuedToken(ctx context.Context, id string) (*storage.IssuedToken, error)
+	GetEntitlements(ctx context.Context, inn string) (map[string]string, error)
+	ReplaceEntitlements(ctx context.Context, inn string, ents map[string]string) error
+	SetEntitlement(ctx context.Context, inn, key, value string) error
+	DeleteEntitlement(ctx context.Context, inn, key string) error
+	BindHardwareFingerprint(ctx context.Context, bindingID int64, fingerprint string) error
+	CreateHardwareTransfer(ctx context.Context, t *storage.HardwareTransfer) error
+	GetHardwareTransfer(ctx context.Context, id int64) (*storage.HardwareTransfer, error)
+	GetHardwareTransfers(ctx context.Context, filter storage.HardwareTransferFilter) ([]*storage.HardwareTransfer, error)
+	CountApprovedHardwareTransfers(ctx context.Context, inn string, since time.Time) (int, error)
+	ApproveHardwareTransfer(ctx context.Context, id int64, decidedBy, note string, auto bool) error
+	RejectHardwareTransfer(ctx context.Context, id int64, decidedBy, note string) error
+	CreatePartner(ctx context.Context, name string, slotPool int, createdBy string) (*storage.Partner, error)
+	GetPartner(ctx context.Context, id int64) (*storage.Partner, error)
+	GetAllPartners(ctx context.Context) ([]*storage.Partner, error)
+	UpdatePartner(ctx context.Context, id int64, name string, slotPool int) error
+	CreatePartnerLicense(ctx context.Context, partnerID int64, inn, org string, maxSlots int, expiresAt time.Time, isTrial bool, graceDays int) error
+	GetLicensesByPartner(ctx context.Context, partnerID int64) ([]*storage.License, error)
+	SetLicensePartner(ctx context.Context, inn string, partnerID int64) error
 }
 
 // CAService defines the interface for certificate operations
 type CAService interface {
 	SignCSR(csr *x509.CertificateRequest) ([]byte, error)
 	GetCACertPEM() []byte
+	CreateCRL(entries []x509.RevocationListEntry, number *big.Int, nextUpdate time.Time) ([]byte, error)
 }
 
 // TokenService defines the interface for token generation
 type TokenService interface {
-	SignToken(claims jwt.Claims) (string, error)
+	SignToken(claims jwt.Claims) (string, error) // also sets the key version of claims with SetKeyVersion
 	GetPublicKeyPEM() ([]byte, error)
+	ActiveKeyVersion() int
+	RotateKey() (version int, kid string, err error)
+	RetireKey(version int) error
+	PublicJWKS() ([]byte, error)
 }
 
 // Service implements the license business logic
 type Service struct {
-	db          Repository
-	ca          CAService
-	token       TokenService
-	staticToken string
+	db      Repository
+	ca      CAService
+	token   TokenService
+	metrics Metrics
+
+	transferQuota int    // hardware transfers per year approved without an admin
+	backupDir     string // where the database is saved before a restore
 }
 
 // NewService creates a new license service
-func NewService(db Repository, ca CAService, token TokenService, staticToken string) *Service {
+func NewService(db Repository, ca CAService, token TokenService) *Service {
 	return &Service{
-		db:          db,
-		ca:          ca,
-		token:       token,
-		staticToken: staticToken,
+		db:      db,
+		ca:      ca,
+		token:   token,
+		metrics: nopMetrics{},
+
+		transferQuota: DefaultTransferQuota,
 	}
 }
 
@@ -62,103 +137,206 @@ func NewService(db Repository, ca CAService, token TokenService, staticToken str
 func (s *Service) RegisterInstance(ctx context.Context, inn, token string, csrPEM []byte, ip string) ([]byte, []byte, []byte, error) {
 	_ = s.db.LogAudit(ctx, "register_attempt", inn, ip, "started")
 
-	// 1. Validate Enrollment Token
-	// Check static token first if configured
-	if s.staticToken != "" && token == s.staticToken {
-		// Valid static token, skip DB validation/consumption
-		_ = s.db.LogAudit(ctx, "register_token_valid", inn, ip, "static_token_used")
-	} else {
-		if err := s.db.ValidateAndConsumeEnrollmentToken(ctx, token, inn); err != nil {
-			_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("token_error: %v", err))
-			return nil, nil, nil, fmt.Errorf("enrollment token validation failed: %w", err)
+	// 1. Validate and consume one use of the enrollment token
+	enrollment, err := s.db.ConsumeEnrollmentToken(ctx, hashToken(token), inn)
+	if err != nil {
+		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("token_error: %v", err))
+		s.metrics.Registration(ResultFailure, "token_error")
+		if ErrorCode(err) != CodeInternal {
+			return nil, nil, nil, Errorf(CodeEnrollmentTokenInvalid, "enrollment token validation failed: %v", err)
 		}
+		return nil, nil, nil, fmt.Errorf("enrollment token validation failed: %w", err)
+	}
+	if enrollment.INN == "" {
+		_ = s.db.LogAudit(ctx, "register_token_valid", inn, ip, fmt.Sprintf("static_token_used: token_id=%d, uses=%d", enrollment.ID, enrollment.UseCount))
+		s.metrics.EnrollmentTokenConsumed(TokenKindStatic)
+	} else {
+		s.metrics.EnrollmentTokenConsumed(TokenKindIssued)
 	}
 
 	// 2. Verify INN exists
 	lic, err := s.db.GetLicenseByINN(ctx, inn)
 	if err != nil {
 		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("inn_lookup_error: %v", err))
+		s.metrics.Registration(ResultFailure, "inn_lookup_error")
 		return nil, nil, nil, fmt.Errorf("license check failed: %w", err)
 	}
 	if lic == nil {
 		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, "license_not_found")
-		return nil, nil, nil, fmt.Errorf("license not found for INN %s", inn)
+		s.metrics.Registration(ResultFailure, "license_not_found")
+		return nil, nil, nil, Errorf(CodeLicenseNotFound, "license not found for INN %s", inn)
 	}
 
-	// 3. Parse CSR
+	// 3. Parse, verify and sign CSR
+	certPEM, binding, err := s.issueClientCert(inn, csrPEM)
+	if err != nil {
+		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("issue_error: %v", err))
+		s.metrics.Registration(ResultFailure, "issue_error")
+		return nil, nil, nil, err
+	}
+
+	// 4. Save Certificate Binding
+	if saveErr := s.db.SaveClientCertBinding(ctx, binding); saveErr != nil {
+		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("binding_save_error: %v", saveErr))
+		s.metrics.Registration(ResultFailure, "binding_save_error")
+		return nil, nil, nil, fmt.Errorf("failed to save certificate binding: %w", saveErr)
+	}
+
+	// 5. Get Public Key
+	pubKeyPEM, err := s.token.GetPublicKeyPEM()
+	if err != nil {
+		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("pubkey_error: %v", err))
+		s.metrics.Registration(ResultFailure, "pubkey_error")
+		return nil, nil, nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	_ = s.db.RecordEnrollmentTokenUse(ctx, &storage.EnrollmentTokenUse{
+		TokenID:    enrollment.ID,
+		INN:        inn,
+		CertSerial: binding.CertSerial,
+		IPAddress:  ip,
+	})
+	_ = s.db.LogAudit(ctx, "register_success", inn, ip, fmt.Sprintf("serial=%s, token_id=%d", binding.CertSerial, enrollment.ID))
+	s.metrics.Registration(ResultSuccess, "")
+	return certPEM, s.ca.GetCACertPEM(), pubKeyPEM, nil
+}
+
+// RenewInstance issues a fresh client certificate to an instance that authenticated
+// with its current (active) certificate. The new certificate is bound to the same INN;
+// the old binding is superseded once the new certificate is first used (see ConfirmRenewal).
+func (s *Service) RenewInstance(ctx context.Context, certFingerprint string, csrPEM []byte, ip string) ([]byte, []byte, []byte, error) {
+	// 1. Resolve the current binding
+	current, err := s.db.GetClientCertBinding(ctx, certFingerprint)
+	if err != nil {
+		_ = s.db.LogAudit(ctx, "renew_failed", "unknown", ip, fmt.Sprintf("binding_lookup_error: %v", err))
+		return nil, nil, nil, fmt.Errorf("failed to check certificate binding: %w", err)
+	}
+	if current == nil {
+		_ = s.db.LogAudit(ctx, "renew_failed", "unknown", ip, "no_binding")
+		return nil, nil, nil, ErrCertificateNotBound
+	}
+	inn := current.INN
+	_ = s.db.LogAudit(ctx, "renew_attempt", inn, ip, fmt.Sprintf("serial=%s", current.CertSerial))
+
+	if current.Status != "active" {
+		_ = s.db.LogAudit(ctx, "renew_failed", inn, ip, fmt.Sprintf("binding_status: %s", current.Status))
+		return nil, nil, nil, ErrCertificateInactive
+	}
+
+	// 2. Verify license status
+	lic, err := s.db.GetLicenseByINN(ctx, inn)
+	if err != nil {
+		_ = s.db.LogAudit(ctx, "renew_failed", inn, ip, fmt.Sprintf("license_lookup_error: %v", err))
+		return nil, nil, nil, fmt.Errorf("license check failed: %w", err)
+	}
+	if lic == nil || lic.Status != "active" {
+		_ = s.db.LogAudit(ctx, "renew_failed", inn, ip, "license_not_active")
+		return nil, nil, nil, Errorf(CodeLicenseInactive, "no active license found for INN %s", inn)
+	}
+
+	// 3. Parse, verify and sign CSR
+	certPEM, binding, err := s.issueClientCert(inn, csrPEM)
+	if err != nil {
+		_ = s.db.LogAudit(ctx, "renew_failed", inn, ip, fmt.Sprintf("issue_error: %v", err))
+		return nil, nil, nil, err
+	}
+
+	// 4. Bind the new certificate to the same machine; the old one stays valid until then
+	binding.HardwareFingerprint = current.HardwareFingerprint
+	if err := s.db.ReplaceClientCertBinding(ctx, current.ID, binding); err != nil {
+		_ = s.db.LogAudit(ctx, "renew_failed", inn, ip, fmt.Sprintf("binding_save_error: %v", err))
+		return nil, nil, nil, fmt.Errorf("failed to save certificate binding: %w", err)
+	}
+
+	pubKeyPEM, err := s.token.GetPublicKeyPEM()
+	if err != nil {
+		_ = s.db.LogAudit(ctx, "renew_failed", inn, ip, fmt.Sprintf("pubkey_error: %v", err))
+		return nil, nil, nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	_ = s.db.LogAudit(ctx, "renew_success", inn, ip, fmt.Sprintf("old_serial=%s, new_serial=%s", current.CertSerial, binding.CertSerial))
+	return certPEM, s.ca.GetCACertPEM(), pubKeyPEM, nil
+}
+
+// ConfirmRenewal supersedes the binding a renewed certificate replaces, on the first
+// request made with the new certificate. Bindings that replace nothing are left as they are.
+func (s *Service) ConfirmRenewal(ctx context.Context, binding *storage.ClientCertBinding, ip string) error {
+	if binding.ReplacesID == 0 {
+		return nil
+	}
+	// The usage of the replaced certificate (and of renewals that were never picked up)
+	// moves to the new one with its next report instead of counting twice until it goes stale
+	previous, err := s.db.GetClientCertBindingsByINN(ctx, binding.INN)
+	if err != nil {
+		return fmt.Errorf("failed to confirm renewal: %w", err)
+	}
+	if err := s.db.ConfirmClientCertBinding(ctx, binding.ID); err != nil {
+		return fmt.Errorf("failed to confirm renewal: %w", err)
+	}
+	for _, b := range previous {
+		if b.ID != binding.ID && (b.ID == binding.ReplacesID || b.ReplacesID == binding.ReplacesID) {
+			_ = s.db.DeleteInstanceUsage(ctx, binding.INN, b.CertSerial)
+		}
+	}
+	binding.ReplacesID = 0
+	_ = s.db.LogAudit(ctx, "renew_confirmed", binding.INN, ip, fmt.Sprintf("serial=%s", binding.CertSerial))
+	return nil
+}
+
+// issueClientCert parses and verifies a PEM CSR, signs it with the CA and returns
+// the certificate together with an (unsaved) active binding for inn
+func (s *Service) issueClientCert(inn string, csrPEM []byte) ([]byte, *storage.ClientCertBinding, error) {
 	block, _ := pem.Decode(csrPEM)
 	if block == nil {
-		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, "invalid_pem")
-		return nil, nil, nil, fmt.Errorf("failed to decode CSR PEM")
+		return nil, nil, Errorf(CodeInvalidRequest, "failed to decode CSR PEM")
 	}
 	csr, err := x509.ParseCertificateRequest(block.Bytes)
 	if err != nil {
-		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("csr_parse_error: %v", err))
-		return nil, nil, nil, fmt.Errorf("failed to parse CSR: %w", err)
+		return nil, nil, Errorf(CodeInvalidRequest, "failed to parse CSR: %v", err)
 	}
-
-	// 4. Verify CSR signature
 	if sigErr := csr.CheckSignature(); sigErr != nil {
-		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("csr_sig_error: %v", sigErr))
-		return nil, nil, nil, fmt.Errorf("invalid CSR signature: %w", sigErr)
+		return nil, nil, Errorf(CodeInvalidRequest, "invalid CSR signature: %v", sigErr)
 	}
 
-	// 5. Sign CSR
 	certPEM, err := s.ca.SignCSR(csr)
 	if err != nil {
-		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("sign_error: %v", err))
-		return nil, nil, nil, fmt.Errorf("failed to sign CSR: %w", err)
+		return nil, nil, fmt.Errorf("failed to sign CSR: %w", err)
 	}
+	s.metrics.CertificateIssued()
 
-	// 6. Save Certificate Binding
 	// Parse the signed certificate to get details for binding
 	block, _ = pem.Decode(certPEM)
 	if block == nil {
-		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, "cert_decode_error")
-		return nil, nil, nil, fmt.Errorf("failed to decode signed certificate")
+		return nil, nil, fmt.Errorf("failed to decode signed certificate")
 	}
 	cert, err := x509.ParseCertificate(block.Bytes)
 	if err != nil {
-		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("cert_parse_error: %v", err))
-		return nil, nil, nil, fmt.Errorf("failed to parse signed certificate: %w", err)
+		return nil, nil, fmt.Errorf("failed to parse signed certificate: %w", err)
 	}
 
-	fingerprint := fmt.Sprintf("%x", sha256.Sum256(cert.Raw))
-
-	binding := &sqlite.ClientCertBinding{
+	binding := &storage.ClientCertBinding{
 		INN:                   inn,
 		CertSerial:            cert.SerialNumber.String(),
-		CertFingerprintSHA256: fingerprint,
+		CertFingerprintSHA256: fmt.Sprintf("%x", sha256.Sum256(cert.Raw)),
 		SubjectCN:             cert.Subject.CommonName,
 		IssuedAt:              cert.NotBefore,
 		ExpiresAt:             cert.NotAfter,
 		Status:                "active",
 	}
-
-	if saveErr := s.db.SaveClientCertBinding(ctx, binding); saveErr != nil {
-		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("binding_save_error: %v", saveErr))
-		return nil, nil, nil, fmt.Errorf("failed to save certificate binding: %w", saveErr)
-	}
-
-	// 7. Get Public Key
-	pubKeyPEM, err := s.token.GetPublicKeyPEM()
-	if err != nil {
-		_ = s.db.LogAudit(ctx, "register_failed", inn, ip, fmt.Sprintf("pubkey_error: %v", err))
-		return nil, nil, nil, fmt.Errorf("failed to get public key: %w", err)
-	}
-
-	_ = s.db.LogAudit(ctx, "register_success", inn, ip, fmt.Sprintf("serial=%s", cert.SerialNumber))
-	return certPEM, s.ca.GetCACertPEM(), pubKeyPEM, nil
+	return certPEM, binding, nil
 }
 
 // ActivateInstance verifies the license and generates a JWT token for the agent
-func (s *Service) ActivateInstance(ctx context.Context, inn, fingerprint, version, certFingerprint string, ip string) (token string, err error) {
+func (s *Service) ActivateInstance(ctx context.Context, inn, fingerprint, version, certFingerprint string, ip string, usage *UsageReport) (token string, err error) {
 	_ = s.db.LogAudit(ctx, "activate_attempt", inn, ip, fmt.Sprintf("fp=%s", fingerprint))
+	reason := "internal_error" // set by every refusal below
 	defer func() {
 		if err != nil {
 			_ = s.db.LogAudit(ctx, "activate_failed", inn, ip, err.Error())
+			s.metrics.Activation(ResultFailure, reason)
 		} else {
 			_ = s.db.LogAudit(ctx, "activate_success", inn, ip, "token_issued")
+			s.metrics.Activation(ResultSuccess, "")
 		}
 	}()
 
@@ -167,8 +345,21 @@ func (s *Service) ActivateInstance(ctx context.Context, inn, fingerprint, versio
 	if err != nil {
 		return "", fmt.Errorf("license check failed: %w", err)
 	}
-	if lic == nil || lic.Status != "active" {
-		return "", fmt.Errorf("no active license found for INN %s", inn)
+	if lic == nil {
+		reason = "license_not_found"
+		return "", Errorf(CodeLicenseNotFound, "license not found for INN %s", inn)
+	}
+	if lic.Status != "active" {
+		reason = "license_not_active"
+		return "", Errorf(CodeLicenseInactive, "no active license found for INN %s", inn)
+	}
+
+	// 1.1 Verify license term (trial/grace are still usable, expired is not)
+	now := time.Now()
+	termStatus := TermStatus(lic, now)
+	if termStatus == StatusExpired {
+		reason = "license_expired"
+		return "", Errorf(CodeLicenseExpired, "license expired for INN %s", inn)
 	}
 
 	// 2. Verify Certificate Binding
@@ -178,27 +369,60 @@ func (s *Service) ActivateInstance(ctx context.Context, inn, fingerprint, versio
 			return "", fmt.Errorf("failed to check certificate binding: %w", bindErr)
 		}
 		if binding == nil {
-			return "", fmt.Errorf("client certificate not bound to any license")
+			reason = "no_binding"
+			return "", ErrCertificateNotBound
 		}
 		if binding.INN != inn {
-			return "", fmt.Errorf("client certificate bound to different INN")
+			reason = "binding_inn_mismatch"
+			return "", Errorf(CodeCertificateINNMismatch, "client certificate bound to different INN")
 		}
 		if binding.Status != "active" {
-			return "", fmt.Errorf("client certificate binding is not active")
+			reason = "binding_not_active"
+			return "", ErrCertificateInactive
+		}
+
+		// 2.1 Verify the instance runs on the machine it is bound to
+		if err = s.checkHardware(ctx, binding, fingerprint, ip); err != nil {
+			reason = failureReason(err)
+			return "", err
 		}
+		usage.bindTo(binding)
+	}
+
+	// 2.2 Verify seat usage across all instances of this license
+	if _, err = s.checkSeats(ctx, lic, usage, ip); err != nil {
+		reason = failureReason(err)
+		return "", err
+	}
+
+	// 3. Generate and sign the token
+	token, _, err = s.issueLicenseToken(ctx, lic, termStatus, fingerprint, now)
+	return token, err
+}
+
+// issueLicenseToken signs a license token for a usable license bound to the hardware fingerprint
+// and records it, so later heartbeats can tell whether the token is still current
+func (s *Service) issueLicenseToken(ctx context.Context, lic *storage.License, termStatus, fingerprint string, now time.Time) (string, *LicenseClaims, error) {
+	ents, err := s.loadEntitlements(ctx, lic.INN)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// During the grace period the token stays valid until the grace period ends
+	expiresAt := lic.ExpiresAt
+	if termStatus == StatusGrace {
+		expiresAt = GraceEndsAt(lic)
 	}
 
-	// 3. Generate Claims
-	now := time.Now()
 	claims := &LicenseClaims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			ID:        fmt.Sprintf("%d", now.UnixNano()), // Unique ID for the token
-			Subject:   inn,
+			Subject:   lic.INN,
 			Issuer:    "lic-server",
 			Audience:  jwt.ClaimStrings{"licd-agent"},
 			IssuedAt:  jwt.NewNumericDate(now),
 			NotBefore: jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(lic.ExpiresAt), // Token valid until license expires
+			ExpiresAt: jwt.NewNumericDate(expiresAt), // Token valid until license (or its grace period) expires
 		},
 		LicenseID:       fmt.Sprintf("%d", lic.ID),
 		INN:             lic.INN,
@@ -206,56 +430,228 @@ func (s *Service) ActivateInstance(ctx context.Context, inn, fingerprint, versio
 		MaxAgents:       lic.MaxSlots,
 		FingerprintHash: fingerprint,
 		ActivationDate:  now.Format(time.RFC3339),
-		KeyVersion:      1,
-		Status:          lic.Status,
+		Status:          termStatus,
+		Entitlements:    ents,
+	}
+
+	token, err := s.token.SignToken(claims)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to sign token: %w", err)
 	}
 
-	// 4. Sign Token
-	token, err = s.token.SignToken(claims)
+	err = s.db.SaveIssuedToken(ctx, &storage.IssuedToken{
+		ID:          claims.ID,
+		INN:         lic.INN,
+		Fingerprint: fingerprint,
+		KeyVersion:  claims.KeyVersion,
+		StateHash:   licenseStateHash(lic, termStatus, ents),
+		IssuedAt:    now,
+		ExpiresAt:   expiresAt,
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to sign token: %w", err)
+		return "", nil, err
 	}
+	return token, claims, nil
+}
 
-	return token, nil
+// VerifyLicenseByCert checks if the client certificate is bound to a valid active license.
+// An optional usage report is recorded and checked against MaxSlots.
+// On success it returns the effective license state: active, trial or grace.
+func (s *Service) VerifyLicenseByCert(ctx context.Context, certFingerprint, ip string, usage *UsageReport) (string, error) {
+	_, _, termStatus, err := s.verifyLicenseByCert(ctx, certFingerprint, ip, usage)
+	if err != nil {
+		s.metrics.Heartbeat(ResultFailure, failureReason(err))
+		return "", err
+	}
+	s.metrics.Heartbeat(ResultOK, "")
+	return termStatus, nil
 }
 
-// VerifyLicenseByCert checks if the client certificate is bound to a valid active license
-func (s *Service) VerifyLicenseByCert(ctx context.Context, certFingerprint, ip string) error {
+// verifyLicenseByCert runs the heartbeat checks and returns the binding and license behind the certificate.
+// A license or binding that can no longer be used is reported as a revocation (see isRevocation).
+func (s *Service) verifyLicenseByCert(ctx context.Context, certFingerprint, ip string, usage *UsageReport) (*storage.ClientCertBinding, *storage.License, string, error) {
 	// 1. Verify Certificate Binding
 	binding, err := s.db.GetClientCertBinding(ctx, certFingerprint)
 	if err != nil {
 		_ = s.db.LogAudit(ctx, "heartbeat_failed", "unknown", ip, fmt.Sprintf("binding_lookup_error: %v", err))
-		return fmt.Errorf("failed to check certificate binding: %w", err)
+		return nil, nil, "", fmt.Errorf("failed to check certificate binding: %w", err)
 	}
 	if binding == nil {
 		_ = s.db.LogAudit(ctx, "heartbeat_failed", "unknown", ip, "no_binding")
-		return fmt.Errorf("client certificate not bound to any license")
+		return nil, nil, "", &Error{Code: CodeCertificateNotBound, Reason: "no_binding", Msg: "client certificate not bound to any license"}
 	}
 
 	// 2. Verify License Status
 	lic, err := s.db.GetLicenseByINN(ctx, binding.INN)
 	if err != nil {
 		_ = s.db.LogAudit(ctx, "heartbeat_failed", binding.INN, ip, fmt.Sprintf("license_lookup_error: %v", err))
-		return fmt.Errorf("license check failed: %w", err)
+		return nil, nil, "", fmt.Errorf("license check failed: %w", err)
 	}
 	if lic == nil {
 		_ = s.db.LogAudit(ctx, "heartbeat_failed", binding.INN, ip, "license_not_found")
-		return fmt.Errorf("license not found for INN %s", binding.INN)
+		return nil, nil, "", Errorf(CodeLicenseNotFound, "license not found for INN %s", binding.INN)
 	}
 	if lic.Status != "active" {
 		_ = s.db.LogAudit(ctx, "heartbeat_failed", binding.INN, ip, fmt.Sprintf("license_status: %s", lic.Status))
-		return fmt.Errorf("license is not active")
+		return nil, nil, "", &Error{Code: CodeLicenseInactive, Reason: lic.Status, Msg: "license is not active"}
+	}
+	termStatus := TermStatus(lic, time.Now())
+	if termStatus == StatusExpired {
+		_ = s.db.LogAudit(ctx, "heartbeat_failed", binding.INN, ip, "license_expired")
+		return nil, nil, "", &Error{Code: CodeLicenseExpired, Reason: StatusExpired, Msg: "license expired"}
 	}
 
 	// 3. Verify Binding Status
 	if binding.Status != "active" {
 		_ = s.db.LogAudit(ctx, "heartbeat_failed", binding.INN, ip, "binding_not_active")
-		return fmt.Errorf("client certificate binding is not active")
+		return nil, nil, "", &Error{Code: CodeCertificateInactive, Reason: "certificate_" + binding.Status, Msg: "client certificate binding is not active"}
+	}
+
+	// 4. Verify seat usage
+	usage.bindTo(binding)
+	if _, err := s.checkSeats(ctx, lic, usage, ip); err != nil {
+		_ = s.db.LogAudit(ctx, "heartbeat_failed", binding.INN, ip, err.Error())
+		return nil, nil, "", err
 	}
 
 	// Log success only occasionally or debug? For audit, maybe "heartbeat" is too noisy?
 	// Let's not log success for every heartbeat to avoid flooding DB.
 	// Or maybe log only errors.
+	return binding, lic, termStatus, nil
+}
+
+// crlValidity is how long a published CRL stays valid (its NextUpdate)
+const crlValidity = 24 * time.Hour
+
+// crlReasonCodes maps revocation reasons to RFC 5280 CRLReason codes
+var crlReasonCodes = map[string]int{
+	"unspecified":            0,
+	"key_compromise":         1,
+	"affiliation_changed":    3,
+	"superseded":             4,
+	"cessation_of_operation": 5,
+	"certificate_hold":       6,
+}
+
+// GetClientCertBinding returns the binding of the certificate with the given fingerprint,
+// or nil if it has none. Callers treat any status other than active as revoked.
+func (s *Service) GetClientCertBinding(ctx context.Context, certFingerprint string) (*storage.ClientCertBinding, error) {
+	binding, err := s.db.GetClientCertBinding(ctx, certFingerprint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check certificate binding: %w", err)
+	}
+	return binding, nil
+}
+
+// RevokeClientCert revokes a single client certificate binding, looked up by serial or fingerprint
+func (s *Service) RevokeClientCert(ctx context.Context, serial, fingerprint, reason, ip string) (*storage.ClientCertBinding, error) {
+	var binding *storage.ClientCertBinding
+	var err error
+	switch {
+	case serial != "":
+		binding, err = s.db.GetClientCertBindingBySerial(ctx, serial)
+	case fingerprint != "":
+		binding, err = s.db.GetClientCertBinding(ctx, fingerprint)
+	default:
+		return nil, Errorf(CodeInvalidRequest, "serial or fingerprint is required")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up certificate binding: %w", err)
+	}
+	if binding == nil {
+		return nil, Errorf(CodeNotFound, "client certificate binding not found")
+	}
+	if binding.Status == "revoked" {
+		return nil, Errorf(CodeConflict, "client certificate already revoked")
+	}
+
+	if reason == "" {
+		reason = "unspecified"
+	}
+	if _, ok := crlReasonCodes[reason]; !ok {
+		return nil, Errorf(CodeInvalidRequest, "unknown revocation reason: %s", reason)
+	}
+
+	if err := s.db.RevokeClientCertBinding(ctx, binding.ID, reason); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "cert_revoked", binding.INN, ip, fmt.Sprintf("serial=%s, reason=%s", binding.CertSerial, reason))
+
+	now := time.Now()
+	binding.Status = "revoked"
+	binding.RevokedAt = &now
+	binding.RevocationReason = reason
+	return binding, nil
+}
+
+// GetCRL builds a DER-encoded CRL covering every non-active, unexpired client certificate
+func (s *Service) GetCRL(ctx context.Context) ([]byte, error) {
+	bindings, err := s.db.GetRevokedClientCertBindings(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]x509.RevocationListEntry, 0, len(bindings))
+	for _, b := range bindings {
+		serial, ok := new(big.Int).SetString(b.CertSerial, 10)
+		if !ok {
+			continue
+		}
+
+		revokedAt := b.CreatedAt
+		if b.RevokedAt != nil {
+			revokedAt = *b.RevokedAt
+		}
+
+		reason := b.RevocationReason
+		switch b.Status {
+		case "revoked":
+		case "suspended":
+			reason = "certificate_hold"
+		default:
+			// Other statuses (e.g. superseded) map onto the reason of the same name
+			reason = b.Status
+		}
+
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: revokedAt,
+			ReasonCode:     crlReasonCodes[reason],
+		})
+	}
+
+	// A nanosecond timestamp is a cheap, monotonically increasing CRL number
+	now := time.Now()
+	return s.ca.CreateCRL(entries, big.NewInt(now.UnixNano()), now.Add(crlValidity))
+}
+
+// GetJWKS returns the public signing keys as a JWKS document
+func (s *Service) GetJWKS() ([]byte, error) {
+	return s.token.PublicJWKS()
+}
+
+// RotateSigningKey makes a freshly generated key the active token signing key
+func (s *Service) RotateSigningKey(ctx context.Context, ip string) (int, string, error) {
+	version, kid, err := s.token.RotateKey()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to rotate signing key: %w", err)
+	}
+	_ = s.db.LogAudit(ctx, "signing_key_rotated", "", ip, fmt.Sprintf("version=%d, kid=%s", version, kid))
+	return version, kid, nil
+}
+
+// RetireSigningKey drops a verification-only key from the published key set
+func (s *Service) RetireSigningKey(ctx context.Context, version int, ip string) error {
+	if err := s.token.RetireKey(version); err != nil {
+		switch {
+		case errors.Is(err, crypto.ErrKeyNotFound):
+			return Errorf(CodeNotFound, "signing key version %d not found", version)
+		case errors.Is(err, crypto.ErrActiveKey):
+			return Errorf(CodeConflict, "cannot retire the active signing key")
+		}
+		return fmt.Errorf("failed to retire signing key: %w", err)
+	}
+	_ = s.db.LogAudit(ctx, "signing_key_retired", "", ip, fmt.Sprintf("version=%d", version))
 	return nil
 }
 
@@ -266,32 +662,152 @@ func (s *Service) LogAudit(ctx context.Context, action, inn, ip, details string)
 
 // --- Admin Methods ---
 
-func (s *Service) GetAllLicenses(ctx context.Context) ([]*sqlite.License, error) {
-	return s.db.GetAllLicenses(ctx)
+// GetAllLicenses returns every license, or only those of the partner making the call
+func (s *Service) GetAllLicenses(ctx context.Context) ([]*storage.License, error) {
+	var licenses []*storage.License
+	var err error
+	if partnerID := PartnerFromContext(ctx); partnerID != 0 {
+		licenses, err = s.db.GetLicensesByPartner(ctx, partnerID)
+	} else {
+		licenses, err = s.db.GetAllLicenses(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, lic := range licenses {
+		lic.TermStatus = TermStatus(lic, now)
+	}
+	return licenses, nil
+}
+
+// CreateLicense creates a license. A zero expiresAt means the default term
+// (30 days for trials, one year otherwise). A partner creates licenses it owns, within its
+// slot pool; it can't take over an existing license.
+func (s *Service) CreateLicense(ctx context.Context, inn, org string, maxSlots int, expiresAt time.Time, isTrial bool, graceDays int, ip string) error {
+	if expiresAt.IsZero() {
+		days := defaultTermDays
+		if isTrial {
+			days = defaultTrialDays
+		}
+		expiresAt = time.Now().AddDate(0, 0, days)
+	}
+	if !expiresAt.After(time.Now()) {
+		return Errorf(CodeInvalidRequest, "expires_at must be in the future")
+	}
+	partnerID := PartnerFromContext(ctx)
+	if partnerID != 0 {
+		// The license's slots come out of the partner's pool
+		if err := s.db.CreatePartnerLicense(ctx, partnerID, inn, org, maxSlots, expiresAt, isTrial, graceDays); err != nil {
+			return err
+		}
+		_ = s.db.LogAudit(ctx, "license_created", inn, ip, fmt.Sprintf("org=%s, maxSlots=%d, expires_at=%s, trial=%t, partner_id=%d", org, maxSlots, expiresAt.Format(time.RFC3339), isTrial, partnerID))
+		return nil
+	}
+	if err := s.db.CreateLicenseWithTerm(ctx, inn, org, maxSlots, expiresAt, isTrial, graceDays); err != nil {
+		return err
+	}
+	_ = s.db.LogAudit(ctx, "license_created", inn, ip, fmt.Sprintf("org=%s, maxSlots=%d, expires_at=%s, trial=%t", org, maxSlots, expiresAt.Format(time.RFC3339), isTrial))
+	return nil
+}
+
+// ExtendLicense pushes the expiry of a license back by the given number of days.
+// The trial flag and grace period are kept as they are.
+func (s *Service) ExtendLicense(ctx context.Context, inn string, days int, ip string) (*storage.License, error) {
+	lic, err := s.db.GetLicenseByINN(ctx, inn)
+	if err != nil {
+		return nil, fmt.Errorf("license check failed: %w", err)
+	}
+	if lic == nil {
+		return nil, Errorf(CodeLicenseNotFound, "license not found for INN %s", inn)
+	}
+
+	expiresAt := lic.ExpiresAt.AddDate(0, 0, days)
+	if err := s.db.UpdateLicenseTerm(ctx, inn, expiresAt, lic.IsTrial, lic.GraceDays); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "license_extended", inn, ip, fmt.Sprintf("days=%d, expires_at=%s", days, expiresAt.Format(time.RFC3339)))
+
+	return s.reloadLicense(ctx, inn)
 }
 
-func (s *Service) CreateLicense(ctx context.Context, inn, org string, maxSlots int) error {
-	return s.db.CreateLicense(ctx, inn, org, maxSlots)
+// RenewLicense starts a new paid term. A zero expiresAt renews for termDays counted
+// from the current expiry (or from now, if the license has already expired).
+// A negative graceDays keeps the current grace period. Renewing always converts
+// a trial into a regular license.
+func (s *Service) RenewLicense(ctx context.Context, inn string, expiresAt time.Time, termDays, graceDays int, ip string) (*storage.License, error) {
+	lic, err := s.db.GetLicenseByINN(ctx, inn)
+	if err != nil {
+		return nil, fmt.Errorf("license check failed: %w", err)
+	}
+	if lic == nil {
+		return nil, Errorf(CodeLicenseNotFound, "license not found for INN %s", inn)
+	}
+
+	if expiresAt.IsZero() {
+		if termDays <= 0 {
+			termDays = defaultTermDays
+		}
+		start := lic.ExpiresAt
+		if now := time.Now(); start.Before(now) {
+			start = now
+		}
+		expiresAt = start.AddDate(0, 0, termDays)
+	}
+	if graceDays < 0 {
+		graceDays = lic.GraceDays
+	}
+
+	if err := s.db.UpdateLicenseTerm(ctx, inn, expiresAt, false, graceDays); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "license_renewed", inn, ip, fmt.Sprintf("expires_at=%s, grace_days=%d, was_trial=%t", expiresAt.Format(time.RFC3339), graceDays, lic.IsTrial))
+
+	return s.reloadLicense(ctx, inn)
 }
 
-// UpdateLicenseDetails updates the organization and max slots of a license
-func (s *Service) UpdateLicenseDetails(ctx context.Context, inn, org string, maxSlots int) error {
-	_ = s.db.LogAudit(ctx, "update_license_details", inn, "admin", fmt.Sprintf("org=%s, maxSlots=%d", org, maxSlots))
-	return s.db.UpdateLicenseDetails(ctx, inn, org, maxSlots)
+// reloadLicense fetches a license after a change and fills in its term status
+func (s *Service) reloadLicense(ctx context.Context, inn string) (*storage.License, error) {
+	lic, err := s.db.GetLicenseByINN(ctx, inn)
+	if err != nil {
+		return nil, err
+	}
+	if lic == nil {
+		return nil, Errorf(CodeLicenseNotFound, "license not found for INN %s", inn)
+	}
+	lic.TermStatus = TermStatus(lic, time.Now())
+	return lic, nil
 }
 
-func (s *Service) UpdateLicenseStatus(ctx context.Context, inn, status string) error {
-	return s.db.UpdateLicenseStatus(ctx, inn, status)
+// UpdateLicenseDetails updates the organization and max slots of a license.
+// More slots for a partner's license come out of the partner's pool.
+func (s *Service) UpdateLicenseDetails(ctx context.Context, inn, org string, maxSlots int, ip string) error {
+	if err := s.db.UpdateLicenseDetails(ctx, inn, org, maxSlots); err != nil {
+		return err
+	}
+	_ = s.db.LogAudit(ctx, "update_license_details", inn, ip, fmt.Sprintf("org=%s, maxSlots=%d", org, maxSlots))
+	return nil
 }
 
-func (s *Service) CreateEnrollmentToken(ctx context.Context, inn string, ttl time.Duration) (string, error) {
-	return s.db.CreateEnrollmentToken(ctx, inn, ttl)
+// UpdateLicenseStatus sets the status of a license. Revoking a partner's license returns
+// its slots to the partner's pool, so bringing it back takes them from the pool again.
+func (s *Service) UpdateLicenseStatus(ctx context.Context, inn, status, ip string) error {
+	if err := s.db.UpdateLicenseStatus(ctx, inn, status); err != nil {
+		return err
+	}
+	_ = s.db.LogAudit(ctx, "update_license_status", inn, ip, fmt.Sprintf("status=%s", status))
+	return nil
 }
 
-func (s *Service) GetAllEnrollmentTokens(ctx context.Context) ([]*sqlite.EnrollmentToken, error) {
-	return s.db.GetAllEnrollmentTokens(ctx)
+// QueryAuditEvents returns one page of matching audit events, newest first.
+// A partner only sees the events of its own licenses.
+func (s *Service) QueryAuditEvents(ctx context.Context, filter storage.AuditFilter) ([]*storage.AuditEvent, error) {
+	filter.PartnerID = PartnerFromContext(ctx)
+	return s.db.QueryAuditEvents(ctx, filter)
 }
 
-func (s *Service) GetAllAuditEvents(ctx context.Context, limit int) ([]*sqlite.AuditEvent, error) {
-	return s.db.GetAllAuditEvents(ctx, limit)
+// ExportAuditEvents streams every matching audit event to fn, newest first
+func (s *Service) ExportAuditEvents(ctx context.Context, filter storage.AuditFilter, fn func(*storage.AuditEvent) error) error {
+	filter.PartnerID = PartnerFromContext(ctx)
+	return s.db.StreamAuditEvents(ctx, filter, fn)
 }
@@ -0,0 +1,38 @@
+package license
+
+import (
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// defaultTrialDays is the trial length used when an admin does not pass an explicit term
+const defaultTrialDays = 30
+
+// defaultTermDays is the length of a paid license term when none is given
+const defaultTermDays = 365
+
+// TermStatus derives the effective state of a license from its admin status and term.
+// A license that is not "active" keeps its admin status (revoked, suspended, ...).
+// Otherwise it is "trial" or "active" until ExpiresAt, "grace" for GraceDays after
+// that, and "expired" afterwards.
+func TermStatus(lic *storage.License, now time.Time) string {
+	if lic.Status != StatusActive {
+		return lic.Status
+	}
+	if now.Before(lic.ExpiresAt) {
+		if lic.IsTrial {
+			return StatusTrial
+		}
+		return StatusActive
+	}
+	if now.Before(GraceEndsAt(lic)) {
+		return StatusGrace
+	}
+	return StatusExpired
+}
+
+// GraceEndsAt returns the moment the grace period of a license ends
+func GraceEndsAt(lic *storage.License) time.Time {
+	return lic.ExpiresAt.AddDate(0, 0, lic.GraceDays)
+}
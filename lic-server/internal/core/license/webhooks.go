@@ -0,0 +1,153 @@
+package license
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/deymonster/lic-server/internal/core/audit"
+	"github.com/deymonster/lic-server/internal/core/webhook"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// webhookSecretPrefix marks webhook signing secrets, like adminKeyPrefix does for admin keys
+const webhookSecretPrefix = "whsec_"
+
+func validateWebhook(rawURL string, eventTypes []string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Errorf(CodeInvalidRequest, "invalid webhook url: must be an absolute http(s) URL")
+	}
+	if len(eventTypes) == 0 {
+		return Errorf(CodeInvalidRequest, "at least one event type is required")
+	}
+	for _, t := range eventTypes {
+		if !webhook.ValidEventType(t) {
+			return Errorf(CodeInvalidRequest, "invalid event type: %s", t)
+		}
+	}
+	return nil
+}
+
+// CreateWebhook subscribes an endpoint to audit events. The signing secret is returned only once.
+func (s *Service) CreateWebhook(ctx context.Context, name, rawURL string, eventTypes []string, ip string) (string, *storage.Webhook, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, Errorf(CodeInvalidRequest, "webhook name is required")
+	}
+	if err := validateWebhook(rawURL, eventTypes); err != nil {
+		return "", nil, err
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	w := &storage.Webhook{
+		Name:       name,
+		URL:        rawURL,
+		Secret:     fmt.Sprintf("%s%x", webhookSecretPrefix, b),
+		EventTypes: eventTypes,
+		Active:     true,
+		CreatedBy:  audit.ActorFromContext(ctx),
+	}
+	if err := s.db.CreateWebhook(ctx, w); err != nil {
+		return "", nil, err
+	}
+	_ = s.db.LogAudit(ctx, "webhook_created", "", ip, fmt.Sprintf("name=%s, url=%s, events=%s", name, rawURL, strings.Join(eventTypes, ",")))
+
+	return w.Secret, w, nil
+}
+
+func (s *Service) GetAllWebhooks(ctx context.Context) ([]*storage.Webhook, error) {
+	return s.db.GetAllWebhooks(ctx)
+}
+
+// UpdateWebhook changes a webhook. Nil arguments keep the current value.
+// Pausing a webhook keeps its queued deliveries until it is reactivated.
+func (s *Service) UpdateWebhook(ctx context.Context, id int64, rawURL *string, eventTypes []string, active *bool, ip string) (*storage.Webhook, error) {
+	w, err := s.db.GetWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil {
+		return nil, Errorf(CodeNotFound, "webhook not found")
+	}
+
+	if rawURL != nil {
+		w.URL = *rawURL
+	}
+	if eventTypes != nil {
+		w.EventTypes = eventTypes
+	}
+	if active != nil {
+		w.Active = *active
+	}
+	if err := validateWebhook(w.URL, w.EventTypes); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.UpdateWebhook(ctx, w); err != nil {
+		return nil, err
+	}
+	_ = s.db.LogAudit(ctx, "webhook_updated", "", ip, fmt.Sprintf("name=%s, url=%s, events=%s, active=%t", w.Name, w.URL, strings.Join(w.EventTypes, ","), w.Active))
+	return w, nil
+}
+
+// DeleteWebhook removes a webhook and drops its undelivered events
+func (s *Service) DeleteWebhook(ctx context.Context, id int64, ip string) error {
+	w, err := s.db.GetWebhook(ctx, id)
+	if err != nil {
+		return err
+	}
+	if w == nil {
+		return Errorf(CodeNotFound, "webhook not found")
+	}
+	if err := s.db.DeleteWebhook(ctx, id); err != nil {
+		return err
+	}
+	_ = s.db.LogAudit(ctx, "webhook_deleted", "", ip, fmt.Sprintf("name=%s", w.Name))
+	return nil
+}
+
+// GetWebhookDeliveries returns the recent deliveries of a webhook
+func (s *Service) GetWebhookDeliveries(ctx context.Context, id int64, limit int) ([]*storage.WebhookDelivery, error) {
+	w, err := s.db.GetWebhook(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if w == nil {
+		return nil, Errorf(CodeNotFound, "webhook not found")
+	}
+	return s.db.GetWebhookDeliveries(ctx, id, limit)
+}
+
+// GetWebhookAttempts returns the delivery log of one delivery
+func (s *Service) GetWebhookAttempts(ctx context.Context, deliveryID int64) ([]*storage.WebhookAttempt, error) {
+	d, err := s.db.GetWebhookDelivery(ctx, deliveryID)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, Errorf(CodeNotFound, "webhook delivery not found")
+	}
+	return s.db.GetWebhookAttempts(ctx, deliveryID)
+}
+
+// RedeliverWebhook queues a delivery for an immediate new attempt
+func (s *Service) RedeliverWebhook(ctx context.Context, deliveryID int64, ip string) error {
+	d, err := s.db.GetWebhookDelivery(ctx, deliveryID)
+	if err != nil {
+		return err
+	}
+	if d == nil {
+		return Errorf(CodeNotFound, "webhook delivery not found")
+	}
+	if err := s.db.RequeueWebhookDelivery(ctx, deliveryID); err != nil {
+		return err
+	}
+	_ = s.db.LogAudit(ctx, "webhook_redelivered", "", ip, fmt.Sprintf("delivery=%d, event=%d", d.ID, d.EventID))
+	return nil
+}
@@ -0,0 +1,337 @@
+// Package webhook delivers audit events to admin-managed HTTP endpoints.
+//
+// The audit log doubles as the outbox: the dispatcher follows it with a persistent
+// cursor, queues one delivery per matching webhook and retries failed deliveries with
+// exponential backoff. Every attempt is recorded in the delivery log.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// Headers sent with every delivery
+const (
+	SignatureHeader = "X-Webhook-Signature" // t=<unix time>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+const (
+	maxAttempts  = 10
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = 6 * time.Hour
+	batchSize    = 100
+	errorMaxSize = 500
+
+	// A batch takes at most batchSize*sendTimeout/deliveryWorkers when deliveries are spread
+	// over webhooks, and batchSize*sendTimeout when they all go to one slow endpoint.
+	// The Postgres claim lease must outlast the latter.
+	sendTimeout     = 10 * time.Second
+	deliveryWorkers = 8
+)
+
+// Store is the storage the dispatcher needs
+type Store interface {
+	GetWebhookCursor(ctx context.Context) (int64, error)
+	QueryAuditEvents(ctx context.Context, filter storage.AuditFilter) ([]*storage.AuditEvent, error)
+	GetAllWebhooks(ctx context.Context) ([]*storage.Webhook, error)
+	EnqueueWebhookDeliveries(ctx context.Context, deliveries []*storage.WebhookDelivery, cursor int64) error
+	GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]*storage.WebhookDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, d *storage.WebhookDelivery, a *storage.WebhookAttempt) error
+}
+
+// Event is the JSON body of a delivery
+type Event struct {
+	ID        int64     `json:"id"`
+	Type      string    `json:"type"`
+	INN       string    `json:"inn,omitempty"`
+	IPAddress string    `json:"ip_address,omitempty"`
+	Actor     string    `json:"actor,omitempty"`
+	Details   string    `json:"details,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Dispatcher queues and sends webhook deliveries
+type Dispatcher struct {
+	store    Store
+	client   *http.Client
+	interval time.Duration
+}
+
+// NewDispatcher creates a dispatcher that polls for new events and due retries every interval
+func NewDispatcher(store Store, interval time.Duration) *Dispatcher {
+	return &Dispatcher{
+		store:    store,
+		client:   &http.Client{Timeout: sendTimeout},
+		interval: interval,
+	}
+}
+
+// Run processes webhooks until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		if err := d.RunOnce(ctx); err != nil {
+			log.Printf("webhooks: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce queues deliveries for new audit events and sends every due delivery
+func (d *Dispatcher) RunOnce(ctx context.Context) error {
+	if err := d.schedule(ctx); err != nil {
+		return err
+	}
+	return d.deliverDue(ctx)
+}
+
+// schedule turns audit events after the cursor into deliveries for matching webhooks
+func (d *Dispatcher) schedule(ctx context.Context) error {
+	for {
+		cursor, err := d.store.GetWebhookCursor(ctx)
+		if err != nil {
+			return err
+		}
+		events, err := d.store.QueryAuditEvents(ctx, storage.AuditFilter{After: cursor, OldestFirst: true, Limit: batchSize})
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		webhooks, err := d.store.GetAllWebhooks(ctx)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		var deliveries []*storage.WebhookDelivery
+		for _, e := range events {
+			for _, w := range webhooks {
+				if !w.Active || !Matches(w.EventTypes, e.Action) {
+					continue
+				}
+				payload, err := json.Marshal(Event{
+					ID:        e.ID,
+					Type:      e.Action,
+					INN:       e.INN,
+					IPAddress: e.IPAddress,
+					Actor:     e.Actor,
+					Details:   e.Details,
+					CreatedAt: e.CreatedAt,
+				})
+				if err != nil {
+					return err
+				}
+				deliveries = append(deliveries, &storage.WebhookDelivery{
+					WebhookID:     w.ID,
+					EventID:       e.ID,
+					EventType:     e.Action,
+					Payload:       string(payload),
+					NextAttemptAt: now,
+				})
+			}
+		}
+		if err := d.store.EnqueueWebhookDeliveries(ctx, deliveries, events[len(events)-1].ID); err != nil {
+			return err
+		}
+		if len(events) < batchSize {
+			return nil
+		}
+	}
+}
+
+// deliverDue sends pending deliveries whose next attempt is due
+func (d *Dispatcher) deliverDue(ctx context.Context) error {
+	due, err := d.store.GetDueWebhookDeliveries(ctx, time.Now(), batchSize)
+	if err != nil {
+		return err
+	}
+	if len(due) == 0 {
+		return nil
+	}
+	webhooks, err := d.store.GetAllWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+	byID := make(map[int64]*storage.Webhook, len(webhooks))
+	for _, w := range webhooks {
+		byID[w.ID] = w
+	}
+
+	// Each webhook gets its own queue, so one slow endpoint holds up only its own deliveries.
+	// Within a queue deliveries stay serial and in event order.
+	var order []int64
+	queues := make(map[int64][]*storage.WebhookDelivery)
+	for _, delivery := range due {
+		w := byID[delivery.WebhookID]
+		if w == nil || !w.Active {
+			// Paused webhooks keep their queue until they are reactivated
+			continue
+		}
+		if _, ok := queues[w.ID]; !ok {
+			order = append(order, w.ID)
+		}
+		queues[w.ID] = append(queues[w.ID], delivery)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, deliveryWorkers)
+	for _, id := range order {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(w *storage.Webhook, queue []*storage.WebhookDelivery) {
+			defer func() { <-sem; wg.Done() }()
+			for _, delivery := range queue {
+				if err := d.deliver(ctx, w, delivery); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}(byID[id], queues[id])
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// deliver makes one attempt and records its outcome
+func (d *Dispatcher) deliver(ctx context.Context, w *storage.Webhook, delivery *storage.WebhookDelivery) error {
+	start := time.Now()
+	statusCode, sendErr := d.send(ctx, w, delivery, start)
+
+	delivery.Attempts++
+	delivery.LastStatusCode = statusCode
+	attempt := &storage.WebhookAttempt{
+		Attempt:    delivery.Attempts,
+		StatusCode: statusCode,
+		DurationMS: time.Since(start).Milliseconds(),
+	}
+
+	switch {
+	case sendErr == nil:
+		now := time.Now()
+		delivery.Status = storage.WebhookDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	default:
+		attempt.Error = truncate(sendErr.Error(), errorMaxSize)
+		delivery.LastError = attempt.Error
+		if delivery.Attempts >= maxAttempts {
+			delivery.Status = storage.WebhookFailed
+			log.Printf("webhooks: giving up on delivery %d to %s after %d attempts: %v", delivery.ID, w.Name, delivery.Attempts, sendErr)
+		} else {
+			delivery.NextAttemptAt = time.Now().Add(Backoff(delivery.Attempts))
+		}
+	}
+	return d.store.RecordWebhookAttempt(ctx, delivery, attempt)
+}
+
+func (d *Dispatcher) send(ctx context.Context, w *storage.Webhook, delivery *storage.WebhookDelivery, now time.Time) (int, error) {
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "lic-server-webhooks")
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(SignatureHeader, Sign(w.Secret, now.Unix(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header value for a body sent at timestamp.
+// Receivers recompute HMAC-SHA256(secret, "<t>.<body>") and should reject stale timestamps.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// Backoff returns the delay before the next attempt after the given number of failed attempts
+func Backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return delay
+}
+
+// Matches reports whether an audit action passes a webhook's event filter.
+// A filter entry is an exact action, a prefix ending in "*" or "*" for everything.
+func Matches(eventTypes []string, action string) bool {
+	for _, t := range eventTypes {
+		if t == action || t == "*" {
+			return true
+		}
+		if strings.HasSuffix(t, "*") && strings.HasPrefix(action, strings.TrimSuffix(t, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidEventType reports whether t is a well-formed filter entry
+func ValidEventType(t string) bool {
+	name := strings.TrimSuffix(t, "*")
+	if name == "" {
+		return t == "*"
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
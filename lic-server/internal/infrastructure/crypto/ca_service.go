@@ -1,6 +1,7 @@
 package crypto
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
@@ -15,77 +16,171 @@ import (
 	"time"
 )
 
+// CAService issues client and server certificates from an intermediate CA. The root CA,
+// which licd pins, only signs intermediates, so its key can be kept offline; a stolen
+// intermediate is replaced by signing a new one, without touching any licd.
 type CAService struct {
-	caCert *x509.Certificate
-	caKey  interface{}
+	root   *x509.Certificate // trust anchor pinned by licd
+	issuer *x509.Certificate // intermediate that signs certificates and CRLs
+	signer KeySigner         // private key of issuer
 }
 
-func NewCAService(certPath, keyPath string) (*CAService, error) {
+// CAConfig locates the root certificate and the intermediate with its key
+type CAConfig struct {
+	RootCertPath string
+	// RootKeyPath is only read to bootstrap a missing intermediate; once it is signed the
+	// root key belongs offline, see cmd/ca
+	RootKeyPath          string
+	IntermediateCertPath string
+	Signer               SignerConfig // intermediate key
+}
+
+// Validity of intermediates signed by the root
+const intermediateValidity = 5 * 365 * 24 * time.Hour
+
+// NewCAService loads the root and the intermediate. A missing root is generated, and a
+// missing intermediate is signed with the root key if that is at hand (development setups
+// and upgrades from a single-tier CA); without the root key it must be signed offline.
+func NewCAService(cfg CAConfig) (*CAService, error) {
 	// Check if CA exists, if not generate
-	if _, err := os.Stat(certPath); os.IsNotExist(err) {
-		if err := GenerateCA(certPath, keyPath); err != nil {
+	if _, err := os.Stat(cfg.RootCertPath); os.IsNotExist(err) {
+		if err := GenerateCA(cfg.RootCertPath, cfg.RootKeyPath); err != nil {
 			return nil, fmt.Errorf("failed to generate CA: %w", err)
 		}
 	}
-
-	// 1. Load CA Cert
-	certPEM, err := os.ReadFile(certPath)
+	root, err := LoadCertificate(cfg.RootCertPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read CA cert: %w", err)
+		return nil, fmt.Errorf("failed to load root CA cert: %w", err)
 	}
-	block, _ := pem.Decode(certPEM)
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode CA cert PEM")
+
+	// A fresh file key is generated when there is none
+	if cfg.Signer.Backend == "" || cfg.Signer.Backend == SignerFile {
+		if _, statErr := os.Stat(cfg.Signer.KeyPath); os.IsNotExist(statErr) {
+			if _, statErr := os.Stat(cfg.IntermediateCertPath); os.IsNotExist(statErr) {
+				if _, err := GenerateKeyFile(cfg.Signer.KeyPath); err != nil {
+					return nil, fmt.Errorf("failed to generate intermediate CA key: %w", err)
+				}
+			}
+		}
 	}
-	cert, err := x509.ParseCertificate(block.Bytes)
+	signer, err := OpenSigner(cfg.Signer)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse CA cert: %w", err)
-	}
-
-	// 2. Load CA Key
-	keyPEM, err := os.ReadFile(keyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read CA key: %w", err)
-	}
-	keyBlock, _ := pem.Decode(keyPEM)
-	if keyBlock == nil {
-		return nil, fmt.Errorf("failed to decode CA key PEM")
-	}
-
-	var key interface{}
-	switch keyBlock.Type {
-	case "EC PRIVATE KEY":
-		key, err = x509.ParseECPrivateKey(keyBlock.Bytes)
-	case "RSA PRIVATE KEY":
-		key, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
-	case "PRIVATE KEY":
-		key, err = x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
-	default:
-		// Try to parse as generic if type is unexpected but valid
-		if k, err2 := x509.ParsePKCS8PrivateKey(keyBlock.Bytes); err2 == nil {
-			key = k
-			err = nil
-		} else if k, err2 := x509.ParseECPrivateKey(keyBlock.Bytes); err2 == nil {
-			key = k
-			err = nil
-		} else if k, err2 := x509.ParsePKCS1PrivateKey(keyBlock.Bytes); err2 == nil {
-			key = k
-			err = nil
-		} else {
-			return nil, fmt.Errorf("unknown private key type: %s", keyBlock.Type)
+		return nil, fmt.Errorf("failed to open intermediate CA key: %w", err)
+	}
+
+	s, err := newCAService(cfg, root, signer)
+	if err != nil {
+		signer.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func newCAService(cfg CAConfig, root *x509.Certificate, signer KeySigner) (*CAService, error) {
+	if _, err := os.Stat(cfg.IntermediateCertPath); os.IsNotExist(err) {
+		rootKey, keyErr := LoadPrivateKey(cfg.RootKeyPath)
+		if keyErr != nil {
+			return nil, fmt.Errorf("intermediate CA cert %s is missing and the root key is not available to sign it (%v); sign one offline with cmd/ca", cfg.IntermediateCertPath, keyErr)
+		}
+		der, err := SignIntermediate(root, rootKey, signer.Public(), pkix.Name{
+			CommonName:   "HW-Monitor Issuing CA",
+			Organization: []string{"HW Monitor"},
+		}, intermediateValidity)
+		if err != nil {
+			return nil, err
+		}
+		if err := writePEM(cfg.IntermediateCertPath, [][]byte{der}); err != nil {
+			return nil, err
 		}
 	}
 
+	issuer, err := LoadCertificate(cfg.IntermediateCertPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+		return nil, fmt.Errorf("failed to load intermediate CA cert: %w", err)
+	}
+	if !issuer.IsCA {
+		return nil, fmt.Errorf("intermediate CA cert %s is not a CA certificate", cfg.IntermediateCertPath)
+	}
+	if err := issuer.CheckSignatureFrom(root); err != nil {
+		return nil, fmt.Errorf("intermediate CA cert %s is not signed by the root CA: %w", cfg.IntermediateCertPath, err)
+	}
+	pub, ok := issuer.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(signer.Public()) {
+		return nil, fmt.Errorf("intermediate CA key does not match the certificate %s", cfg.IntermediateCertPath)
 	}
 
 	return &CAService{
-		caCert: cert,
-		caKey:  key,
+		root:   root,
+		issuer: issuer,
+		signer: signer,
 	}, nil
 }
 
+// Close releases the intermediate key
+func (s *CAService) Close() error {
+	return s.signer.Close()
+}
+
+// SignIntermediate lets the root CA sign an intermediate for pub and returns its DER.
+// The intermediate may only sign end-entity certificates and does not outlive the root.
+func SignIntermediate(root *x509.Certificate, rootKey crypto.Signer, pub crypto.PublicKey, subject pkix.Name, validity time.Duration) ([]byte, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	notAfter := time.Now().Add(validity)
+	if notAfter.After(root.NotAfter) {
+		notAfter = root.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               subject,
+		NotBefore:             time.Now(),
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root, pub, rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create intermediate certificate: %w", err)
+	}
+	return der, nil
+}
+
+// LoadCertificate reads the first certificate of a PEM file
+func LoadCertificate(path string) (*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cert: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode cert PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse cert: %w", err)
+	}
+	return cert, nil
+}
+
+// writePEM writes certificates, in order, to path
+func writePEM(path string, certs [][]byte) error {
+	var out []byte
+	for _, der := range certs {
+		out = append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	if err := os.WriteFile(path, out, 0644); err != nil {
+		return fmt.Errorf("failed to write cert: %w", err)
+	}
+	return nil
+}
+
 func GenerateCA(certPath, keyPath string) error {
 	// Ensure directory exists
 	if err := os.MkdirAll(filepath.Dir(certPath), 0755); err != nil {
@@ -139,6 +234,8 @@ func GenerateCA(certPath, keyPath string) error {
 	return nil
 }
 
+// SignCSR issues a client certificate from the intermediate and returns the chain: the
+// certificate followed by the intermediate. The root is left out, licd pins it.
 func (s *CAService) SignCSR(csr *x509.CertificateRequest) ([]byte, error) {
 	// Generate random serial number
 	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
@@ -157,20 +254,45 @@ func (s *CAService) SignCSR(csr *x509.CertificateRequest) ([]byte, error) {
 	}
 
 	// Sign the certificate
-	certDER, err := x509.CreateCertificate(rand.Reader, template, s.caCert, csr.PublicKey, s.caKey)
+	certDER, err := x509.CreateCertificate(rand.Reader, template, s.issuer, csr.PublicKey, s.signer)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create certificate: %w", err)
 	}
 
 	// Encode to PEM
 	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
-	return certPEM, nil
+	return append(certPEM, s.GetIssuerCertPEM()...), nil
 }
 
+// CreateCRL builds a certificate revocation list for the given entries, signed by the
+// intermediate that issued them. The CRL number must increase with every newly issued list.
+func (s *CAService) CreateCRL(entries []x509.RevocationListEntry, number *big.Int, nextUpdate time.Time) ([]byte, error) {
+	template := &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		Number:                    number,
+		ThisUpdate:                time.Now(),
+		NextUpdate:                nextUpdate,
+	}
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, template, s.issuer, s.signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CRL: %w", err)
+	}
+	return crlDER, nil
+}
+
+// GetCACertPEM returns the root CA certificate
 func (s *CAService) GetCACertPEM() []byte {
-	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.caCert.Raw})
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.root.Raw})
+}
+
+// GetIssuerCertPEM returns the intermediate CA certificate
+func (s *CAService) GetIssuerCertPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.issuer.Raw})
 }
 
+// GenerateServerCert issues a server certificate from the intermediate. The certificate file
+// holds the chain, so TLS clients that only know the root can verify it.
 func (s *CAService) GenerateServerCert(certPath, keyPath, commonName string, dnsNames []string, ipAddresses []net.IP) error {
 	// 1. Generate Key
 	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -196,18 +318,15 @@ func (s *CAService) GenerateServerCert(certPath, keyPath, commonName string, dns
 	}
 
 	// 3. Sign
-	der, err := x509.CreateCertificate(rand.Reader, &template, s.caCert, &priv.PublicKey, s.caKey)
+	der, err := x509.CreateCertificate(rand.Reader, &template, s.issuer, &priv.PublicKey, s.signer)
 	if err != nil {
 		return fmt.Errorf("failed to create certificate: %w", err)
 	}
 
 	// 4. Save Cert
-	certOut, err := os.Create(certPath)
-	if err != nil {
-		return fmt.Errorf("failed to open cert file: %w", err)
+	if err := writePEM(certPath, [][]byte{der, s.issuer.Raw}); err != nil {
+		return err
 	}
-	pem.Encode(certOut, &pem.Block{Type: "CERTIFICATE", Bytes: der})
-	certOut.Close()
 
 	// 5. Save Key
 	keyOut, err := os.OpenFile(keyPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
@@ -0,0 +1,122 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Backends of a CA private key
+const (
+	SignerFile   = "file"
+	SignerPKCS11 = "pkcs11"
+)
+
+// SignerConfig tells where a CA private key lives
+type SignerConfig struct {
+	Backend string // SignerFile (default) or SignerPKCS11
+	KeyPath string // file backend: PEM encoded private key
+
+	// PKCS#11 backend: the key is looked up by label and never leaves the token
+	PKCS11Module     string // module library, e.g. /usr/lib/softhsm/libsofthsm2.so
+	PKCS11TokenLabel string // may be empty when the module has a single token
+	PKCS11PIN        string
+	PKCS11KeyLabel   string // label of the private key and its public key object
+}
+
+// KeySigner is a CA private key. Keys in a token keep a session open until Close.
+type KeySigner interface {
+	crypto.Signer
+	Close() error
+}
+
+// OpenSigner opens the key described by cfg
+func OpenSigner(cfg SignerConfig) (KeySigner, error) {
+	switch cfg.Backend {
+	case "", SignerFile:
+		key, err := LoadPrivateKey(cfg.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		return fileSigner{key}, nil
+	case SignerPKCS11:
+		return openPKCS11Signer(cfg)
+	default:
+		return nil, fmt.Errorf("unknown signer backend %q (want %s or %s)", cfg.Backend, SignerFile, SignerPKCS11)
+	}
+}
+
+// fileSigner is a private key read from a file
+type fileSigner struct {
+	crypto.Signer
+}
+
+func (fileSigner) Close() error { return nil }
+
+// LoadPrivateKey reads a PEM encoded EC, RSA or PKCS#8 private key
+func LoadPrivateKey(path string) (crypto.Signer, error) {
+	keyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, fmt.Errorf("failed to decode key PEM")
+	}
+
+	var key interface{}
+	switch keyBlock.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(keyBlock.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(keyBlock.Bytes)
+	default:
+		// Try to parse as generic if type is unexpected but valid
+		if k, err2 := x509.ParsePKCS8PrivateKey(keyBlock.Bytes); err2 == nil {
+			key = k
+		} else if k, err2 := x509.ParseECPrivateKey(keyBlock.Bytes); err2 == nil {
+			key = k
+		} else if k, err2 := x509.ParsePKCS1PrivateKey(keyBlock.Bytes); err2 == nil {
+			key = k
+		} else {
+			return nil, fmt.Errorf("unknown private key type: %s", keyBlock.Type)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("key of type %T does not support signing", key)
+	}
+	return signer, nil
+}
+
+// GenerateKeyFile writes a new P-256 key to path, readable by the owner only
+func GenerateKeyFile(path string) (crypto.Signer, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyBytes})
+	if err := os.WriteFile(path, keyPEM, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write key: %w", err)
+	}
+	return priv, nil
+}
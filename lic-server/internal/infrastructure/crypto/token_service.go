@@ -1,22 +1,69 @@
 package crypto
 
 import (
+	"context"
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// Errors returned by RetireKey
+var (
+	ErrKeyNotFound = errors.New("signing key not found")
+	ErrActiveKey   = errors.New("cannot retire the active signing key")
+)
+
+// signingKey is one versioned Ed25519 key of the token key ring
+type signingKey struct {
+	version int
+	kid     string
+	private ed25519.PrivateKey
+}
+
+// TokenService signs license tokens with the newest key of a key ring.
+// Version 1 lives at keyPath, later versions at keyPath.v<N>. Older keys are
+// kept only so that tokens they signed can still be verified via the JWKS.
 type TokenService struct {
-	privateKey ed25519.PrivateKey
+	mu      sync.RWMutex
+	keyPath string
+	keys    []*signingKey // sorted by version, the last one is active
+}
+
+// JWK is an Ed25519 public key in JSON Web Key form (RFC 8037)
+type JWK struct {
+	Kty    string `json:"kty"`
+	Crv    string `json:"crv"`
+	X      string `json:"x"`
+	Kid    string `json:"kid"`
+	Alg    string `json:"alg"`
+	Use    string `json:"use"`
+	Ver    int    `json:"ver"`
+	Status string `json:"status"` // active or verify-only
+}
+
+// JWKS is the public key set published to licd
+type JWKS struct {
+	Keys []JWK `json:"keys"`
 }
 
 func (s *TokenService) GetPublicKeyPEM() ([]byte, error) {
-	pub := s.privateKey.Public().(ed25519.PublicKey)
+	pub := s.active().private.Public().(ed25519.PublicKey)
 	pubBytes, err := x509.MarshalPKIXPublicKey(pub)
 	if err != nil {
 		return nil, fmt.Errorf("failed to marshal public key: %w", err)
@@ -26,39 +73,249 @@ func (s *TokenService) GetPublicKeyPEM() ([]byte, error) {
 }
 
 func NewTokenService(keyPath string) (*TokenService, error) {
-	// Try to load key
-	keyBytes, err := os.ReadFile(keyPath)
-	if os.IsNotExist(err) {
-		// Generate new key if not exists (for dev convenience)
-		pub, priv, genErr := ed25519.GenerateKey(rand.Reader)
-		if genErr != nil {
-			return nil, fmt.Errorf("failed to generate key: %w", genErr)
+	keys, err := loadKeyRing(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenService{keyPath: keyPath, keys: keys}, nil
+}
+
+// loadKeyRing reads every signing key that is not retired, sorted by version
+func loadKeyRing(keyPath string) ([]*signingKey, error) {
+	var keys []*signingKey
+
+	// Version 1 keeps the historical location so existing deployments keep their key
+	if _, err := os.Stat(keyPath + ".retired"); os.IsNotExist(err) {
+		priv, loadErr := loadOrGenerateKey(keyPath)
+		if loadErr != nil {
+			return nil, loadErr
 		}
+		keys = append(keys, newSigningKey(1, priv))
+	}
 
-		// Save private key
-		privBytes, marshalErr := x509.MarshalPKCS8PrivateKey(priv)
-		if marshalErr != nil {
-			return nil, fmt.Errorf("failed to marshal private key: %w", marshalErr)
+	// Rotated keys: keyPath.v2, keyPath.v3, ...
+	matches, err := filepath.Glob(keyPath + ".v*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rotated keys: %w", err)
+	}
+	for _, path := range matches {
+		version, convErr := strconv.Atoi(strings.TrimPrefix(path, keyPath+".v"))
+		if convErr != nil || version < 2 {
+			// .pub files, retired keys and anything else that is not a private key
+			continue
+		}
+		rotated, loadErr := loadKey(path)
+		if loadErr != nil {
+			return nil, fmt.Errorf("failed to load key version %d: %w", version, loadErr)
 		}
-		pemBlock := &pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}
-		if writeErr := os.WriteFile(keyPath, pem.EncodeToMemory(pemBlock), 0600); writeErr != nil {
-			return nil, fmt.Errorf("failed to save private key: %w", writeErr)
+		keys = append(keys, newSigningKey(version, rotated))
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys found at %s", keyPath)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].version < keys[j].version })
+	return keys, nil
+}
+
+// Reload reads the key ring from disk again, picking up keys rotated or retired by
+// another replica that shares the key directory
+func (s *TokenService) Reload() error {
+	keys, err := loadKeyRing(s.keyPath)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	return nil
+}
+
+// ReloadEvery reloads the key ring every interval until ctx is done
+func (s *TokenService) ReloadEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Reload(); err != nil {
+				log.Printf("WARN: Failed to reload signing keys: %v", err)
+			}
 		}
+	}
+}
+
+// keyVersioned claims record the version of the key they are signed with
+type keyVersioned interface {
+	SetKeyVersion(version int)
+}
+
+// SignToken signs claims with the active key. The key is read once, so the key version
+// set on keyVersioned claims always matches the kid of the token.
+func (s *TokenService) SignToken(claims jwt.Claims) (string, error) {
+	key := s.active()
+	if v, ok := claims.(keyVersioned); ok {
+		v.SetKeyVersion(key.version)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// ActiveKeyVersion returns the version of the key new tokens are signed with
+func (s *TokenService) ActiveKeyVersion() int {
+	return s.active().version
+}
+
+// RotateKey generates a new signing key and makes it active.
+// The previous keys stay in the JWKS so already issued tokens remain verifiable.
+func (s *TokenService) RotateKey() (int, string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another replica sharing the key directory may have rotated since the last reload
+	if keys, err := loadKeyRing(s.keyPath); err == nil {
+		s.keys = keys
+	}
+	version := s.keys[len(s.keys)-1].version + 1
+	path := fmt.Sprintf("%s.v%d", s.keyPath, version)
+	priv, err := generateKey(path)
+	if err != nil {
+		return 0, "", err
+	}
 
-		// Save public key (assumed to be keyPath + ".pub")
-		pubBytes, pubMarshalErr := x509.MarshalPKIXPublicKey(pub)
-		if pubMarshalErr != nil {
-			return nil, fmt.Errorf("failed to marshal public key: %w", pubMarshalErr)
+	key := newSigningKey(version, priv)
+	s.keys = append(s.keys, key)
+	return key.version, key.kid, nil
+}
+
+// RetireKey removes a verification-only key from the key ring, e.g. after it leaked.
+// Tokens signed with it stop verifying once licd refreshes its key set.
+// The key files are renamed rather than deleted.
+func (s *TokenService) RetireKey(version int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	idx := -1
+	for i, k := range s.keys {
+		if k.version == version {
+			idx = i
 		}
-		pubPemBlock := &pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}
-		if writeErr := os.WriteFile(keyPath+".pub", pem.EncodeToMemory(pubPemBlock), 0644); writeErr != nil {
-			return nil, fmt.Errorf("failed to save public key: %w", writeErr)
+	}
+	if idx == -1 {
+		return fmt.Errorf("%w: version %d", ErrKeyNotFound, version)
+	}
+	if idx == len(s.keys)-1 {
+		return ErrActiveKey
+	}
+
+	path := s.keyPath
+	if version > 1 {
+		path = fmt.Sprintf("%s.v%d", s.keyPath, version)
+	}
+	if err := os.Rename(path, path+".retired"); err != nil {
+		return fmt.Errorf("failed to retire key: %w", err)
+	}
+	_ = os.Rename(path+".pub", path+".pub.retired")
+
+	s.keys = append(s.keys[:idx], s.keys[idx+1:]...)
+	return nil
+}
+
+// PublicJWKS returns the JSON-encoded public key set, newest key first
+func (s *TokenService) PublicJWKS() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	set := JWKS{Keys: make([]JWK, 0, len(s.keys))}
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		k := s.keys[i]
+		status := "verify-only"
+		if i == len(s.keys)-1 {
+			status = "active"
 		}
+		set.Keys = append(set.Keys, JWK{
+			Kty:    "OKP",
+			Crv:    "Ed25519",
+			X:      base64.RawURLEncoding.EncodeToString(k.private.Public().(ed25519.PublicKey)),
+			Kid:    k.kid,
+			Alg:    "EdDSA",
+			Use:    "sig",
+			Ver:    k.version,
+			Status: status,
+		})
+	}
+	return json.Marshal(set)
+}
+
+func (s *TokenService) active() *signingKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.keys[len(s.keys)-1]
+}
+
+func newSigningKey(version int, priv ed25519.PrivateKey) *signingKey {
+	return &signingKey{
+		version: version,
+		kid:     thumbprint(priv.Public().(ed25519.PublicKey)),
+		private: priv,
+	}
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint used as kid
+func thumbprint(pub ed25519.PublicKey) string {
+	x := base64.RawURLEncoding.EncodeToString(pub)
+	sum := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + x + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
 
-		return &TokenService{privateKey: priv}, nil
+func loadOrGenerateKey(keyPath string) (ed25519.PrivateKey, error) {
+	_, err := os.Stat(keyPath)
+	if os.IsNotExist(err) {
+		// Generate new key if not exists (for dev convenience)
+		return generateKey(keyPath)
 	} else if err != nil {
 		return nil, fmt.Errorf("failed to read key file: %w", err)
 	}
+	return loadKey(keyPath)
+}
+
+// generateKey creates a new Ed25519 key and saves it to keyPath (private) and keyPath.pub (public)
+func generateKey(keyPath string) (ed25519.PrivateKey, error) {
+	pub, priv, genErr := ed25519.GenerateKey(rand.Reader)
+	if genErr != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", genErr)
+	}
+
+	// Save private key
+	privBytes, marshalErr := x509.MarshalPKCS8PrivateKey(priv)
+	if marshalErr != nil {
+		return nil, fmt.Errorf("failed to marshal private key: %w", marshalErr)
+	}
+	pemBlock := &pem.Block{Type: "PRIVATE KEY", Bytes: privBytes}
+	if writeErr := os.WriteFile(keyPath, pem.EncodeToMemory(pemBlock), 0600); writeErr != nil {
+		return nil, fmt.Errorf("failed to save private key: %w", writeErr)
+	}
+
+	// Save public key (assumed to be keyPath + ".pub")
+	pubBytes, pubMarshalErr := x509.MarshalPKIXPublicKey(pub)
+	if pubMarshalErr != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", pubMarshalErr)
+	}
+	pubPemBlock := &pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes}
+	if writeErr := os.WriteFile(keyPath+".pub", pem.EncodeToMemory(pubPemBlock), 0644); writeErr != nil {
+		return nil, fmt.Errorf("failed to save public key: %w", writeErr)
+	}
+
+	return priv, nil
+}
+
+func loadKey(keyPath string) (ed25519.PrivateKey, error) {
+	keyBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
 
 	block, _ := pem.Decode(keyBytes)
 	if block == nil {
@@ -75,10 +332,5 @@ func NewTokenService(keyPath string) (*TokenService, error) {
 		return nil, fmt.Errorf("key is not Ed25519")
 	}
 
-	return &TokenService{privateKey: edPriv}, nil
-}
-
-func (s *TokenService) SignToken(claims jwt.Claims) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
-	return token.SignedString(s.privateKey)
+	return edPriv, nil
 }
@@ -0,0 +1,192 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "lic_server"
+
+// LicenseLister is the storage the per-license slot gauges are read from on every scrape
+type LicenseLister interface {
+	GetAllLicenses(ctx context.Context) ([]*storage.License, error)
+}
+
+// Metrics holds the Prometheus collectors of lic-server. A nil *Metrics records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	registrations    *prometheus.CounterVec
+	activations      *prometheus.CounterVec
+	heartbeats       *prometheus.CounterVec
+	mtlsRejections   *prometheus.CounterVec
+	certsIssued      prometheus.Counter
+	tokensConsumed   *prometheus.CounterVec
+	requestDurations *prometheus.HistogramVec
+}
+
+// New creates the collectors on a registry of their own, so several servers
+// (as in tests) can coexist in one process
+func New(licenses LicenseLister) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "registrations_total",
+			Help:      "Instance registrations (/v1/register) by result and failure reason.",
+		}, []string{"result", "reason"}),
+		activations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "activations_total",
+			Help:      "License activations (/v1/activate) by result and failure reason.",
+		}, []string{"result", "reason"}),
+		heartbeats: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "heartbeats_total",
+			Help:      "Heartbeats by result (ok, unchanged, updated, revoked, failure) and reason.",
+		}, []string{"result", "reason"}),
+		mtlsRejections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "mtls_rejections_total",
+			Help:      "Requests rejected by the mTLS check, by reason.",
+		}, []string{"reason"}),
+		certsIssued: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "certificates_issued_total",
+			Help:      "Client certificates signed by the CA.",
+		}),
+		tokensConsumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "enrollment_tokens_consumed_total",
+			Help:      "Enrollment tokens accepted at registration, by kind (static or issued).",
+		}, []string{"kind"}),
+		requestDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of HTTP requests by method, route pattern and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+	}
+
+	m.registry.MustRegister(
+		m.registrations,
+		m.activations,
+		m.heartbeats,
+		m.mtlsRejections,
+		m.certsIssued,
+		m.tokensConsumed,
+		m.requestDurations,
+		&slotsCollector{licenses: licenses},
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Registration counts a registration outcome; reason is empty on success
+func (m *Metrics) Registration(result, reason string) {
+	if m == nil {
+		return
+	}
+	m.registrations.WithLabelValues(result, reason).Inc()
+}
+
+// Activation counts an activation outcome; reason is empty on success
+func (m *Metrics) Activation(result, reason string) {
+	if m == nil {
+		return
+	}
+	m.activations.WithLabelValues(result, reason).Inc()
+}
+
+// Heartbeat counts a heartbeat outcome
+func (m *Metrics) Heartbeat(result, reason string) {
+	if m == nil {
+		return
+	}
+	m.heartbeats.WithLabelValues(result, reason).Inc()
+}
+
+// MTLSRejected counts a request turned away by RequireMTLS
+func (m *Metrics) MTLSRejected(reason string) {
+	if m == nil {
+		return
+	}
+	m.mtlsRejections.WithLabelValues(reason).Inc()
+}
+
+// CertificateIssued counts a signed client certificate
+func (m *Metrics) CertificateIssued() {
+	if m == nil {
+		return
+	}
+	m.certsIssued.Inc()
+}
+
+// EnrollmentTokenConsumed counts an enrollment token accepted at registration
+func (m *Metrics) EnrollmentTokenConsumed(kind string) {
+	if m == nil {
+		return
+	}
+	m.tokensConsumed.WithLabelValues(kind).Inc()
+}
+
+// ObserveRequest records the latency of a handled request
+func (m *Metrics) ObserveRequest(method, route string, status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requestDurations.WithLabelValues(method, route, strconv.Itoa(status)).Observe(d.Seconds())
+}
+
+// slotsCollector exports the used and max slots of every license, read at scrape time
+// so the gauges never go stale and deleted licenses drop out
+type slotsCollector struct {
+	licenses LicenseLister
+}
+
+var (
+	usedSlotsDesc = prometheus.NewDesc(namespace+"_license_used_slots",
+		"Agents in use across all instances of a license.", []string{"inn"}, nil)
+	maxSlotsDesc = prometheus.NewDesc(namespace+"_license_max_slots",
+		"Agents a license allows.", []string{"inn"}, nil)
+)
+
+// slotsScrapeTimeout bounds the storage query of a scrape
+const slotsScrapeTimeout = 5 * time.Second
+
+func (c *slotsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usedSlotsDesc
+	ch <- maxSlotsDesc
+}
+
+func (c *slotsCollector) Collect(ch chan<- prometheus.Metric) {
+	if c.licenses == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), slotsScrapeTimeout)
+	defer cancel()
+
+	licenses, err := c.licenses.GetAllLicenses(ctx)
+	if err != nil {
+		log.Printf("metrics: failed to list licenses: %v", err)
+		return
+	}
+	for _, lic := range licenses {
+		ch <- prometheus.MustNewConstMetric(usedSlotsDesc, prometheus.GaugeValue, float64(lic.UsedSlots), lic.INN)
+		ch <- prometheus.MustNewConstMetric(maxSlotsDesc, prometheus.GaugeValue, float64(lic.MaxSlots), lic.INN)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -31,7 +32,6 @@ func TestAccessControlMatrix(t *testing.T) {
 	// 1. Setup Infrastructure
 	tempDir := t.TempDir()
 	caCertPath := filepath.Join(tempDir, "ca.crt")
-	caKeyPath := filepath.Join(tempDir, "ca.key")
 	tokenPrivPath := filepath.Join(tempDir, "token.key")
 
 	// Storage (In-Memory)
@@ -42,7 +42,7 @@ func TestAccessControlMatrix(t *testing.T) {
 	defer store.Close()
 
 	// CA Service
-	caSvc, err := crypto.NewCAService(caCertPath, caKeyPath)
+	caSvc, err := crypto.NewCAService(caConfig(tempDir))
 	if err != nil {
 		t.Fatalf("Failed to create CA service: %v", err)
 	}
@@ -54,10 +54,10 @@ func TestAccessControlMatrix(t *testing.T) {
 	}
 
 	// License Service
-	svc := license.NewService(store, caSvc, tokenSvc, "")
+	svc := license.NewService(store, caSvc, tokenSvc)
 
 	// Router
-	r := router.NewRouter(svc, "test-admin-key")
+	r := router.NewRouter(svc, "test-admin-key", router.RateLimits{}, nil)
 
 	// Create TLS Server with VerifyClientCertIfGiven
 	caCertPEM, err := os.ReadFile(caCertPath)
@@ -87,9 +87,9 @@ func TestAccessControlMatrix(t *testing.T) {
 	store.CreateLicense(ctx, innB, "Org B", 10)
 
 	// Create Enrollment Tokens
-	tokenA, _ := store.CreateEnrollmentToken(ctx, innA, 1*time.Hour)
+	tokenA, _, _ := svc.CreateEnrollmentToken(ctx, innA, 1*time.Hour, 1, "")
 	// Token B is created but not used in tests directly, kept for completeness of data setup
-	_, _ = store.CreateEnrollmentToken(ctx, innB, 1*time.Hour)
+	_, _, _ = svc.CreateEnrollmentToken(ctx, innB, 1*time.Hour, 1, "")
 
 	// Helper to create client cert
 	createClientCert := func(cn string) (*tls.Certificate, error) {
@@ -147,7 +147,7 @@ func TestAccessControlMatrix(t *testing.T) {
 		return resp.StatusCode, string(respBody)
 	}
 
-	// Test 0: /v1/register with invalid token -> 500
+	// Test 0: /v1/register with invalid token -> 403
 	t.Run("Register with invalid token", func(t *testing.T) {
 		// Generate CSR
 		priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -161,9 +161,9 @@ func TestAccessControlMatrix(t *testing.T) {
 			Token: "invalid-token",
 		}
 
-		code, _ := makeRequest("POST", "/v1/register", req, nil)
-		if code != http.StatusInternalServerError {
-			t.Errorf("Expected 500, got %d", code)
+		code, body := makeRequest("POST", "/v1/register", req, nil)
+		if code != http.StatusForbidden || !strings.Contains(body, `"code":"enrollment_token_invalid"`) {
+			t.Errorf("Expected 403 enrollment_token_invalid, got %d %s", code, body)
 		}
 	})
 
@@ -216,11 +216,14 @@ func TestAccessControlMatrix(t *testing.T) {
 			"cert_fingerprint": "any", // Server will look up by actual cert fingerprint
 		}
 
+		// RequireMTLS refuses a CA-signed certificate that was never bound to a license
 		code, body := makeRequest("POST", "/v1/activate", req, cert)
-		// Expect 403 because RequireMTLS passes (valid cert), but ActivateInstance logic checks binding
-
-		if code != http.StatusForbidden {
-			t.Errorf("Expected 403, got %d. Body: %s", code, body)
+		if code != http.StatusForbidden || !strings.Contains(string(body), "certificate_not_bound") {
+			t.Errorf("Expected 403 certificate_not_bound, got %d. Body: %s", code, body)
+		}
+		code, body = makeRequest("GET", "/v1/heartbeat", nil, cert)
+		if code != http.StatusForbidden || !strings.Contains(string(body), "certificate_not_bound") {
+			t.Errorf("Expected 403 certificate_not_bound for heartbeat, got %d. Body: %s", code, body)
 		}
 	})
 
@@ -238,7 +241,7 @@ func TestAccessControlMatrix(t *testing.T) {
 
 		// Token A was used in Test 1, so it is consumed!
 		// Need new token.
-		tokenA2, _ := store.CreateEnrollmentToken(ctx, innA, 1*time.Hour)
+		tokenA2, _, _ := svc.CreateEnrollmentToken(ctx, innA, 1*time.Hour, 1, "")
 
 		req := router.RegisterRequest{
 			INN:   innA,
@@ -0,0 +1,127 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestScopedAdminKeys(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	createKey := func(t *testing.T, name string, scopes ...string) (string, int64) {
+		t.Helper()
+		code, body := env.do(t, "POST", "/api/admin/api-keys", map[string]interface{}{
+			"name":   name,
+			"scopes": scopes,
+		}, nil, testAdminKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Create key %s failed: %d %s", name, code, body)
+		}
+		var resp struct {
+			Key    string             `json:"key"`
+			APIKey struct{ ID int64 } `json:"api_key"`
+		}
+		_ = json.Unmarshal(body, &resp)
+		return resp.Key, resp.APIKey.ID
+	}
+
+	readKey, _ := createKey(t, "viewer", "read-only")
+	writeKey, writeID := createKey(t, "sales", "license-write")
+
+	t.Run("Invalid scope rejected", func(t *testing.T) {
+		code, _ := env.do(t, "POST", "/api/admin/api-keys", map[string]interface{}{
+			"name": "bad", "scopes": []string{"superuser"},
+		}, nil, testAdminKey)
+		if code != http.StatusBadRequest {
+			t.Fatalf("Expected 400, got %d", code)
+		}
+	})
+
+	t.Run("Duplicate name rejected", func(t *testing.T) {
+		code, _ := env.do(t, "POST", "/api/admin/api-keys", map[string]interface{}{
+			"name": "viewer", "scopes": []string{"read-only"},
+		}, nil, testAdminKey)
+		if code != http.StatusConflict {
+			t.Fatalf("Expected 409, got %d", code)
+		}
+	})
+
+	t.Run("Scopes are enforced per route", func(t *testing.T) {
+		if code, _ := env.do(t, "GET", "/api/admin/licenses", nil, nil, readKey); code != http.StatusOK {
+			t.Errorf("read-only key: expected 200 on GET /licenses, got %d", code)
+		}
+		if code, _ := env.do(t, "POST", "/api/admin/licenses", map[string]interface{}{
+			"inn": "7777777777", "organization": "Scoped Org", "max_slots": 5,
+		}, nil, readKey); code != http.StatusForbidden {
+			t.Errorf("read-only key: expected 403 on POST /licenses, got %d", code)
+		}
+		if code, _ := env.do(t, "GET", "/api/admin/audit", nil, nil, readKey); code != http.StatusForbidden {
+			t.Errorf("read-only key: expected 403 on GET /audit, got %d", code)
+		}
+
+		code, body := env.do(t, "POST", "/api/admin/licenses", map[string]interface{}{
+			"inn": "7777777777", "organization": "Scoped Org", "max_slots": 5,
+		}, nil, writeKey)
+		if code != http.StatusCreated {
+			t.Fatalf("license-write key: expected 201 on POST /licenses, got %d: %s", code, body)
+		}
+		var resp map[string]string
+		_ = json.Unmarshal(body, &resp)
+		if resp["token"] != "" {
+			t.Errorf("license-write key without token-issue must not receive an enrollment token")
+		}
+		if code, _ := env.do(t, "POST", "/api/admin/tokens", map[string]interface{}{
+			"inn": "7777777777", "ttl_hours": 1,
+		}, nil, writeKey); code != http.StatusForbidden {
+			t.Errorf("license-write key: expected 403 on POST /tokens, got %d", code)
+		}
+		if code, _ := env.do(t, "GET", "/api/admin/api-keys", nil, nil, writeKey); code != http.StatusForbidden {
+			t.Errorf("license-write key: expected 403 on GET /api-keys, got %d", code)
+		}
+	})
+
+	t.Run("Audit events record the acting key", func(t *testing.T) {
+		events, err := env.store.GetAuditEvents(ctx, "7777777777")
+		if err != nil {
+			t.Fatalf("Failed to get audit events: %v", err)
+		}
+		found := false
+		for _, e := range events {
+			if e.Action == "license_created" {
+				found = true
+				if e.Actor != "sales" {
+					t.Errorf("Expected actor 'sales', got %q", e.Actor)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("license_created audit event not found")
+		}
+	})
+
+	t.Run("Revoked key is refused", func(t *testing.T) {
+		code, _ := env.do(t, "DELETE", fmt.Sprintf("/api/admin/api-keys/%d", writeID), nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if code, _ := env.do(t, "GET", "/api/admin/licenses", nil, nil, writeKey); code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for revoked key, got %d", code)
+		}
+	})
+
+	t.Run("Keys are stored hashed", func(t *testing.T) {
+		keys, _ := env.store.GetAllAdminAPIKeys(ctx)
+		for _, k := range keys {
+			if k.KeyPrefix == readKey {
+				t.Errorf("Full key must not be stored")
+			}
+		}
+		if k, _ := env.store.GetAdminAPIKeyByHash(ctx, readKey); k != nil {
+			t.Errorf("Plaintext key must not match as a hash")
+		}
+	})
+}
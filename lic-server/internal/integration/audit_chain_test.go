@@ -0,0 +1,132 @@
+package integration_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/infrastructure/crypto"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestAuditHashChain(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	for i := 0; i < 10; i++ {
+		_ = env.store.LogAudit(ctx, "activate_attempt", "1212121212", "10.0.0.1", fmt.Sprintf("n=%d", i))
+	}
+
+	// raw opens the database behind the store, the way someone with file access would
+	raw, err := sql.Open("sqlite3", env.dbPath)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer raw.Close()
+
+	type verifyResp struct {
+		Valid    bool   `json:"valid"`
+		Checked  int    `json:"checked"`
+		HeadID   int64  `json:"head_id"`
+		HeadHash string `json:"head_hash"`
+		BrokenID int64  `json:"broken_id"`
+		Reason   string `json:"reason"`
+	}
+	verify := func(t *testing.T, query string) verifyResp {
+		t.Helper()
+		code, body := env.do(t, "GET", "/api/admin/audit/verify"+query, nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Verify failed: %d %s", code, body)
+		}
+		var resp verifyResp
+		_ = json.Unmarshal(body, &resp)
+		return resp
+	}
+
+	var checkpoint license.AuditCheckpointClaims
+
+	t.Run("Intact chain verifies", func(t *testing.T) {
+		resp := verify(t, "")
+		if !resp.Valid || resp.Checked < 10 {
+			t.Fatalf("Expected a valid chain of at least 10 events, got %+v", resp)
+		}
+	})
+
+	t.Run("Checkpoint is signed with the license key", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/api/admin/audit/checkpoint", nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Checkpoint failed: %d %s", code, body)
+		}
+		var resp struct{ Token string }
+		_ = json.Unmarshal(body, &resp)
+
+		_, body = env.do(t, "GET", "/v1/jwks", nil, nil, "")
+		var set crypto.JWKS
+		_ = json.Unmarshal(body, &set)
+		_, err := jwt.ParseWithClaims(resp.Token, &checkpoint, func(tok *jwt.Token) (interface{}, error) {
+			for _, k := range set.Keys {
+				if k.Kid == tok.Header["kid"] {
+					raw, _ := base64.RawURLEncoding.DecodeString(k.X)
+					return ed25519.PublicKey(raw), nil
+				}
+			}
+			return nil, jwt.ErrTokenUnverifiable
+		})
+		if err != nil {
+			t.Fatalf("Checkpoint signature does not verify: %v", err)
+		}
+		if checkpoint.LastEventID == 0 || checkpoint.HeadHash == "" {
+			t.Fatalf("Checkpoint is missing the chain head: %+v", checkpoint)
+		}
+
+		q := fmt.Sprintf("?checkpoint_id=%d&checkpoint_hash=%s", checkpoint.LastEventID, checkpoint.HeadHash)
+		if resp := verify(t, q); !resp.Valid {
+			t.Fatalf("Expected chain to match the fresh checkpoint, got %+v", resp)
+		}
+	})
+
+	t.Run("Truncated tail is caught by the checkpoint", func(t *testing.T) {
+		if _, err := raw.Exec(`DELETE FROM audit_events WHERE id >= ?`, checkpoint.LastEventID); err != nil {
+			t.Fatalf("Failed to delete events: %v", err)
+		}
+		if resp := verify(t, ""); !resp.Valid {
+			t.Fatalf("Chain alone cannot see a removed tail, got %+v", resp)
+		}
+		q := fmt.Sprintf("?checkpoint_id=%d&checkpoint_hash=%s", checkpoint.LastEventID, checkpoint.HeadHash)
+		resp := verify(t, q)
+		if resp.Valid || resp.BrokenID != checkpoint.LastEventID {
+			t.Fatalf("Expected checkpoint mismatch at %d, got %+v", checkpoint.LastEventID, resp)
+		}
+	})
+
+	t.Run("Edited event is reported", func(t *testing.T) {
+		if _, err := raw.Exec(`UPDATE audit_events SET details = 'n=tampered' WHERE id = 5`); err != nil {
+			t.Fatalf("Failed to tamper: %v", err)
+		}
+		resp := verify(t, "")
+		if resp.Valid || resp.BrokenID != 5 || resp.HeadID != 4 {
+			t.Fatalf("Expected break at event 5, got %+v", resp)
+		}
+
+		code, _ := env.do(t, "GET", "/api/admin/audit/checkpoint", nil, nil, testAdminKey)
+		if code != http.StatusConflict {
+			t.Errorf("Expected 409 when checkpointing a broken chain, got %d", code)
+		}
+	})
+
+	t.Run("Deleted event is reported", func(t *testing.T) {
+		if _, err := raw.Exec(`DELETE FROM audit_events WHERE id = 5`); err != nil {
+			t.Fatalf("Failed to delete: %v", err)
+		}
+		resp := verify(t, "")
+		if resp.Valid || resp.BrokenID != 6 {
+			t.Fatalf("Expected break at event 6, got %+v", resp)
+		}
+	})
+}
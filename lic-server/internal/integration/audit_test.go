@@ -0,0 +1,144 @@
+package integration_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAuditQueryAndExport(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	for i := 0; i < 25; i++ {
+		_ = env.store.LogAudit(ctx, "activate_attempt", "1111111111", "10.0.0.1", fmt.Sprintf("n=%d", i))
+	}
+	for i := 0; i < 5; i++ {
+		_ = env.store.LogAudit(ctx, "activate_failed", "1111111111", "10.0.0.2", "no license")
+		_ = env.store.LogAudit(ctx, "activate_attempt", "2222222222", "10.0.0.3", "other customer")
+	}
+
+	// get returns the response headers as well, which env.do does not
+	get := func(t *testing.T, path string, query url.Values) (http.Header, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", env.ts.URL+path+"?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+testAdminKey)
+		resp, err := env.ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", resp.StatusCode, body)
+		}
+		return resp.Header, body
+	}
+
+	type event struct {
+		ID        int64
+		Action    string
+		INN       string
+		IPAddress string
+	}
+
+	t.Run("Filters", func(t *testing.T) {
+		_, body := get(t, "/api/admin/audit", url.Values{"inn": {"1111111111"}, "action": {"activate_failed"}})
+		var events []event
+		_ = json.Unmarshal(body, &events)
+		if len(events) != 5 {
+			t.Fatalf("Expected 5 events, got %d", len(events))
+		}
+
+		_, body = get(t, "/api/admin/licenses/2222222222/audit", url.Values{"ip": {"10.0.0.3"}})
+		_ = json.Unmarshal(body, &events)
+		if len(events) != 5 || events[0].INN != "2222222222" {
+			t.Fatalf("Expected 5 events for 2222222222, got %+v", events)
+		}
+
+		_, body = get(t, "/api/admin/audit", url.Values{"to": {time.Now().Add(-time.Hour).Format(time.RFC3339)}})
+		_ = json.Unmarshal(body, &events)
+		if len(events) != 0 {
+			t.Fatalf("Expected no events older than an hour, got %d", len(events))
+		}
+	})
+
+	t.Run("Cursor pagination", func(t *testing.T) {
+		seen := map[int64]bool{}
+		cursor := ""
+		pages := 0
+		for {
+			q := url.Values{"inn": {"1111111111"}, "limit": {"10"}}
+			if cursor != "" {
+				q.Set("cursor", cursor)
+			}
+			header, body := get(t, "/api/admin/audit", q)
+			var events []event
+			_ = json.Unmarshal(body, &events)
+			for _, e := range events {
+				if seen[e.ID] {
+					t.Fatalf("Event %d returned twice", e.ID)
+				}
+				seen[e.ID] = true
+			}
+			pages++
+			cursor = header.Get("X-Next-Cursor")
+			if cursor == "" {
+				break
+			}
+		}
+		if len(seen) != 30 || pages != 3 {
+			t.Errorf("Expected 30 events over 3 pages, got %d over %d", len(seen), pages)
+		}
+	})
+
+	t.Run("CSV export", func(t *testing.T) {
+		header, body := get(t, "/api/admin/audit/export", url.Values{"format": {"csv"}, "inn": {"2222222222"}})
+		if header.Get("Content-Type") != "text/csv" {
+			t.Errorf("Unexpected content type %q", header.Get("Content-Type"))
+		}
+		records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatalf("Invalid CSV: %v", err)
+		}
+		if len(records) != 6 || records[0][0] != "id" {
+			t.Errorf("Expected header + 5 rows, got %d rows", len(records))
+		}
+	})
+
+	t.Run("CSV export escapes formulas", func(t *testing.T) {
+		_ = env.store.LogAudit(ctx, "activate_failed", "3333333333", "10.0.0.4", `=HYPERLINK("http://evil","x")`)
+		_, body := get(t, "/api/admin/audit/export", url.Values{"format": {"csv"}, "inn": {"3333333333"}})
+		records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatalf("Invalid CSV: %v", err)
+		}
+		if len(records) != 2 || records[1][6] != `'=HYPERLINK("http://evil","x")` {
+			t.Errorf("Expected the formula to be prefixed with a quote, got %q", records)
+		}
+	})
+
+	t.Run("NDJSON export", func(t *testing.T) {
+		_, body := get(t, "/api/admin/audit/export", url.Values{"format": {"ndjson"}})
+		lines := 0
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			var e event
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Fatalf("Invalid NDJSON line %q: %v", scanner.Text(), err)
+			}
+			lines++
+		}
+		if lines < 35 {
+			t.Errorf("Expected at least 35 events, got %d", lines)
+		}
+	})
+}
@@ -0,0 +1,168 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/api/router"
+	"github.com/deymonster/lic-server/internal/core/backup"
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/storage"
+	"github.com/deymonster/lic-server/internal/storage/sqlite"
+)
+
+// doRaw sends body as is, with the given checksum header, and returns the response
+func (e *testEnv) doRaw(t *testing.T, method, path string, body []byte, checksum string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, e.ts.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminKey)
+	if checksum != "" {
+		req.Header.Set(router.ChecksumHeader, checksum)
+	}
+	resp, err := e.ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Request %s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	return resp, respBody
+}
+
+func TestDatabaseBackup(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	if err := env.store.CreateLicense(ctx, "6161616161", "Backed Up Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	var snapshot []byte
+	var checksum string
+	t.Run("Download a snapshot", func(t *testing.T) {
+		resp, body := env.doRaw(t, "GET", "/api/admin/backup", nil, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", resp.StatusCode, body)
+		}
+		sum := sha256.Sum256(body)
+		checksum = resp.Header.Get(router.ChecksumHeader)
+		if checksum != hex.EncodeToString(sum[:]) {
+			t.Errorf("Checksum header %q does not match the body", checksum)
+		}
+		if !strings.Contains(resp.Header.Get("Content-Disposition"), "attachment") {
+			t.Errorf("Expected an attachment, got %q", resp.Header.Get("Content-Disposition"))
+		}
+		snapshot = body
+	})
+
+	t.Run("Backup requires the admin scope", func(t *testing.T) {
+		key, _, err := env.svc.CreateAdminKey(ctx, "reader", []string{license.ScopeReadOnly}, "")
+		if err != nil {
+			t.Fatalf("Failed to create admin key: %v", err)
+		}
+		if code, _ := env.do(t, "GET", "/api/admin/backup", nil, nil, key); code != http.StatusForbidden {
+			t.Errorf("Expected 403 for a read-only key, got %d", code)
+		}
+	})
+
+	t.Run("Restore refuses bad snapshots", func(t *testing.T) {
+		resp, body := env.doRaw(t, "POST", "/api/admin/restore", snapshot, strings.Repeat("0", 64))
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), license.CodeInvalidRequest) {
+			t.Errorf("Expected 400 for a checksum mismatch, got %d: %s", resp.StatusCode, body)
+		}
+		resp, body = env.doRaw(t, "POST", "/api/admin/restore", []byte("definitely not a database"), "")
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), license.CodeInvalidRequest) {
+			t.Errorf("Expected 400 for garbage, got %d: %s", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("Restore replaces the database", func(t *testing.T) {
+		backupDir := t.TempDir()
+		env.svc.SetBackupDir(backupDir)
+		if err := env.store.CreateLicense(ctx, "6262626262", "Created Later", 10); err != nil {
+			t.Fatalf("Failed to create license: %v", err)
+		}
+
+		resp, body := env.doRaw(t, "POST", "/api/admin/restore", snapshot, checksum)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", resp.StatusCode, body)
+		}
+		if lic, _ := env.store.GetLicenseByINN(ctx, "6262626262"); lic != nil {
+			t.Error("Expected the license created after the backup to be gone")
+		}
+		if lic, _ := env.store.GetLicenseByINN(ctx, "6161616161"); lic == nil {
+			t.Error("Expected the license from the backup")
+		}
+
+		// The replaced database was saved first
+		var result struct{ Previous *backup.File }
+		if err := json.Unmarshal(body, &result); err != nil || result.Previous == nil {
+			t.Fatalf("Expected the saved copy in the response, got %s", body)
+		}
+		saved, err := sqlite.NewStorage(filepath.Join(backupDir, result.Previous.Name))
+		if err != nil {
+			t.Fatalf("Saved copy does not open: %v", err)
+		}
+		defer saved.Close()
+		if lic, _ := saved.GetLicenseByINN(ctx, "6262626262"); lic == nil {
+			t.Error("Expected the saved copy to hold the replaced data")
+		}
+
+		events, err := env.store.QueryAuditEvents(ctx, storage.AuditFilter{Action: "database_restored"})
+		if err != nil || len(events) != 1 {
+			t.Errorf("Expected one database_restored audit event, got %d, %v", len(events), err)
+		}
+	})
+}
+
+func TestScheduledBackups(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Two older snapshots and an unrelated file, which must survive the retention
+	for _, age := range []time.Duration{48 * time.Hour, 24 * time.Hour} {
+		if _, err := backup.Write(ctx, env.store, filepath.Join(dir, backup.Name(time.Now().Add(-age)))); err != nil {
+			t.Fatalf("Failed to write snapshot: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep me"), 0o600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	f, err := backup.NewScheduler(env.store, dir, time.Hour, 2).RunOnce(ctx)
+	if err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+
+	files, err := backup.List(dir)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(files) != 2 || files[0].Name != f.Name {
+		t.Fatalf("Expected the 2 newest snapshots, newest first, got %d", len(files))
+	}
+	if files[1].CreatedAt.After(time.Now().Add(-23 * time.Hour)) {
+		t.Errorf("Expected the 48h old snapshot to be deleted, kept %s", files[1].Name)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Errorf("Expected unrelated files to be kept: %v", err)
+	}
+
+	sum, err := backup.Checksum(f.Path)
+	if err != nil || sum != files[0].SHA256 {
+		t.Errorf("Expected the checksum file to match the snapshot, got %s and %s (%v)", files[0].SHA256, sum, err)
+	}
+}
@@ -0,0 +1,252 @@
+package integration_test
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/api/router"
+	"github.com/deymonster/lic-server/internal/infrastructure/crypto"
+)
+
+// The PKCS#11 test runs against a prepared token, e.g. SoftHSM:
+//
+//	softhsm2-util --init-token --free --label lic-test --pin 1234 --so-pin 1234
+//	pkcs11-tool --module /usr/lib/softhsm/libsofthsm2.so --token-label lic-test --login --pin 1234 \
+//	    --keypairgen --key-type EC:prime256v1 --label issuing-ca
+//	LIC_TEST_PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so LIC_TEST_PKCS11_TOKEN=lic-test \
+//	    LIC_TEST_PKCS11_PIN=1234 LIC_TEST_PKCS11_KEY=issuing-ca go test ./internal/integration -run PKCS11
+const (
+	pkcs11ModuleEnv = "LIC_TEST_PKCS11_MODULE"
+	pkcs11TokenEnv  = "LIC_TEST_PKCS11_TOKEN"
+	pkcs11PINEnv    = "LIC_TEST_PKCS11_PIN"
+	pkcs11KeyEnv    = "LIC_TEST_PKCS11_KEY"
+)
+
+// parseChainPEM parses every certificate of a PEM bundle, in order
+func parseChainPEM(t *testing.T, chainPEM []byte) []*x509.Certificate {
+	t.Helper()
+
+	var certs []*x509.Certificate
+	for block, rest := pem.Decode(chainPEM); block != nil; block, rest = pem.Decode(rest) {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatalf("Failed to parse certificate: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+	return certs
+}
+
+// verifyIssued checks that chainPEM is a client certificate and the intermediate, chaining to rootPEM
+func verifyIssued(t *testing.T, chainPEM, rootPEM []byte) []*x509.Certificate {
+	t.Helper()
+
+	chain := parseChainPEM(t, chainPEM)
+	if len(chain) != 2 {
+		t.Fatalf("Expected the certificate and the intermediate, got %d certificates", len(chain))
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(rootPEM)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(chain[1])
+	if _, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		t.Fatalf("Issued certificate does not chain to the root: %v", err)
+	}
+	if _, err := chain[0].Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}}); err == nil {
+		t.Error("Expected the certificate to be issued by the intermediate, not the root")
+	}
+	return chain
+}
+
+func TestIntermediateCA(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	t.Run("Register returns the chain", func(t *testing.T) {
+		if err := env.store.CreateLicense(ctx, "7171717171", "Chained Org", 10); err != nil {
+			t.Fatalf("Failed to create license: %v", err)
+		}
+		token, _, err := env.svc.CreateEnrollmentToken(ctx, "7171717171", time.Hour, 1, "")
+		if err != nil {
+			t.Fatalf("Failed to create enrollment token: %v", err)
+		}
+		_, csrPEM := newCSR(t)
+		code, body := env.do(t, "POST", "/v1/register", router.RegisterRequest{
+			INN:   "7171717171",
+			CSR:   string(csrPEM),
+			Token: token,
+		}, nil, "")
+		if code != http.StatusOK {
+			t.Fatalf("Register failed: %d %s", code, body)
+		}
+		var resp router.RegisterResponse
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("Failed to decode register response: %v", err)
+		}
+		chain := verifyIssued(t, []byte(resp.Certificate), []byte(resp.CACertificate))
+		if !chain[1].Equal(mustParseCertPEM(t, env.ca.GetIssuerCertPEM())) {
+			t.Error("Expected the intermediate of the server in the chain")
+		}
+	})
+
+	t.Run("Runs without the root key", func(t *testing.T) {
+		dir := t.TempDir()
+		cfg := caConfig(dir)
+		ca, err := crypto.NewCAService(cfg)
+		if err != nil {
+			t.Fatalf("Failed to bootstrap CA: %v", err)
+		}
+		ca.Close()
+		if err := os.Remove(cfg.RootKeyPath); err != nil {
+			t.Fatalf("Failed to remove root key: %v", err)
+		}
+
+		ca, err = crypto.NewCAService(cfg)
+		if err != nil {
+			t.Fatalf("Expected the CA to start from the intermediate alone: %v", err)
+		}
+		defer ca.Close()
+		_, csrPEM := newCSR(t)
+		block, _ := pem.Decode(csrPEM)
+		csr, _ := x509.ParseCertificateRequest(block.Bytes)
+		certPEM, err := ca.SignCSR(csr)
+		if err != nil {
+			t.Fatalf("SignCSR failed: %v", err)
+		}
+		verifyIssued(t, certPEM, ca.GetCACertPEM())
+
+		// A new intermediate can't be made without the root
+		cfg.IntermediateCertPath = filepath.Join(dir, "other.crt")
+		cfg.Signer.KeyPath = filepath.Join(dir, "other.key")
+		if _, err := crypto.NewCAService(cfg); err == nil {
+			t.Error("Expected an error for a missing intermediate without the root key")
+		}
+	})
+
+	t.Run("Refuses a foreign intermediate", func(t *testing.T) {
+		dir := t.TempDir()
+		cfg := caConfig(dir)
+		ca, err := crypto.NewCAService(cfg)
+		if err != nil {
+			t.Fatalf("Failed to bootstrap CA: %v", err)
+		}
+		ca.Close()
+
+		// The key no longer matches the certificate
+		good := cfg.Signer.KeyPath
+		cfg.Signer.KeyPath = filepath.Join(dir, "stray.key")
+		if _, err := crypto.GenerateKeyFile(cfg.Signer.KeyPath); err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		if _, err := crypto.NewCAService(cfg); err == nil {
+			t.Error("Expected an error for a key that does not match the intermediate")
+		}
+
+		// The intermediate is signed by another root
+		other := caConfig(t.TempDir())
+		if err := crypto.GenerateCA(other.RootCertPath, other.RootKeyPath); err != nil {
+			t.Fatalf("Failed to generate CA: %v", err)
+		}
+		cfg.Signer.KeyPath = good
+		cfg.RootCertPath = other.RootCertPath
+		if _, err := crypto.NewCAService(cfg); err == nil {
+			t.Error("Expected an error for an intermediate of another root")
+		}
+	})
+
+	t.Run("Missing PKCS#11 module", func(t *testing.T) {
+		_, err := crypto.OpenSigner(crypto.SignerConfig{
+			Backend:        crypto.SignerPKCS11,
+			PKCS11Module:   filepath.Join(t.TempDir(), "missing.so"),
+			PKCS11KeyLabel: "issuing-ca",
+		})
+		if err == nil {
+			t.Error("Expected an error for a module that does not exist")
+		}
+	})
+}
+
+func TestPKCS11Signer(t *testing.T) {
+	module := os.Getenv(pkcs11ModuleEnv)
+	if module == "" {
+		t.Skipf("%s is not set", pkcs11ModuleEnv)
+	}
+	signerCfg := crypto.SignerConfig{
+		Backend:          crypto.SignerPKCS11,
+		PKCS11Module:     module,
+		PKCS11TokenLabel: os.Getenv(pkcs11TokenEnv),
+		PKCS11PIN:        os.Getenv(pkcs11PINEnv),
+		PKCS11KeyLabel:   os.Getenv(pkcs11KeyEnv),
+	}
+
+	// The offline root signs the key in the token, then leaves
+	dir := t.TempDir()
+	cfg := caConfig(dir)
+	cfg.Signer = signerCfg
+	if err := crypto.GenerateCA(cfg.RootCertPath, cfg.RootKeyPath); err != nil {
+		t.Fatalf("Failed to generate CA: %v", err)
+	}
+	root, err := crypto.LoadCertificate(cfg.RootCertPath)
+	if err != nil {
+		t.Fatalf("Failed to load root: %v", err)
+	}
+	rootKey, err := crypto.LoadPrivateKey(cfg.RootKeyPath)
+	if err != nil {
+		t.Fatalf("Failed to load root key: %v", err)
+	}
+	tokenKey, err := crypto.OpenSigner(signerCfg)
+	if err != nil {
+		t.Fatalf("Failed to open PKCS#11 key: %v", err)
+	}
+	der, err := crypto.SignIntermediate(root, rootKey, tokenKey.Public(), pkix.Name{CommonName: "PKCS#11 Issuing CA"}, 24*time.Hour)
+	tokenKey.Close()
+	if err != nil {
+		t.Fatalf("Failed to sign intermediate: %v", err)
+	}
+	if err := os.WriteFile(cfg.IntermediateCertPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Failed to write intermediate: %v", err)
+	}
+	if err := os.Remove(cfg.RootKeyPath); err != nil {
+		t.Fatalf("Failed to remove root key: %v", err)
+	}
+
+	ca, err := crypto.NewCAService(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create CA service: %v", err)
+	}
+	defer ca.Close()
+
+	_, csrPEM := newCSR(t)
+	block, _ := pem.Decode(csrPEM)
+	csr, _ := x509.ParseCertificateRequest(block.Bytes)
+	certPEM, err := ca.SignCSR(csr)
+	if err != nil {
+		t.Fatalf("SignCSR failed: %v", err)
+	}
+	chain := verifyIssued(t, certPEM, ca.GetCACertPEM())
+
+	crlDER, err := ca.CreateCRL([]x509.RevocationListEntry{{SerialNumber: chain[0].SerialNumber, RevocationTime: time.Now()}}, big.NewInt(1), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("CreateCRL failed: %v", err)
+	}
+	crl, err := x509.ParseRevocationList(crlDER)
+	if err != nil {
+		t.Fatalf("Failed to parse CRL: %v", err)
+	}
+	if err := crl.CheckSignatureFrom(chain[1]); err != nil {
+		t.Errorf("CRL not signed by the token key: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package integration_test
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+func TestCertificateBindingAdmin(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "7373737373"
+	base := "/api/admin/licenses/" + inn + "/certificates"
+
+	if err := env.store.CreateLicense(ctx, inn, "Support Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	enrollToken, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	cert, leaf := env.register(t, inn, enrollToken)
+	serial := leaf.SerialNumber.String()
+	path := base + "/" + serial
+
+	activate := func() int {
+		code, _ := env.do(t, "POST", "/v1/activate", map[string]interface{}{"inn": inn, "fingerprint": "support-host"}, cert, "")
+		return code
+	}
+	crlReason := func(t *testing.T) (int, bool) {
+		t.Helper()
+		_, body := env.do(t, "GET", "/v1/crl", nil, nil, "")
+		crl, err := x509.ParseRevocationList(body)
+		if err != nil {
+			t.Fatalf("Failed to parse CRL: %v", err)
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+				return entry.ReasonCode, true
+			}
+		}
+		return 0, false
+	}
+	binding := func(body []byte) *storage.ClientCertBinding {
+		var b storage.ClientCertBinding
+		_ = json.Unmarshal(body, &b)
+		return &b
+	}
+
+	t.Run("List and inspect bindings", func(t *testing.T) {
+		code, body := env.do(t, "GET", base, nil, nil, testAdminKey)
+		var bindings []*storage.ClientCertBinding
+		_ = json.Unmarshal(body, &bindings)
+		if code != http.StatusOK || len(bindings) != 1 {
+			t.Fatalf("Expected one binding, got %d %s", code, body)
+		}
+		b := bindings[0]
+		if b.CertSerial != serial || b.SubjectCN != leaf.Subject.CommonName || b.Status != "active" || !b.ExpiresAt.Equal(leaf.NotAfter) {
+			t.Errorf("Unexpected binding: %+v", b)
+		}
+
+		if code, body := env.do(t, "GET", path, nil, nil, testAdminKey); code != http.StatusOK || binding(body).CertSerial != serial {
+			t.Errorf("Expected the binding, got %d %s", code, body)
+		}
+		if code, _ := env.do(t, "GET", "/api/admin/licenses/0000000000/certificates/"+serial, nil, nil, testAdminKey); code != http.StatusNotFound {
+			t.Errorf("Expected 404 for a certificate of another license, got %d", code)
+		}
+		if code, _ := env.do(t, "GET", "/api/admin/licenses/0000000000/certificates", nil, nil, testAdminKey); code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown license, got %d", code)
+		}
+	})
+
+	t.Run("Suspend puts the certificate on hold", func(t *testing.T) {
+		code, body := env.do(t, "POST", path+"/suspend", nil, nil, testAdminKey)
+		if code != http.StatusOK || binding(body).Status != "suspended" {
+			t.Fatalf("Suspend failed: %d %s", code, body)
+		}
+		if code := activate(); code != http.StatusForbidden {
+			t.Errorf("Expected a suspended certificate to be refused, got %d", code)
+		}
+		if reason, ok := crlReason(t); !ok || reason != 6 {
+			t.Errorf("Expected the serial in the CRL with certificateHold, got %d, %v", reason, ok)
+		}
+		if code, _ := env.do(t, "POST", path+"/suspend", nil, nil, testAdminKey); code != http.StatusConflict {
+			t.Errorf("Expected 409 when suspending twice, got %d", code)
+		}
+	})
+
+	t.Run("Reactivate takes it off hold", func(t *testing.T) {
+		code, body := env.do(t, "POST", path+"/reactivate", nil, nil, testAdminKey)
+		if code != http.StatusOK || binding(body).Status != "active" || binding(body).RevokedAt != nil {
+			t.Fatalf("Reactivate failed: %d %s", code, body)
+		}
+		if code := activate(); code != http.StatusOK {
+			t.Errorf("Expected the reactivated certificate to work, got %d", code)
+		}
+		if _, ok := crlReason(t); ok {
+			t.Error("Expected the serial to leave the CRL")
+		}
+	})
+
+	t.Run("Revoke is final", func(t *testing.T) {
+		if code, _ := env.do(t, "POST", path+"/revoke", map[string]string{"reason": "bogus"}, nil, testAdminKey); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown reason, got %d", code)
+		}
+		code, body := env.do(t, "POST", path+"/revoke", map[string]string{"reason": "cessation_of_operation"}, nil, testAdminKey)
+		if code != http.StatusOK || binding(body).Status != "revoked" {
+			t.Fatalf("Revoke failed: %d %s", code, body)
+		}
+		if code := activate(); code != http.StatusForbidden {
+			t.Errorf("Expected a revoked certificate to be refused, got %d", code)
+		}
+		if reason, ok := crlReason(t); !ok || reason != 5 {
+			t.Errorf("Expected the serial in the CRL with cessationOfOperation, got %d, %v", reason, ok)
+		}
+		if code, _ := env.do(t, "POST", path+"/reactivate", nil, nil, testAdminKey); code != http.StatusConflict {
+			t.Errorf("Expected 409 when reactivating a revoked certificate, got %d", code)
+		}
+	})
+
+	t.Run("Actions are audited", func(t *testing.T) {
+		counts := map[string]int{}
+		events, _ := env.store.QueryAuditEvents(ctx, storage.AuditFilter{INN: inn})
+		for _, e := range events {
+			counts[e.Action]++
+		}
+		for _, action := range []string{"cert_suspended", "cert_reactivated", "cert_revoked"} {
+			if counts[action] != 1 {
+				t.Errorf("Expected one %s event, got %d", action, counts[action])
+			}
+		}
+	})
+}
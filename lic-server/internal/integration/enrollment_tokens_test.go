@@ -0,0 +1,167 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/api/router"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+func TestEnrollmentTokenLifecycle(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "5151515151"
+
+	if err := env.store.CreateLicense(ctx, inn, "Token Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	var token string
+	var record storage.EnrollmentToken
+
+	t.Run("Create multi-use token", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/api/admin/tokens", map[string]interface{}{
+			"inn":       inn,
+			"ttl_hours": 1,
+			"max_uses":  2,
+		}, nil, testAdminKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", code, body)
+		}
+		var resp struct {
+			Token           string                  `json:"token"`
+			EnrollmentToken storage.EnrollmentToken `json:"enrollment_token"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		token, record = resp.Token, resp.EnrollmentToken
+		if !strings.HasPrefix(token, record.TokenPrefix) || record.TokenPrefix == "" {
+			t.Errorf("Expected prefix %q to start the token", record.TokenPrefix)
+		}
+		if record.MaxUses != 2 || record.UseCount != 0 {
+			t.Errorf("Expected 0 of 2 uses, got %d of %d", record.UseCount, record.MaxUses)
+		}
+	})
+
+	var serials []string
+	t.Run("Token allows max_uses registrations", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			_, leaf := env.register(t, inn, token)
+			serials = append(serials, leaf.SerialNumber.String())
+		}
+
+		_, csrPEM := newCSR(t)
+		code, body := env.do(t, "POST", "/v1/register", router.RegisterRequest{INN: inn, CSR: string(csrPEM), Token: token}, nil, "")
+		if code == http.StatusOK {
+			t.Fatalf("Third registration must be refused: %s", body)
+		}
+	})
+
+	t.Run("List does not reveal the token", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/api/admin/licenses/"+inn+"/tokens", nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		if strings.Contains(string(body), token) {
+			t.Fatalf("Token list contains the plaintext token")
+		}
+		var tokens []storage.EnrollmentToken
+		json.Unmarshal(body, &tokens)
+		if len(tokens) != 1 || tokens[0].UseCount != 2 {
+			t.Fatalf("Expected one token with 2 uses, got %+v", tokens)
+		}
+	})
+
+	t.Run("Uses name the issued certificates", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/api/admin/tokens/"+strconv.FormatInt(record.ID, 10)+"/uses", nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		var uses []storage.EnrollmentTokenUse
+		json.Unmarshal(body, &uses)
+		if len(uses) != len(serials) {
+			t.Fatalf("Expected %d uses, got %d", len(serials), len(uses))
+		}
+		for i, u := range uses {
+			if u.CertSerial != serials[i] || u.INN != inn {
+				t.Errorf("Use %d: expected serial %s for %s, got %s for %s", i, serials[i], inn, u.CertSerial, u.INN)
+			}
+		}
+	})
+
+	t.Run("Revoked token is refused", func(t *testing.T) {
+		fresh, issued, err := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 5, "")
+		if err != nil {
+			t.Fatalf("Failed to create token: %v", err)
+		}
+		path := "/api/admin/tokens/" + strconv.FormatInt(issued.ID, 10)
+		if code, body := env.do(t, "DELETE", path, nil, nil, testAdminKey); code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		if code, _ := env.do(t, "DELETE", path, nil, nil, testAdminKey); code != http.StatusNotFound {
+			t.Fatalf("Expected 404 on second revocation, got %d", code)
+		}
+
+		_, csrPEM := newCSR(t)
+		code, body := env.do(t, "POST", "/v1/register", router.RegisterRequest{INN: inn, CSR: string(csrPEM), Token: fresh}, nil, "")
+		if code == http.StatusOK {
+			t.Fatalf("Registration with a revoked token must be refused: %s", body)
+		}
+	})
+}
+
+func TestStaticEnrollmentToken(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	static := "static-token-for-every-inn"
+
+	for _, inn := range []string{"6161616161", "6262626262"} {
+		if err := env.store.CreateLicense(ctx, inn, "Static Org", 10); err != nil {
+			t.Fatalf("Failed to create license: %v", err)
+		}
+	}
+
+	if _, err := env.svc.EnsureStaticEnrollmentToken(ctx, static, time.Time{}, time.Hour, 2); err != nil {
+		t.Fatalf("Failed to register static token: %v", err)
+	}
+
+	t.Run("Valid for any INN up to max uses", func(t *testing.T) {
+		env.register(t, "6161616161", static)
+		env.register(t, "6262626262", static)
+
+		_, csrPEM := newCSR(t)
+		code, _ := env.do(t, "POST", "/v1/register", router.RegisterRequest{INN: "6161616161", CSR: string(csrPEM), Token: static}, nil, "")
+		if code == http.StatusOK {
+			t.Fatalf("Static token must stop after max uses")
+		}
+	})
+
+	t.Run("Raising max uses on restart keeps the use count", func(t *testing.T) {
+		tok, err := env.svc.EnsureStaticEnrollmentToken(ctx, static, time.Time{}, time.Hour, 3)
+		if err != nil {
+			t.Fatalf("Failed to update static token: %v", err)
+		}
+		if tok.UseCount != 2 || tok.MaxUses != 3 {
+			t.Fatalf("Expected 2 of 3 uses, got %d of %d", tok.UseCount, tok.MaxUses)
+		}
+		env.register(t, "6161616161", static)
+	})
+
+	t.Run("Expired static token is refused", func(t *testing.T) {
+		if _, err := env.svc.EnsureStaticEnrollmentToken(ctx, static, time.Now().Add(-time.Minute), time.Hour, 0); err != nil {
+			t.Fatalf("Failed to update static token: %v", err)
+		}
+		_, csrPEM := newCSR(t)
+		code, _ := env.do(t, "POST", "/v1/register", router.RegisterRequest{INN: "6262626262", CSR: string(csrPEM), Token: static}, nil, "")
+		if code == http.StatusOK {
+			t.Fatalf("Expired static token must be refused")
+		}
+	})
+}
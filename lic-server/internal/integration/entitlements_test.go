@@ -0,0 +1,116 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestEntitlements(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "7171717171"
+	path := "/api/admin/licenses/" + inn + "/entitlements"
+
+	if err := env.store.CreateLicense(ctx, inn, "Modules Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	enrollToken, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	cert, _ := env.register(t, inn, enrollToken)
+
+	parseClaims := func(t *testing.T, token string) *license.LicenseClaims {
+		t.Helper()
+		claims := &license.LicenseClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		return claims
+	}
+
+	t.Run("Manage entitlements", func(t *testing.T) {
+		code, body := env.do(t, "PUT", path, map[string]interface{}{
+			"network_scanner": true,
+			"retention_days":  90,
+		}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+
+		code, body = env.do(t, "PUT", path+"/retention_days", map[string]interface{}{"value": 365}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		code, body = env.do(t, "PUT", path+"/inventory_reports", map[string]interface{}{"value": true}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		code, body = env.do(t, "DELETE", path+"/inventory_reports", nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+
+		var ents map[string]interface{}
+		json.Unmarshal(body, &ents)
+		if len(ents) != 2 || ents["network_scanner"] != true || ents["retention_days"] != float64(365) {
+			t.Fatalf("Unexpected entitlements: %s", body)
+		}
+	})
+
+	t.Run("Invalid entitlements are rejected", func(t *testing.T) {
+		for _, value := range []interface{}{"yes", -1, 1.5} {
+			if code, _ := env.do(t, "PUT", path+"/retention_days", map[string]interface{}{"value": value}, nil, testAdminKey); code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for value %v, got %d", value, code)
+			}
+		}
+		if code, _ := env.do(t, "PUT", path+"/Bad%20Key", map[string]interface{}{"value": true}, nil, testAdminKey); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an invalid key, got %d", code)
+		}
+		if code, _ := env.do(t, "GET", "/api/admin/licenses/0000000000/entitlements", nil, nil, testAdminKey); code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown license, got %d", code)
+		}
+	})
+
+	var claims *license.LicenseClaims
+	t.Run("Token carries entitlements", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/v1/activate", map[string]interface{}{
+			"inn": inn, "fingerprint": "ent-host", "used_slots": 1,
+		}, cert, "")
+		if code != http.StatusOK {
+			t.Fatalf("Activate failed: %d %s", code, body)
+		}
+		var activated struct {
+			Token string `json:"token"`
+		}
+		_ = json.Unmarshal(body, &activated)
+		claims = parseClaims(t, activated.Token)
+		if claims.Entitlements["network_scanner"] != true || claims.Entitlements["retention_days"] != float64(365) {
+			t.Fatalf("Unexpected ent claim: %v", claims.Entitlements)
+		}
+	})
+
+	t.Run("Changed entitlements refresh the token on heartbeat", func(t *testing.T) {
+		if code, body := env.do(t, "PUT", path+"/network_scanner", map[string]interface{}{"value": false}, nil, testAdminKey); code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+
+		code, body := env.do(t, "POST", "/v1/heartbeat", map[string]interface{}{
+			"fingerprint": "ent-host", "used_slots": 1, "jti": claims.ID, "version": claims.KeyVersion,
+		}, cert, "")
+		var resp struct {
+			Status string `json:"status"`
+			Token  string `json:"token"`
+		}
+		_ = json.Unmarshal(body, &resp)
+		if code != http.StatusOK || resp.Status != license.HeartbeatUpdated {
+			t.Fatalf("Expected updated, got %d %s", code, body)
+		}
+		if ents := parseClaims(t, resp.Token).Entitlements; ents["network_scanner"] != false {
+			t.Errorf("Expected the refreshed token to disable network_scanner, got %v", ents)
+		}
+	})
+}
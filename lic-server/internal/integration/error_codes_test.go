@@ -0,0 +1,95 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+)
+
+// TestErrorCodes checks the codes licd branches on: they must stay stable whatever the messages say
+func TestErrorCodes(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "5151515151"
+
+	if err := env.store.CreateLicense(ctx, inn, "Codes Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	token, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	cert, _ := env.register(t, inn, token)
+
+	expectCode := func(t *testing.T, status int, body []byte, wantStatus int, wantCode string) {
+		t.Helper()
+		var resp struct {
+			Error string `json:"error"`
+			Code  string `json:"code"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("Failed to decode error response %q: %v", body, err)
+		}
+		if status != wantStatus || resp.Code != wantCode {
+			t.Fatalf("Expected %d %s, got %d %s: %s", wantStatus, wantCode, status, resp.Code, resp.Error)
+		}
+		if resp.Error == "" {
+			t.Errorf("Expected a message alongside code %s", resp.Code)
+		}
+	}
+	activate := func(inn, fingerprint string) (int, []byte) {
+		return env.do(t, "POST", "/v1/activate", map[string]string{"inn": inn, "fingerprint": fingerprint}, cert, "")
+	}
+
+	if code, body := activate(inn, "hw-1"); code != http.StatusOK {
+		t.Fatalf("Expected 200 on first activation, got %d: %s", code, body)
+	}
+
+	t.Run("Invalid request", func(t *testing.T) {
+		status, body := env.do(t, "POST", "/v1/register", map[string]string{"inn": inn}, nil, "")
+		expectCode(t, status, body, http.StatusBadRequest, license.CodeInvalidRequest)
+	})
+
+	t.Run("Invalid enrollment token", func(t *testing.T) {
+		_, csr := newCSR(t)
+		status, body := env.do(t, "POST", "/v1/register", map[string]string{
+			"inn": inn, "csr": string(csr), "token": "wrong-token",
+		}, nil, "")
+		expectCode(t, status, body, http.StatusForbidden, license.CodeEnrollmentTokenInvalid)
+	})
+
+	t.Run("License not found", func(t *testing.T) {
+		status, body := activate("9999999999", "hw-1")
+		expectCode(t, status, body, http.StatusNotFound, license.CodeLicenseNotFound)
+	})
+
+	t.Run("Certificate bound to another INN", func(t *testing.T) {
+		if err := env.store.CreateLicense(ctx, "5252525252", "Other Org", 10); err != nil {
+			t.Fatalf("Failed to create license: %v", err)
+		}
+		status, body := activate("5252525252", "hw-1")
+		expectCode(t, status, body, http.StatusForbidden, license.CodeCertificateINNMismatch)
+	})
+
+	t.Run("Hardware mismatch", func(t *testing.T) {
+		status, body := activate(inn, "hw-2")
+		expectCode(t, status, body, http.StatusForbidden, license.CodeHardwareMismatch)
+	})
+
+	t.Run("License expired", func(t *testing.T) {
+		if err := env.store.UpdateLicenseTerm(ctx, inn, time.Now().AddDate(0, 0, -10), false, 3); err != nil {
+			t.Fatalf("Failed to expire license: %v", err)
+		}
+		status, body := activate(inn, "hw-1")
+		expectCode(t, status, body, http.StatusForbidden, license.CodeLicenseExpired)
+	})
+
+	t.Run("License revoked", func(t *testing.T) {
+		if err := env.store.UpdateLicenseStatus(ctx, inn, license.StatusRevoked); err != nil {
+			t.Fatalf("Failed to revoke license: %v", err)
+		}
+		status, body := activate(inn, "hw-1")
+		expectCode(t, status, body, http.StatusForbidden, license.CodeLicenseInactive)
+	})
+}
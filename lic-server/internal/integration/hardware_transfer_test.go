@@ -0,0 +1,187 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/api/router"
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/storage"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestHardwareTransfer(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "7272727272"
+
+	if err := env.store.CreateLicense(ctx, inn, "Moving Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	env.svc.SetTransferQuota(1)
+	enrollToken, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	cert, _ := env.register(t, inn, enrollToken)
+
+	activate := func(fingerprint string) (int, []byte) {
+		return env.do(t, "POST", "/v1/activate", map[string]interface{}{"inn": inn, "fingerprint": fingerprint}, cert, "")
+	}
+	transfer := func(oldFP, newFP string) (int, router.TransferResponse) {
+		code, body := env.do(t, "POST", "/v1/transfer", map[string]interface{}{
+			"old_fingerprint": oldFP, "new_fingerprint": newFP, "reason": "hardware replaced",
+		}, cert, "")
+		var res router.TransferResponse
+		_ = json.Unmarshal(body, &res)
+		return code, res
+	}
+
+	var firstToken license.LicenseClaims
+	t.Run("First activation binds the machine", func(t *testing.T) {
+		code, body := activate("hw-1")
+		if code != http.StatusOK {
+			t.Fatalf("Activate failed: %d %s", code, body)
+		}
+		var activated router.ActivateResponse
+		_ = json.Unmarshal(body, &activated)
+		if _, _, err := jwt.NewParser().ParseUnverified(activated.Token, &firstToken); err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		code, body = activate("hw-2")
+		if code != http.StatusForbidden || !strings.Contains(string(body), "hardware fingerprint mismatch") {
+			t.Fatalf("Expected 403 on another machine, got %d %s", code, body)
+		}
+	})
+
+	t.Run("Invalid transfer requests are rejected", func(t *testing.T) {
+		if code, _ := transfer("hw-1", "hw-1"); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for the same fingerprints, got %d", code)
+		}
+		if code, _ := transfer("hw-9", "hw-2"); code != http.StatusForbidden {
+			t.Errorf("Expected 403 for a machine the instance is not bound to, got %d", code)
+		}
+	})
+
+	t.Run("Transfer within the quota is approved at once", func(t *testing.T) {
+		code, res := transfer("hw-1", "hw-2")
+		if code != http.StatusOK || res.Status != license.TransferApproved || !res.AutoApproved {
+			t.Fatalf("Expected an auto-approved transfer, got %d %+v", code, res)
+		}
+		// The token of the old machine is replaced by one for the new machine
+		code, body := env.do(t, "POST", "/v1/heartbeat", map[string]interface{}{
+			"fingerprint": "hw-2", "jti": firstToken.ID, "version": firstToken.KeyVersion,
+		}, cert, "")
+		var hb router.HeartbeatResponse
+		_ = json.Unmarshal(body, &hb)
+		var refreshed license.LicenseClaims
+		_, _, _ = jwt.NewParser().ParseUnverified(hb.Token, &refreshed)
+		if code != http.StatusOK || hb.Status != license.HeartbeatUpdated || refreshed.FingerprintHash != "hw-2" {
+			t.Fatalf("Expected a token for hw-2, got %d %s", code, body)
+		}
+
+		if code, body := activate("hw-2"); code != http.StatusOK {
+			t.Fatalf("Activate on the new machine failed: %d %s", code, body)
+		}
+		if code, _ := activate("hw-1"); code != http.StatusForbidden {
+			t.Errorf("Expected the old machine to be refused, got %d", code)
+		}
+		// Asking again reports the same decision
+		if code, again := transfer("hw-1", "hw-2"); code != http.StatusOK || again.ID != res.ID {
+			t.Errorf("Expected the same transfer, got %d %+v", code, again)
+		}
+	})
+
+	var pendingID int64
+	t.Run("Transfer over the quota waits for an admin", func(t *testing.T) {
+		code, res := transfer("hw-2", "hw-3")
+		if code != http.StatusOK || res.Status != license.TransferPending {
+			t.Fatalf("Expected a pending transfer, got %d %+v", code, res)
+		}
+		pendingID = res.ID
+		if code, again := transfer("hw-2", "hw-3"); code != http.StatusOK || again.ID != pendingID {
+			t.Errorf("Expected the pending transfer to be returned again, got %d %+v", code, again)
+		}
+		if code, _ := transfer("hw-2", "hw-4"); code != http.StatusConflict {
+			t.Errorf("Expected 409 for a second pending transfer, got %d", code)
+		}
+		if code, _ := activate("hw-3"); code != http.StatusForbidden {
+			t.Errorf("Expected the new machine to be refused until approval, got %d", code)
+		}
+
+		code, body := env.do(t, "GET", "/api/admin/transfers?status=pending", nil, nil, testAdminKey)
+		var pending []*storage.HardwareTransfer
+		_ = json.Unmarshal(body, &pending)
+		if code != http.StatusOK || len(pending) != 1 || pending[0].ID != pendingID {
+			t.Fatalf("Expected one pending transfer, got %d %s", code, body)
+		}
+	})
+
+	t.Run("Admin approves the transfer", func(t *testing.T) {
+		path := fmt.Sprintf("/api/admin/transfers/%d/approve", pendingID)
+		code, body := env.do(t, "POST", path, map[string]string{"note": "confirmed by phone"}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Approve failed: %d %s", code, body)
+		}
+		if code, _ := env.do(t, "POST", path, nil, nil, testAdminKey); code != http.StatusConflict {
+			t.Errorf("Expected 409 for a decided transfer, got %d", code)
+		}
+		if code, body := activate("hw-3"); code != http.StatusOK {
+			t.Fatalf("Activate after approval failed: %d %s", code, body)
+		}
+		if code, _ := env.do(t, "POST", "/api/admin/transfers/999999/approve", nil, nil, testAdminKey); code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown transfer, got %d", code)
+		}
+	})
+
+	t.Run("Admin rejects the transfer", func(t *testing.T) {
+		_, res := transfer("hw-3", "hw-4")
+		code, body := env.do(t, "POST", fmt.Sprintf("/api/admin/transfers/%d/reject", res.ID), map[string]string{"note": "unknown machine"}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Reject failed: %d %s", code, body)
+		}
+		if code, _ := activate("hw-4"); code != http.StatusForbidden {
+			t.Errorf("Expected the rejected machine to be refused, got %d", code)
+		}
+		if code, _ := activate("hw-3"); code != http.StatusOK {
+			t.Errorf("Expected the instance to stay on its machine, got %d", code)
+		}
+	})
+
+	t.Run("Entitlement overrides the quota", func(t *testing.T) {
+		if err := env.svc.SetEntitlement(ctx, inn, license.TransferQuotaEntitlement, 5, ""); err != nil {
+			t.Fatalf("SetEntitlement failed: %v", err)
+		}
+		if _, res := transfer("hw-3", "hw-5"); res.Status != license.TransferApproved {
+			t.Errorf("Expected the raised quota to approve the transfer, got %+v", res)
+		}
+	})
+
+	t.Run("Every transfer is audited", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/api/admin/licenses/"+inn+"/transfers", nil, nil, testAdminKey)
+		var transfers []*storage.HardwareTransfer
+		_ = json.Unmarshal(body, &transfers)
+		if code != http.StatusOK || len(transfers) != 4 {
+			t.Fatalf("Expected 4 transfers, got %d %s", code, body)
+		}
+
+		counts := map[string]int{}
+		events, _ := env.store.QueryAuditEvents(ctx, storage.AuditFilter{INN: inn})
+		for _, e := range events {
+			counts[e.Action]++
+		}
+		want := map[string]int{
+			"hardware_bound":              1,
+			"hardware_transfer_requested": 4,
+			"hardware_transfer_approved":  3,
+			"hardware_transfer_rejected":  1,
+		}
+		for action, n := range want {
+			if counts[action] != n {
+				t.Errorf("Expected %d %s events, got %d", n, action, counts[action])
+			}
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestHeartbeatTokenRefresh(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "4444444444"
+
+	if err := env.store.CreateLicense(ctx, inn, "Heartbeat Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	enrollToken, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	cert, _ := env.register(t, inn, enrollToken)
+
+	parseClaims := func(t *testing.T, token string) *license.LicenseClaims {
+		t.Helper()
+		claims := &license.LicenseClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		return claims
+	}
+	heartbeat := func(t *testing.T, claims *license.LicenseClaims) (int, license.HeartbeatResult) {
+		t.Helper()
+		code, body := env.do(t, "POST", "/v1/heartbeat", map[string]interface{}{
+			"fingerprint": "hb-host", "used_slots": 1, "jti": claims.ID, "version": claims.KeyVersion,
+		}, cert, "")
+		var resp struct {
+			Status        string `json:"status"`
+			LicenseStatus string `json:"license_status"`
+			Token         string `json:"token"`
+			Reason        string `json:"reason"`
+		}
+		_ = json.Unmarshal(body, &resp)
+		return code, license.HeartbeatResult{Status: resp.Status, LicenseStatus: resp.LicenseStatus, Token: resp.Token, Reason: resp.Reason}
+	}
+
+	code, body := env.do(t, "POST", "/v1/activate", map[string]interface{}{
+		"inn": inn, "fingerprint": "hb-host", "used_slots": 1,
+	}, cert, "")
+	if code != http.StatusOK {
+		t.Fatalf("Activate failed: %d %s", code, body)
+	}
+	var activated struct {
+		Token string `json:"token"`
+	}
+	_ = json.Unmarshal(body, &activated)
+	claims := parseClaims(t, activated.Token)
+
+	t.Run("Current token is unchanged", func(t *testing.T) {
+		code, result := heartbeat(t, claims)
+		if code != http.StatusOK || result.Status != license.HeartbeatUnchanged || result.Token != "" {
+			t.Fatalf("Expected unchanged, got %d %+v", code, result)
+		}
+	})
+
+	t.Run("License changes return a new token", func(t *testing.T) {
+		code, body := env.do(t, "PUT", "/api/admin/licenses/"+inn+"/details", map[string]interface{}{
+			"organization": "Heartbeat Org Renamed", "max_slots": 20,
+		}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Update details failed: %d %s", code, body)
+		}
+
+		code, result := heartbeat(t, claims)
+		if code != http.StatusOK || result.Status != license.HeartbeatUpdated {
+			t.Fatalf("Expected updated, got %d %+v", code, result)
+		}
+		newClaims := parseClaims(t, result.Token)
+		if newClaims.OrgName != "Heartbeat Org Renamed" || newClaims.MaxAgents != 20 || newClaims.FingerprintHash != "hb-host" {
+			t.Errorf("Unexpected refreshed claims: %+v", newClaims)
+		}
+		if newClaims.ID == claims.ID {
+			t.Error("Expected a new jti")
+		}
+		claims = newClaims
+
+		if _, result := heartbeat(t, claims); result.Status != license.HeartbeatUnchanged {
+			t.Errorf("Expected the refreshed token to be current, got %+v", result)
+		}
+	})
+
+	t.Run("Key rotation returns a new token", func(t *testing.T) {
+		if code, body := env.do(t, "POST", "/api/admin/keys/rotate", nil, nil, testAdminKey); code != http.StatusOK {
+			t.Fatalf("Rotate failed: %d %s", code, body)
+		}
+		_, result := heartbeat(t, claims)
+		if result.Status != license.HeartbeatUpdated {
+			t.Fatalf("Expected updated after key rotation, got %+v", result)
+		}
+		newClaims := parseClaims(t, result.Token)
+		if newClaims.KeyVersion == claims.KeyVersion {
+			t.Errorf("Expected the new key version, got %d", newClaims.KeyVersion)
+		}
+		claims = newClaims
+	})
+
+	t.Run("Plain heartbeat keeps the old response", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/v1/heartbeat", nil, cert, "")
+		var resp map[string]string
+		_ = json.Unmarshal(body, &resp)
+		if code != http.StatusOK || resp["status"] != "ok" {
+			t.Errorf("Expected ok, got %d %s", code, body)
+		}
+	})
+
+	t.Run("Suspended license returns a revocation notice", func(t *testing.T) {
+		code, body := env.do(t, "PUT", "/api/admin/licenses/"+inn+"/status", map[string]interface{}{"status": "suspended"}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Update status failed: %d %s", code, body)
+		}
+		code, result := heartbeat(t, claims)
+		if code != http.StatusOK || result.Status != license.HeartbeatRevoked || result.Reason != "suspended" {
+			t.Fatalf("Expected revocation notice, got %d %+v", code, result)
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package integration_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deymonster/lic-server/internal/api/router"
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/infrastructure/crypto"
+	"github.com/deymonster/lic-server/internal/infrastructure/metrics"
+	"github.com/deymonster/lic-server/internal/storage/sqlite"
+)
+
+const testAdminKey = "test-admin-key"
+
+// testEnv bundles a fully wired lic-server behind an httptest TLS server
+type testEnv struct {
+	dbPath  string
+	store   *sqlite.Storage
+	ca      *crypto.CAService
+	svc     *license.Service
+	metrics *metrics.Metrics
+	ts      *httptest.Server
+}
+
+// caConfig lays out a root CA and a file-backed intermediate in dir, both generated on first use
+func caConfig(dir string) crypto.CAConfig {
+	return crypto.CAConfig{
+		RootCertPath:         filepath.Join(dir, "ca.crt"),
+		RootKeyPath:          filepath.Join(dir, "ca.key"),
+		IntermediateCertPath: filepath.Join(dir, "intermediate.crt"),
+		Signer:               crypto.SignerConfig{KeyPath: filepath.Join(dir, "intermediate.key")},
+	}
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	return newTestEnvWithLimits(t, router.RateLimits{})
+}
+
+// newTestEnvWithLimits is newTestEnv with rate limiting enabled
+func newTestEnvWithLimits(t *testing.T, limits router.RateLimits) *testEnv {
+	t.Helper()
+	return newTestEnvBehindProxies(t, limits, nil)
+}
+
+// newTestEnvBehindProxies is newTestEnvWithLimits with forwarding headers believed from proxies
+func newTestEnvBehindProxies(t *testing.T, limits router.RateLimits, proxies router.TrustedProxies) *testEnv {
+	t.Helper()
+
+	tempDir := t.TempDir()
+	caCertPath := filepath.Join(tempDir, "ca.crt")
+	tokenPrivPath := filepath.Join(tempDir, "token.key")
+
+	dbPath := filepath.Join(tempDir, "lic.db")
+	store, err := sqlite.NewStorage(dbPath)
+	if err != nil {
+		t.Fatalf("Failed to create storage: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	caSvc, err := crypto.NewCAService(caConfig(tempDir))
+	if err != nil {
+		t.Fatalf("Failed to create CA service: %v", err)
+	}
+	t.Cleanup(func() { caSvc.Close() })
+
+	tokenSvc, err := crypto.NewTokenService(tokenPrivPath)
+	if err != nil {
+		t.Fatalf("Failed to create Token service: %v", err)
+	}
+
+	svc := license.NewService(store, caSvc, tokenSvc)
+	m := metrics.New(store)
+	svc.SetMetrics(m)
+	r := router.NewRouter(svc, testAdminKey, limits, m)
+
+	caCertPEM, err := os.ReadFile(caCertPath)
+	if err != nil {
+		t.Fatalf("Failed to read CA cert: %v", err)
+	}
+	caCertPool := x509.NewCertPool()
+	if !caCertPool.AppendCertsFromPEM(caCertPEM) {
+		t.Fatalf("Failed to append CA cert to pool")
+	}
+
+	ts := httptest.NewUnstartedServer(proxies.RealIP(r))
+	ts.TLS = &tls.Config{
+		ClientCAs:  caCertPool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	return &testEnv{dbPath: dbPath, store: store, ca: caSvc, svc: svc, metrics: m, ts: ts}
+}
+
+// do sends a JSON request, optionally presenting a client certificate or admin key
+func (e *testEnv) do(t *testing.T, method, path string, body interface{}, cert *tls.Certificate, adminKey string) (int, []byte) {
+	t.Helper()
+
+	var bodyReader io.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		bodyReader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, e.ts.URL+path, bodyReader)
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if adminKey != "" {
+		req.Header.Set("Authorization", "Bearer "+adminKey)
+	}
+
+	transport := e.ts.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.Certificates = nil
+	if cert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+	client := &http.Client{Transport: transport}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request %s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, respBody
+}
+
+// register enrolls a new licd instance for inn via /v1/register and returns its client certificate
+func (e *testEnv) register(t *testing.T, inn, enrollmentToken string) (*tls.Certificate, *x509.Certificate) {
+	t.Helper()
+
+	priv, csrPEM := newCSR(t)
+
+	code, body := e.do(t, "POST", "/v1/register", router.RegisterRequest{
+		INN:   inn,
+		CSR:   string(csrPEM),
+		Token: enrollmentToken,
+	}, nil, "")
+	if code != http.StatusOK {
+		t.Fatalf("Register failed: %d %s", code, body)
+	}
+	return clientCertFromResponse(t, body, priv)
+}
+
+// newCSR generates a licd-style key and CSR
+func newCSR(t *testing.T) (*ecdsa.PrivateKey, []byte) {
+	t.Helper()
+
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrBytes, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "licd-client"},
+	}, priv)
+	if err != nil {
+		t.Fatalf("Failed to create CSR: %v", err)
+	}
+	return priv, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrBytes})
+}
+
+// clientCertFromResponse turns a /register or /renew response into a usable client certificate
+func clientCertFromResponse(t *testing.T, body []byte, priv *ecdsa.PrivateKey) (*tls.Certificate, *x509.Certificate) {
+	t.Helper()
+
+	var resp router.RegisterResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatalf("Failed to decode register response: %v", err)
+	}
+
+	block, _ := pem.Decode([]byte(resp.Certificate))
+	leaf, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse issued certificate: %v", err)
+	}
+
+	tlsCert, err := tls.X509KeyPair([]byte(resp.Certificate), encodeKeyToPEM(priv))
+	if err != nil {
+		t.Fatalf("Failed to build client keypair: %v", err)
+	}
+	return &tlsCert, leaf
+}
+
+func mustParseCertPEM(t *testing.T, certPEM []byte) *x509.Certificate {
+	t.Helper()
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		t.Fatalf("Failed to decode certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	return cert
+}
@@ -16,7 +16,6 @@ import (
 	"net/http/httptest"
 	"os"
 	"path/filepath"
-	"strings"
 	"testing"
 	"time"
 
@@ -30,7 +29,6 @@ func TestFullEnrollmentAndActivationFlow(t *testing.T) {
 	// 1. Setup Infrastructure
 	tempDir := t.TempDir()
 	caCertPath := filepath.Join(tempDir, "ca.crt")
-	caKeyPath := filepath.Join(tempDir, "ca.key")
 	tokenPrivPath := filepath.Join(tempDir, "token.key")
 
 	// Storage (In-Memory)
@@ -41,7 +39,7 @@ func TestFullEnrollmentAndActivationFlow(t *testing.T) {
 	defer store.Close()
 
 	// CA Service
-	caSvc, err := crypto.NewCAService(caCertPath, caKeyPath)
+	caSvc, err := crypto.NewCAService(caConfig(tempDir))
 	if err != nil {
 		t.Fatalf("Failed to create CA service: %v", err)
 	}
@@ -53,10 +51,10 @@ func TestFullEnrollmentAndActivationFlow(t *testing.T) {
 	}
 
 	// License Service
-	svc := license.NewService(store, caSvc, tokenSvc, "")
+	svc := license.NewService(store, caSvc, tokenSvc)
 
 	// Router
-	r := router.NewRouter(svc, "test-admin-key")
+	r := router.NewRouter(svc, "test-admin-key", router.RateLimits{Register: router.RouteLimits{IP: router.Limit{Rate: 1, Burst: 3}}}, nil)
 
 	// Create TLS Server
 	// We need to configure it to trust our CA for client auth
@@ -86,7 +84,7 @@ func TestFullEnrollmentAndActivationFlow(t *testing.T) {
 	}
 
 	// Create Enrollment Token
-	enrollmentToken, err := store.CreateEnrollmentToken(ctx, inn, 1*time.Hour)
+	enrollmentToken, _, err := svc.CreateEnrollmentToken(ctx, inn, 1*time.Hour, 1, "")
 	if err != nil {
 		t.Fatalf("Failed to create enrollment token: %v", err)
 	}
@@ -172,13 +170,12 @@ func TestFullEnrollmentAndActivationFlow(t *testing.T) {
 	}
 
 	// Verify Token Consumed
-	err = store.ValidateAndConsumeEnrollmentToken(ctx, enrollmentToken, inn)
-	if err == nil {
-		t.Fatal("Token should be consumed/used, but validation succeeded again")
-	} else if !strings.Contains(err.Error(), "already used") {
-		// It might return "already used" or similar.
-		// My implementation checks `used` flag.
-		// If used, it returns error.
+	tokens, err := store.GetEnrollmentTokensByINN(ctx, inn)
+	if err != nil || len(tokens) != 1 {
+		t.Fatalf("Expected one enrollment token, got %d, %v", len(tokens), err)
+	}
+	if tokens[0].UseCount != tokens[0].MaxUses {
+		t.Fatalf("Token should be used up, got %d of %d uses", tokens[0].UseCount, tokens[0].MaxUses)
 	}
 
 	// 4. Activate (mTLS)
@@ -226,7 +223,7 @@ func TestFullEnrollmentAndActivationFlow(t *testing.T) {
 	}
 
 	// 5. Test Rate Limiting
-	// The rate limiter is per-IP (1 rps, burst 3).
+	// The register route is limited per IP (1 rps, burst 3).
 	// We've already sent 2 requests (register, activate) which might count if same IP?
 	// httptest server uses "127.0.0.1" usually.
 	// We should wait a bit to reset bucket or just hammer it until 429.
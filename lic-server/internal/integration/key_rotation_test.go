@@ -0,0 +1,187 @@
+package integration_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/infrastructure/crypto"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSigningKeyRotation(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "5555555555"
+
+	if err := env.store.CreateLicense(ctx, inn, "Rotation Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	token, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	cert, _ := env.register(t, inn, token)
+
+	activate := func(t *testing.T) string {
+		t.Helper()
+		code, body := env.do(t, "POST", "/v1/activate", map[string]string{
+			"inn":         inn,
+			"fingerprint": "hw-fp",
+		}, cert, "")
+		if code != http.StatusOK {
+			t.Fatalf("Activate failed: %d %s", code, body)
+		}
+		var resp struct{ Token string }
+		_ = json.Unmarshal(body, &resp)
+		return resp.Token
+	}
+
+	fetchJWKS := func(t *testing.T) crypto.JWKS {
+		t.Helper()
+		code, body := env.do(t, "GET", "/v1/jwks", nil, nil, "")
+		if code != http.StatusOK {
+			t.Fatalf("JWKS failed: %d %s", code, body)
+		}
+		var set crypto.JWKS
+		if err := json.Unmarshal(body, &set); err != nil {
+			t.Fatalf("Failed to decode JWKS: %v", err)
+		}
+		return set
+	}
+
+	// verify checks a token against the published key set, picking the key by kid
+	verify := func(t *testing.T, tokenString string, set crypto.JWKS) (*license.LicenseClaims, error) {
+		t.Helper()
+		claims := &license.LicenseClaims{}
+		_, err := jwt.ParseWithClaims(tokenString, claims, func(tok *jwt.Token) (interface{}, error) {
+			for _, k := range set.Keys {
+				if k.Kid == tok.Header["kid"] {
+					raw, _ := base64.RawURLEncoding.DecodeString(k.X)
+					return ed25519.PublicKey(raw), nil
+				}
+			}
+			return nil, jwt.ErrTokenUnverifiable
+		})
+		return claims, err
+	}
+
+	oldToken := activate(t)
+	initial := fetchJWKS(t)
+	if len(initial.Keys) != 1 || initial.Keys[0].Ver != 1 || initial.Keys[0].Status != "active" {
+		t.Fatalf("Expected a single active v1 key, got %+v", initial.Keys)
+	}
+
+	t.Run("Rotate requires admin key", func(t *testing.T) {
+		code, _ := env.do(t, "POST", "/api/admin/keys/rotate", nil, nil, "")
+		if code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401, got %d", code)
+		}
+	})
+
+	t.Run("Rotate and sign with the new key", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/api/admin/keys/rotate", nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+
+		set := fetchJWKS(t)
+		if len(set.Keys) != 2 || set.Keys[0].Ver != 2 || set.Keys[0].Status != "active" {
+			t.Fatalf("Expected active v2 key first, got %+v", set.Keys)
+		}
+
+		claims, err := verify(t, activate(t), set)
+		if err != nil {
+			t.Fatalf("New token does not verify: %v", err)
+		}
+		if claims.KeyVersion != 2 {
+			t.Errorf("Expected ver=2, got %d", claims.KeyVersion)
+		}
+
+		if _, err := verify(t, oldToken, set); err != nil {
+			t.Errorf("Token signed before rotation must still verify: %v", err)
+		}
+	})
+
+	t.Run("Cannot retire the active key", func(t *testing.T) {
+		code, _ := env.do(t, "DELETE", "/api/admin/keys/2", nil, nil, testAdminKey)
+		if code != http.StatusConflict {
+			t.Fatalf("Expected 409, got %d", code)
+		}
+	})
+
+	t.Run("Cannot retire an unknown key", func(t *testing.T) {
+		code, _ := env.do(t, "DELETE", "/api/admin/keys/9", nil, nil, testAdminKey)
+		if code != http.StatusNotFound {
+			t.Fatalf("Expected 404, got %d", code)
+		}
+	})
+
+	t.Run("Retire old key", func(t *testing.T) {
+		code, body := env.do(t, "DELETE", "/api/admin/keys/1", nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+
+		set := fetchJWKS(t)
+		if len(set.Keys) != 1 || set.Keys[0].Ver != 2 {
+			t.Fatalf("Expected only v2 to remain, got %+v", set.Keys)
+		}
+		if _, err := verify(t, oldToken, set); err == nil {
+			t.Errorf("Token signed with a retired key must not verify")
+		}
+	})
+}
+
+func TestSigningKeyRingSharedByReplicas(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "license.key")
+	first, err := crypto.NewTokenService(keyPath)
+	if err != nil {
+		t.Fatalf("Failed to create token service: %v", err)
+	}
+	second, err := crypto.NewTokenService(keyPath)
+	if err != nil {
+		t.Fatalf("Failed to create token service: %v", err)
+	}
+
+	version, kid, err := first.RotateKey()
+	if err != nil || version != 2 {
+		t.Fatalf("RotateKey failed: %d, %v", version, err)
+	}
+	if err := second.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if second.ActiveKeyVersion() != 2 {
+		t.Fatalf("Expected the other replica to sign with version 2, got %d", second.ActiveKeyVersion())
+	}
+
+	// The key version claim is set from the key that signs the token
+	claims := &license.LicenseClaims{INN: "5555555555", Status: "active"}
+	token, err := second.SignToken(claims)
+	if err != nil {
+		t.Fatalf("SignToken failed: %v", err)
+	}
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, &license.LicenseClaims{})
+	if err != nil {
+		t.Fatalf("Failed to parse token: %v", err)
+	}
+	if parsed.Header["kid"] != kid || parsed.Claims.(*license.LicenseClaims).KeyVersion != 2 || claims.KeyVersion != 2 {
+		t.Errorf("Expected kid %s with ver 2, got %v with ver %d", kid, parsed.Header["kid"], parsed.Claims.(*license.LicenseClaims).KeyVersion)
+	}
+
+	if err := second.RetireKey(1); err != nil {
+		t.Fatalf("RetireKey failed: %v", err)
+	}
+	if err := first.Reload(); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	var set crypto.JWKS
+	data, _ := first.PublicJWKS()
+	_ = json.Unmarshal(data, &set)
+	if len(set.Keys) != 1 || set.Keys[0].Ver != 2 {
+		t.Errorf("Expected the retired key to leave the key set of the other replica, got %+v", set.Keys)
+	}
+}
@@ -0,0 +1,68 @@
+package integration_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/api/router"
+)
+
+func TestMetrics(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "5050505050"
+
+	if err := env.store.CreateLicense(ctx, inn, "Metrics Org", 7); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	token, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	cert, _ := env.register(t, inn, token)
+
+	// A consumed token fails the second time
+	env.do(t, "POST", "/v1/register", router.RegisterRequest{INN: inn, CSR: "invalid", Token: token}, nil, "")
+
+	usedSlots := 3
+	if code, body := env.do(t, "POST", "/v1/activate", router.ActivateRequest{INN: inn, Fingerprint: "fp-1", UsedSlots: &usedSlots}, cert, ""); code != http.StatusOK {
+		t.Fatalf("Activate failed: %d %s", code, body)
+	}
+	if code, _ := env.do(t, "POST", "/v1/activate", router.ActivateRequest{INN: "9999999999", Fingerprint: "fp-1"}, cert, ""); code == http.StatusOK {
+		t.Fatalf("Activation of an unknown license must fail")
+	}
+	if code, body := env.do(t, "GET", "/v1/heartbeat", nil, cert, ""); code != http.StatusOK {
+		t.Fatalf("Heartbeat failed: %d %s", code, body)
+	}
+	if code, _ := env.do(t, "GET", "/v1/heartbeat", nil, nil, ""); code != http.StatusForbidden {
+		t.Fatalf("Heartbeat without certificate must be rejected, got %d", code)
+	}
+	env.do(t, "GET", "/api/admin/licenses/"+inn+"/instances", nil, nil, testAdminKey)
+
+	rec := httptest.NewRecorder()
+	env.metrics.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 from /metrics, got %d", rec.Code)
+	}
+	exposition := rec.Body.String()
+
+	for _, want := range []string{
+		`lic_server_registrations_total{reason="",result="success"} 1`,
+		`lic_server_registrations_total{reason="token_error",result="failure"} 1`,
+		`lic_server_activations_total{reason="",result="success"} 1`,
+		`lic_server_activations_total{reason="license_not_found",result="failure"} 1`,
+		`lic_server_heartbeats_total{reason="",result="ok"} 1`,
+		`lic_server_mtls_rejections_total{reason="missing_client_cert"} 1`,
+		`lic_server_certificates_issued_total 1`,
+		`lic_server_enrollment_tokens_consumed_total{kind="issued"} 1`,
+		`lic_server_license_used_slots{inn="5050505050"} 3`,
+		`lic_server_license_max_slots{inn="5050505050"} 7`,
+		`lic_server_http_request_duration_seconds_count{method="POST",route="/v1/activate",status="200"} 1`,
+		`lic_server_http_request_duration_seconds_count{method="GET",route="/api/admin/licenses/{inn}/instances",status="200"} 1`,
+	} {
+		if !strings.Contains(exposition, want) {
+			t.Errorf("Metrics missing %s", want)
+		}
+	}
+}
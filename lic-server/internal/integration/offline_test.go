@@ -0,0 +1,148 @@
+package integration_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// offlineRequest builds a signed activation request file the way licd does
+func offlineRequest(t *testing.T, key *ecdsa.PrivateKey, payload license.OfflineActivationPayload) *license.OfflineActivationRequest {
+	t.Helper()
+	payloadBytes, _ := json.Marshal(payload)
+	digest := sha256.Sum256(payloadBytes)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign request: %v", err)
+	}
+	pubDER, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	return &license.OfflineActivationRequest{
+		Format:    license.OfflineRequestFormat,
+		Payload:   base64.StdEncoding.EncodeToString(payloadBytes),
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})),
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	}
+}
+
+func TestOfflineActivation(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "8888888888"
+
+	if err := env.store.CreateLicense(ctx, inn, "Air-gapped Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	payload := license.OfflineActivationPayload{
+		INN:         inn,
+		Fingerprint: "offline-hw-fp",
+		Version:     "1.2.3",
+		UsedSlots:   3,
+		Nonce:       "nonce-1",
+		CreatedAt:   time.Now().UTC(),
+	}
+	req := offlineRequest(t, key, payload)
+
+	t.Run("Request is redeemed for a license token", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/api/admin/offline/activate", req, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		var resp license.OfflineActivationResponse
+		_ = json.Unmarshal(body, &resp)
+		if resp.Format != license.OfflineResponseFormat || resp.Nonce != payload.Nonce {
+			t.Fatalf("Unexpected response file: %+v", resp)
+		}
+
+		claims := &license.LicenseClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(resp.Token, claims); err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		if claims.FingerprintHash != payload.Fingerprint || claims.INN != inn {
+			t.Errorf("Token not bound to the requesting instance: %+v", claims)
+		}
+
+		lic, _ := env.store.GetLicenseByINN(ctx, inn)
+		if lic.UsedSlots != 3 {
+			t.Errorf("Expected reported usage of 3, got %d", lic.UsedSlots)
+		}
+	})
+
+	t.Run("Replayed request is refused", func(t *testing.T) {
+		code, _ := env.do(t, "POST", "/api/admin/offline/activate", req, nil, testAdminKey)
+		if code != http.StatusConflict {
+			t.Fatalf("Expected 409, got %d", code)
+		}
+
+		// A new request file reusing the nonce records neither usage nor a token
+		replayed := payload
+		replayed.UsedSlots = 7
+		if code, _ := env.do(t, "POST", "/api/admin/offline/activate", offlineRequest(t, key, replayed), nil, testAdminKey); code != http.StatusConflict {
+			t.Fatalf("Expected 409, got %d", code)
+		}
+		if lic, _ := env.store.GetLicenseByINN(ctx, inn); lic.UsedSlots != 3 {
+			t.Errorf("Expected usage to stay at 3, got %d", lic.UsedSlots)
+		}
+	})
+
+	t.Run("Tampered request is refused", func(t *testing.T) {
+		forged := *req
+		other := payload
+		other.Nonce = "nonce-2"
+		other.INN = "9999999999"
+		otherBytes, _ := json.Marshal(other)
+		forged.Payload = base64.StdEncoding.EncodeToString(otherBytes)
+		code, _ := env.do(t, "POST", "/api/admin/offline/activate", &forged, nil, testAdminKey)
+		if code != http.StatusBadRequest {
+			t.Fatalf("Expected 400, got %d", code)
+		}
+	})
+
+	t.Run("Stale request is refused", func(t *testing.T) {
+		stale := payload
+		stale.Nonce = "nonce-3"
+		stale.CreatedAt = time.Now().AddDate(0, -2, 0)
+		code, _ := env.do(t, "POST", "/api/admin/offline/activate", offlineRequest(t, key, stale), nil, testAdminKey)
+		if code != http.StatusBadRequest {
+			t.Fatalf("Expected 400, got %d", code)
+		}
+	})
+
+	t.Run("Activation is recorded", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/api/admin/licenses/"+inn+"/offline-activations", nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		var activations []struct {
+			Fingerprint string
+			CreatedBy   string
+		}
+		_ = json.Unmarshal(body, &activations)
+		if len(activations) != 1 || activations[0].Fingerprint != payload.Fingerprint || activations[0].CreatedBy != "config" {
+			t.Errorf("Expected one recorded activation by the config key, got %+v", activations)
+		}
+
+		events, _ := env.store.GetAuditEvents(ctx, inn)
+		found := false
+		for _, e := range events {
+			if e.Action == "offline_activation" {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("offline_activation audit event not found")
+		}
+	})
+}
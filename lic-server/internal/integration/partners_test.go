@@ -0,0 +1,289 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+func TestPartners(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+
+	createPartner := func(t *testing.T, name string, pool int) int64 {
+		t.Helper()
+		code, body := env.do(t, "POST", "/api/admin/partners", map[string]interface{}{
+			"name": name, "slot_pool": pool,
+		}, nil, testAdminKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Create partner %s failed: %d %s", name, code, body)
+		}
+		var p storage.Partner
+		_ = json.Unmarshal(body, &p)
+		return p.ID
+	}
+	createKey := func(t *testing.T, partnerID int64, name string, scopes ...string) string {
+		t.Helper()
+		code, body := env.do(t, "POST", fmt.Sprintf("/api/admin/partners/%d/api-keys", partnerID), map[string]interface{}{
+			"name": name, "scopes": scopes,
+		}, nil, testAdminKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Create partner key %s failed: %d %s", name, code, body)
+		}
+		var resp struct {
+			Key string `json:"key"`
+		}
+		_ = json.Unmarshal(body, &resp)
+		return resp.Key
+	}
+	expectCode := func(t *testing.T, status int, body []byte, wantStatus int, wantCode string) {
+		t.Helper()
+		var resp struct {
+			Code string `json:"code"`
+		}
+		_ = json.Unmarshal(body, &resp)
+		if status != wantStatus || resp.Code != wantCode {
+			t.Fatalf("Expected %d %s, got %d: %s", wantStatus, wantCode, status, body)
+		}
+	}
+
+	acme := createPartner(t, "Acme Reseller", 10)
+	other := createPartner(t, "Other Reseller", 10)
+	acmeKey := createKey(t, acme, "acme", license.ScopeReadOnly, license.ScopeLicenseWrite, license.ScopeTokenIssue, license.ScopeAuditRead)
+	otherKey := createKey(t, other, "other", license.ScopeReadOnly, license.ScopeLicenseWrite)
+
+	if err := env.store.CreateLicense(ctx, "9090909090", "Direct Customer", 100); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+
+	t.Run("Partner keys can't be admins", func(t *testing.T) {
+		code, body := env.do(t, "POST", fmt.Sprintf("/api/admin/partners/%d/api-keys", acme), map[string]interface{}{
+			"name": "acme-root", "scopes": []string{license.ScopeAdmin},
+		}, nil, testAdminKey)
+		expectCode(t, code, body, http.StatusBadRequest, license.CodeInvalidRequest)
+
+		code, body = env.do(t, "POST", "/api/admin/partners/999999/api-keys", map[string]interface{}{
+			"name": "ghost", "scopes": []string{license.ScopeReadOnly},
+		}, nil, testAdminKey)
+		expectCode(t, code, body, http.StatusNotFound, license.CodeNotFound)
+
+		if code, _ := env.do(t, "GET", "/api/admin/partners", nil, nil, acmeKey); code != http.StatusForbidden {
+			t.Errorf("Expected 403 for a partner key on /partners, got %d", code)
+		}
+	})
+
+	t.Run("Licenses within the pool", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/api/admin/licenses", map[string]interface{}{
+			"inn": "1010101010", "organization": "Acme Customer", "max_slots": 6,
+		}, nil, acmeKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", code, body)
+		}
+
+		// 6 of 10 slots are allocated, 5 more don't fit
+		code, body = env.do(t, "POST", "/api/admin/licenses", map[string]interface{}{
+			"inn": "1010101011", "organization": "Too Big", "max_slots": 5,
+		}, nil, acmeKey)
+		expectCode(t, code, body, http.StatusConflict, license.CodeSlotPoolExceeded)
+		code, body = env.do(t, "PUT", "/api/admin/licenses/1010101010/details", map[string]interface{}{
+			"organization": "Acme Customer", "max_slots": 11,
+		}, nil, acmeKey)
+		expectCode(t, code, body, http.StatusConflict, license.CodeSlotPoolExceeded)
+
+		code, body = env.do(t, "POST", "/api/admin/licenses", map[string]interface{}{
+			"inn": "1010101012", "organization": "Small", "max_slots": 4,
+		}, nil, acmeKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", code, body)
+		}
+
+		// An existing license is never taken over
+		code, body = env.do(t, "POST", "/api/admin/licenses", map[string]interface{}{
+			"inn": "9090909090", "organization": "Hijack", "max_slots": 1,
+		}, nil, otherKey)
+		expectCode(t, code, body, http.StatusConflict, license.CodeConflict)
+
+		lic, _ := env.store.GetLicenseByINN(ctx, "1010101010")
+		if lic.PartnerID == nil || *lic.PartnerID != acme {
+			t.Errorf("Expected the license to belong to the partner, got %v", lic.PartnerID)
+		}
+
+		code, body = env.do(t, "GET", "/api/admin/partner", nil, nil, acmeKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		var p storage.Partner
+		_ = json.Unmarshal(body, &p)
+		if p.ID != acme || p.SlotPool != 10 || p.AllocatedSlots != 10 {
+			t.Errorf("Unexpected partner: %+v", p)
+		}
+	})
+
+	t.Run("Revoking returns slots to the pool", func(t *testing.T) {
+		code, body := env.do(t, "PUT", "/api/admin/licenses/1010101012/status", map[string]string{"status": "revoked"}, nil, acmeKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		code, body = env.do(t, "PUT", "/api/admin/licenses/1010101010/details", map[string]interface{}{
+			"organization": "Acme Customer", "max_slots": 9,
+		}, nil, acmeKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		// Reactivating needs 4 slots, only 1 is left
+		code, body = env.do(t, "PUT", "/api/admin/licenses/1010101012/status", map[string]string{"status": "active"}, nil, acmeKey)
+		expectCode(t, code, body, http.StatusConflict, license.CodeSlotPoolExceeded)
+	})
+
+	t.Run("Listings are scoped", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/api/admin/licenses", nil, nil, acmeKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		var licenses []storage.License
+		_ = json.Unmarshal(body, &licenses)
+		if len(licenses) != 2 {
+			t.Fatalf("Expected the 2 partner licenses, got %d", len(licenses))
+		}
+		for _, l := range licenses {
+			if l.PartnerID == nil || *l.PartnerID != acme {
+				t.Errorf("Listed a license of someone else: %s", l.INN)
+			}
+		}
+
+		code, body = env.do(t, "GET", "/api/admin/licenses", nil, nil, otherKey)
+		_ = json.Unmarshal(body, &licenses)
+		if code != http.StatusOK || len(licenses) != 0 {
+			t.Errorf("Expected no licenses for the other partner, got %d: %s", code, body)
+		}
+
+		code, body = env.do(t, "GET", "/api/admin/licenses", nil, nil, testAdminKey)
+		_ = json.Unmarshal(body, &licenses)
+		if code != http.StatusOK || len(licenses) != 3 {
+			t.Errorf("Expected the operator to see all 3 licenses, got %d", len(licenses))
+		}
+	})
+
+	t.Run("Foreign licenses don't exist", func(t *testing.T) {
+		for _, req := range []struct{ method, path string }{
+			{"GET", "/api/admin/licenses/9090909090/certificates"},
+			{"GET", "/api/admin/licenses/9090909090/entitlements"},
+			{"POST", "/api/admin/licenses/9090909090/extend"},
+			{"PUT", "/api/admin/licenses/9090909090/status"},
+			{"GET", "/api/admin/licenses/1010101010/instances"},
+		} {
+			code, body := env.do(t, req.method, req.path, map[string]interface{}{"days": 30, "status": "revoked"}, nil, otherKey)
+			expectCode(t, code, body, http.StatusNotFound, license.CodeLicenseNotFound)
+		}
+		if lic, _ := env.store.GetLicenseByINN(ctx, "9090909090"); lic.Status != "active" {
+			t.Error("Expected the direct license to stay untouched")
+		}
+	})
+
+	t.Run("Partners can't grant entitlements", func(t *testing.T) {
+		if code, body := env.do(t, "GET", "/api/admin/licenses/1010101010/entitlements", nil, nil, acmeKey); code != http.StatusOK {
+			t.Errorf("Expected a partner to read entitlements of its license, got %d: %s", code, body)
+		}
+		for _, req := range []struct{ method, path string }{
+			{"PUT", "/api/admin/licenses/1010101010/entitlements"},
+			{"PUT", "/api/admin/licenses/1010101010/entitlements/max_agents"},
+			{"DELETE", "/api/admin/licenses/1010101010/entitlements/max_agents"},
+		} {
+			code, _ := env.do(t, req.method, req.path, map[string]interface{}{"value": "1000", "max_agents": "1000"}, nil, acmeKey)
+			if code != http.StatusForbidden {
+				t.Errorf("Expected 403 for %s %s with a partner key, got %d", req.method, req.path, code)
+			}
+		}
+		if ents, _ := env.store.GetEntitlements(ctx, "1010101010"); len(ents) != 0 {
+			t.Errorf("Expected no entitlements to be granted, got %v", ents)
+		}
+	})
+
+	t.Run("Tokens are scoped", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/api/admin/tokens", map[string]interface{}{"inn": "1010101010", "ttl_hours": 1}, nil, acmeKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", code, body)
+		}
+		var resp struct {
+			Token string
+		}
+		_ = json.Unmarshal(body, &resp)
+		env.register(t, "1010101010", resp.Token)
+
+		code, body = env.do(t, "POST", "/api/admin/tokens", map[string]interface{}{"inn": "9090909090", "ttl_hours": 1}, nil, acmeKey)
+		expectCode(t, code, body, http.StatusNotFound, license.CodeLicenseNotFound)
+
+		_, direct, err := env.svc.CreateEnrollmentToken(ctx, "9090909090", time.Hour, 1, "")
+		if err != nil {
+			t.Fatalf("Failed to create enrollment token: %v", err)
+		}
+		code, body = env.do(t, "GET", "/api/admin/tokens", nil, nil, acmeKey)
+		var tokens []storage.EnrollmentToken
+		_ = json.Unmarshal(body, &tokens)
+		if code != http.StatusOK || len(tokens) != 1 || tokens[0].INN != "1010101010" {
+			t.Errorf("Expected the partner token only, got %d: %s", code, body)
+		}
+		code, body = env.do(t, "GET", fmt.Sprintf("/api/admin/tokens/%d/uses", direct.ID), nil, nil, acmeKey)
+		expectCode(t, code, body, http.StatusNotFound, license.CodeNotFound)
+		code, body = env.do(t, "DELETE", fmt.Sprintf("/api/admin/tokens/%d", direct.ID), nil, nil, acmeKey)
+		expectCode(t, code, body, http.StatusNotFound, license.CodeNotFound)
+	})
+
+	t.Run("Audit log is scoped", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/api/admin/audit", nil, nil, acmeKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		var events []storage.AuditEvent
+		_ = json.Unmarshal(body, &events)
+		if len(events) == 0 {
+			t.Fatal("Expected the events of the partner licenses")
+		}
+		for _, e := range events {
+			if e.INN != "1010101010" && e.INN != "1010101012" {
+				t.Errorf("Partner sees event %s of %q", e.Action, e.INN)
+			}
+		}
+
+		code, body = env.do(t, "GET", "/api/admin/audit?inn=9090909090", nil, nil, acmeKey)
+		_ = json.Unmarshal(body, &events)
+		if code != http.StatusOK || len(events) != 0 {
+			t.Errorf("Expected no events of a foreign INN, got %d: %s", code, body)
+		}
+		if code, _ := env.do(t, "GET", "/api/admin/audit/verify", nil, nil, acmeKey); code != http.StatusForbidden {
+			t.Errorf("Expected 403 on /audit/verify for a partner key, got %d", code)
+		}
+	})
+
+	t.Run("Operator assigns licenses and pools", func(t *testing.T) {
+		// The 100 slots of the direct license exceed the pool of 10
+		code, body := env.do(t, "PUT", "/api/admin/licenses/9090909090/partner", map[string]interface{}{"partner_id": other}, nil, testAdminKey)
+		expectCode(t, code, body, http.StatusConflict, license.CodeSlotPoolExceeded)
+
+		code, body = env.do(t, "PUT", fmt.Sprintf("/api/admin/partners/%d", other), map[string]interface{}{
+			"name": "Other Reseller", "slot_pool": 100,
+		}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		code, body = env.do(t, "PUT", "/api/admin/licenses/9090909090/partner", map[string]interface{}{"partner_id": other}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		if code, _ := env.do(t, "GET", "/api/admin/licenses/9090909090/certificates", nil, nil, otherKey); code != http.StatusOK {
+			t.Errorf("Expected the assigned license to be reachable, got %d", code)
+		}
+
+		// The pool can't shrink below what is allocated
+		code, body = env.do(t, "PUT", fmt.Sprintf("/api/admin/partners/%d", acme), map[string]interface{}{
+			"name": "Acme Reseller", "slot_pool": 5,
+		}, nil, testAdminKey)
+		expectCode(t, code, body, http.StatusConflict, license.CodeSlotPoolExceeded)
+	})
+}
@@ -0,0 +1,115 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/api/router"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+func TestRateLimiting(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Per-IP limit answers 429 with Retry-After", func(t *testing.T) {
+		env := newTestEnvWithLimits(t, router.RateLimits{
+			Register: router.RouteLimits{IP: router.Limit{Rate: 0.01, Burst: 2}},
+		})
+
+		var resp *http.Response
+		for i := 0; i < 3; i++ {
+			body, _ := json.Marshal(router.RegisterRequest{INN: "1010101010", CSR: "invalid", Token: "invalid"})
+			var err error
+			resp, err = env.ts.Client().Post(env.ts.URL+"/v1/register", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatalf("Request %d failed: %v", i, err)
+			}
+			resp.Body.Close()
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			t.Fatalf("Expected 429 on the third request, got %d", resp.StatusCode)
+		}
+		retryAfter, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || retryAfter < 1 {
+			t.Errorf("Expected a positive Retry-After, got %q", resp.Header.Get("Retry-After"))
+		}
+	})
+
+	t.Run("Per-INN limit applies across requests", func(t *testing.T) {
+		env := newTestEnvWithLimits(t, router.RateLimits{
+			Register: router.RouteLimits{INN: router.Limit{Rate: 0.01, Burst: 1}},
+		})
+		for _, inn := range []string{"2020202020", "3030303030"} {
+			if err := env.store.CreateLicense(ctx, inn, "Throttled Org", 10); err != nil {
+				t.Fatalf("Failed to create license: %v", err)
+			}
+		}
+		token, _, _ := env.svc.CreateEnrollmentToken(ctx, "2020202020", time.Hour, 10, "")
+		otherToken, _, _ := env.svc.CreateEnrollmentToken(ctx, "3030303030", time.Hour, 10, "")
+
+		// Requests without a valid token for the INN do not count against it
+		forged := router.RegisterRequest{INN: "2020202020", CSR: "invalid", Token: "invalid"}
+		for i := 0; i < 3; i++ {
+			if code, _ := env.do(t, "POST", "/v1/register", forged, nil, ""); code == http.StatusTooManyRequests {
+				t.Fatalf("Request %d with a forged token was throttled by INN", i)
+			}
+		}
+		forged.Token = otherToken
+		if code, _ := env.do(t, "POST", "/v1/register", forged, nil, ""); code == http.StatusTooManyRequests {
+			t.Fatalf("A token of another INN must not count against this INN")
+		}
+
+		req := router.RegisterRequest{INN: "2020202020", CSR: "invalid", Token: token}
+		if code, _ := env.do(t, "POST", "/v1/register", req, nil, ""); code == http.StatusTooManyRequests {
+			t.Fatalf("First request must not be throttled")
+		}
+		if code, _ := env.do(t, "POST", "/v1/register", req, nil, ""); code != http.StatusTooManyRequests {
+			t.Fatalf("Expected 429 for the same INN, got %d", code)
+		}
+
+		// Another INN from the same IP has its own bucket
+		req = router.RegisterRequest{INN: "3030303030", CSR: "invalid", Token: otherToken}
+		if code, _ := env.do(t, "POST", "/v1/register", req, nil, ""); code == http.StatusTooManyRequests {
+			t.Errorf("Another INN must not be throttled")
+		}
+	})
+
+	t.Run("Per-INN limit uses the certificate binding", func(t *testing.T) {
+		env := newTestEnvWithLimits(t, router.RateLimits{
+			Heartbeat: router.RouteLimits{INN: router.Limit{Rate: 0.01, Burst: 2}},
+		})
+		inn := "4040404040"
+		if err := env.store.CreateLicense(ctx, inn, "Throttled Org", 10); err != nil {
+			t.Fatalf("Failed to create license: %v", err)
+		}
+		token, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+		cert, _ := env.register(t, inn, token)
+
+		for i := 0; i < 2; i++ {
+			if code, body := env.do(t, "GET", "/v1/heartbeat", nil, cert, ""); code != http.StatusOK {
+				t.Fatalf("Heartbeat %d: expected 200, got %d: %s", i, code, body)
+			}
+		}
+		for i := 0; i < 3; i++ {
+			if code, _ := env.do(t, "GET", "/v1/heartbeat", nil, cert, ""); code != http.StatusTooManyRequests {
+				t.Fatalf("Expected 429, got %d", code)
+			}
+		}
+
+		events, err := env.store.QueryAuditEvents(ctx, storage.AuditFilter{Action: "rate_limited"})
+		if err != nil {
+			t.Fatalf("Failed to query audit events: %v", err)
+		}
+		if len(events) != 1 {
+			t.Fatalf("Expected a single rate_limited audit event, got %d", len(events))
+		}
+		if events[0].INN != inn {
+			t.Errorf("Expected the event to name INN %s, got %q", inn, events[0].INN)
+		}
+	})
+}
@@ -0,0 +1,97 @@
+package integration_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/api/router"
+)
+
+func TestClientCertRenewal(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "4444444444"
+
+	if err := env.store.CreateLicense(ctx, inn, "Renewal Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	token, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	oldCert, oldLeaf := env.register(t, inn, token)
+
+	t.Run("Renew requires client certificate", func(t *testing.T) {
+		_, csrPEM := newCSR(t)
+		code, _ := env.do(t, "POST", "/v1/renew", router.RenewRequest{CSR: string(csrPEM)}, nil, "")
+		if code != http.StatusForbidden {
+			t.Fatalf("Expected 403, got %d", code)
+		}
+	})
+
+	priv, csrPEM := newCSR(t)
+	code, body := env.do(t, "POST", "/v1/renew", router.RenewRequest{CSR: string(csrPEM)}, oldCert, "")
+	if code != http.StatusOK {
+		t.Fatalf("Renew failed: %d %s", code, body)
+	}
+	newCert, newLeaf := clientCertFromResponse(t, body, priv)
+
+	t.Run("New certificate bound to same INN", func(t *testing.T) {
+		binding, err := env.store.GetClientCertBinding(ctx, fmt.Sprintf("%x", sha256.Sum256(newLeaf.Raw)))
+		if err != nil || binding == nil {
+			t.Fatalf("New binding not found: %v", err)
+		}
+		if binding.INN != inn || binding.Status != "active" {
+			t.Errorf("Unexpected new binding: inn=%s status=%s", binding.INN, binding.Status)
+		}
+	})
+
+	t.Run("Old certificate valid until the new one is used", func(t *testing.T) {
+		// The renewal response may have been lost, so the old certificate keeps working
+		usage := map[string]interface{}{"fingerprint": "renewal-host", "used_slots": 3}
+		if code, body := env.do(t, "POST", "/v1/heartbeat", usage, oldCert, ""); code != http.StatusOK {
+			t.Errorf("Expected 200 for the old certificate before the renewal is used, got %d: %s", code, body)
+		}
+		old, _ := env.store.GetClientCertBinding(ctx, fmt.Sprintf("%x", sha256.Sum256(oldLeaf.Raw)))
+		if old == nil || old.Status != "active" {
+			t.Errorf("Expected old binding to stay active, got %+v", old)
+		}
+	})
+
+	t.Run("Old certificate refused once the new one is used", func(t *testing.T) {
+		usage := map[string]interface{}{"fingerprint": "renewal-host", "used_slots": 3}
+		if code, body := env.do(t, "POST", "/v1/heartbeat", usage, newCert, ""); code != http.StatusOK {
+			t.Errorf("Expected 200 for renewed certificate, got %d: %s", code, body)
+		}
+		// The usage of the old certificate moved to the new one rather than counting twice
+		if lic, _ := env.store.GetLicenseByINN(ctx, inn); lic.UsedSlots != 3 {
+			t.Errorf("Expected 3 used slots after the renewal, got %d", lic.UsedSlots)
+		}
+		old, _ := env.store.GetClientCertBinding(ctx, fmt.Sprintf("%x", sha256.Sum256(oldLeaf.Raw)))
+		if old == nil || old.Status != "superseded" {
+			t.Errorf("Expected old binding to be superseded, got %+v", old)
+		}
+		if code, _ := env.do(t, "GET", "/v1/heartbeat", nil, oldCert, ""); code != http.StatusForbidden {
+			t.Errorf("Expected 403 for superseded certificate, got %d", code)
+		}
+	})
+
+	t.Run("Superseded certificate listed in CRL", func(t *testing.T) {
+		_, body := env.do(t, "GET", "/v1/crl", nil, nil, "")
+		crl, err := x509.ParseRevocationList(body)
+		if err != nil {
+			t.Fatalf("Failed to parse CRL: %v", err)
+		}
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(oldLeaf.SerialNumber) == 0 {
+				if entry.ReasonCode != 4 {
+					t.Errorf("Expected reason code 4 (superseded), got %d", entry.ReasonCode)
+				}
+				return
+			}
+		}
+		t.Errorf("Superseded serial %s not present in CRL", oldLeaf.SerialNumber)
+	})
+}
@@ -0,0 +1,101 @@
+package integration_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientCertRevocation(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "3333333333"
+
+	if err := env.store.CreateLicense(ctx, inn, "Revocation Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	token, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	cert, leaf := env.register(t, inn, token)
+
+	t.Run("Heartbeat before revocation", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/v1/heartbeat", nil, cert, "")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+	})
+
+	t.Run("Revoke requires admin key", func(t *testing.T) {
+		code, _ := env.do(t, "POST", "/api/admin/certificates/revoke", map[string]string{
+			"serial": leaf.SerialNumber.String(),
+		}, nil, "")
+		if code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401, got %d", code)
+		}
+	})
+
+	t.Run("Revoke by serial", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/api/admin/certificates/revoke", map[string]string{
+			"serial": leaf.SerialNumber.String(),
+			"reason": "key_compromise",
+		}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+
+		code, _ = env.do(t, "POST", "/api/admin/certificates/revoke", map[string]string{
+			"fingerprint": fmt.Sprintf("%x", sha256.Sum256(leaf.Raw)),
+		}, nil, testAdminKey)
+		if code != http.StatusConflict {
+			t.Fatalf("Expected 409 on second revocation, got %d", code)
+		}
+	})
+
+	t.Run("Revoked certificate refused by RequireMTLS", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/v1/heartbeat", nil, cert, "")
+		if code != http.StatusForbidden {
+			t.Fatalf("Expected 403 for heartbeat, got %d: %s", code, body)
+		}
+
+		code, body = env.do(t, "POST", "/v1/activate", map[string]string{
+			"inn":         inn,
+			"fingerprint": "hw-fp",
+		}, cert, "")
+		if code != http.StatusForbidden {
+			t.Fatalf("Expected 403 for activate, got %d: %s", code, body)
+		}
+	})
+
+	t.Run("CRL lists revoked serial", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/v1/crl", nil, nil, "")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+
+		crl, err := x509.ParseRevocationList(body)
+		if err != nil {
+			t.Fatalf("Failed to parse CRL: %v", err)
+		}
+
+		issuer := mustParseCertPEM(t, env.ca.GetIssuerCertPEM())
+		if err := crl.CheckSignatureFrom(issuer); err != nil {
+			t.Fatalf("CRL not signed by the issuing CA: %v", err)
+		}
+
+		found := false
+		for _, entry := range crl.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+				found = true
+				if entry.ReasonCode != 1 {
+					t.Errorf("Expected reason code 1 (keyCompromise), got %d", entry.ReasonCode)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("Revoked serial %s not present in CRL", leaf.SerialNumber)
+		}
+	})
+}
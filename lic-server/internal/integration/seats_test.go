@@ -0,0 +1,127 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSeatAccounting(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "6666666666"
+
+	if err := env.store.CreateLicense(ctx, inn, "Seats Org", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	tokenA, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	certA, _ := env.register(t, inn, tokenA)
+	tokenB, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	certB, _ := env.register(t, inn, tokenB)
+
+	t.Run("Usage is summed across instances", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/v1/activate", map[string]interface{}{
+			"inn": inn, "fingerprint": "host-a", "used_slots": 4,
+		}, certA, "")
+		if code != http.StatusOK {
+			t.Fatalf("Activate A failed: %d %s", code, body)
+		}
+
+		code, body = env.do(t, "POST", "/v1/heartbeat", map[string]interface{}{
+			"fingerprint": "host-b", "used_slots": 5,
+		}, certB, "")
+		if code != http.StatusOK {
+			t.Fatalf("Heartbeat B failed: %d %s", code, body)
+		}
+
+		lic, _ := env.store.GetLicenseByINN(ctx, inn)
+		if lic.UsedSlots != 9 || lic.RemainingSlots != 1 {
+			t.Errorf("Expected 9 used / 1 remaining, got %d / %d", lic.UsedSlots, lic.RemainingSlots)
+		}
+	})
+
+	t.Run("Exceeding MaxSlots refuses tokens", func(t *testing.T) {
+		code, _ := env.do(t, "POST", "/v1/activate", map[string]interface{}{
+			"inn": inn, "fingerprint": "host-a", "used_slots": 6,
+		}, certA, "")
+		if code != http.StatusForbidden {
+			t.Fatalf("Expected 403 when over the limit, got %d", code)
+		}
+
+		// The refused report is not recorded, so it does not lock out the other instances
+		lic, _ := env.store.GetLicenseByINN(ctx, inn)
+		if lic.UsedSlots != 9 {
+			t.Errorf("Expected the refused report to leave usage at 9, got %d", lic.UsedSlots)
+		}
+		if code, body := env.do(t, "GET", "/v1/heartbeat", nil, certB, ""); code != http.StatusOK {
+			t.Fatalf("Expected 200 heartbeat after a refused report, got %d: %s", code, body)
+		}
+
+		// Other instances are refused too while the license is over its limit
+		if err := env.store.UpdateLicenseDetails(ctx, inn, "Seats Org", 8); err != nil {
+			t.Fatalf("Failed to lower max slots: %v", err)
+		}
+		code, _ = env.do(t, "GET", "/v1/heartbeat", nil, certB, "")
+		if code != http.StatusForbidden {
+			t.Fatalf("Expected 403 heartbeat when over the limit, got %d", code)
+		}
+		_ = env.store.UpdateLicenseDetails(ctx, inn, "Seats Org", 10)
+	})
+
+	t.Run("Usage is keyed by certificate, not reported fingerprint", func(t *testing.T) {
+		// Changing the reported fingerprint does not add a second instance for the same certificate
+		code, body := env.do(t, "POST", "/v1/heartbeat", map[string]interface{}{
+			"fingerprint": "host-b-renamed", "used_slots": 5,
+		}, certB, "")
+		if code != http.StatusOK {
+			t.Fatalf("Heartbeat B failed: %d %s", code, body)
+		}
+		if lic, _ := env.store.GetLicenseByINN(ctx, inn); lic.UsedSlots != 9 {
+			t.Errorf("Expected usage to stay at 9, got %d", lic.UsedSlots)
+		}
+	})
+
+	t.Run("Dropping back under the limit restores access", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/v1/activate", map[string]interface{}{
+			"inn": inn, "fingerprint": "host-a", "used_slots": 2,
+		}, certA, "")
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+	})
+
+	t.Run("Admin sees per-instance usage", func(t *testing.T) {
+		code, body := env.do(t, "GET", "/api/admin/licenses/"+inn+"/instances", nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		var usage []struct {
+			InstanceID string
+			UsedSlots  int
+		}
+		if err := json.Unmarshal(body, &usage); err != nil {
+			t.Fatalf("Failed to decode usage: %v", err)
+		}
+		if len(usage) != 2 {
+			t.Fatalf("Expected 2 instances, got %d", len(usage))
+		}
+
+		code, body = env.do(t, "GET", "/api/admin/licenses", nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		var licenses []struct {
+			INN            string
+			UsedSlots      int
+			RemainingSlots int
+		}
+		_ = json.Unmarshal(body, &licenses)
+		for _, l := range licenses {
+			if l.INN == inn && (l.UsedSlots != 7 || l.RemainingSlots != 3) {
+				t.Errorf("Expected 7 used / 3 remaining in admin list, got %d / %d", l.UsedSlots, l.RemainingSlots)
+			}
+		}
+	})
+}
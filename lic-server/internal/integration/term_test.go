@@ -0,0 +1,145 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestLicenseTerms(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	inn := "4444444444"
+
+	if err := env.store.CreateLicenseWithTerm(ctx, inn, "Term Org", 10, time.Now().Add(24*time.Hour), true, 3); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	token, _, _ := env.svc.CreateEnrollmentToken(ctx, inn, time.Hour, 1, "")
+	cert, _ := env.register(t, inn, token)
+
+	activate := func(t *testing.T) (int, *license.LicenseClaims) {
+		t.Helper()
+		code, body := env.do(t, "POST", "/v1/activate", map[string]string{
+			"inn":         inn,
+			"fingerprint": "hw-fp",
+		}, cert, "")
+		if code != http.StatusOK {
+			return code, nil
+		}
+		var resp struct{ Token string }
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatalf("Failed to decode activate response: %v", err)
+		}
+		claims := &license.LicenseClaims{}
+		if _, _, err := jwt.NewParser().ParseUnverified(resp.Token, claims); err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		return code, claims
+	}
+
+	heartbeatStatus := func(t *testing.T) (int, string) {
+		t.Helper()
+		code, body := env.do(t, "GET", "/v1/heartbeat", nil, cert, "")
+		var resp struct {
+			LicenseStatus string `json:"license_status"`
+		}
+		_ = json.Unmarshal(body, &resp)
+		return code, resp.LicenseStatus
+	}
+
+	t.Run("Trial license", func(t *testing.T) {
+		code, claims := activate(t)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if claims.Status != license.StatusTrial {
+			t.Errorf("Expected status %q, got %q", license.StatusTrial, claims.Status)
+		}
+		if code, status := heartbeatStatus(t); code != http.StatusOK || status != license.StatusTrial {
+			t.Errorf("Expected heartbeat 200/%s, got %d/%s", license.StatusTrial, code, status)
+		}
+	})
+
+	t.Run("Grace period", func(t *testing.T) {
+		expiresAt := time.Now().Add(-24 * time.Hour)
+		if err := env.store.UpdateLicenseTerm(ctx, inn, expiresAt, false, 3); err != nil {
+			t.Fatalf("Failed to update term: %v", err)
+		}
+
+		code, claims := activate(t)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200 during grace, got %d", code)
+		}
+		if claims.Status != license.StatusGrace {
+			t.Errorf("Expected status %q, got %q", license.StatusGrace, claims.Status)
+		}
+		graceEnd := expiresAt.AddDate(0, 0, 3)
+		if d := claims.ExpiresAt.Time.Sub(graceEnd); d < -time.Second || d > time.Second {
+			t.Errorf("Expected token to expire at grace end %v, got %v", graceEnd, claims.ExpiresAt.Time)
+		}
+	})
+
+	t.Run("Expired license", func(t *testing.T) {
+		if err := env.store.UpdateLicenseTerm(ctx, inn, time.Now().AddDate(0, 0, -10), false, 3); err != nil {
+			t.Fatalf("Failed to update term: %v", err)
+		}
+
+		if code, _ := activate(t); code != http.StatusForbidden {
+			t.Fatalf("Expected 403 for expired license, got %d", code)
+		}
+		if code, _ := heartbeatStatus(t); code != http.StatusForbidden {
+			t.Fatalf("Expected 403 heartbeat for expired license, got %d", code)
+		}
+	})
+
+	t.Run("Renew via admin API", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/api/admin/licenses/"+inn+"/renew", map[string]int{
+			"duration_days": 30,
+		}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+
+		code, claims := activate(t)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200 after renewal, got %d", code)
+		}
+		if claims.Status != license.StatusActive {
+			t.Errorf("Expected status %q, got %q", license.StatusActive, claims.Status)
+		}
+	})
+
+	t.Run("Extend via admin API", func(t *testing.T) {
+		before, _ := env.store.GetLicenseByINN(ctx, inn)
+
+		code, body := env.do(t, "POST", "/api/admin/licenses/"+inn+"/extend", map[string]int{
+			"days": 10,
+		}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+
+		after, _ := env.store.GetLicenseByINN(ctx, inn)
+		if got := after.ExpiresAt.Sub(before.ExpiresAt); got < 239*time.Hour || got > 241*time.Hour {
+			t.Errorf("Expected expiry to move by 10 days, moved by %v", got)
+		}
+	})
+
+	t.Run("Create with a past expiry is refused", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/api/admin/licenses", map[string]interface{}{
+			"inn": "5656565656", "organization": "Expired Org", "max_slots": 5,
+			"expires_at": time.Now().Add(-time.Hour),
+		}, nil, testAdminKey)
+		if code != http.StatusBadRequest {
+			t.Fatalf("Expected 400, got %d: %s", code, body)
+		}
+		if lic, _ := env.store.GetLicenseByINN(ctx, "5656565656"); lic != nil {
+			t.Errorf("Expected no license to be created, got %+v", lic)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package integration_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/deymonster/lic-server/internal/api/router"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	ctx := context.Background()
+	limits := router.RateLimits{
+		Register: router.RouteLimits{IP: router.Limit{Rate: 0.01, Burst: 2}},
+	}
+
+	// register posts an invalid registration with the given forwarding header
+	register := func(t *testing.T, env *testEnv, header, value string) int {
+		t.Helper()
+		body, _ := json.Marshal(router.RegisterRequest{INN: "5050505050", CSR: "invalid", Token: "invalid"})
+		req, _ := http.NewRequest("POST", env.ts.URL+"/v1/register", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(header, value)
+		resp, err := env.ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	throttledIPs := func(t *testing.T, env *testEnv) []string {
+		t.Helper()
+		events, err := env.store.QueryAuditEvents(ctx, storage.AuditFilter{Action: "rate_limited"})
+		if err != nil {
+			t.Fatalf("Failed to query audit events: %v", err)
+		}
+		var ips []string
+		for _, e := range events {
+			ips = append(ips, e.IPAddress)
+		}
+		return ips
+	}
+
+	t.Run("Spoofed headers do not dodge the limiter", func(t *testing.T) {
+		env := newTestEnvWithLimits(t, limits)
+
+		codes := []int{
+			register(t, env, "X-Forwarded-For", "198.51.100.1"),
+			register(t, env, "Forwarded", "for=198.51.100.2"),
+			register(t, env, "X-Forwarded-For", "198.51.100.3"),
+		}
+		if codes[2] != http.StatusTooManyRequests {
+			t.Fatalf("Expected the third request to be throttled, got %v", codes)
+		}
+		if ips := throttledIPs(t, env); len(ips) != 1 || ips[0] != "127.0.0.1" {
+			t.Errorf("Expected the peer address in the audit log, got %v", ips)
+		}
+	})
+
+	t.Run("Clients behind a trusted proxy are told apart", func(t *testing.T) {
+		proxies, _ := router.ParseTrustedProxies("127.0.0.1")
+		env := newTestEnvBehindProxies(t, limits, proxies)
+
+		for i, ip := range []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"} {
+			if code := register(t, env, "X-Forwarded-For", ip); code == http.StatusTooManyRequests {
+				t.Fatalf("Request %d from its own client must not be throttled", i)
+			}
+		}
+		register(t, env, "Forwarded", `for="198.51.100.4:4711"`)
+		register(t, env, "Forwarded", "for=198.51.100.4")
+		if code := register(t, env, "X-Forwarded-For", "1.2.3.4, 198.51.100.4"); code != http.StatusTooManyRequests {
+			t.Fatalf("Expected the same client to be throttled, got %d", code)
+		}
+		if ips := throttledIPs(t, env); len(ips) != 1 || ips[0] != "198.51.100.4" {
+			t.Errorf("Expected the forwarded client address in the audit log, got %v", ips)
+		}
+	})
+}
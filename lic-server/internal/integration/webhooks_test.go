@@ -0,0 +1,300 @@
+package integration_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/webhook"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// webhookReceiver records signed deliveries and fails while failing is set
+type webhookReceiver struct {
+	mu      sync.Mutex
+	secret  string
+	failing bool
+	events  []webhook.Event
+	badSig  int
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	sig := r.Header.Get(webhook.SignatureHeader)
+	var ts int64
+	_, _ = fmt.Sscanf(sig, "t=%d,", &ts)
+	if sig != webhook.Sign(rc.secret, ts, body) {
+		rc.badSig++
+	}
+	if rc.failing {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var e webhook.Event
+	_ = json.Unmarshal(body, &e)
+	rc.events = append(rc.events, e)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func TestWebhooks(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	dispatcher := webhook.NewDispatcher(env.store, time.Second)
+
+	receiver := &webhookReceiver{}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	var webhookID int64
+
+	t.Run("Create returns the signing secret once", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/api/admin/webhooks", map[string]interface{}{
+			"name":        "crm",
+			"url":         srv.URL,
+			"event_types": []string{"license_*"},
+		}, nil, testAdminKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Expected 201, got %d: %s", code, body)
+		}
+		var resp struct {
+			Secret  string          `json:"secret"`
+			Webhook storage.Webhook `json:"webhook"`
+		}
+		_ = json.Unmarshal(body, &resp)
+		if !strings.HasPrefix(resp.Secret, "whsec_") || resp.Webhook.ID == 0 {
+			t.Fatalf("Unexpected create response: %s", body)
+		}
+		receiver.secret = resp.Secret
+		webhookID = resp.Webhook.ID
+
+		code, body = env.do(t, "GET", "/api/admin/webhooks", nil, nil, testAdminKey)
+		if code != http.StatusOK || strings.Contains(string(body), resp.Secret) {
+			t.Errorf("Secret must not be listed: %d %s", code, body)
+		}
+	})
+
+	t.Run("Invalid webhooks rejected", func(t *testing.T) {
+		code, _ := env.do(t, "POST", "/api/admin/webhooks", map[string]interface{}{
+			"name": "bad", "url": "ftp://example.com", "event_types": []string{"*"},
+		}, nil, testAdminKey)
+		if code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for bad url, got %d", code)
+		}
+		code, _ = env.do(t, "POST", "/api/admin/webhooks", map[string]interface{}{
+			"name": "bad", "url": srv.URL, "event_types": []string{"License Created"},
+		}, nil, testAdminKey)
+		if code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for bad event type, got %d", code)
+		}
+		code, _ = env.do(t, "POST", "/api/admin/webhooks", map[string]interface{}{
+			"name": "crm", "url": srv.URL, "event_types": []string{"*"},
+		}, nil, testAdminKey)
+		if code != http.StatusConflict {
+			t.Errorf("Expected 409 for duplicate name, got %d", code)
+		}
+	})
+
+	t.Run("Matching events are delivered signed", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/api/admin/licenses", map[string]interface{}{
+			"inn": "5555555555", "organization": "Hooked Org", "max_slots": 5,
+		}, nil, testAdminKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Create license failed: %d %s", code, body)
+		}
+		if err := dispatcher.RunOnce(ctx); err != nil {
+			t.Fatalf("RunOnce failed: %v", err)
+		}
+
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		if len(receiver.events) != 1 || receiver.events[0].Type != "license_created" || receiver.events[0].INN != "5555555555" {
+			t.Fatalf("Expected one license_created event, got %+v", receiver.events)
+		}
+		if receiver.badSig != 0 {
+			t.Errorf("Got %d deliveries with a bad signature", receiver.badSig)
+		}
+	})
+
+	var deliveryID int64
+
+	t.Run("Failed deliveries are retried with backoff", func(t *testing.T) {
+		receiver.mu.Lock()
+		receiver.failing = true
+		receiver.mu.Unlock()
+
+		env.do(t, "POST", "/api/admin/licenses/5555555555/extend", map[string]interface{}{"days": 30}, nil, testAdminKey)
+		if err := dispatcher.RunOnce(ctx); err != nil {
+			t.Fatalf("RunOnce failed: %v", err)
+		}
+
+		code, body := env.do(t, "GET", fmt.Sprintf("/api/admin/webhooks/%d/deliveries", webhookID), nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		var deliveries []storage.WebhookDelivery
+		_ = json.Unmarshal(body, &deliveries)
+		if len(deliveries) != 2 {
+			t.Fatalf("Expected 2 deliveries, got %d", len(deliveries))
+		}
+		failed := deliveries[0]
+		if failed.EventType != "license_extended" || failed.Status != storage.WebhookPending || failed.Attempts != 1 || failed.LastStatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("Unexpected failed delivery: %+v", failed)
+		}
+		if !failed.NextAttemptAt.After(time.Now()) {
+			t.Errorf("Expected the retry to be scheduled in the future, got %v", failed.NextAttemptAt)
+		}
+		deliveryID = failed.ID
+
+		code, body = env.do(t, "GET", fmt.Sprintf("/api/admin/webhooks/deliveries/%d/attempts", deliveryID), nil, nil, testAdminKey)
+		var attempts []storage.WebhookAttempt
+		_ = json.Unmarshal(body, &attempts)
+		if code != http.StatusOK || len(attempts) != 1 || attempts[0].StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("Unexpected delivery log: %d %s", code, body)
+		}
+
+		// Not due yet: another run must not retry
+		_ = dispatcher.RunOnce(ctx)
+		d, _ := env.store.GetWebhookDelivery(ctx, deliveryID)
+		if d.Attempts != 1 {
+			t.Errorf("Expected no early retry, got %d attempts", d.Attempts)
+		}
+	})
+
+	t.Run("Manual redelivery", func(t *testing.T) {
+		receiver.mu.Lock()
+		receiver.failing = false
+		receiver.mu.Unlock()
+
+		code, _ := env.do(t, "POST", fmt.Sprintf("/api/admin/webhooks/deliveries/%d/redeliver", deliveryID), nil, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if err := dispatcher.RunOnce(ctx); err != nil {
+			t.Fatalf("RunOnce failed: %v", err)
+		}
+		d, _ := env.store.GetWebhookDelivery(ctx, deliveryID)
+		if d.Status != storage.WebhookDelivered || d.Attempts != 2 || d.DeliveredAt == nil {
+			t.Errorf("Expected delivered after redelivery, got %+v", d)
+		}
+
+		if code, _ := env.do(t, "POST", "/api/admin/webhooks/deliveries/999999/redeliver", nil, nil, testAdminKey); code != http.StatusNotFound {
+			t.Errorf("Expected 404 for unknown delivery, got %d", code)
+		}
+	})
+
+	t.Run("Paused webhooks get no new deliveries", func(t *testing.T) {
+		code, body := env.do(t, "PUT", fmt.Sprintf("/api/admin/webhooks/%d", webhookID), map[string]interface{}{"active": false}, nil, testAdminKey)
+		if code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", code, body)
+		}
+		env.do(t, "POST", "/api/admin/licenses", map[string]interface{}{
+			"inn": "5555555556", "organization": "Quiet Org", "max_slots": 5,
+		}, nil, testAdminKey)
+		_ = dispatcher.RunOnce(ctx)
+
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		if len(receiver.events) != 2 {
+			t.Errorf("Expected no delivery while paused, got %d events", len(receiver.events))
+		}
+	})
+
+	t.Run("Webhooks require the admin scope", func(t *testing.T) {
+		code, body := env.do(t, "POST", "/api/admin/api-keys", map[string]interface{}{
+			"name": "auditor", "scopes": []string{"audit-read"},
+		}, nil, testAdminKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Create key failed: %d %s", code, body)
+		}
+		var resp struct {
+			Key string `json:"key"`
+		}
+		_ = json.Unmarshal(body, &resp)
+		if code, _ := env.do(t, "GET", "/api/admin/webhooks", nil, nil, resp.Key); code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", code)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if code, _ := env.do(t, "DELETE", fmt.Sprintf("/api/admin/webhooks/%d", webhookID), nil, nil, testAdminKey); code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", code)
+		}
+		if code, _ := env.do(t, "GET", fmt.Sprintf("/api/admin/webhooks/%d/deliveries", webhookID), nil, nil, testAdminKey); code != http.StatusNotFound {
+			t.Errorf("Expected 404 after delete, got %d", code)
+		}
+	})
+}
+
+func TestWebhooksSlowEndpoint(t *testing.T) {
+	env := newTestEnv(t)
+	ctx := context.Background()
+	dispatcher := webhook.NewDispatcher(env.store, time.Second)
+
+	const events = 3
+	fastDone := make(chan struct{})
+	var fastMu sync.Mutex
+	fastSeen := 0
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fastMu.Lock()
+		defer fastMu.Unlock()
+		if fastSeen++; fastSeen == events {
+			close(fastDone)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer fast.Close()
+
+	// The slow endpoint answers only once the fast one got everything,
+	// which never happens if deliveries to both share one queue
+	var stalled sync.Once
+	slowStalled := false
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-fastDone:
+		case <-time.After(3 * time.Second):
+			stalled.Do(func() { slowStalled = true })
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer slow.Close()
+
+	// The slow webhook is created first, so its deliveries come first in every batch
+	for _, hook := range []struct{ name, url string }{{"slow", slow.URL}, {"fast", fast.URL}} {
+		code, body := env.do(t, "POST", "/api/admin/webhooks", map[string]interface{}{
+			"name": hook.name, "url": hook.url, "event_types": []string{"license_created"},
+		}, nil, testAdminKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Create webhook failed: %d %s", code, body)
+		}
+	}
+	for i := 0; i < events; i++ {
+		code, body := env.do(t, "POST", "/api/admin/licenses", map[string]interface{}{
+			"inn": fmt.Sprintf("777777777%d", i), "organization": "Slow Org", "max_slots": 5,
+		}, nil, testAdminKey)
+		if code != http.StatusCreated {
+			t.Fatalf("Create license failed: %d %s", code, body)
+		}
+	}
+
+	if err := dispatcher.RunOnce(ctx); err != nil {
+		t.Fatalf("RunOnce failed: %v", err)
+	}
+	if slowStalled {
+		t.Error("Expected the fast webhook to be served while the slow one was waiting")
+	}
+	fastMu.Lock()
+	defer fastMu.Unlock()
+	if fastSeen != events {
+		t.Errorf("Expected %d deliveries to the fast webhook, got %d", events, fastSeen)
+	}
+}
@@ -0,0 +1,16 @@
+package storage
+
+import "time"
+
+// AdminAPIKey is a named admin credential. Only the SHA-256 hash of the key is stored.
+type AdminAPIKey struct {
+	ID         int64
+	Name       string
+	KeyPrefix  string // first characters of the key, to recognise it without revealing it
+	Scopes     []string
+	PartnerID  *int64 // the key only reaches the licenses of this partner; nil for operator keys
+	CreatedBy  string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
@@ -0,0 +1,11 @@
+package storage
+
+// AuditChainStatus is the result of walking the audit hash chain
+type AuditChainStatus struct {
+	Valid    bool
+	Checked  int    // events verified before stopping
+	HeadID   int64  // last event of the intact chain
+	HeadHash string // hash of HeadID
+	BrokenID int64  // first event that does not verify
+	Reason   string
+}
@@ -0,0 +1,43 @@
+package storage
+
+import "time"
+
+// EnrollmentToken authorizes registrations of licd instances for an INN.
+// Only the SHA-256 hash of the token is stored.
+type EnrollmentToken struct {
+	ID          int64
+	TokenPrefix string // first characters of the token, to recognise it without revealing it
+	INN         string // empty for the static token from config, which is valid for any INN
+	MaxUses     int    // 0 means unlimited
+	UseCount    int
+	ExpiresAt   time.Time
+	RevokedAt   *time.Time
+	CreatedBy   string
+	CreatedAt   time.Time
+}
+
+// EnrollmentTokenUse records a registration made with an enrollment token
+type EnrollmentTokenUse struct {
+	ID         int64
+	TokenID    int64
+	INN        string
+	CertSerial string
+	IPAddress  string
+	UsedAt     time.Time
+}
+
+// CheckEnrollmentToken reports why a token cannot be redeemed for inn, or nil if it can.
+// Both storage backends use it so the errors are the same.
+func CheckEnrollmentToken(t *EnrollmentToken, inn string, now time.Time) error {
+	switch {
+	case t.RevokedAt != nil:
+		return Conflictf("enrollment token revoked")
+	case t.MaxUses > 0 && t.UseCount >= t.MaxUses:
+		return Conflictf("enrollment token already used")
+	case now.After(t.ExpiresAt):
+		return Conflictf("enrollment token expired")
+	case t.INN != "" && t.INN != inn:
+		return Conflictf("enrollment token does not match INN")
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kinds of storage refusals, shared by both backends. Test with errors.Is;
+// the message of the returned error still says what was refused.
+var (
+	ErrNotFound = errors.New("not found")
+	ErrConflict = errors.New("conflict") // the row exists already or is in the wrong state
+	ErrInvalid  = errors.New("invalid")  // the input cannot be stored, e.g. a corrupt backup
+
+	ErrSlotPoolExceeded = errors.New("slot pool exceeded") // a partner has no slots left in its pool
+)
+
+// kindError is a storage refusal of a given kind
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+// NotFoundf returns an ErrNotFound refusal with the formatted message
+func NotFoundf(format string, args ...interface{}) error {
+	return &kindError{kind: ErrNotFound, msg: fmt.Sprintf(format, args...)}
+}
+
+// Conflictf returns an ErrConflict refusal with the formatted message
+func Conflictf(format string, args ...interface{}) error {
+	return &kindError{kind: ErrConflict, msg: fmt.Sprintf(format, args...)}
+}
+
+// Invalidf returns an ErrInvalid refusal with the formatted message
+func Invalidf(format string, args ...interface{}) error {
+	return &kindError{kind: ErrInvalid, msg: fmt.Sprintf(format, args...)}
+}
+
+// SlotPoolExceededf returns an ErrSlotPoolExceeded refusal with the formatted message
+func SlotPoolExceededf(format string, args ...interface{}) error {
+	return &kindError{kind: ErrSlotPoolExceeded, msg: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,26 @@
+package storage
+
+import "time"
+
+// HardwareTransfer is a request to move a licd instance from one machine to another
+type HardwareTransfer struct {
+	ID             int64
+	INN            string
+	CertSerial     string // certificate of the instance that asked for the transfer
+	OldFingerprint string
+	NewFingerprint string
+	Reason         string
+	Status         string // pending, approved or rejected
+	AutoApproved   bool   // approved within the yearly quota without an admin
+	DecidedBy      string
+	DecisionNote   string
+	IPAddress      string
+	CreatedAt      time.Time
+	DecidedAt      *time.Time
+}
+
+// HardwareTransferFilter selects hardware transfers; empty fields match everything
+type HardwareTransferFilter struct {
+	INN    string
+	Status string
+}
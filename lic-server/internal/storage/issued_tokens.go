@@ -0,0 +1,14 @@
+package storage
+
+import "time"
+
+// IssuedToken records a signed license token so heartbeats can tell whether it is still current
+type IssuedToken struct {
+	ID          string // jti
+	INN         string
+	Fingerprint string // hardware fingerprint the token is bound to
+	KeyVersion  int
+	StateHash   string // digest of the license state encoded in the token
+	IssuedAt    time.Time
+	ExpiresAt   time.Time
+}
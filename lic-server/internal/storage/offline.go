@@ -0,0 +1,17 @@
+package storage
+
+import "time"
+
+// OfflineActivation records a license issued from an uploaded offline activation request
+type OfflineActivation struct {
+	ID             int64
+	INN            string
+	Fingerprint    string // hardware fingerprint of the licd host
+	Nonce          string // request nonce; each request file can be redeemed once
+	KeyFingerprint string // SHA-256 of the licd instance key that signed the request
+	LicdVersion    string
+	UsedSlots      int
+	TokenID        string // jti of the issued license token
+	CreatedBy      string // admin key that uploaded the request
+	CreatedAt      time.Time
+}
@@ -0,0 +1,22 @@
+package storage
+
+import "time"
+
+// Partner is a reseller that manages its own customers' licenses within a pool of slots
+type Partner struct {
+	ID             int64
+	Name           string
+	SlotPool       int // slots the partner may hand out over all of its licenses
+	AllocatedSlots int // max slots of its licenses that are not revoked
+	CreatedBy      string
+	CreatedAt      time.Time
+}
+
+// CheckSlotPool refuses to allocate extra slots beyond what is left of a partner's pool
+func CheckSlotPool(p *Partner, extra int) error {
+	if extra > 0 && p.AllocatedSlots+extra > p.SlotPool {
+		return SlotPoolExceededf("slot pool of partner %s exceeded: %d of %d slots allocated, %d more requested",
+			p.Name, p.AllocatedSlots, p.SlotPool, extra)
+	}
+	return nil
+}
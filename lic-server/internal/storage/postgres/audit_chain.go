@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/deymonster/lic-server/internal/core/audit"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// The audit hash chain is the same as in package sqlite. Replicas append to one chain,
+// so the head is read and extended under an advisory lock instead of a process mutex.
+
+const auditEventColumns = `id, action, inn, ip_address, details, actor, created_at, prev_hash, hash`
+
+func scanAuditEvent(row rowScanner) (*storage.AuditEvent, error) {
+	var e storage.AuditEvent
+	if err := row.Scan(&e.ID, &e.Action, &e.INN, &e.IPAddress, &e.Details, &e.Actor, &e.CreatedAt, &e.PrevHash, &e.Hash); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+func computeAuditHash(e *storage.AuditEvent, prevHash string) string {
+	return audit.ChainHash(prevHash, e.Action, e.INN, e.IPAddress, e.Details, e.Actor, e.CreatedAt)
+}
+
+// appendAuditEvent links e to the current chain head and inserts it
+func (s *Storage) appendAuditEvent(ctx context.Context, e *storage.AuditEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, auditChainLock); err != nil {
+		return fmt.Errorf("failed to lock audit chain: %w", err)
+	}
+
+	var prevHash string
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read audit chain head: %w", err)
+	}
+
+	e.PrevHash = prevHash
+	e.Hash = computeAuditHash(e, prevHash)
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO audit_events (action, inn, ip_address, details, actor, created_at, prev_hash, hash) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		e.Action, e.INN, e.IPAddress, e.Details, e.Actor, e.CreatedAt.UTC(), e.PrevHash, e.Hash).Scan(&e.ID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// VerifyAuditChain walks all audit events from oldest to newest and stops at the first broken link
+func (s *Storage) VerifyAuditChain(ctx context.Context) (*storage.AuditChainStatus, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+auditEventColumns+` FROM audit_events ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	status := &storage.AuditChainStatus{Valid: true}
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if e.PrevHash != status.HeadHash {
+			status.Valid = false
+			status.BrokenID = e.ID
+			if status.HeadID == 0 {
+				status.Reason = "first event does not start the chain; earlier events may have been deleted"
+			} else {
+				status.Reason = fmt.Sprintf("previous hash does not match event %d; events may have been deleted or inserted", status.HeadID)
+			}
+			return status, nil
+		}
+		if computeAuditHash(e, e.PrevHash) != e.Hash {
+			status.Valid = false
+			status.BrokenID = e.ID
+			status.Reason = "event contents do not match its hash; the event was modified"
+			return status, nil
+		}
+		status.Checked++
+		status.HeadID = e.ID
+		status.HeadHash = e.Hash
+	}
+	return status, rows.Err()
+}
+
+// GetAuditEventHash returns the stored hash of one event, or "" if it does not exist
+func (s *Storage) GetAuditEventHash(ctx context.Context, id int64) (string, error) {
+	var hash string
+	err := s.db.QueryRowContext(ctx, `SELECT hash FROM audit_events WHERE id = $1`, id).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// GetEntitlements returns the entitlements of a license as key -> JSON value
+func (s *Storage) GetEntitlements(ctx context.Context, inn string) (map[string]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT key, value FROM license_entitlements WHERE inn = $1`, inn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query entitlements: %w", err)
+	}
+	defer rows.Close()
+
+	ents := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("failed to scan entitlement: %w", err)
+		}
+		ents[key] = value
+	}
+	return ents, rows.Err()
+}
+
+// ReplaceEntitlements replaces all entitlements of a license with ents
+func (s *Storage) ReplaceEntitlements(ctx context.Context, inn string, ents map[string]string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM license_entitlements WHERE inn = $1`, inn); err != nil {
+		return fmt.Errorf("failed to clear entitlements: %w", err)
+	}
+	for key, value := range ents {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO license_entitlements (inn, key, value) VALUES ($1, $2, $3)`, inn, key, value); err != nil {
+			return fmt.Errorf("failed to save entitlement: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SetEntitlement creates or updates one entitlement of a license
+func (s *Storage) SetEntitlement(ctx context.Context, inn, key, value string) error {
+	query := `
+		INSERT INTO license_entitlements (inn, key, value) VALUES ($1, $2, $3)
+		ON CONFLICT(inn, key) DO UPDATE SET value = excluded.value, updated_at = NOW()
+	`
+	if _, err := s.db.ExecContext(ctx, query, inn, key, value); err != nil {
+		return fmt.Errorf("failed to save entitlement: %w", err)
+	}
+	return nil
+}
+
+// DeleteEntitlement removes one entitlement of a license
+func (s *Storage) DeleteEntitlement(ctx context.Context, inn, key string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM license_entitlements WHERE inn = $1 AND key = $2`, inn, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete entitlement: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.NotFoundf("entitlement not found")
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+const hardwareTransferColumns = `id, inn, cert_serial, old_fingerprint, new_fingerprint, reason, status, auto_approved, decided_by, decision_note, ip_address, created_at, decided_at`
+
+func scanHardwareTransfer(row rowScanner) (*storage.HardwareTransfer, error) {
+	var t storage.HardwareTransfer
+	var decidedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.INN, &t.CertSerial, &t.OldFingerprint, &t.NewFingerprint, &t.Reason, &t.Status,
+		&t.AutoApproved, &t.DecidedBy, &t.DecisionNote, &t.IPAddress, &t.CreatedAt, &decidedAt)
+	if err != nil {
+		return nil, err
+	}
+	if decidedAt.Valid {
+		t.DecidedAt = &decidedAt.Time
+	}
+	return &t, nil
+}
+
+// BindHardwareFingerprint binds an instance that has no machine yet to fingerprint
+func (s *Storage) BindHardwareFingerprint(ctx context.Context, bindingID int64, fingerprint string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE client_cert_bindings SET hardware_fingerprint = $1 WHERE id = $2 AND hardware_fingerprint = ''`, fingerprint, bindingID)
+	if err != nil {
+		return fmt.Errorf("failed to bind hardware fingerprint: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.Conflictf("hardware fingerprint already bound")
+	}
+	return nil
+}
+
+// CreateHardwareTransfer stores a pending hardware transfer and sets its ID
+func (s *Storage) CreateHardwareTransfer(ctx context.Context, t *storage.HardwareTransfer) error {
+	query := `
+		INSERT INTO hardware_transfers (inn, cert_serial, old_fingerprint, new_fingerprint, reason, status, ip_address)
+		VALUES ($1, $2, $3, $4, $5, 'pending', $6)
+		RETURNING id
+	`
+	err := s.db.QueryRowContext(ctx, query, t.INN, t.CertSerial, t.OldFingerprint, t.NewFingerprint, t.Reason, t.IPAddress).Scan(&t.ID)
+	if err != nil {
+		return fmt.Errorf("failed to create hardware transfer: %w", err)
+	}
+	t.Status = "pending"
+	return nil
+}
+
+// GetHardwareTransfer returns a hardware transfer by ID, or nil if it does not exist
+func (s *Storage) GetHardwareTransfer(ctx context.Context, id int64) (*storage.HardwareTransfer, error) {
+	query := `SELECT ` + hardwareTransferColumns + ` FROM hardware_transfers WHERE id = $1`
+	t, err := scanHardwareTransfer(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan hardware transfer: %w", err)
+	}
+	return t, nil
+}
+
+// GetHardwareTransfers returns the matching hardware transfers, newest first
+func (s *Storage) GetHardwareTransfers(ctx context.Context, filter storage.HardwareTransferFilter) ([]*storage.HardwareTransfer, error) {
+	query := `SELECT ` + hardwareTransferColumns + ` FROM hardware_transfers WHERE 1=1`
+	var args []interface{}
+	if filter.INN != "" {
+		args = append(args, filter.INN)
+		query += fmt.Sprintf(` AND inn = $%d`, len(args))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		query += fmt.Sprintf(` AND status = $%d`, len(args))
+	}
+	query += ` ORDER BY id DESC`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query hardware transfers: %w", err)
+	}
+	defer rows.Close()
+
+	var transfers []*storage.HardwareTransfer
+	for rows.Next() {
+		t, err := scanHardwareTransfer(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan hardware transfer: %w", err)
+		}
+		transfers = append(transfers, t)
+	}
+	return transfers, rows.Err()
+}
+
+// CountApprovedHardwareTransfers counts the transfers of a license approved since the given time
+func (s *Storage) CountApprovedHardwareTransfers(ctx context.Context, inn string, since time.Time) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM hardware_transfers WHERE inn = $1 AND status = 'approved' AND decided_at >= $2`, inn, since).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count hardware transfers: %w", err)
+	}
+	return n, nil
+}
+
+// ApproveHardwareTransfer approves a pending transfer and rebinds the active instances
+// of the license on the old machine to the new one, in one transaction
+func (s *Storage) ApproveHardwareTransfer(ctx context.Context, id int64, decidedBy, note string, auto bool) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var inn, oldFP, newFP string
+	err = tx.QueryRowContext(ctx, `SELECT inn, old_fingerprint, new_fingerprint FROM hardware_transfers WHERE id = $1 AND status = 'pending'`, id).Scan(&inn, &oldFP, &newFP)
+	if err == sql.ErrNoRows {
+		return storage.Conflictf("hardware transfer is already decided")
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get hardware transfer: %w", err)
+	}
+
+	res, err := tx.ExecContext(ctx, `UPDATE client_cert_bindings SET hardware_fingerprint = $1 WHERE inn = $2 AND hardware_fingerprint = $3 AND status = 'active'`, newFP, inn, oldFP)
+	if err != nil {
+		return fmt.Errorf("failed to rebind hardware fingerprint: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.Conflictf("no active instance is bound to the old hardware fingerprint")
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE hardware_transfers SET status = 'approved', auto_approved = $1, decided_by = $2, decision_note = $3, decided_at = $4 WHERE id = $5`,
+		auto, decidedBy, note, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to approve hardware transfer: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RejectHardwareTransfer rejects a pending transfer
+func (s *Storage) RejectHardwareTransfer(ctx context.Context, id int64, decidedBy, note string) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE hardware_transfers SET status = 'rejected', decided_by = $1, decision_note = $2, decided_at = $3 WHERE id = $4 AND status = 'pending'`,
+		decidedBy, note, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to reject hardware transfer: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.Conflictf("hardware transfer is already decided")
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// SaveIssuedToken stores an issued license token
+func (s *Storage) SaveIssuedToken(ctx context.Context, t *storage.IssuedToken) error {
+	query := `
+		INSERT INTO issued_tokens (id, inn, fingerprint, key_version, state_hash, issued_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`
+	_, err := s.db.ExecContext(ctx, query, t.ID, t.INN, t.Fingerprint, t.KeyVersion, t.StateHash, t.IssuedAt, t.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to save issued token: %w", err)
+	}
+	return nil
+}
+
+// GetIssuedToken returns an issued token by its jti, or nil if it is unknown
+func (s *Storage) GetIssuedToken(ctx context.Context, id string) (*storage.IssuedToken, error) {
+	query := `SELECT id, inn, fingerprint, key_version, state_hash, issued_at, expires_at FROM issued_tokens WHERE id = $1`
+	var t storage.IssuedToken
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&t.ID, &t.INN, &t.Fingerprint, &t.KeyVersion, &t.StateHash, &t.IssuedAt, &t.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get issued token: %w", err)
+	}
+	return &t, nil
+}
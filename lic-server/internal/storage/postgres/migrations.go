@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+
+	"github.com/deymonster/lic-server/internal/storage/schema"
+	"github.com/golang-migrate/migrate/v4"
+	migratepg "github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrate brings the schema up to the newest embedded migration.
+// The migrate driver holds an advisory lock, so replicas starting together apply each migration once.
+func (s *Storage) migrate() error {
+	src, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+	latest, err := schema.Latest(src)
+	if err != nil {
+		src.Close()
+		return err
+	}
+
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		src.Close()
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	// Built from a connection, the driver closes only that connection and leaves s.db open
+	driver, err := migratepg.WithConnection(ctx, conn, &migratepg.Config{})
+	if err != nil {
+		conn.Close()
+		src.Close()
+		return fmt.Errorf("failed to create migration driver: %w", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "postgres", driver)
+	if err != nil {
+		driver.Close()
+		src.Close()
+		return fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+	defer m.Close()
+
+	s.schemaVersion, err = schema.Migrate(m, latest)
+	return err
+}
+
+// SchemaVersion returns the migration version the database is at
+func (s *Storage) SchemaVersion() uint {
+	return s.schemaVersion
+}
@@ -0,0 +1,141 @@
+CREATE TABLE IF NOT EXISTS licenses (
+    id BIGSERIAL PRIMARY KEY,
+    inn TEXT NOT NULL UNIQUE,
+    organization TEXT NOT NULL,
+    max_slots INTEGER NOT NULL DEFAULT 10,
+    used_slots INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'active',
+    expires_at TIMESTAMPTZ NOT NULL,
+    is_trial BOOLEAN NOT NULL DEFAULT FALSE,
+    grace_days INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS client_cert_bindings (
+    id BIGSERIAL PRIMARY KEY,
+    inn TEXT NOT NULL,
+    cert_serial TEXT NOT NULL,
+    cert_fingerprint_sha256 TEXT NOT NULL,
+    subject_cn TEXT NOT NULL,
+    issued_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
+    revoked_at TIMESTAMPTZ,
+    revocation_reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_cert_serial ON client_cert_bindings(cert_serial);
+CREATE INDEX IF NOT EXISTS idx_cert_fingerprint ON client_cert_bindings(cert_fingerprint_sha256);
+CREATE INDEX IF NOT EXISTS idx_inn ON client_cert_bindings(inn);
+
+CREATE TABLE IF NOT EXISTS enrollment_tokens (
+    token TEXT PRIMARY KEY,
+    inn TEXT NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_enrollment_inn ON enrollment_tokens(inn);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGSERIAL PRIMARY KEY,
+    action TEXT NOT NULL,
+    inn TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    details TEXT NOT NULL DEFAULT '',
+    actor TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    prev_hash TEXT NOT NULL DEFAULT '',
+    hash TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_audit_inn ON audit_events(inn);
+CREATE INDEX IF NOT EXISTS idx_audit_action ON audit_events(action);
+
+CREATE TABLE IF NOT EXISTS instance_usage (
+    inn TEXT NOT NULL,
+    instance_id TEXT NOT NULL,
+    used_slots INTEGER NOT NULL DEFAULT 0,
+    reported_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (inn, instance_id)
+);
+
+CREATE TABLE IF NOT EXISTS admin_api_keys (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    key_hash TEXT NOT NULL UNIQUE,
+    key_prefix TEXT NOT NULL,
+    scopes TEXT NOT NULL,
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS offline_activations (
+    id BIGSERIAL PRIMARY KEY,
+    inn TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    nonce TEXT NOT NULL UNIQUE,
+    key_fingerprint TEXT NOT NULL,
+    licd_version TEXT NOT NULL DEFAULT '',
+    used_slots INTEGER NOT NULL DEFAULT 0,
+    token_id TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_offline_activations_inn ON offline_activations(inn);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    event_types TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id BIGSERIAL PRIMARY KEY,
+    webhook_id BIGINT NOT NULL,
+    event_id BIGINT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    last_status_code INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMPTZ,
+    UNIQUE (webhook_id, event_id)
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    delivery_id BIGINT NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    duration_ms BIGINT NOT NULL DEFAULT 0,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery ON webhook_attempts(delivery_id);
+
+CREATE TABLE IF NOT EXISTS webhook_cursor (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    last_event_id BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS issued_tokens (
+    id TEXT PRIMARY KEY,
+    inn TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    key_version INTEGER NOT NULL,
+    state_hash TEXT NOT NULL,
+    issued_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_issued_tokens_inn ON issued_tokens(inn);
@@ -0,0 +1,16 @@
+-- Tokens stay hashed: after going down they can no longer be redeemed and must be reissued
+DROP TABLE IF EXISTS enrollment_token_uses;
+
+ALTER TABLE enrollment_tokens ADD COLUMN used BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE enrollment_tokens SET used = (max_uses > 0 AND use_count >= max_uses) OR revoked_at IS NOT NULL;
+
+ALTER TABLE enrollment_tokens DROP CONSTRAINT enrollment_tokens_token_hash_key;
+ALTER TABLE enrollment_tokens RENAME COLUMN token_hash TO token;
+ALTER TABLE enrollment_tokens DROP CONSTRAINT enrollment_tokens_pkey;
+ALTER TABLE enrollment_tokens DROP COLUMN id;
+ALTER TABLE enrollment_tokens ADD PRIMARY KEY (token);
+ALTER TABLE enrollment_tokens DROP COLUMN token_prefix;
+ALTER TABLE enrollment_tokens DROP COLUMN max_uses;
+ALTER TABLE enrollment_tokens DROP COLUMN use_count;
+ALTER TABLE enrollment_tokens DROP COLUMN revoked_at;
+ALTER TABLE enrollment_tokens DROP COLUMN created_by;
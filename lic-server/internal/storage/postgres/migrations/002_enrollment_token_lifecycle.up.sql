@@ -0,0 +1,27 @@
+-- Enrollment tokens get an ID, a use limit and revocation, and are stored as a SHA-256 hash
+ALTER TABLE enrollment_tokens DROP CONSTRAINT enrollment_tokens_pkey;
+ALTER TABLE enrollment_tokens ADD COLUMN id BIGSERIAL PRIMARY KEY;
+ALTER TABLE enrollment_tokens ADD COLUMN token_prefix TEXT NOT NULL DEFAULT '';
+ALTER TABLE enrollment_tokens ADD COLUMN max_uses INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE enrollment_tokens ADD COLUMN use_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE enrollment_tokens ADD COLUMN revoked_at TIMESTAMPTZ;
+ALTER TABLE enrollment_tokens ADD COLUMN created_by TEXT NOT NULL DEFAULT '';
+
+UPDATE enrollment_tokens SET
+    token_prefix = substr(token, 1, 8),
+    token = encode(sha256(convert_to(token, 'UTF8')), 'hex'),
+    use_count = CASE WHEN used THEN 1 ELSE 0 END;
+
+ALTER TABLE enrollment_tokens RENAME COLUMN token TO token_hash;
+ALTER TABLE enrollment_tokens ADD CONSTRAINT enrollment_tokens_token_hash_key UNIQUE (token_hash);
+ALTER TABLE enrollment_tokens DROP COLUMN used;
+
+CREATE TABLE IF NOT EXISTS enrollment_token_uses (
+    id BIGSERIAL PRIMARY KEY,
+    token_id BIGINT NOT NULL REFERENCES enrollment_tokens(id),
+    inn TEXT NOT NULL,
+    cert_serial TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    used_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_enrollment_token_uses_token ON enrollment_token_uses(token_id);
@@ -0,0 +1 @@
+ALTER TABLE client_cert_bindings DROP COLUMN replaces_id;
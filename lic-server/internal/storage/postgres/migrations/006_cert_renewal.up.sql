@@ -0,0 +1,3 @@
+-- The binding a renewed certificate replaces. The old binding stays active until the
+-- new certificate is first used, so a renewal the instance never received does not lock it out.
+ALTER TABLE client_cert_bindings ADD COLUMN replaces_id BIGINT;
@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+const offlineActivationColumns = `id, inn, fingerprint, nonce, key_fingerprint, licd_version, used_slots, token_id, created_by, created_at`
+
+// SaveOfflineActivation stores a redeemed offline activation request.
+// It fails if the request nonce was redeemed before.
+func (s *Storage) SaveOfflineActivation(ctx context.Context, a *storage.OfflineActivation) error {
+	query := `
+		INSERT INTO offline_activations (inn, fingerprint, nonce, key_fingerprint, licd_version, used_slots, token_id, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`
+	err := s.db.QueryRowContext(ctx, query, a.INN, a.Fingerprint, a.Nonce, a.KeyFingerprint, a.LicdVersion, a.UsedSlots, a.TokenID, a.CreatedBy).Scan(&a.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.Conflictf("activation request already used")
+		}
+		return fmt.Errorf("failed to save offline activation: %w", err)
+	}
+	return nil
+}
+
+// SetOfflineActivationToken records the jti of the token issued for a saved activation
+func (s *Storage) SetOfflineActivationToken(ctx context.Context, id int64, tokenID string) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE offline_activations SET token_id = $1 WHERE id = $2`, tokenID, id)
+	if err != nil {
+		return fmt.Errorf("failed to update offline activation: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.NotFoundf("offline activation %d not found", id)
+	}
+	return nil
+}
+
+// DeleteOfflineActivation removes a saved activation, releasing its nonce
+func (s *Storage) DeleteOfflineActivation(ctx context.Context, id int64) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM offline_activations WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete offline activation: %w", err)
+	}
+	return nil
+}
+
+// GetOfflineActivations returns the offline activations of a license, newest first
+func (s *Storage) GetOfflineActivations(ctx context.Context, inn string) ([]*storage.OfflineActivation, error) {
+	query := `SELECT ` + offlineActivationColumns + ` FROM offline_activations WHERE inn = $1 ORDER BY id DESC`
+	rows, err := s.db.QueryContext(ctx, query, inn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query offline activations: %w", err)
+	}
+	defer rows.Close()
+
+	var activations []*storage.OfflineActivation
+	for rows.Next() {
+		var a storage.OfflineActivation
+		if err := rows.Scan(&a.ID, &a.INN, &a.Fingerprint, &a.Nonce, &a.KeyFingerprint, &a.LicdVersion, &a.UsedSlots, &a.TokenID, &a.CreatedBy, &a.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan offline activation: %w", err)
+		}
+		activations = append(activations, &a)
+	}
+	return activations, rows.Err()
+}
@@ -0,0 +1,213 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+const partnerColumns = `id, name, slot_pool, created_by, created_at,
+	(SELECT COALESCE(SUM(max_slots), 0) FROM licenses WHERE licenses.partner_id = partners.id AND licenses.status != 'revoked')`
+
+func scanPartner(row rowScanner) (*storage.Partner, error) {
+	var p storage.Partner
+	if err := row.Scan(&p.ID, &p.Name, &p.SlotPool, &p.CreatedBy, &p.CreatedAt, &p.AllocatedSlots); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// nullID stores an ID of 0 as NULL
+func nullID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// lockPartner locks the row of a partner for the rest of tx and reads it. Every change to
+// the slots a partner holds takes this lock first, so pool checks can't interleave.
+func lockPartner(ctx context.Context, tx *sql.Tx, id int64) (*storage.Partner, error) {
+	var locked int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM partners WHERE id = $1 FOR UPDATE`, id).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return nil, storage.NotFoundf("partner not found")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock partner: %w", err)
+	}
+	// The allocation is summed only once the lock is held, so it sees every change committed before
+	p, err := scanPartner(tx.QueryRowContext(ctx, `SELECT `+partnerColumns+` FROM partners WHERE id = $1`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan partner: %w", err)
+	}
+	return p, nil
+}
+
+// lockLicense locks the row of the license of inn for the rest of tx and reads it,
+// or returns nil if there is no such license
+func lockLicense(ctx context.Context, tx *sql.Tx, inn string) (*storage.License, error) {
+	l, err := scanLicense(tx.QueryRowContext(ctx, `SELECT `+licenseColumns+` FROM licenses WHERE inn = $1 FOR UPDATE`, inn))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan license: %w", err)
+	}
+	return l, nil
+}
+
+// CreatePartner adds a partner with a pool of slotPool slots
+func (s *Storage) CreatePartner(ctx context.Context, name string, slotPool int, createdBy string) (*storage.Partner, error) {
+	var id int64
+	err := s.db.QueryRowContext(ctx, `INSERT INTO partners (name, slot_pool, created_by) VALUES ($1, $2, $3) RETURNING id`,
+		name, slotPool, createdBy).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return nil, storage.Conflictf("partner %q already exists", name)
+		}
+		return nil, fmt.Errorf("failed to create partner: %w", err)
+	}
+	return s.GetPartner(ctx, id)
+}
+
+// GetPartner returns a partner by ID, or nil if it does not exist
+func (s *Storage) GetPartner(ctx context.Context, id int64) (*storage.Partner, error) {
+	p, err := scanPartner(s.db.QueryRowContext(ctx, `SELECT `+partnerColumns+` FROM partners WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan partner: %w", err)
+	}
+	return p, nil
+}
+
+// GetAllPartners returns all partners by name
+func (s *Storage) GetAllPartners(ctx context.Context) ([]*storage.Partner, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+partnerColumns+` FROM partners ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query partners: %w", err)
+	}
+	defer rows.Close()
+
+	var partners []*storage.Partner
+	for rows.Next() {
+		p, err := scanPartner(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan partner: %w", err)
+		}
+		partners = append(partners, p)
+	}
+	return partners, rows.Err()
+}
+
+// UpdatePartner renames a partner and sets its slot pool, which can't shrink below the allocated slots
+func (s *Storage) UpdatePartner(ctx context.Context, id int64, name string, slotPool int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p, err := lockPartner(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if slotPool < p.AllocatedSlots {
+		return storage.SlotPoolExceededf("partner %s already allocated %d slots, more than a pool of %d", p.Name, p.AllocatedSlots, slotPool)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE partners SET name = $1, slot_pool = $2 WHERE id = $3`, name, slotPool, id); err != nil {
+		if isUniqueViolation(err) {
+			return storage.Conflictf("partner %q already exists", name)
+		}
+		return fmt.Errorf("failed to update partner: %w", err)
+	}
+	return tx.Commit()
+}
+
+// CreatePartnerLicense adds a license managed by a partner within its pool; an existing license for inn is a conflict
+func (s *Storage) CreatePartnerLicense(ctx context.Context, partnerID int64, inn, org string, maxSlots int, expiresAt time.Time, isTrial bool, graceDays int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p, err := lockPartner(ctx, tx, partnerID)
+	if err != nil {
+		return err
+	}
+	if err := storage.CheckSlotPool(p, maxSlots); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO licenses (inn, organization, max_slots, status, expires_at, is_trial, grace_days, partner_id)
+		VALUES ($1, $2, $3, 'active', $4, $5, $6, $7)
+	`
+	if _, err := tx.ExecContext(ctx, query, inn, org, maxSlots, expiresAt, isTrial, graceDays, partnerID); err != nil {
+		if isUniqueViolation(err) {
+			return storage.Conflictf("license for INN %s already exists", inn)
+		}
+		return fmt.Errorf("failed to create license: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetLicensesByPartner returns the licenses of a partner, newest first
+func (s *Storage) GetLicensesByPartner(ctx context.Context, partnerID int64) ([]*storage.License, error) {
+	query := `SELECT ` + licenseColumns + ` FROM licenses WHERE partner_id = $1 ORDER BY created_at DESC, id DESC`
+	rows, err := s.db.QueryContext(ctx, query, partnerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query licenses: %w", err)
+	}
+	defer rows.Close()
+
+	var licenses []*storage.License
+	for rows.Next() {
+		l, err := scanLicense(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan license: %w", err)
+		}
+		licenses = append(licenses, l)
+	}
+	return licenses, rows.Err()
+}
+
+// SetLicensePartner hands a license to a partner, or back to the operator with partnerID 0;
+// the slots of a license that is not revoked come out of the new partner's pool
+func (s *Storage) SetLicensePartner(ctx context.Context, inn string, partnerID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lic, err := lockLicense(ctx, tx, inn)
+	if err != nil {
+		return err
+	}
+	if lic == nil {
+		return storage.NotFoundf("license not found for INN %s", inn)
+	}
+	if partnerID != 0 && (lic.PartnerID == nil || *lic.PartnerID != partnerID) {
+		p, err := lockPartner(ctx, tx, partnerID)
+		if err != nil {
+			return err
+		}
+		if lic.Status != "revoked" {
+			if err := storage.CheckSlotPool(p, lic.MaxSlots); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE licenses SET partner_id = $1 WHERE inn = $2`, nullID(partnerID), inn); err != nil {
+		return fmt.Errorf("failed to set license partner: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,574 @@
+// Package postgres stores lic-server data in PostgreSQL.
+//
+// It implements the same repository as package sqlite over the records of package
+// storage, so the license service does not know which backend it runs on. Unlike the
+// SQLite file, one database can be shared by several lic-server replicas; operations
+// that must be serialized across replicas use transaction-scoped advisory locks.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/audit"
+	"github.com/deymonster/lic-server/internal/storage"
+	"github.com/lib/pq"
+)
+
+type Storage struct {
+	db            *sql.DB
+	schemaVersion uint
+}
+
+// NewStorage connects to the database described by dsn (URL or key=value form)
+// and applies pending schema migrations
+func NewStorage(dsn string) (*Storage, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	s := &Storage{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate schema: %w", err)
+	}
+
+	return s, nil
+}
+
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// auditChainLock is the advisory lock key that serializes appends to the audit chain
+const auditChainLock int64 = 0x6c6963 // "lic"
+
+// isUniqueViolation reports whether err is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
+
+// LogAudit records an audit event. The actor (admin key name) is taken from ctx.
+// Each event is chained to the previous one, see audit_chain.go.
+func (s *Storage) LogAudit(ctx context.Context, action, inn, ip, details string) error {
+	e := &storage.AuditEvent{
+		Action:    action,
+		INN:       inn,
+		IPAddress: ip,
+		Details:   details,
+		Actor:     audit.ActorFromContext(ctx),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+	return s.appendAuditEvent(ctx, e)
+}
+
+func (s *Storage) GetAuditEvents(ctx context.Context, inn string) ([]*storage.AuditEvent, error) {
+	return s.QueryAuditEvents(ctx, storage.AuditFilter{INN: inn})
+}
+
+func (s *Storage) GetAllAuditEvents(ctx context.Context, limit int) ([]*storage.AuditEvent, error) {
+	return s.QueryAuditEvents(ctx, storage.AuditFilter{Limit: limit})
+}
+
+// auditFilterQuery builds the SELECT for an audit filter with numbered placeholders
+func auditFilterQuery(f storage.AuditFilter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.INN != "" {
+		add("inn = $%d", f.INN)
+	}
+	if f.PartnerID > 0 {
+		add("inn IN (SELECT inn FROM licenses WHERE partner_id = $%d)", f.PartnerID)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.IP != "" {
+		add("ip_address = $%d", f.IP)
+	}
+	if f.Actor != "" {
+		add("actor = $%d", f.Actor)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	if f.Before > 0 {
+		add("id < $%d", f.Before)
+	}
+	if f.After > 0 {
+		add("id > $%d", f.After)
+	}
+
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	if f.OldestFirst {
+		query += ` ORDER BY id`
+	} else {
+		query += ` ORDER BY id DESC`
+	}
+	if f.Limit > 0 {
+		args = append(args, f.Limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+	return query, args
+}
+
+// QueryAuditEvents returns matching audit events, newest first
+func (s *Storage) QueryAuditEvents(ctx context.Context, f storage.AuditFilter) ([]*storage.AuditEvent, error) {
+	var events []*storage.AuditEvent
+	err := s.StreamAuditEvents(ctx, f, func(e *storage.AuditEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	return events, err
+}
+
+// StreamAuditEvents calls fn for every matching audit event without loading the whole result into memory
+func (s *Storage) StreamAuditEvents(ctx context.Context, f storage.AuditFilter, fn func(*storage.AuditEvent) error) error {
+	query, args := auditFilterQuery(f)
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+const clientCertBindingColumns = `id, inn, cert_serial, cert_fingerprint_sha256, subject_cn, issued_at, expires_at, status, revoked_at, revocation_reason, hardware_fingerprint, replaces_id, created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanClientCertBinding(row rowScanner) (*storage.ClientCertBinding, error) {
+	var b storage.ClientCertBinding
+	var revokedAt sql.NullTime
+	var replacesID sql.NullInt64
+	err := row.Scan(&b.ID, &b.INN, &b.CertSerial, &b.CertFingerprintSHA256, &b.SubjectCN,
+		&b.IssuedAt, &b.ExpiresAt, &b.Status, &revokedAt, &b.RevocationReason, &b.HardwareFingerprint, &replacesID, &b.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		b.RevokedAt = &revokedAt.Time
+	}
+	b.ReplacesID = replacesID.Int64
+	return &b, nil
+}
+
+const insertClientCertBinding = `
+	INSERT INTO client_cert_bindings (inn, cert_serial, cert_fingerprint_sha256, subject_cn, issued_at, expires_at, status, hardware_fingerprint)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+`
+
+func (s *Storage) SaveClientCertBinding(ctx context.Context, b *storage.ClientCertBinding) error {
+	_, err := s.db.ExecContext(ctx, insertClientCertBinding, b.INN, b.CertSerial, b.CertFingerprintSHA256, b.SubjectCN, b.IssuedAt, b.ExpiresAt, b.Status, b.HardwareFingerprint)
+	if err != nil {
+		return fmt.Errorf("failed to save client cert binding: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) getClientCertBinding(ctx context.Context, column, value string) (*storage.ClientCertBinding, error) {
+	query := `SELECT ` + clientCertBindingColumns + ` FROM client_cert_bindings WHERE ` + column + ` = $1 ORDER BY id DESC LIMIT 1`
+	b, err := scanClientCertBinding(s.db.QueryRowContext(ctx, query, value))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan binding: %w", err)
+	}
+	return b, nil
+}
+
+func (s *Storage) GetClientCertBinding(ctx context.Context, fingerprint string) (*storage.ClientCertBinding, error) {
+	return s.getClientCertBinding(ctx, "cert_fingerprint_sha256", fingerprint)
+}
+
+// GetClientCertBindingBySerial looks up a binding by the certificate serial number (decimal)
+func (s *Storage) GetClientCertBindingBySerial(ctx context.Context, serial string) (*storage.ClientCertBinding, error) {
+	return s.getClientCertBinding(ctx, "cert_serial", serial)
+}
+
+// RevokeClientCertBinding marks a binding as revoked and records when and why
+func (s *Storage) RevokeClientCertBinding(ctx context.Context, id int64, reason string) error {
+	query := `UPDATE client_cert_bindings SET status = 'revoked', revoked_at = $1, revocation_reason = $2 WHERE id = $3`
+	res, err := s.db.ExecContext(ctx, query, time.Now(), reason, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke client cert binding: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.NotFoundf("client cert binding %d not found", id)
+	}
+	return nil
+}
+
+// GetClientCertBindingsByINN returns every binding of a license, newest first
+func (s *Storage) GetClientCertBindingsByINN(ctx context.Context, inn string) ([]*storage.ClientCertBinding, error) {
+	query := `SELECT ` + clientCertBindingColumns + ` FROM client_cert_bindings WHERE inn = $1 ORDER BY id DESC`
+	rows, err := s.db.QueryContext(ctx, query, inn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query bindings: %w", err)
+	}
+	defer rows.Close()
+
+	var bindings []*storage.ClientCertBinding
+	for rows.Next() {
+		b, err := scanClientCertBinding(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan binding: %w", err)
+		}
+		bindings = append(bindings, b)
+	}
+	return bindings, rows.Err()
+}
+
+// SuspendClientCertBinding puts an active binding on hold (CRL reason certificate_hold)
+func (s *Storage) SuspendClientCertBinding(ctx context.Context, id int64) error {
+	query := `UPDATE client_cert_bindings SET status = 'suspended', revoked_at = $1, revocation_reason = 'certificate_hold' WHERE id = $2 AND status = 'active'`
+	res, err := s.db.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to suspend client cert binding: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.Conflictf("client cert binding %d is not active", id)
+	}
+	return nil
+}
+
+// ReactivateClientCertBinding takes a suspended binding off hold
+func (s *Storage) ReactivateClientCertBinding(ctx context.Context, id int64) error {
+	query := `UPDATE client_cert_bindings SET status = 'active', revoked_at = NULL, revocation_reason = '' WHERE id = $1 AND status = 'suspended'`
+	res, err := s.db.ExecContext(ctx, query, id)
+	if err != nil {
+		return fmt.Errorf("failed to reactivate client cert binding: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.Conflictf("client cert binding %d is not suspended", id)
+	}
+	return nil
+}
+
+// ReplaceClientCertBinding saves the binding of a renewed certificate. The old binding must
+// be active and stays so until the new certificate is first used (see ConfirmClientCertBinding).
+func (s *Storage) ReplaceClientCertBinding(ctx context.Context, oldID int64, b *storage.ClientCertBinding) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM client_cert_bindings WHERE id = $1 FOR UPDATE`, oldID).Scan(&status)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check client cert binding: %w", err)
+	}
+	if status != "active" {
+		return storage.Conflictf("client cert binding %d is not active", oldID)
+	}
+
+	query := `
+		INSERT INTO client_cert_bindings (inn, cert_serial, cert_fingerprint_sha256, subject_cn, issued_at, expires_at, status, hardware_fingerprint, replaces_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	if _, err := tx.ExecContext(ctx, query, b.INN, b.CertSerial, b.CertFingerprintSHA256, b.SubjectCN, b.IssuedAt, b.ExpiresAt, b.Status, b.HardwareFingerprint, oldID); err != nil {
+		return fmt.Errorf("failed to save client cert binding: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ConfirmClientCertBinding completes a renewal once its certificate is first used: the replaced
+// binding and any other renewal of it that was never picked up are marked as superseded
+func (s *Storage) ConfirmClientCertBinding(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var replacesID sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT replaces_id FROM client_cert_bindings WHERE id = $1 FOR UPDATE`, id).Scan(&replacesID)
+	if err == sql.ErrNoRows {
+		return storage.NotFoundf("client cert binding %d not found", id)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check client cert binding: %w", err)
+	}
+	if !replacesID.Valid {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE client_cert_bindings SET status = 'superseded', revoked_at = $1, revocation_reason = 'superseded'
+		 WHERE (id = $2 OR (replaces_id = $2 AND id != $3)) AND status = 'active'`,
+		time.Now(), replacesID.Int64, id)
+	if err != nil {
+		return fmt.Errorf("failed to supersede client cert binding: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE client_cert_bindings SET replaces_id = NULL WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to confirm client cert binding: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// GetRevokedClientCertBindings returns the bindings that are no longer active
+// and whose certificates have not yet expired (i.e. the CRL contents)
+func (s *Storage) GetRevokedClientCertBindings(ctx context.Context) ([]*storage.ClientCertBinding, error) {
+	query := `SELECT ` + clientCertBindingColumns + ` FROM client_cert_bindings WHERE status != 'active' AND expires_at >= $1 ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revoked bindings: %w", err)
+	}
+	defer rows.Close()
+
+	var bindings []*storage.ClientCertBinding
+	for rows.Next() {
+		b, err := scanClientCertBinding(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan binding: %w", err)
+		}
+		bindings = append(bindings, b)
+	}
+	return bindings, rows.Err()
+}
+
+const licenseColumns = `id, inn, organization, max_slots, used_slots, status, expires_at, is_trial, grace_days, partner_id, created_at`
+
+func scanLicense(row rowScanner) (*storage.License, error) {
+	var l storage.License
+	var partnerID sql.NullInt64
+	err := row.Scan(&l.ID, &l.INN, &l.Organization, &l.MaxSlots, &l.UsedSlots, &l.Status,
+		&l.ExpiresAt, &l.IsTrial, &l.GraceDays, &partnerID, &l.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if partnerID.Valid {
+		l.PartnerID = &partnerID.Int64
+	}
+	l.RemainingSlots = l.MaxSlots - l.UsedSlots
+	if l.RemainingSlots < 0 {
+		l.RemainingSlots = 0
+	}
+	return &l, nil
+}
+
+func (s *Storage) GetLicenseByINN(ctx context.Context, inn string) (*storage.License, error) {
+	query := `SELECT ` + licenseColumns + ` FROM licenses WHERE inn = $1`
+	l, err := scanLicense(s.db.QueryRowContext(ctx, query, inn))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan license: %w", err)
+	}
+	return l, nil
+}
+
+func (s *Storage) GetAllLicenses(ctx context.Context) ([]*storage.License, error) {
+	query := `SELECT ` + licenseColumns + ` FROM licenses ORDER BY created_at DESC, id DESC`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query licenses: %w", err)
+	}
+	defer rows.Close()
+
+	var licenses []*storage.License
+	for rows.Next() {
+		l, err := scanLicense(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan license: %w", err)
+		}
+		licenses = append(licenses, l)
+	}
+	return licenses, rows.Err()
+}
+
+// UpdateLicenseStatus sets the status of a license; bringing back a revoked partner's
+// license takes its slots from the partner's pool again
+func (s *Storage) UpdateLicenseStatus(ctx context.Context, inn string, status string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lic, err := lockLicense(ctx, tx, inn)
+	if err != nil {
+		return err
+	}
+	if lic != nil && lic.PartnerID != nil && lic.Status == "revoked" && status != "revoked" {
+		p, err := lockPartner(ctx, tx, *lic.PartnerID)
+		if err != nil {
+			return err
+		}
+		if err := storage.CheckSlotPool(p, lic.MaxSlots); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE licenses SET status = $1 WHERE inn = $2`, status, inn); err != nil {
+		return fmt.Errorf("failed to update license status: %w", err)
+	}
+	return tx.Commit()
+}
+
+// CreateLicense adds a new one-year license (helper for seeding/admin)
+func (s *Storage) CreateLicense(ctx context.Context, inn, org string, maxSlots int) error {
+	return s.CreateLicenseWithTerm(ctx, inn, org, maxSlots, time.Now().AddDate(1, 0, 0), false, 0)
+}
+
+// CreateLicenseWithTerm adds a new license with an explicit expiry, trial flag and grace period
+func (s *Storage) CreateLicenseWithTerm(ctx context.Context, inn, org string, maxSlots int, expiresAt time.Time, isTrial bool, graceDays int) error {
+	query := `
+		INSERT INTO licenses (inn, organization, max_slots, status, expires_at, is_trial, grace_days)
+		VALUES ($1, $2, $3, 'active', $4, $5, $6)
+		ON CONFLICT (inn) DO NOTHING
+	`
+	if _, err := s.db.ExecContext(ctx, query, inn, org, maxSlots, expiresAt, isTrial, graceDays); err != nil {
+		return fmt.Errorf("failed to create license: %w", err)
+	}
+	return nil
+}
+
+// UpdateLicenseTerm sets the expiry, trial flag and grace period of a license
+func (s *Storage) UpdateLicenseTerm(ctx context.Context, inn string, expiresAt time.Time, isTrial bool, graceDays int) error {
+	query := `UPDATE licenses SET expires_at = $1, is_trial = $2, grace_days = $3 WHERE inn = $4`
+	res, err := s.db.ExecContext(ctx, query, expiresAt, isTrial, graceDays, inn)
+	if err != nil {
+		return fmt.Errorf("failed to update license term: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.NotFoundf("license not found for INN %s", inn)
+	}
+	return nil
+}
+
+// UpdateLicenseDetails updates the organization and max slots of a license;
+// more slots for a partner's license come out of the partner's pool
+func (s *Storage) UpdateLicenseDetails(ctx context.Context, inn, org string, maxSlots int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lic, err := lockLicense(ctx, tx, inn)
+	if err != nil {
+		return err
+	}
+	if lic != nil && lic.PartnerID != nil && lic.Status != "revoked" {
+		p, err := lockPartner(ctx, tx, *lic.PartnerID)
+		if err != nil {
+			return err
+		}
+		if err := storage.CheckSlotPool(p, maxSlots-lic.MaxSlots); err != nil {
+			return err
+		}
+	}
+
+	query := `UPDATE licenses SET organization = $1, max_slots = $2 WHERE inn = $3`
+	if _, err := tx.ExecContext(ctx, query, org, maxSlots, inn); err != nil {
+		return fmt.Errorf("failed to update license details: %w", err)
+	}
+	return tx.Commit()
+}
+
+// SaveInstanceUsage stores the agent count reported by a licd instance
+func (s *Storage) SaveInstanceUsage(ctx context.Context, inn, instanceID string, usedSlots int) error {
+	query := `
+		INSERT INTO instance_usage (inn, instance_id, used_slots, reported_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (inn, instance_id) DO UPDATE SET
+			used_slots = excluded.used_slots,
+			reported_at = excluded.reported_at
+	`
+	if _, err := s.db.ExecContext(ctx, query, inn, instanceID, usedSlots, time.Now()); err != nil {
+		return fmt.Errorf("failed to save instance usage: %w", err)
+	}
+	return nil
+}
+
+// DeleteInstanceUsage removes the usage report of one instance, e.g. of a renewed certificate
+func (s *Storage) DeleteInstanceUsage(ctx context.Context, inn, instanceID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM instance_usage WHERE inn = $1 AND instance_id = $2`, inn, instanceID); err != nil {
+		return fmt.Errorf("failed to delete instance usage: %w", err)
+	}
+	return nil
+}
+
+// GetInstanceUsage returns the usage reports of all instances of a license, most recent first
+func (s *Storage) GetInstanceUsage(ctx context.Context, inn string) ([]*storage.InstanceUsage, error) {
+	query := `
+		SELECT inn, instance_id, used_slots, reported_at
+		FROM instance_usage
+		WHERE inn = $1
+		ORDER BY reported_at DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query, inn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query instance usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []*storage.InstanceUsage
+	for rows.Next() {
+		var u storage.InstanceUsage
+		if err := rows.Scan(&u.INN, &u.InstanceID, &u.UsedSlots, &u.ReportedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan instance usage: %w", err)
+		}
+		usage = append(usage, &u)
+	}
+	return usage, rows.Err()
+}
+
+// RecalculateUsedSlots sums the usage of instances that reported since activeSince
+// and stores the result in licenses.used_slots
+func (s *Storage) RecalculateUsedSlots(ctx context.Context, inn string, activeSince time.Time) (int, error) {
+	var used int
+	// Written only when the total changed, so heartbeats do not rewrite the license row each time
+	query := `
+		WITH total AS (
+			SELECT COALESCE(SUM(used_slots), 0) AS used FROM instance_usage WHERE inn = $1 AND reported_at >= $2
+		), updated AS (
+			UPDATE licenses SET used_slots = total.used FROM total
+			WHERE licenses.inn = $1 AND licenses.used_slots <> total.used
+		)
+		SELECT used FROM total
+	`
+	if err := s.db.QueryRowContext(ctx, query, inn, activeSince).Scan(&used); err != nil {
+		return 0, fmt.Errorf("failed to update used slots: %w", err)
+	}
+	return used, nil
+}
@@ -0,0 +1,307 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// deliveryLease is how long a claimed delivery stays hidden from other replicas.
+// It outlasts the dispatcher's worst-case batch (100 deliveries to one endpoint that
+// times out after 10s each), so a claimed delivery is always attempted before another
+// replica can claim it; if a replica dies mid-delivery, it becomes due again once the lease expires.
+const deliveryLease = 30 * time.Minute
+
+const webhookColumns = `id, name, url, secret, event_types, active, created_by, created_at`
+
+func scanWebhook(row rowScanner) (*storage.Webhook, error) {
+	var w storage.Webhook
+	var eventTypes string
+	if err := row.Scan(&w.ID, &w.Name, &w.URL, &w.Secret, &eventTypes, &w.Active, &w.CreatedBy, &w.CreatedAt); err != nil {
+		return nil, err
+	}
+	if eventTypes != "" {
+		w.EventTypes = strings.Split(eventTypes, ",")
+	}
+	return &w, nil
+}
+
+const webhookDeliveryColumns = `id, webhook_id, event_id, event_type, payload, status, attempts, next_attempt_at, last_status_code, last_error, created_at, delivered_at`
+
+func scanWebhookDelivery(row rowScanner) (*storage.WebhookDelivery, error) {
+	var d storage.WebhookDelivery
+	var deliveredAt sql.NullTime
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &d.Payload, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &d.LastStatusCode, &d.LastError, &d.CreatedAt, &deliveredAt)
+	if err != nil {
+		return nil, err
+	}
+	if deliveredAt.Valid {
+		d.DeliveredAt = &deliveredAt.Time
+	}
+	return &d, nil
+}
+
+// CreateWebhook stores a new webhook subscription
+func (s *Storage) CreateWebhook(ctx context.Context, w *storage.Webhook) error {
+	// Pin the cursor before the first webhook exists, so it sees every event from now on
+	if _, err := s.db.ExecContext(ctx, initWebhookCursorQuery); err != nil {
+		return fmt.Errorf("failed to init webhook cursor: %w", err)
+	}
+	query := `
+		INSERT INTO webhooks (name, url, secret, event_types, active, created_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	err := s.db.QueryRowContext(ctx, query, w.Name, w.URL, w.Secret, strings.Join(w.EventTypes, ","), w.Active, w.CreatedBy).Scan(&w.ID)
+	if err != nil {
+		if isUniqueViolation(err) {
+			return storage.Conflictf("webhook %q already exists", w.Name)
+		}
+		return fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return nil
+}
+
+// GetWebhook returns a webhook by ID, or nil if it does not exist
+func (s *Storage) GetWebhook(ctx context.Context, id int64) (*storage.Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE id = $1`
+	w, err := scanWebhook(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan webhook: %w", err)
+	}
+	return w, nil
+}
+
+func (s *Storage) GetAllWebhooks(ctx context.Context) ([]*storage.Webhook, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []*storage.Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+// UpdateWebhook changes the URL, event filter and active flag of a webhook
+func (s *Storage) UpdateWebhook(ctx context.Context, w *storage.Webhook) error {
+	query := `UPDATE webhooks SET url = $1, event_types = $2, active = $3 WHERE id = $4`
+	_, err := s.db.ExecContext(ctx, query, w.URL, strings.Join(w.EventTypes, ","), w.Active, w.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook: %w", err)
+	}
+	return nil
+}
+
+// DeleteWebhook removes a webhook together with its queued deliveries and delivery log
+func (s *Storage) DeleteWebhook(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`DELETE FROM webhook_attempts WHERE delivery_id IN (SELECT id FROM webhook_deliveries WHERE webhook_id = $1)`,
+		`DELETE FROM webhook_deliveries WHERE webhook_id = $1`,
+		`DELETE FROM webhooks WHERE id = $1`,
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt, id); err != nil {
+			return fmt.Errorf("failed to delete webhook: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// initWebhookCursorQuery starts the cursor at the current end of the audit log:
+// history from before webhooks were configured is not replayed
+const initWebhookCursorQuery = `
+	INSERT INTO webhook_cursor (id, last_event_id)
+	SELECT 1, COALESCE(MAX(id), 0) FROM audit_events
+	ON CONFLICT (id) DO NOTHING
+`
+
+// GetWebhookCursor returns the ID of the last audit event scheduled for delivery
+func (s *Storage) GetWebhookCursor(ctx context.Context) (int64, error) {
+	if _, err := s.db.ExecContext(ctx, initWebhookCursorQuery); err != nil {
+		return 0, fmt.Errorf("failed to init webhook cursor: %w", err)
+	}
+	var cursor int64
+	if err := s.db.QueryRowContext(ctx, `SELECT last_event_id FROM webhook_cursor WHERE id = 1`).Scan(&cursor); err != nil {
+		return 0, fmt.Errorf("failed to read webhook cursor: %w", err)
+	}
+	return cursor, nil
+}
+
+// EnqueueWebhookDeliveries queues deliveries and advances the cursor in one transaction.
+// Replicas scheduling the same events concurrently are serialized on the cursor row;
+// whichever commits second finds the cursor already advanced and queues nothing.
+func (s *Storage) EnqueueWebhookDeliveries(ctx context.Context, deliveries []*storage.WebhookDelivery, cursor int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current int64
+	if err := tx.QueryRowContext(ctx, `SELECT last_event_id FROM webhook_cursor WHERE id = 1 FOR UPDATE`).Scan(&current); err != nil {
+		return fmt.Errorf("failed to lock webhook cursor: %w", err)
+	}
+	if current >= cursor {
+		return nil
+	}
+
+	for _, d := range deliveries {
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, status, next_attempt_at)
+			VALUES ($1, $2, $3, $4, $5, $6)
+			ON CONFLICT (webhook_id, event_id) DO NOTHING
+		`, d.WebhookID, d.EventID, d.EventType, d.Payload, storage.WebhookPending, d.NextAttemptAt)
+		if err != nil {
+			return fmt.Errorf("failed to queue webhook delivery: %w", err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE webhook_cursor SET last_event_id = $1 WHERE id = 1`, cursor); err != nil {
+		return fmt.Errorf("failed to advance webhook cursor: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetDueWebhookDeliveries claims pending deliveries whose next attempt is due, oldest first.
+// Claimed deliveries are pushed back by deliveryLease, so another replica polling at the
+// same time does not send them twice.
+func (s *Storage) GetDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]*storage.WebhookDelivery, error) {
+	query := `
+		UPDATE webhook_deliveries SET next_attempt_at = $1
+		WHERE id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE status = $2 AND next_attempt_at <= $3
+			ORDER BY id LIMIT $4
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + webhookDeliveryColumns
+	deliveries, err := s.queryWebhookDeliveries(ctx, query, now.Add(deliveryLease), storage.WebhookPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	// RETURNING does not keep the order of the subquery
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].ID < deliveries[j].ID })
+	return deliveries, nil
+}
+
+// GetWebhookDeliveries returns the most recent deliveries of a webhook, newest first
+func (s *Storage) GetWebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]*storage.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC LIMIT $2`
+	return s.queryWebhookDeliveries(ctx, query, webhookID, limit)
+}
+
+// GetWebhookDelivery returns a delivery by ID, or nil if it does not exist
+func (s *Storage) GetWebhookDelivery(ctx context.Context, id int64) (*storage.WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries WHERE id = $1`
+	d, err := scanWebhookDelivery(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+	}
+	return d, nil
+}
+
+func (s *Storage) queryWebhookDeliveries(ctx context.Context, query string, args ...interface{}) ([]*storage.WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	var deliveries []*storage.WebhookDelivery
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// RecordWebhookAttempt appends an attempt to the delivery log and stores the new delivery state
+func (s *Storage) RecordWebhookAttempt(ctx context.Context, d *storage.WebhookDelivery, a *storage.WebhookAttempt) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO webhook_attempts (delivery_id, attempt, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5)
+	`, d.ID, a.Attempt, a.StatusCode, a.Error, a.DurationMS)
+	if err != nil {
+		return fmt.Errorf("failed to log webhook attempt: %w", err)
+	}
+
+	var deliveredAt interface{}
+	if d.DeliveredAt != nil {
+		deliveredAt = *d.DeliveredAt
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, next_attempt_at = $3, last_status_code = $4, last_error = $5, delivered_at = $6
+		WHERE id = $7
+	`, d.Status, d.Attempts, d.NextAttemptAt, d.LastStatusCode, d.LastError, deliveredAt, d.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetWebhookAttempts returns the delivery log of one delivery, oldest first
+func (s *Storage) GetWebhookAttempts(ctx context.Context, deliveryID int64) ([]*storage.WebhookAttempt, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, delivery_id, attempt, status_code, error, duration_ms, attempted_at
+		FROM webhook_attempts WHERE delivery_id = $1 ORDER BY id
+	`, deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []*storage.WebhookAttempt
+	for rows.Next() {
+		var a storage.WebhookAttempt
+		if err := rows.Scan(&a.ID, &a.DeliveryID, &a.Attempt, &a.StatusCode, &a.Error, &a.DurationMS, &a.AttemptedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webhook attempt: %w", err)
+		}
+		attempts = append(attempts, &a)
+	}
+	return attempts, rows.Err()
+}
+
+// RequeueWebhookDelivery makes a delivered or failed delivery pending again, due immediately
+func (s *Storage) RequeueWebhookDelivery(ctx context.Context, id int64) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE webhook_deliveries SET status = $1, next_attempt_at = $2 WHERE id = $3`,
+		storage.WebhookPending, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to requeue webhook delivery: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Package schema applies the embedded, versioned migrations of a storage backend.
+//
+// Each backend keeps its own NNN_name.up.sql / NNN_name.down.sql files next to its
+// code and embeds them into the binary; this package runs them through golang-migrate
+// and guards against databases this build does not understand.
+package schema
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// ErrTooNew is returned when the database was migrated by a newer lic-server
+var ErrTooNew = errors.New("database schema is newer than this lic-server")
+
+// ErrDirty is returned when a previous migration failed half-way
+var ErrDirty = errors.New("database schema is dirty")
+
+// Latest returns the highest migration version in src
+func Latest(src source.Driver) (uint, error) {
+	version, err := src.First()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read migrations: %w", err)
+	}
+	for {
+		next, err := src.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read migrations: %w", err)
+		}
+		version = next
+	}
+}
+
+// Migrate applies all pending up migrations and returns the resulting version.
+// It refuses to run when the database is dirty or already at a version above latest:
+// older code must not write to a schema it does not know.
+func Migrate(m *migrate.Migrate, latest uint) (uint, error) {
+	version, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if dirty {
+		return 0, fmt.Errorf("%w at version %d; repair it and force the version with the migrate CLI", ErrDirty, version)
+	}
+	if version > latest {
+		return 0, fmt.Errorf("%w: database is at version %d, this build knows up to %d", ErrTooNew, version, latest)
+	}
+
+	if err := m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return 0, fmt.Errorf("failed to apply migrations: %w", err)
+	}
+	return latest, nil
+}
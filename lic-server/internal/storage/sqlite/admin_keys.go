@@ -0,0 +1,126 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+const adminAPIKeyColumns = `id, name, key_prefix, scopes, partner_id, created_by, created_at, last_used_at, revoked_at`
+
+func scanAdminAPIKey(row rowScanner) (*storage.AdminAPIKey, error) {
+	var k storage.AdminAPIKey
+	var scopes string
+	var partnerID sql.NullInt64
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(&k.ID, &k.Name, &k.KeyPrefix, &scopes, &partnerID, &k.CreatedBy, &k.CreatedAt, &lastUsedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+	if partnerID.Valid {
+		k.PartnerID = &partnerID.Int64
+	}
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsedAt.Valid {
+		k.LastUsedAt = &lastUsedAt.Time
+	}
+	if revokedAt.Valid {
+		k.RevokedAt = &revokedAt.Time
+	}
+	return &k, nil
+}
+
+// CreateAdminAPIKey stores a new admin key by its hash. A partnerID other than 0 confines
+// the key to the licenses of that partner.
+func (s *Storage) CreateAdminAPIKey(ctx context.Context, name, keyHash, keyPrefix string, scopes []string, partnerID int64, createdBy string) (*storage.AdminAPIKey, error) {
+	query := `
+		INSERT INTO admin_api_keys (name, key_hash, key_prefix, scopes, partner_id, created_by)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	res, err := s.db.ExecContext(ctx, query, name, keyHash, keyPrefix, strings.Join(scopes, ","), nullID(partnerID), createdBy)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, storage.Conflictf("admin key %q already exists", name)
+		}
+		return nil, fmt.Errorf("failed to create admin key: %w", err)
+	}
+	id, _ := res.LastInsertId()
+	return s.GetAdminAPIKey(ctx, id)
+}
+
+// GetAdminAPIKey returns an admin key by ID, or nil if it does not exist
+func (s *Storage) GetAdminAPIKey(ctx context.Context, id int64) (*storage.AdminAPIKey, error) {
+	query := `SELECT ` + adminAPIKeyColumns + ` FROM admin_api_keys WHERE id = ?`
+	k, err := scanAdminAPIKey(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan admin key: %w", err)
+	}
+	return k, nil
+}
+
+// lastUsedResolution is how stale last_used_at may get before a request with the key updates it,
+// so busy keys do not cost a write on every request
+const lastUsedResolution = time.Minute
+
+// GetAdminAPIKeyByHash looks up a non-revoked admin key by its hash and marks it as used
+func (s *Storage) GetAdminAPIKeyByHash(ctx context.Context, keyHash string) (*storage.AdminAPIKey, error) {
+	query := `SELECT ` + adminAPIKeyColumns + ` FROM admin_api_keys WHERE key_hash = ? AND revoked_at IS NULL`
+	k, err := scanAdminAPIKey(s.db.QueryRowContext(ctx, query, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan admin key: %w", err)
+	}
+
+	now := time.Now()
+	if k.LastUsedAt != nil && now.Sub(*k.LastUsedAt) < lastUsedResolution {
+		return k, nil
+	}
+	if _, err := s.db.ExecContext(ctx, `UPDATE admin_api_keys SET last_used_at = ? WHERE id = ?`, now, k.ID); err != nil {
+		return nil, fmt.Errorf("failed to update admin key usage: %w", err)
+	}
+	k.LastUsedAt = &now
+	return k, nil
+}
+
+// GetAllAdminAPIKeys returns all admin keys, including revoked ones
+func (s *Storage) GetAllAdminAPIKeys(ctx context.Context) ([]*storage.AdminAPIKey, error) {
+	query := `SELECT ` + adminAPIKeyColumns + ` FROM admin_api_keys ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query admin keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*storage.AdminAPIKey
+	for rows.Next() {
+		k, err := scanAdminAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan admin key: %w", err)
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAdminAPIKey marks an admin key as revoked
+func (s *Storage) RevokeAdminAPIKey(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE admin_api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke admin key: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.NotFoundf("admin key not found or already revoked")
+	}
+	return nil
+}
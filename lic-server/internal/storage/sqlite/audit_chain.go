@@ -0,0 +1,151 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/deymonster/lic-server/internal/core/audit"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// Audit events form a hash chain: every row stores the hash of the previous row and a
+// hash over its own contents plus that previous hash. Editing a row breaks its own hash,
+// deleting or inserting a row breaks the link of the row after it.
+
+const auditEventColumns = `id, action, inn, ip_address, details, actor, created_at, prev_hash, hash`
+
+func scanAuditEvent(row rowScanner) (*storage.AuditEvent, error) {
+	var e storage.AuditEvent
+	if err := row.Scan(&e.ID, &e.Action, &e.INN, &e.IPAddress, &e.Details, &e.Actor, &e.CreatedAt, &e.PrevHash, &e.Hash); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// computeAuditHash hashes the event contents together with prevHash
+func computeAuditHash(e *storage.AuditEvent, prevHash string) string {
+	return audit.ChainHash(prevHash, e.Action, e.INN, e.IPAddress, e.Details, e.Actor, e.CreatedAt)
+}
+
+// appendAuditEvent links e to the current chain head and inserts it
+func (s *Storage) appendAuditEvent(ctx context.Context, e *storage.AuditEvent) error {
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var prevHash string
+	err = tx.QueryRowContext(ctx, `SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1`).Scan(&prevHash)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to read audit chain head: %w", err)
+	}
+
+	e.PrevHash = prevHash
+	e.Hash = computeAuditHash(e, prevHash)
+	res, err := tx.ExecContext(ctx,
+		`INSERT INTO audit_events (action, inn, ip_address, details, actor, created_at, prev_hash, hash) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Action, e.INN, e.IPAddress, e.Details, e.Actor, e.CreatedAt.UTC().Format(auditTimeFormat), e.PrevHash, e.Hash)
+	if err != nil {
+		return err
+	}
+	if e.ID, err = res.LastInsertId(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sealUnchainedAuditEvents chains events written before hash chaining existed,
+// so upgraded databases are covered from their first event on.
+func (s *Storage) sealUnchainedAuditEvents() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT ` + auditEventColumns + ` FROM audit_events ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to read audit events: %w", err)
+	}
+	var unsealed []*storage.AuditEvent
+	var prevHash string
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if e.Hash == "" {
+			e.PrevHash = prevHash
+			e.Hash = computeAuditHash(e, prevHash)
+			unsealed = append(unsealed, e)
+		}
+		prevHash = e.Hash
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(unsealed) == 0 {
+		return nil
+	}
+
+	for _, e := range unsealed {
+		if _, err := tx.Exec(`UPDATE audit_events SET prev_hash = ?, hash = ? WHERE id = ?`, e.PrevHash, e.Hash, e.ID); err != nil {
+			return fmt.Errorf("failed to seal audit event %d: %w", e.ID, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// VerifyAuditChain walks all audit events from oldest to newest and stops at the first broken link
+func (s *Storage) VerifyAuditChain(ctx context.Context) (*storage.AuditChainStatus, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+auditEventColumns+` FROM audit_events ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	status := &storage.AuditChainStatus{Valid: true}
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if e.PrevHash != status.HeadHash {
+			status.Valid = false
+			status.BrokenID = e.ID
+			if status.HeadID == 0 {
+				status.Reason = "first event does not start the chain; earlier events may have been deleted"
+			} else {
+				status.Reason = fmt.Sprintf("previous hash does not match event %d; events may have been deleted or inserted", status.HeadID)
+			}
+			return status, nil
+		}
+		if computeAuditHash(e, e.PrevHash) != e.Hash {
+			status.Valid = false
+			status.BrokenID = e.ID
+			status.Reason = "event contents do not match its hash; the event was modified"
+			return status, nil
+		}
+		status.Checked++
+		status.HeadID = e.ID
+		status.HeadHash = e.Hash
+	}
+	return status, rows.Err()
+}
+
+// GetAuditEventHash returns the stored hash of one event, or "" if it does not exist
+func (s *Storage) GetAuditEventHash(ctx context.Context, id int64) (string, error) {
+	var hash string
+	err := s.db.QueryRowContext(ctx, `SELECT hash FROM audit_events WHERE id = ?`, id).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return hash, err
+}
@@ -0,0 +1,168 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	gosqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const (
+	backupPagesPerStep = 256                  // pages copied while the source is locked
+	backupStepPause    = 5 * time.Millisecond // lets writers in between steps
+)
+
+// Backup writes a consistent copy of the database to path while the server keeps using it,
+// with SQLite's online backup API. The copy is made in small steps so writers are only held
+// up briefly; a write between two steps makes SQLite start the copy over.
+func (s *Storage) Backup(ctx context.Context, path string) error {
+	dest, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer dest.Close()
+
+	if err := copyDatabase(ctx, dest, s.db, backupPagesPerStep); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces the database with the snapshot at path. The snapshot is checked first:
+// it must be intact, be a lic-server database and have a schema this build can migrate.
+// It is then copied over the live database in one step, so other connections see either
+// the old or the new data, and migrated to the current schema.
+func (s *Storage) Restore(ctx context.Context, path string) error {
+	src, err := sql.Open("sqlite3", "file:"+(&url.URL{Path: path}).EscapedPath()+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer src.Close()
+
+	if err := checkSnapshot(ctx, src); err != nil {
+		return err
+	}
+
+	// No audit event may be chained onto the old log while it is replaced
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+
+	if err := copyDatabase(ctx, s.db, src, -1); err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+	return s.prepare()
+}
+
+// checkSnapshot refuses files that are not intact lic-server databases, or whose schema
+// is dirty or newer than the embedded migrations
+func checkSnapshot(ctx context.Context, db *sql.DB) error {
+	var integrity string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&integrity); err != nil {
+		return storage.Invalidf("snapshot is not a SQLite database: %v", err)
+	}
+	if integrity != "ok" {
+		return storage.Invalidf("snapshot failed the integrity check: %s", integrity)
+	}
+
+	tables := map[string]bool{}
+	rows, err := db.QueryContext(ctx, `SELECT name FROM sqlite_master WHERE type = 'table'`)
+	if err != nil {
+		return fmt.Errorf("failed to inspect snapshot: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return fmt.Errorf("failed to inspect snapshot: %w", err)
+		}
+		tables[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to inspect snapshot: %w", err)
+	}
+	if !tables["licenses"] {
+		return storage.Invalidf("snapshot is not a lic-server database")
+	}
+	if !tables[sqlite3.DefaultMigrationsTable] {
+		// Created before versioned migrations, adopted like a legacy database on startup
+		return nil
+	}
+
+	var (
+		version uint
+		dirty   bool
+	)
+	err = db.QueryRowContext(ctx, `SELECT version, dirty FROM `+sqlite3.DefaultMigrationsTable+` LIMIT 1`).Scan(&version, &dirty)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot schema version: %w", err)
+	}
+	latest, err := latestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return storage.Invalidf("snapshot schema is dirty at version %d", version)
+	}
+	if version > latest {
+		return storage.Invalidf("snapshot schema is at version %d, this build knows up to %d", version, latest)
+	}
+	return nil
+}
+
+// copyDatabase copies the main database of src over the one of dest, pagesPerStep
+// pages at a time (-1 copies everything at once)
+func copyDatabase(ctx context.Context, dest, src *sql.DB, pagesPerStep int) error {
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriver interface{}) error {
+		return srcConn.Raw(func(srcDriver interface{}) error {
+			d, ok := destDriver.(*gosqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", destDriver)
+			}
+			s, ok := srcDriver.(*gosqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", srcDriver)
+			}
+
+			b, err := d.Backup("main", s, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				// Step reports busy and locked databases as not done, without an error
+				done, err := b.Step(pagesPerStep)
+				if err != nil {
+					b.Close()
+					return err
+				}
+				if done {
+					return b.Finish()
+				}
+				select {
+				case <-ctx.Done():
+					b.Close()
+					return ctx.Err()
+				case <-time.After(backupStepPause):
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,108 @@
+package sqlite
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctx := t.Context()
+	dir := t.TempDir()
+	s, err := NewStorage(filepath.Join(dir, "lic.db"))
+	if err != nil {
+		t.Fatalf("NewStorage failed: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.CreateLicense(ctx, "1111111111", "Before Backup", 10); err != nil {
+		t.Fatalf("Failed to create license: %v", err)
+	}
+	if err := s.LogAudit(ctx, "license_created", "1111111111", "", ""); err != nil {
+		t.Fatalf("Failed to log audit event: %v", err)
+	}
+
+	snapshot := filepath.Join(dir, "snapshot.db")
+	t.Run("Backup copies the database in use", func(t *testing.T) {
+		if err := s.Backup(ctx, snapshot); err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+		copied, err := NewStorage(snapshot)
+		if err != nil {
+			t.Fatalf("Snapshot does not open: %v", err)
+		}
+		defer copied.Close()
+		lic, err := copied.GetLicenseByINN(ctx, "1111111111")
+		if err != nil || lic == nil || lic.Organization != "Before Backup" {
+			t.Errorf("Expected the license in the snapshot, got %+v, %v", lic, err)
+		}
+	})
+
+	t.Run("Restore replaces the data", func(t *testing.T) {
+		if err := s.CreateLicense(ctx, "2222222222", "After Backup", 10); err != nil {
+			t.Fatalf("Failed to create license: %v", err)
+		}
+		if err := s.Restore(ctx, snapshot); err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+
+		if lic, _ := s.GetLicenseByINN(ctx, "2222222222"); lic != nil {
+			t.Error("Expected the license created after the backup to be gone")
+		}
+		if lic, _ := s.GetLicenseByINN(ctx, "1111111111"); lic == nil {
+			t.Error("Expected the license from the backup")
+		}
+		if s.SchemaVersion() != latestMigration(t) {
+			t.Errorf("Expected schema version %d after restore, got %d", latestMigration(t), s.SchemaVersion())
+		}
+
+		// The audit chain goes on from the restored log
+		if err := s.LogAudit(ctx, "database_restored", "", "", ""); err != nil {
+			t.Fatalf("Failed to log audit event: %v", err)
+		}
+		status, err := s.VerifyAuditChain(ctx)
+		if err != nil || !status.Valid || status.Checked != 2 {
+			t.Errorf("Expected a valid chain of 2 events, got %+v, %v", status, err)
+		}
+	})
+
+	t.Run("Incompatible snapshots are refused", func(t *testing.T) {
+		garbage := filepath.Join(dir, "garbage.db")
+		if err := os.WriteFile(garbage, []byte("not a database at all, just some text"), 0o600); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+
+		foreign := filepath.Join(dir, "foreign.db")
+		if _, err := openRaw(t, foreign).Exec(`CREATE TABLE notes (id INTEGER PRIMARY KEY)`); err != nil {
+			t.Fatalf("Failed to create foreign database: %v", err)
+		}
+
+		newer := filepath.Join(dir, "newer.db")
+		if err := s.Backup(ctx, newer); err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+		if _, err := openRaw(t, newer).Exec(`UPDATE schema_migrations SET version = ?`, latestMigration(t)+1); err != nil {
+			t.Fatalf("Failed to bump version: %v", err)
+		}
+
+		dirty := filepath.Join(dir, "dirty.db")
+		if err := s.Backup(ctx, dirty); err != nil {
+			t.Fatalf("Backup failed: %v", err)
+		}
+		if _, err := openRaw(t, dirty).Exec(`UPDATE schema_migrations SET dirty = 1`); err != nil {
+			t.Fatalf("Failed to mark dirty: %v", err)
+		}
+
+		for name, path := range map[string]string{"garbage": garbage, "foreign": foreign, "newer": newer, "dirty": dirty} {
+			if err := s.Restore(ctx, path); !errors.Is(err, storage.ErrInvalid) {
+				t.Errorf("Expected ErrInvalid for the %s snapshot, got %v", name, err)
+			}
+		}
+		if lic, _ := s.GetLicenseByINN(ctx, "1111111111"); lic == nil {
+			t.Error("Expected the database to be untouched by refused restores")
+		}
+	})
+}
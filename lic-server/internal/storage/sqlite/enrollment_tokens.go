@@ -0,0 +1,219 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+const enrollmentTokenColumns = `id, token_prefix, inn, max_uses, use_count, expires_at, revoked_at, created_by, created_at`
+
+func scanEnrollmentToken(row rowScanner) (*storage.EnrollmentToken, error) {
+	var t storage.EnrollmentToken
+	var revokedAt sql.NullTime
+	err := row.Scan(&t.ID, &t.TokenPrefix, &t.INN, &t.MaxUses, &t.UseCount, &t.ExpiresAt, &revokedAt, &t.CreatedBy, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		t.RevokedAt = &revokedAt.Time
+	}
+	return &t, nil
+}
+
+// CreateEnrollmentToken stores a new enrollment token by its hash
+func (s *Storage) CreateEnrollmentToken(ctx context.Context, tokenHash, tokenPrefix, inn string, maxUses int, expiresAt time.Time, createdBy string) (*storage.EnrollmentToken, error) {
+	query := `
+		INSERT INTO enrollment_tokens (token_hash, token_prefix, inn, max_uses, expires_at, created_by)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`
+	res, err := s.db.ExecContext(ctx, query, tokenHash, tokenPrefix, inn, maxUses, expiresAt, createdBy)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, storage.Conflictf("enrollment token already exists")
+		}
+		return nil, fmt.Errorf("failed to create enrollment token: %w", err)
+	}
+	id, _ := res.LastInsertId()
+	return s.GetEnrollmentToken(ctx, id)
+}
+
+// GetEnrollmentToken returns an enrollment token by ID, or nil if it does not exist
+func (s *Storage) GetEnrollmentToken(ctx context.Context, id int64) (*storage.EnrollmentToken, error) {
+	query := `SELECT ` + enrollmentTokenColumns + ` FROM enrollment_tokens WHERE id = ?`
+	t, err := scanEnrollmentToken(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan token: %w", err)
+	}
+	return t, nil
+}
+
+// GetEnrollmentTokenByHash returns an enrollment token by its hash, or nil if it does not exist
+func (s *Storage) GetEnrollmentTokenByHash(ctx context.Context, tokenHash string) (*storage.EnrollmentToken, error) {
+	query := `SELECT ` + enrollmentTokenColumns + ` FROM enrollment_tokens WHERE token_hash = ?`
+	t, err := scanEnrollmentToken(s.db.QueryRowContext(ctx, query, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan token: %w", err)
+	}
+	return t, nil
+}
+
+// ConsumeEnrollmentToken redeems one use of the token with the given hash for inn
+func (s *Storage) ConsumeEnrollmentToken(ctx context.Context, tokenHash, inn string) (*storage.EnrollmentToken, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT ` + enrollmentTokenColumns + ` FROM enrollment_tokens WHERE token_hash = ?`
+	t, err := scanEnrollmentToken(tx.QueryRowContext(ctx, query, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, storage.NotFoundf("invalid enrollment token")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", err)
+	}
+	if err := storage.CheckEnrollmentToken(t, inn, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE enrollment_tokens SET use_count = use_count + 1 WHERE id = ?`, t.ID); err != nil {
+		return nil, fmt.Errorf("failed to consume token: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	t.UseCount++
+	return t, nil
+}
+
+// RecordEnrollmentTokenUse links a registration to the token it was made with
+func (s *Storage) RecordEnrollmentTokenUse(ctx context.Context, u *storage.EnrollmentTokenUse) error {
+	query := `
+		INSERT INTO enrollment_token_uses (token_id, inn, cert_serial, ip_address)
+		VALUES (?, ?, ?, ?)
+	`
+	if _, err := s.db.ExecContext(ctx, query, u.TokenID, u.INN, u.CertSerial, u.IPAddress); err != nil {
+		return fmt.Errorf("failed to record enrollment token use: %w", err)
+	}
+	return nil
+}
+
+// GetEnrollmentTokenUses returns the registrations made with a token, oldest first
+func (s *Storage) GetEnrollmentTokenUses(ctx context.Context, tokenID int64) ([]*storage.EnrollmentTokenUse, error) {
+	query := `
+		SELECT id, token_id, inn, cert_serial, ip_address, used_at
+		FROM enrollment_token_uses
+		WHERE token_id = ?
+		ORDER BY id
+	`
+	rows, err := s.db.QueryContext(ctx, query, tokenID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query enrollment token uses: %w", err)
+	}
+	defer rows.Close()
+
+	var uses []*storage.EnrollmentTokenUse
+	for rows.Next() {
+		var u storage.EnrollmentTokenUse
+		if err := rows.Scan(&u.ID, &u.TokenID, &u.INN, &u.CertSerial, &u.IPAddress, &u.UsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan enrollment token use: %w", err)
+		}
+		uses = append(uses, &u)
+	}
+	return uses, rows.Err()
+}
+
+// GetAllEnrollmentTokens returns all enrollment tokens, newest first
+func (s *Storage) GetAllEnrollmentTokens(ctx context.Context) ([]*storage.EnrollmentToken, error) {
+	return s.queryEnrollmentTokens(ctx, `SELECT `+enrollmentTokenColumns+` FROM enrollment_tokens ORDER BY id DESC`)
+}
+
+// GetEnrollmentTokensByINN returns the enrollment tokens issued for inn, newest first
+func (s *Storage) GetEnrollmentTokensByINN(ctx context.Context, inn string) ([]*storage.EnrollmentToken, error) {
+	return s.queryEnrollmentTokens(ctx, `SELECT `+enrollmentTokenColumns+` FROM enrollment_tokens WHERE inn = ? ORDER BY id DESC`, inn)
+}
+
+func (s *Storage) queryEnrollmentTokens(ctx context.Context, query string, args ...interface{}) ([]*storage.EnrollmentToken, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*storage.EnrollmentToken
+	for rows.Next() {
+		t, err := scanEnrollmentToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan token: %w", err)
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// UpdateEnrollmentTokenLimits changes the use limit and expiry of a token
+func (s *Storage) UpdateEnrollmentTokenLimits(ctx context.Context, id int64, maxUses int, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE enrollment_tokens SET max_uses = ?, expires_at = ? WHERE id = ?`, maxUses, expiresAt, id)
+	if err != nil {
+		return fmt.Errorf("failed to update enrollment token: %w", err)
+	}
+	return nil
+}
+
+// RevokeEnrollmentToken marks an enrollment token as revoked
+func (s *Storage) RevokeEnrollmentToken(ctx context.Context, id int64) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE enrollment_tokens SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL`, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke enrollment token: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.NotFoundf("enrollment token not found or already revoked")
+	}
+	return nil
+}
+
+// legacyTokenMarker prefixes plaintext tokens copied by migration 002 until they are hashed
+const legacyTokenMarker = "legacy:"
+
+// hashLegacyEnrollmentTokens replaces the plaintext tokens migration 002 carried over with their hashes
+func (s *Storage) hashLegacyEnrollmentTokens() error {
+	rows, err := s.db.Query(`SELECT id, token_hash FROM enrollment_tokens WHERE token_hash LIKE ?`, legacyTokenMarker+"%")
+	if err != nil {
+		return err
+	}
+	plaintexts := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var marked string
+		if err := rows.Scan(&id, &marked); err != nil {
+			rows.Close()
+			return err
+		}
+		plaintexts[id] = strings.TrimPrefix(marked, legacyTokenMarker)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for id, token := range plaintexts {
+		hash := fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+		if _, err := s.db.Exec(`UPDATE enrollment_tokens SET token_hash = ? WHERE id = ?`, hash, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
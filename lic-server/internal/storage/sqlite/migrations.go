@@ -0,0 +1,143 @@
+package sqlite
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+
+	"github.com/deymonster/lic-server/internal/storage/schema"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrate brings the schema up to the newest embedded migration
+func (s *Storage) migrate() error {
+	if err := s.upgradeLegacySchema(); err != nil {
+		return err
+	}
+
+	src, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+	// Not m.Close: the sqlite3 driver would close s.db with it
+	defer src.Close()
+
+	latest, err := schema.Latest(src)
+	if err != nil {
+		return err
+	}
+	driver, err := sqlite3.WithInstance(s.db, &sqlite3.Config{})
+	if err != nil {
+		return fmt.Errorf("failed to create migration driver: %w", err)
+	}
+	m, err := migrate.NewWithInstance("iofs", src, "sqlite3", driver)
+	if err != nil {
+		return fmt.Errorf("failed to create migrate instance: %w", err)
+	}
+
+	s.schemaVersion, err = schema.Migrate(m, latest)
+	return err
+}
+
+// latestSchemaVersion returns the version of the newest embedded migration
+func latestSchemaVersion() (uint, error) {
+	src, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return 0, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	defer src.Close()
+	return schema.Latest(src)
+}
+
+// SchemaVersion returns the migration version the database is at
+func (s *Storage) SchemaVersion() uint {
+	return s.schemaVersion
+}
+
+// upgradeLegacySchema prepares databases created before versioned migrations.
+// Back then columns were added with ALTER TABLE at startup; a database that missed
+// some of them gets them now, so migration 001 (CREATE TABLE IF NOT EXISTS) can adopt it.
+func (s *Storage) upgradeLegacySchema() error {
+	legacy, err := s.tableExists("licenses")
+	if err != nil || !legacy {
+		return err
+	}
+	if versioned, err := s.tableExists(sqlite3.DefaultMigrationsTable); err != nil || versioned {
+		return err
+	}
+
+	columns := []struct{ table, column, definition string }{
+		{"client_cert_bindings", "revoked_at", "DATETIME"},
+		{"client_cert_bindings", "revocation_reason", "TEXT NOT NULL DEFAULT ''"},
+		{"licenses", "is_trial", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"licenses", "grace_days", "INTEGER NOT NULL DEFAULT 0"},
+		{"audit_events", "actor", "TEXT NOT NULL DEFAULT ''"},
+		{"audit_events", "prev_hash", "TEXT NOT NULL DEFAULT ''"},
+		{"audit_events", "hash", "TEXT NOT NULL DEFAULT ''"},
+	}
+	for _, c := range columns {
+		exists, err := s.tableExists(c.table)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue // created complete by migration 001
+		}
+		if err := s.ensureColumn(c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) tableExists(table string) (bool, error) {
+	var name string
+	err := s.db.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	return true, nil
+}
+
+// ensureColumn adds a column to an existing table if it is missing
+func (s *Storage) ensureColumn(table, column, definition string) error {
+	rows, err := s.db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   bool
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return fmt.Errorf("failed to scan table info: %w", err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	if _, err := s.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
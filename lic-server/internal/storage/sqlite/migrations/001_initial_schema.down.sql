@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS issued_tokens;
+DROP TABLE IF EXISTS webhook_cursor;
+DROP TABLE IF EXISTS webhook_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhooks;
+DROP TABLE IF EXISTS offline_activations;
+DROP TABLE IF EXISTS admin_api_keys;
+DROP TABLE IF EXISTS instance_usage;
+DROP TABLE IF EXISTS audit_events;
+DROP TABLE IF EXISTS enrollment_tokens;
+DROP TABLE IF EXISTS client_cert_bindings;
+DROP TABLE IF EXISTS licenses;
@@ -0,0 +1,143 @@
+-- Schema as of the switch to versioned migrations. IF NOT EXISTS lets databases
+-- created before that (see upgradeLegacySchema) adopt this version.
+CREATE TABLE IF NOT EXISTS licenses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    inn TEXT NOT NULL UNIQUE,
+    organization TEXT NOT NULL,
+    max_slots INTEGER NOT NULL DEFAULT 10,
+    used_slots INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'active',
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    is_trial BOOLEAN NOT NULL DEFAULT 0,
+    grace_days INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS client_cert_bindings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    inn TEXT NOT NULL,
+    cert_serial TEXT NOT NULL,
+    cert_fingerprint_sha256 TEXT NOT NULL,
+    subject_cn TEXT NOT NULL,
+    issued_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    status TEXT NOT NULL DEFAULT 'active',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    revoked_at DATETIME,
+    revocation_reason TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_cert_serial ON client_cert_bindings(cert_serial);
+CREATE INDEX IF NOT EXISTS idx_cert_fingerprint ON client_cert_bindings(cert_fingerprint_sha256);
+CREATE INDEX IF NOT EXISTS idx_inn ON client_cert_bindings(inn);
+
+CREATE TABLE IF NOT EXISTS enrollment_tokens (
+    token TEXT PRIMARY KEY,
+    inn TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used BOOLEAN DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_enrollment_inn ON enrollment_tokens(inn);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL,
+    inn TEXT,
+    ip_address TEXT,
+    details TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    actor TEXT NOT NULL DEFAULT '',
+    prev_hash TEXT NOT NULL DEFAULT '',
+    hash TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS idx_audit_inn ON audit_events(inn);
+CREATE INDEX IF NOT EXISTS idx_audit_action ON audit_events(action);
+
+CREATE TABLE IF NOT EXISTS instance_usage (
+    inn TEXT NOT NULL,
+    instance_id TEXT NOT NULL,
+    used_slots INTEGER NOT NULL DEFAULT 0,
+    reported_at DATETIME NOT NULL,
+    PRIMARY KEY (inn, instance_id)
+);
+
+CREATE TABLE IF NOT EXISTS admin_api_keys (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    key_hash TEXT NOT NULL UNIQUE,
+    key_prefix TEXT NOT NULL,
+    scopes TEXT NOT NULL,
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME,
+    revoked_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS offline_activations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    inn TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    nonce TEXT NOT NULL UNIQUE,
+    key_fingerprint TEXT NOT NULL,
+    licd_version TEXT NOT NULL DEFAULT '',
+    used_slots INTEGER NOT NULL DEFAULT 0,
+    token_id TEXT NOT NULL DEFAULT '',
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_offline_activations_inn ON offline_activations(inn);
+
+CREATE TABLE IF NOT EXISTS webhooks (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    event_types TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT 1,
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id INTEGER NOT NULL,
+    event_id INTEGER NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at INTEGER NOT NULL,
+    last_status_code INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    delivered_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries(status, next_attempt_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries(webhook_id);
+
+CREATE TABLE IF NOT EXISTS webhook_attempts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    delivery_id INTEGER NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    duration_ms INTEGER NOT NULL DEFAULT 0,
+    attempted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_webhook_attempts_delivery ON webhook_attempts(delivery_id);
+
+CREATE TABLE IF NOT EXISTS webhook_cursor (
+    id INTEGER PRIMARY KEY CHECK (id = 1),
+    last_event_id INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS issued_tokens (
+    id TEXT PRIMARY KEY,
+    inn TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    key_version INTEGER NOT NULL,
+    state_hash TEXT NOT NULL,
+    issued_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_issued_tokens_inn ON issued_tokens(inn);
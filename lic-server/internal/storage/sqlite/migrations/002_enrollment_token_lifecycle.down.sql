@@ -0,0 +1,18 @@
+-- Tokens stay hashed: after going down they can no longer be redeemed and must be reissued
+DROP TABLE IF EXISTS enrollment_token_uses;
+
+CREATE TABLE enrollment_tokens_v1 (
+    token TEXT PRIMARY KEY,
+    inn TEXT NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used BOOLEAN DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO enrollment_tokens_v1 (token, inn, expires_at, used, created_at)
+SELECT token_hash, inn, expires_at, (max_uses > 0 AND use_count >= max_uses) OR revoked_at IS NOT NULL, created_at
+FROM enrollment_tokens;
+
+DROP TABLE enrollment_tokens;
+ALTER TABLE enrollment_tokens_v1 RENAME TO enrollment_tokens;
+CREATE INDEX IF NOT EXISTS idx_enrollment_inn ON enrollment_tokens(inn);
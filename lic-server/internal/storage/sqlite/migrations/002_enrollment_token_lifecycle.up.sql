@@ -0,0 +1,34 @@
+-- Enrollment tokens get an ID, a use limit and revocation, and are stored as a SHA-256 hash.
+-- SQLite has no hash function: existing tokens are copied with a "legacy:" marker
+-- and hashed by the storage right after migrating (see hashLegacyEnrollmentTokens).
+CREATE TABLE enrollment_tokens_v2 (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_hash TEXT NOT NULL UNIQUE,
+    token_prefix TEXT NOT NULL DEFAULT '',
+    inn TEXT NOT NULL,
+    max_uses INTEGER NOT NULL DEFAULT 1,
+    use_count INTEGER NOT NULL DEFAULT 0,
+    expires_at DATETIME NOT NULL,
+    revoked_at DATETIME,
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO enrollment_tokens_v2 (token_hash, token_prefix, inn, max_uses, use_count, expires_at, created_at)
+SELECT 'legacy:' || token, substr(token, 1, 8), inn, 1, CASE WHEN used THEN 1 ELSE 0 END, expires_at, created_at
+FROM enrollment_tokens;
+
+DROP TABLE enrollment_tokens;
+ALTER TABLE enrollment_tokens_v2 RENAME TO enrollment_tokens;
+CREATE INDEX IF NOT EXISTS idx_enrollment_inn ON enrollment_tokens(inn);
+
+CREATE TABLE IF NOT EXISTS enrollment_token_uses (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    token_id INTEGER NOT NULL,
+    inn TEXT NOT NULL,
+    cert_serial TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY(token_id) REFERENCES enrollment_tokens(id)
+);
+CREATE INDEX IF NOT EXISTS idx_enrollment_token_uses_token ON enrollment_token_uses(token_id);
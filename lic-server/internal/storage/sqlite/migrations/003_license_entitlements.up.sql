@@ -0,0 +1,9 @@
+-- Feature flags and limits sold per license, carried in the ent claim of license tokens.
+-- value holds JSON: true/false for flags, an integer for limits.
+CREATE TABLE license_entitlements (
+    inn TEXT NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (inn, key)
+);
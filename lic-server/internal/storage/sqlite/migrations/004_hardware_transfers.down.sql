@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS hardware_transfers;
+ALTER TABLE client_cert_bindings DROP COLUMN hardware_fingerprint;
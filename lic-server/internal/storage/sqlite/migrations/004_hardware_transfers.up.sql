@@ -0,0 +1,21 @@
+-- The machine each licd instance is bound to, set on its first activation
+ALTER TABLE client_cert_bindings ADD COLUMN hardware_fingerprint TEXT NOT NULL DEFAULT '';
+
+-- Requests to move an instance to new hardware, approved by an admin or automatically within a yearly quota
+CREATE TABLE hardware_transfers (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    inn TEXT NOT NULL,
+    cert_serial TEXT NOT NULL,
+    old_fingerprint TEXT NOT NULL,
+    new_fingerprint TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending',
+    auto_approved BOOLEAN NOT NULL DEFAULT 0,
+    decided_by TEXT NOT NULL DEFAULT '',
+    decision_note TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    decided_at DATETIME
+);
+CREATE INDEX idx_hardware_transfers_inn ON hardware_transfers(inn);
+CREATE INDEX idx_hardware_transfers_status ON hardware_transfers(status);
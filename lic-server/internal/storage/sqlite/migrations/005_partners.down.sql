@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_licenses_partner;
+ALTER TABLE admin_api_keys DROP COLUMN partner_id;
+ALTER TABLE licenses DROP COLUMN partner_id;
+DROP TABLE IF EXISTS partners;
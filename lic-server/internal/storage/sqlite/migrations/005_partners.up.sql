@@ -0,0 +1,13 @@
+-- Resellers that manage their own customers within a pool of slots
+CREATE TABLE partners (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    slot_pool INTEGER NOT NULL DEFAULT 0,
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The partner a license belongs to, and the partner an admin key is confined to; NULL for the operator
+ALTER TABLE licenses ADD COLUMN partner_id INTEGER;
+ALTER TABLE admin_api_keys ADD COLUMN partner_id INTEGER;
+CREATE INDEX idx_licenses_partner ON licenses(partner_id);
@@ -0,0 +1,8 @@
+UPDATE instance_usage SET instance_id = (
+    SELECT b.hardware_fingerprint FROM client_cert_bindings b
+    WHERE b.inn = instance_usage.inn AND b.cert_serial = instance_usage.instance_id
+)
+WHERE EXISTS (
+    SELECT 1 FROM client_cert_bindings b
+    WHERE b.inn = instance_usage.inn AND b.cert_serial = instance_usage.instance_id AND b.hardware_fingerprint != ''
+);
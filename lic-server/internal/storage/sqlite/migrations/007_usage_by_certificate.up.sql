@@ -0,0 +1,11 @@
+-- Usage reports of online instances are keyed by the serial of their client certificate
+-- rather than the hardware fingerprint the instance reports about itself
+UPDATE instance_usage SET instance_id = (
+    SELECT b.cert_serial FROM client_cert_bindings b
+    WHERE b.inn = instance_usage.inn AND b.hardware_fingerprint = instance_usage.instance_id AND b.status = 'active'
+    ORDER BY b.id DESC LIMIT 1
+)
+WHERE EXISTS (
+    SELECT 1 FROM client_cert_bindings b
+    WHERE b.inn = instance_usage.inn AND b.hardware_fingerprint = instance_usage.instance_id AND b.status = 'active'
+);
@@ -0,0 +1,163 @@
+package sqlite
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/deymonster/lic-server/internal/storage/schema"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+func latestMigration(t *testing.T) uint {
+	t.Helper()
+	src, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	defer src.Close()
+	latest, err := schema.Latest(src)
+	if err != nil {
+		t.Fatalf("Failed to read migrations: %v", err)
+	}
+	return latest
+}
+
+func openRaw(t *testing.T, path string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestMigrations(t *testing.T) {
+	latest := latestMigration(t)
+
+	t.Run("New database is migrated to the latest version", func(t *testing.T) {
+		s, err := NewStorage(filepath.Join(t.TempDir(), "lic.db"))
+		if err != nil {
+			t.Fatalf("NewStorage failed: %v", err)
+		}
+		defer s.Close()
+		if s.SchemaVersion() != latest {
+			t.Errorf("Expected version %d, got %d", latest, s.SchemaVersion())
+		}
+	})
+
+	t.Run("Newer schema is refused", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lic.db")
+		s, err := NewStorage(path)
+		if err != nil {
+			t.Fatalf("NewStorage failed: %v", err)
+		}
+		s.Close()
+
+		raw := openRaw(t, path)
+		if _, err := raw.Exec(`UPDATE schema_migrations SET version = ?`, latest+1); err != nil {
+			t.Fatalf("Failed to bump version: %v", err)
+		}
+		if _, err := NewStorage(path); !errors.Is(err, schema.ErrTooNew) {
+			t.Errorf("Expected ErrTooNew, got %v", err)
+		}
+
+		if _, err := raw.Exec(`UPDATE schema_migrations SET version = ?, dirty = 1`, latest); err != nil {
+			t.Fatalf("Failed to mark dirty: %v", err)
+		}
+		if _, err := NewStorage(path); !errors.Is(err, schema.ErrDirty) {
+			t.Errorf("Expected ErrDirty, got %v", err)
+		}
+	})
+
+	t.Run("Legacy database is adopted", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lic.db")
+		raw := openRaw(t, path)
+		// The schema the first lic-server releases created, before any columns were added
+		_, err := raw.Exec(`
+			CREATE TABLE licenses (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				inn TEXT NOT NULL UNIQUE,
+				organization TEXT NOT NULL,
+				max_slots INTEGER NOT NULL DEFAULT 10,
+				used_slots INTEGER NOT NULL DEFAULT 0,
+				status TEXT NOT NULL DEFAULT 'active',
+				expires_at DATETIME NOT NULL,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+			CREATE TABLE audit_events (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				action TEXT NOT NULL,
+				inn TEXT,
+				ip_address TEXT,
+				details TEXT,
+				created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+			);
+			INSERT INTO licenses (inn, organization, expires_at) VALUES ('1111111111', 'Legacy Org', '2030-01-01 00:00:00');
+			INSERT INTO audit_events (action, inn, ip_address, details) VALUES ('license_created', '1111111111', '', '');
+		`)
+		if err != nil {
+			t.Fatalf("Failed to create legacy schema: %v", err)
+		}
+
+		s, err := NewStorage(path)
+		if err != nil {
+			t.Fatalf("NewStorage failed on a legacy database: %v", err)
+		}
+		defer s.Close()
+		if s.SchemaVersion() != latest {
+			t.Errorf("Expected version %d, got %d", latest, s.SchemaVersion())
+		}
+
+		lic, err := s.GetLicenseByINN(t.Context(), "1111111111")
+		if err != nil || lic == nil || lic.Organization != "Legacy Org" || lic.IsTrial {
+			t.Errorf("Legacy license not readable: %+v, %v", lic, err)
+		}
+		status, err := s.VerifyAuditChain(t.Context())
+		if err != nil || !status.Valid || status.Checked != 1 {
+			t.Errorf("Expected the legacy audit event to be chained, got %+v, %v", status, err)
+		}
+	})
+
+	t.Run("Down migrations revert the schema", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "lic.db")
+		s, err := NewStorage(path)
+		if err != nil {
+			t.Fatalf("NewStorage failed: %v", err)
+		}
+		s.Close()
+
+		src, _ := iofs.New(migrationsFS, "migrations")
+		driver, err := sqlite3.WithInstance(openRaw(t, path), &sqlite3.Config{})
+		if err != nil {
+			t.Fatalf("Failed to create migration driver: %v", err)
+		}
+		m, err := migrate.NewWithInstance("iofs", src, "sqlite3", driver)
+		if err != nil {
+			t.Fatalf("Failed to create migrate instance: %v", err)
+		}
+		if err := m.Down(); err != nil {
+			t.Fatalf("Down failed: %v", err)
+		}
+		m.Close()
+
+		var tables int
+		_ = openRaw(t, path).QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'licenses'`).Scan(&tables)
+		if tables != 0 {
+			t.Error("Expected the licenses table to be dropped")
+		}
+
+		s, err = NewStorage(path)
+		if err != nil {
+			t.Fatalf("NewStorage failed after down: %v", err)
+		}
+		defer s.Close()
+		if err := s.CreateLicense(t.Context(), "1111111111", "Org", 1); err != nil {
+			t.Errorf("Schema not usable after migrating up again: %v", err)
+		}
+	})
+}
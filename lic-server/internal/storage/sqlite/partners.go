@@ -0,0 +1,218 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+const partnerColumns = `id, name, slot_pool, created_by, created_at,
+	(SELECT COALESCE(SUM(max_slots), 0) FROM licenses WHERE licenses.partner_id = partners.id AND licenses.status != 'revoked')`
+
+func scanPartner(row rowScanner) (*storage.Partner, error) {
+	var p storage.Partner
+	if err := row.Scan(&p.ID, &p.Name, &p.SlotPool, &p.CreatedBy, &p.CreatedAt, &p.AllocatedSlots); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// nullID stores an ID of 0 as NULL
+func nullID(id int64) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// lockPartner reads a partner inside tx after taking the write lock with a no-op update,
+// so no other writer can allocate from its pool until tx ends
+func lockPartner(ctx context.Context, tx *sql.Tx, id int64) (*storage.Partner, error) {
+	res, err := tx.ExecContext(ctx, `UPDATE partners SET slot_pool = slot_pool WHERE id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock partner: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, storage.NotFoundf("partner not found")
+	}
+	p, err := scanPartner(tx.QueryRowContext(ctx, `SELECT `+partnerColumns+` FROM partners WHERE id = ?`, id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan partner: %w", err)
+	}
+	return p, nil
+}
+
+// lockLicense reads the license of inn inside tx after taking the write lock with a no-op
+// update, so the read can't go stale before tx writes. It returns nil if there is no such license.
+func lockLicense(ctx context.Context, tx *sql.Tx, inn string) (*storage.License, error) {
+	res, err := tx.ExecContext(ctx, `UPDATE licenses SET max_slots = max_slots WHERE inn = ?`, inn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock license: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, nil
+	}
+	l, err := scanLicense(tx.QueryRowContext(ctx, `SELECT `+licenseColumns+` FROM licenses WHERE inn = ?`, inn))
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan license: %w", err)
+	}
+	return l, nil
+}
+
+// CreatePartner adds a partner with a pool of slotPool slots
+func (s *Storage) CreatePartner(ctx context.Context, name string, slotPool int, createdBy string) (*storage.Partner, error) {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO partners (name, slot_pool, created_by) VALUES (?, ?, ?)`, name, slotPool, createdBy)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return nil, storage.Conflictf("partner %q already exists", name)
+		}
+		return nil, fmt.Errorf("failed to create partner: %w", err)
+	}
+	id, _ := res.LastInsertId()
+	return s.GetPartner(ctx, id)
+}
+
+// GetPartner returns a partner by ID, or nil if it does not exist
+func (s *Storage) GetPartner(ctx context.Context, id int64) (*storage.Partner, error) {
+	p, err := scanPartner(s.db.QueryRowContext(ctx, `SELECT `+partnerColumns+` FROM partners WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan partner: %w", err)
+	}
+	return p, nil
+}
+
+// GetAllPartners returns all partners by name
+func (s *Storage) GetAllPartners(ctx context.Context) ([]*storage.Partner, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+partnerColumns+` FROM partners ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query partners: %w", err)
+	}
+	defer rows.Close()
+
+	var partners []*storage.Partner
+	for rows.Next() {
+		p, err := scanPartner(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan partner: %w", err)
+		}
+		partners = append(partners, p)
+	}
+	return partners, rows.Err()
+}
+
+// UpdatePartner renames a partner and sets its slot pool. The pool can't shrink
+// below the slots the partner's licenses already hold.
+func (s *Storage) UpdatePartner(ctx context.Context, id int64, name string, slotPool int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p, err := lockPartner(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if slotPool < p.AllocatedSlots {
+		return storage.SlotPoolExceededf("partner %s already allocated %d slots, more than a pool of %d", p.Name, p.AllocatedSlots, slotPool)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE partners SET name = ?, slot_pool = ? WHERE id = ?`, name, slotPool, id); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return storage.Conflictf("partner %q already exists", name)
+		}
+		return fmt.Errorf("failed to update partner: %w", err)
+	}
+	return tx.Commit()
+}
+
+// CreatePartnerLicense adds a license managed by a partner, taking its slots from the
+// partner's pool. Unlike CreateLicenseWithTerm, an existing license for inn is a conflict:
+// it is never taken over.
+func (s *Storage) CreatePartnerLicense(ctx context.Context, partnerID int64, inn, org string, maxSlots int, expiresAt time.Time, isTrial bool, graceDays int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	p, err := lockPartner(ctx, tx, partnerID)
+	if err != nil {
+		return err
+	}
+	if err := storage.CheckSlotPool(p, maxSlots); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO licenses (inn, organization, max_slots, status, expires_at, is_trial, grace_days, partner_id)
+		VALUES (?, ?, ?, 'active', ?, ?, ?, ?)
+	`
+	if _, err := tx.ExecContext(ctx, query, inn, org, maxSlots, expiresAt, isTrial, graceDays, partnerID); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return storage.Conflictf("license for INN %s already exists", inn)
+		}
+		return fmt.Errorf("failed to create license: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetLicensesByPartner returns the licenses of a partner, newest first
+func (s *Storage) GetLicensesByPartner(ctx context.Context, partnerID int64) ([]*storage.License, error) {
+	query := `SELECT ` + licenseColumns + ` FROM licenses WHERE partner_id = ? ORDER BY created_at DESC`
+	rows, err := s.db.QueryContext(ctx, query, partnerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query licenses: %w", err)
+	}
+	defer rows.Close()
+
+	var licenses []*storage.License
+	for rows.Next() {
+		l, err := scanLicense(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan license: %w", err)
+		}
+		licenses = append(licenses, l)
+	}
+	return licenses, rows.Err()
+}
+
+// SetLicensePartner hands a license to a partner, or back to the operator with partnerID 0.
+// The slots of a license that is not revoked are taken from the new partner's pool.
+func (s *Storage) SetLicensePartner(ctx context.Context, inn string, partnerID int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lic, err := lockLicense(ctx, tx, inn)
+	if err != nil {
+		return err
+	}
+	if lic == nil {
+		return storage.NotFoundf("license not found for INN %s", inn)
+	}
+	if partnerID != 0 && (lic.PartnerID == nil || *lic.PartnerID != partnerID) {
+		p, err := lockPartner(ctx, tx, partnerID)
+		if err != nil {
+			return err
+		}
+		if lic.Status != "revoked" {
+			if err := storage.CheckSlotPool(p, lic.MaxSlots); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE licenses SET partner_id = ? WHERE inn = ?`, nullID(partnerID), inn); err != nil {
+		return fmt.Errorf("failed to set license partner: %w", err)
+	}
+	return tx.Commit()
+}
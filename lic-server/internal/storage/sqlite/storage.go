@@ -2,28 +2,23 @@ package sqlite
 
 import (
 	"context"
-	"crypto/rand"
 	"database/sql"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/deymonster/lic-server/internal/core/audit"
+	"github.com/deymonster/lic-server/internal/storage"
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type Storage struct {
-	db *sql.DB
-}
+	db            *sql.DB
+	schemaVersion uint
 
-type License struct {
-	ID             int64
-	INN            string
-	Organization   string
-	MaxSlots       int
-	UsedSlots      int
-	RemainingSlots int
-	Status         string
-	ExpiresAt      time.Time
-	CreatedAt      time.Time
+	// auditMu serializes LogAudit so every event chains onto the latest hash
+	auditMu sync.Mutex
 }
 
 func NewStorage(dbPath string) (*Storage, error) {
@@ -37,243 +32,294 @@ func NewStorage(dbPath string) (*Storage, error) {
 	}
 
 	s := &Storage{db: db}
-	if err := s.initSchema(); err != nil {
-		return nil, fmt.Errorf("failed to init schema: %w", err)
+	if err := s.prepare(); err != nil {
+		db.Close()
+		return nil, err
 	}
 
 	return s, nil
 }
 
+// prepare migrates the schema and upgrades data written by older versions,
+// on startup and after a restore
+func (s *Storage) prepare() error {
+	if err := s.migrate(); err != nil {
+		return fmt.Errorf("failed to migrate schema: %w", err)
+	}
+	if err := s.hashLegacyEnrollmentTokens(); err != nil {
+		return fmt.Errorf("failed to hash enrollment tokens: %w", err)
+	}
+	if err := s.sealUnchainedAuditEvents(); err != nil {
+		return fmt.Errorf("failed to seal audit events: %w", err)
+	}
+	return nil
+}
+
 func (s *Storage) Close() error {
 	return s.db.Close()
 }
 
-func (s *Storage) initSchema() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS licenses (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		inn TEXT NOT NULL UNIQUE,
-		organization TEXT NOT NULL,
-		max_slots INTEGER NOT NULL DEFAULT 10,
-		used_slots INTEGER NOT NULL DEFAULT 0,
-		status TEXT NOT NULL DEFAULT 'active',
-		expires_at DATETIME NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS client_cert_bindings (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		inn TEXT NOT NULL,
-		cert_serial TEXT NOT NULL,
-		cert_fingerprint_sha256 TEXT NOT NULL,
-		subject_cn TEXT NOT NULL,
-		issued_at DATETIME NOT NULL,
-		expires_at DATETIME NOT NULL,
-		status TEXT NOT NULL DEFAULT 'active',
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	CREATE INDEX IF NOT EXISTS idx_cert_serial ON client_cert_bindings(cert_serial);
-	CREATE INDEX IF NOT EXISTS idx_cert_fingerprint ON client_cert_bindings(cert_fingerprint_sha256);
-	CREATE INDEX IF NOT EXISTS idx_inn ON client_cert_bindings(inn);
-
-	CREATE TABLE IF NOT EXISTS enrollment_tokens (
-		token TEXT PRIMARY KEY,
-		inn TEXT NOT NULL,
-		expires_at DATETIME NOT NULL,
-		used BOOLEAN DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	CREATE INDEX IF NOT EXISTS idx_enrollment_inn ON enrollment_tokens(inn);
-
-	CREATE TABLE IF NOT EXISTS audit_events (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		action TEXT NOT NULL,
-		inn TEXT,
-		ip_address TEXT,
-		details TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-	CREATE INDEX IF NOT EXISTS idx_audit_inn ON audit_events(inn);
-	CREATE INDEX IF NOT EXISTS idx_audit_action ON audit_events(action);
-	`
-	_, err := s.db.Exec(query)
-	return err
-}
-
-type EnrollmentToken struct {
-	Token     string
-	INN       string
-	ExpiresAt time.Time
-	Used      bool
-	CreatedAt time.Time
-}
-
-func (s *Storage) CreateEnrollmentToken(ctx context.Context, inn string, ttl time.Duration) (string, error) {
-	// Generate random token (simplified for now, ideally use crypto/rand)
-	// In production, use a proper CSPRNG and sufficient length
-	// For this task, I'll use a UUID-like string or similar
-	// Since I don't have uuid package, I'll use a simple random string
-	// But wait, I can use crypto/rand
-	b := make([]byte, 16)
-	_, err := rand.Read(b)
-	if err != nil {
-		return "", err
+// LogAudit records an audit event. The actor (admin key name) is taken from ctx.
+// Each event is chained to the previous one, see audit_chain.go.
+func (s *Storage) LogAudit(ctx context.Context, action, inn, ip, details string) error {
+	e := &storage.AuditEvent{
+		Action:    action,
+		INN:       inn,
+		IPAddress: ip,
+		Details:   details,
+		Actor:     audit.ActorFromContext(ctx),
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
 	}
-	token := fmt.Sprintf("%x", b)
+	return s.appendAuditEvent(ctx, e)
+}
 
-	query := `
-		INSERT INTO enrollment_tokens (token, inn, expires_at)
-		VALUES (?, ?, ?)
-	`
-	expiresAt := time.Now().Add(ttl)
-	_, err = s.db.ExecContext(ctx, query, token, inn, expiresAt)
+func (s *Storage) GetAuditEvents(ctx context.Context, inn string) ([]*storage.AuditEvent, error) {
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events WHERE inn = ? ORDER BY created_at DESC`
+	rows, err := s.db.QueryContext(ctx, query, inn)
 	if err != nil {
-		return "", fmt.Errorf("failed to create enrollment token: %w", err)
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*storage.AuditEvent
+	for rows.Next() {
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		events = append(events, e)
 	}
-	return token, nil
+	return events, rows.Err()
 }
 
-func (s *Storage) ValidateAndConsumeEnrollmentToken(ctx context.Context, token, inn string) error {
-	tx, err := s.db.BeginTx(ctx, nil)
+const clientCertBindingColumns = `id, inn, cert_serial, cert_fingerprint_sha256, subject_cn, issued_at, expires_at, status, revoked_at, revocation_reason, hardware_fingerprint, replaces_id, created_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanClientCertBinding(row rowScanner) (*storage.ClientCertBinding, error) {
+	var b storage.ClientCertBinding
+	var revokedAt sql.NullTime
+	var replacesID sql.NullInt64
+	err := row.Scan(
+		&b.ID,
+		&b.INN,
+		&b.CertSerial,
+		&b.CertFingerprintSHA256,
+		&b.SubjectCN,
+		&b.IssuedAt,
+		&b.ExpiresAt,
+		&b.Status,
+		&revokedAt,
+		&b.RevocationReason,
+		&b.HardwareFingerprint,
+		&replacesID,
+		&b.CreatedAt,
+	)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer tx.Rollback()
+	if revokedAt.Valid {
+		b.RevokedAt = &revokedAt.Time
+	}
+	b.ReplacesID = replacesID.Int64
+	return &b, nil
+}
 
+func (s *Storage) SaveClientCertBinding(ctx context.Context, b *storage.ClientCertBinding) error {
 	query := `
-		SELECT inn, expires_at, used
-		FROM enrollment_tokens
-		WHERE token = ?
+		INSERT INTO client_cert_bindings (inn, cert_serial, cert_fingerprint_sha256, subject_cn, issued_at, expires_at, status, hardware_fingerprint)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	row := tx.QueryRowContext(ctx, query, token)
+	_, err := s.db.ExecContext(ctx, query, b.INN, b.CertSerial, b.CertFingerprintSHA256, b.SubjectCN, b.IssuedAt, b.ExpiresAt, b.Status, b.HardwareFingerprint)
+	if err != nil {
+		return fmt.Errorf("failed to save client cert binding: %w", err)
+	}
+	return nil
+}
 
-	var tInn string
-	var expiresAt time.Time
-	var used bool
-	err = row.Scan(&tInn, &expiresAt, &used)
+func (s *Storage) GetClientCertBinding(ctx context.Context, fingerprint string) (*storage.ClientCertBinding, error) {
+	query := `SELECT ` + clientCertBindingColumns + ` FROM client_cert_bindings WHERE cert_fingerprint_sha256 = ?`
+	b, err := scanClientCertBinding(s.db.QueryRowContext(ctx, query, fingerprint))
 	if err == sql.ErrNoRows {
-		return fmt.Errorf("invalid enrollment token")
+		return nil, nil
 	}
 	if err != nil {
-		return fmt.Errorf("database error: %w", err)
+		return nil, fmt.Errorf("failed to scan binding: %w", err)
 	}
+	return b, nil
+}
 
-	if used {
-		return fmt.Errorf("enrollment token already used")
-	}
-	if time.Now().After(expiresAt) {
-		return fmt.Errorf("enrollment token expired")
-	}
-	if tInn != inn {
-		return fmt.Errorf("enrollment token does not match INN")
+// GetClientCertBindingBySerial looks up a binding by the certificate serial number (decimal)
+func (s *Storage) GetClientCertBindingBySerial(ctx context.Context, serial string) (*storage.ClientCertBinding, error) {
+	query := `SELECT ` + clientCertBindingColumns + ` FROM client_cert_bindings WHERE cert_serial = ?`
+	b, err := scanClientCertBinding(s.db.QueryRowContext(ctx, query, serial))
+	if err == sql.ErrNoRows {
+		return nil, nil
 	}
-
-	// Consume token
-	updateQuery := `UPDATE enrollment_tokens SET used = 1 WHERE token = ?`
-	_, err = tx.ExecContext(ctx, updateQuery, token)
 	if err != nil {
-		return fmt.Errorf("failed to consume token: %w", err)
+		return nil, fmt.Errorf("failed to scan binding: %w", err)
 	}
-
-	return tx.Commit()
-}
-
-func (s *Storage) LogAudit(ctx context.Context, action, inn, ip, details string) error {
-	query := `INSERT INTO audit_events (action, inn, ip_address, details) VALUES (?, ?, ?, ?)`
-	_, err := s.db.ExecContext(ctx, query, action, inn, ip, details)
-	return err
+	return b, nil
 }
 
-type AuditEvent struct {
-	ID        int64
-	Action    string
-	INN       string
-	IPAddress string
-	Details   string
-	CreatedAt time.Time
+// RevokeClientCertBinding marks a binding as revoked and records when and why
+func (s *Storage) RevokeClientCertBinding(ctx context.Context, id int64, reason string) error {
+	query := `UPDATE client_cert_bindings SET status = 'revoked', revoked_at = ?, revocation_reason = ? WHERE id = ?`
+	res, err := s.db.ExecContext(ctx, query, time.Now(), reason, id)
+	if err != nil {
+		return fmt.Errorf("failed to revoke client cert binding: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.NotFoundf("client cert binding %d not found", id)
+	}
+	return nil
 }
 
-func (s *Storage) GetAuditEvents(ctx context.Context, inn string) ([]*AuditEvent, error) {
-	query := `SELECT id, action, inn, ip_address, details, created_at FROM audit_events WHERE inn = ? ORDER BY created_at DESC`
+// GetClientCertBindingsByINN returns every binding of a license, newest first
+func (s *Storage) GetClientCertBindingsByINN(ctx context.Context, inn string) ([]*storage.ClientCertBinding, error) {
+	query := `SELECT ` + clientCertBindingColumns + ` FROM client_cert_bindings WHERE inn = ? ORDER BY id DESC`
 	rows, err := s.db.QueryContext(ctx, query, inn)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query audit events: %w", err)
+		return nil, fmt.Errorf("failed to query bindings: %w", err)
 	}
 	defer rows.Close()
 
-	var events []*AuditEvent
+	var bindings []*storage.ClientCertBinding
 	for rows.Next() {
-		var e AuditEvent
-		if err := rows.Scan(&e.ID, &e.Action, &e.INN, &e.IPAddress, &e.Details, &e.CreatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		b, err := scanClientCertBinding(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan binding: %w", err)
 		}
-		events = append(events, &e)
+		bindings = append(bindings, b)
 	}
-	return events, rows.Err()
+	return bindings, rows.Err()
 }
 
-type ClientCertBinding struct {
-	ID                    int64
-	INN                   string
-	CertSerial            string
-	CertFingerprintSHA256 string
-	SubjectCN             string
-	IssuedAt              time.Time
-	ExpiresAt             time.Time
-	Status                string
-	CreatedAt             time.Time
+// SuspendClientCertBinding puts an active binding on hold (CRL reason certificate_hold)
+func (s *Storage) SuspendClientCertBinding(ctx context.Context, id int64) error {
+	query := `UPDATE client_cert_bindings SET status = 'suspended', revoked_at = ?, revocation_reason = 'certificate_hold' WHERE id = ? AND status = 'active'`
+	res, err := s.db.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to suspend client cert binding: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.Conflictf("client cert binding %d is not active", id)
+	}
+	return nil
 }
 
-func (s *Storage) SaveClientCertBinding(ctx context.Context, b *ClientCertBinding) error {
-	query := `
-		INSERT INTO client_cert_bindings (inn, cert_serial, cert_fingerprint_sha256, subject_cn, issued_at, expires_at, status)
-		VALUES (?, ?, ?, ?, ?, ?, ?)
-	`
-	_, err := s.db.ExecContext(ctx, query, b.INN, b.CertSerial, b.CertFingerprintSHA256, b.SubjectCN, b.IssuedAt, b.ExpiresAt, b.Status)
+// ReactivateClientCertBinding takes a suspended binding off hold
+func (s *Storage) ReactivateClientCertBinding(ctx context.Context, id int64) error {
+	query := `UPDATE client_cert_bindings SET status = 'active', revoked_at = NULL, revocation_reason = '' WHERE id = ? AND status = 'suspended'`
+	res, err := s.db.ExecContext(ctx, query, id)
 	if err != nil {
-		return fmt.Errorf("failed to save client cert binding: %w", err)
+		return fmt.Errorf("failed to reactivate client cert binding: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.Conflictf("client cert binding %d is not suspended", id)
 	}
 	return nil
 }
 
-func (s *Storage) GetClientCertBinding(ctx context.Context, fingerprint string) (*ClientCertBinding, error) {
+// ReplaceClientCertBinding saves the binding of a renewed certificate. The old binding must
+// be active and stays so until the new certificate is first used (see ConfirmClientCertBinding),
+// so an instance that never received the renewed certificate keeps working with its old one.
+func (s *Storage) ReplaceClientCertBinding(ctx context.Context, oldID int64, b *storage.ClientCertBinding) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM client_cert_bindings WHERE id = ?`, oldID).Scan(&status)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check client cert binding: %w", err)
+	}
+	if status != "active" {
+		return storage.Conflictf("client cert binding %d is not active", oldID)
+	}
+
 	query := `
-		SELECT id, inn, cert_serial, cert_fingerprint_sha256, subject_cn, issued_at, expires_at, status, created_at
-		FROM client_cert_bindings
-		WHERE cert_fingerprint_sha256 = ?
+		INSERT INTO client_cert_bindings (inn, cert_serial, cert_fingerprint_sha256, subject_cn, issued_at, expires_at, status, hardware_fingerprint, replaces_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
 	`
-	row := s.db.QueryRowContext(ctx, query, fingerprint)
+	if _, err := tx.ExecContext(ctx, query, b.INN, b.CertSerial, b.CertFingerprintSHA256, b.SubjectCN, b.IssuedAt, b.ExpiresAt, b.Status, b.HardwareFingerprint, oldID); err != nil {
+		return fmt.Errorf("failed to save client cert binding: %w", err)
+	}
 
-	var b ClientCertBinding
-	err := row.Scan(
-		&b.ID,
-		&b.INN,
-		&b.CertSerial,
-		&b.CertFingerprintSHA256,
-		&b.SubjectCN,
-		&b.IssuedAt,
-		&b.ExpiresAt,
-		&b.Status,
-		&b.CreatedAt,
-	)
+	return tx.Commit()
+}
+
+// ConfirmClientCertBinding completes a renewal once its certificate is first used: the replaced
+// binding and any other renewal of it that was never picked up are marked as superseded.
+// Bindings that replace nothing are left as they are.
+func (s *Storage) ConfirmClientCertBinding(ctx context.Context, id int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var replacesID sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT replaces_id FROM client_cert_bindings WHERE id = ?`, id).Scan(&replacesID)
 	if err == sql.ErrNoRows {
-		return nil, nil
+		return storage.NotFoundf("client cert binding %d not found", id)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to scan binding: %w", err)
+		return fmt.Errorf("failed to check client cert binding: %w", err)
 	}
-	return &b, nil
+	if !replacesID.Valid {
+		return nil
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE client_cert_bindings SET status = 'superseded', revoked_at = ?, revocation_reason = 'superseded'
+		 WHERE (id = ? OR (replaces_id = ? AND id != ?)) AND status = 'active'`,
+		time.Now(), replacesID.Int64, replacesID.Int64, id)
+	if err != nil {
+		return fmt.Errorf("failed to supersede client cert binding: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE client_cert_bindings SET replaces_id = NULL WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to confirm client cert binding: %w", err)
+	}
+
+	return tx.Commit()
 }
 
-func (s *Storage) GetLicenseByINN(ctx context.Context, inn string) (*License, error) {
-	query := `
-		SELECT id, inn, organization, max_slots, used_slots, status, expires_at, created_at
-		FROM licenses
-		WHERE inn = ?
-	`
-	row := s.db.QueryRowContext(ctx, query, inn)
+// GetRevokedClientCertBindings returns all bindings that are no longer active
+// and whose certificates have not yet expired (i.e. the CRL contents)
+func (s *Storage) GetRevokedClientCertBindings(ctx context.Context) ([]*storage.ClientCertBinding, error) {
+	query := `SELECT ` + clientCertBindingColumns + ` FROM client_cert_bindings WHERE status != 'active' ORDER BY id`
+	rows, err := s.db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query revoked bindings: %w", err)
+	}
+	defer rows.Close()
 
-	var l License
+	now := time.Now()
+	var bindings []*storage.ClientCertBinding
+	for rows.Next() {
+		b, err := scanClientCertBinding(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan binding: %w", err)
+		}
+		// Expired certificates are rejected by chain verification anyway
+		if b.ExpiresAt.Before(now) {
+			continue
+		}
+		bindings = append(bindings, b)
+	}
+	return bindings, rows.Err()
+}
+
+const licenseColumns = `id, inn, organization, max_slots, used_slots, status, expires_at, is_trial, grace_days, partner_id, created_at`
+
+func scanLicense(row rowScanner) (*storage.License, error) {
+	var l storage.License
+	var partnerID sql.NullInt64
 	err := row.Scan(
 		&l.ID,
 		&l.INN,
@@ -282,113 +328,311 @@ func (s *Storage) GetLicenseByINN(ctx context.Context, inn string) (*License, er
 		&l.UsedSlots,
 		&l.Status,
 		&l.ExpiresAt,
+		&l.IsTrial,
+		&l.GraceDays,
+		&partnerID,
 		&l.CreatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+	if partnerID.Valid {
+		l.PartnerID = &partnerID.Int64
+	}
+	l.RemainingSlots = l.MaxSlots - l.UsedSlots
+	if l.RemainingSlots < 0 {
+		l.RemainingSlots = 0
+	}
+	return &l, nil
+}
+
+func (s *Storage) GetLicenseByINN(ctx context.Context, inn string) (*storage.License, error) {
+	query := `SELECT ` + licenseColumns + ` FROM licenses WHERE inn = ?`
+	l, err := scanLicense(s.db.QueryRowContext(ctx, query, inn))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to scan license: %w", err)
 	}
-
-	l.RemainingSlots = l.MaxSlots - l.UsedSlots
-	return &l, nil
+	return l, nil
 }
 
-func (s *Storage) GetAllLicenses(ctx context.Context) ([]*License, error) {
-	query := `
-		SELECT id, inn, organization, max_slots, used_slots, status, expires_at, created_at
-		FROM licenses
-		ORDER BY created_at DESC
-	`
+func (s *Storage) GetAllLicenses(ctx context.Context) ([]*storage.License, error) {
+	query := `SELECT ` + licenseColumns + ` FROM licenses ORDER BY created_at DESC`
 	rows, err := s.db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query licenses: %w", err)
 	}
 	defer rows.Close()
 
-	var licenses []*License
+	var licenses []*storage.License
 	for rows.Next() {
-		var l License
-		if err := rows.Scan(
-			&l.ID, &l.INN, &l.Organization, &l.MaxSlots, &l.UsedSlots,
-			&l.Status, &l.ExpiresAt, &l.CreatedAt,
-		); err != nil {
+		l, err := scanLicense(rows)
+		if err != nil {
 			return nil, fmt.Errorf("failed to scan license: %w", err)
 		}
-		l.RemainingSlots = l.MaxSlots - l.UsedSlots
-		licenses = append(licenses, &l)
+		licenses = append(licenses, l)
 	}
 	return licenses, rows.Err()
 }
 
+// UpdateLicenseStatus sets the status of a license. Revoking a partner's license returns
+// its slots to the partner's pool, so bringing it back takes them from the pool again.
 func (s *Storage) UpdateLicenseStatus(ctx context.Context, inn string, status string) error {
-	query := `UPDATE licenses SET status = ? WHERE inn = ?`
-	_, err := s.db.ExecContext(ctx, query, status, inn)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lic, err := lockLicense(ctx, tx, inn)
 	if err != nil {
+		return err
+	}
+	if lic != nil && lic.PartnerID != nil && lic.Status == "revoked" && status != "revoked" {
+		p, err := lockPartner(ctx, tx, *lic.PartnerID)
+		if err != nil {
+			return err
+		}
+		if err := storage.CheckSlotPool(p, lic.MaxSlots); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE licenses SET status = ? WHERE inn = ?`, status, inn); err != nil {
 		return fmt.Errorf("failed to update license status: %w", err)
 	}
-	return nil
+	return tx.Commit()
 }
 
-func (s *Storage) GetAllEnrollmentTokens(ctx context.Context) ([]*EnrollmentToken, error) {
-	query := `SELECT token, inn, expires_at, used, created_at FROM enrollment_tokens ORDER BY created_at DESC`
-	rows, err := s.db.QueryContext(ctx, query)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query tokens: %w", err)
+func (s *Storage) GetAllAuditEvents(ctx context.Context, limit int) ([]*storage.AuditEvent, error) {
+	return s.QueryAuditEvents(ctx, storage.AuditFilter{Limit: limit})
+}
+
+// auditTimeFormat matches how CURRENT_TIMESTAMP stores created_at, so range filters compare correctly
+const auditTimeFormat = "2006-01-02 15:04:05"
+
+// auditFilterQuery builds the SELECT for an audit filter
+func auditFilterQuery(f storage.AuditFilter) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	if f.INN != "" {
+		where = append(where, "inn = ?")
+		args = append(args, f.INN)
+	}
+	if f.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.IP != "" {
+		where = append(where, "ip_address = ?")
+		args = append(args, f.IP)
+	}
+	if f.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, f.Actor)
+	}
+	if !f.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.From.UTC().Format(auditTimeFormat))
+	}
+	if !f.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.To.UTC().Format(auditTimeFormat))
+	}
+	if f.Before > 0 {
+		where = append(where, "id < ?")
+		args = append(args, f.Before)
+	}
+	if f.After > 0 {
+		where = append(where, "id > ?")
+		args = append(args, f.After)
+	}
+	if f.PartnerID > 0 {
+		where = append(where, "inn IN (SELECT inn FROM licenses WHERE partner_id = ?)")
+		args = append(args, f.PartnerID)
 	}
-	defer rows.Close()
 
-	var tokens []*EnrollmentToken
-	for rows.Next() {
-		var t EnrollmentToken
-		if err := rows.Scan(&t.Token, &t.INN, &t.ExpiresAt, &t.Used, &t.CreatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan token: %w", err)
-		}
-		tokens = append(tokens, &t)
+	query := `SELECT ` + auditEventColumns + ` FROM audit_events`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	// IDs are monotonic, so ordering by ID gives newest first and a stable cursor
+	if f.OldestFirst {
+		query += ` ORDER BY id`
+	} else {
+		query += ` ORDER BY id DESC`
+	}
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
 	}
-	return tokens, rows.Err()
+	return query, args
 }
 
-func (s *Storage) GetAllAuditEvents(ctx context.Context, limit int) ([]*AuditEvent, error) {
-	query := `SELECT id, action, inn, ip_address, details, created_at FROM audit_events ORDER BY created_at DESC LIMIT ?`
-	rows, err := s.db.QueryContext(ctx, query, limit)
+// QueryAuditEvents returns matching audit events, newest first
+func (s *Storage) QueryAuditEvents(ctx context.Context, f storage.AuditFilter) ([]*storage.AuditEvent, error) {
+	var events []*storage.AuditEvent
+	err := s.StreamAuditEvents(ctx, f, func(e *storage.AuditEvent) error {
+		events = append(events, e)
+		return nil
+	})
+	return events, err
+}
+
+// StreamAuditEvents calls fn for every matching audit event, newest first, without
+// loading the whole result into memory. fn must not use the storage.
+func (s *Storage) StreamAuditEvents(ctx context.Context, f storage.AuditFilter, fn func(*storage.AuditEvent) error) error {
+	query, args := auditFilterQuery(f)
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to query audit events: %w", err)
+		return fmt.Errorf("failed to query audit events: %w", err)
 	}
 	defer rows.Close()
 
-	var events []*AuditEvent
 	for rows.Next() {
-		var e AuditEvent
-		if err := rows.Scan(&e.ID, &e.Action, &e.INN, &e.IPAddress, &e.Details, &e.CreatedAt); err != nil {
-			return nil, fmt.Errorf("failed to scan audit event: %w", err)
+		e, err := scanAuditEvent(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if err := fn(e); err != nil {
+			return err
 		}
-		events = append(events, &e)
 	}
-	return events, rows.Err()
+	return rows.Err()
 }
 
-// CreateLicense adds a new license (helper for seeding/admin)
+// CreateLicense adds a new one-year license (helper for seeding/admin)
 func (s *Storage) CreateLicense(ctx context.Context, inn, org string, maxSlots int) error {
+	return s.CreateLicenseWithTerm(ctx, inn, org, maxSlots, time.Now().AddDate(1, 0, 0), false, 0)
+}
+
+// CreateLicenseWithTerm adds a new license with an explicit expiry, trial flag and grace period
+func (s *Storage) CreateLicenseWithTerm(ctx context.Context, inn, org string, maxSlots int, expiresAt time.Time, isTrial bool, graceDays int) error {
 	query := `
-		INSERT INTO licenses (inn, organization, max_slots, status, expires_at)
-		VALUES (?, ?, ?, 'active', datetime('now', '+1 year'))
+		INSERT INTO licenses (inn, organization, max_slots, status, expires_at, is_trial, grace_days)
+		VALUES (?, ?, ?, 'active', ?, ?, ?)
 		ON CONFLICT(inn) DO NOTHING;
 	`
-	_, err := s.db.ExecContext(ctx, query, inn, org, maxSlots)
+	_, err := s.db.ExecContext(ctx, query, inn, org, maxSlots, expiresAt, isTrial, graceDays)
 	if err != nil {
 		return fmt.Errorf("failed to create license: %w", err)
 	}
 	return nil
 }
 
-// UpdateLicenseDetails updates the organization and max slots of a license
+// UpdateLicenseTerm sets the expiry, trial flag and grace period of a license
+func (s *Storage) UpdateLicenseTerm(ctx context.Context, inn string, expiresAt time.Time, isTrial bool, graceDays int) error {
+	query := `UPDATE licenses SET expires_at = ?, is_trial = ?, grace_days = ? WHERE inn = ?`
+	res, err := s.db.ExecContext(ctx, query, expiresAt, isTrial, graceDays, inn)
+	if err != nil {
+		return fmt.Errorf("failed to update license term: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return storage.NotFoundf("license not found for INN %s", inn)
+	}
+	return nil
+}
+
+// SaveInstanceUsage stores the agent count reported by a licd instance
+func (s *Storage) SaveInstanceUsage(ctx context.Context, inn, instanceID string, usedSlots int) error {
+	query := `
+		INSERT INTO instance_usage (inn, instance_id, used_slots, reported_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(inn, instance_id) DO UPDATE SET
+			used_slots = excluded.used_slots,
+			reported_at = excluded.reported_at
+	`
+	_, err := s.db.ExecContext(ctx, query, inn, instanceID, usedSlots, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to save instance usage: %w", err)
+	}
+	return nil
+}
+
+// DeleteInstanceUsage removes the usage report of one instance, e.g. of a renewed certificate
+func (s *Storage) DeleteInstanceUsage(ctx context.Context, inn, instanceID string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM instance_usage WHERE inn = ? AND instance_id = ?`, inn, instanceID); err != nil {
+		return fmt.Errorf("failed to delete instance usage: %w", err)
+	}
+	return nil
+}
+
+// GetInstanceUsage returns the usage reports of all instances of a license, most recent first
+func (s *Storage) GetInstanceUsage(ctx context.Context, inn string) ([]*storage.InstanceUsage, error) {
+	query := `
+		SELECT inn, instance_id, used_slots, reported_at
+		FROM instance_usage
+		WHERE inn = ?
+		ORDER BY reported_at DESC
+	`
+	rows, err := s.db.QueryContext(ctx, query, inn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query instance usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []*storage.InstanceUsage
+	for rows.Next() {
+		var u storage.InstanceUsage
+		if err := rows.Scan(&u.INN, &u.InstanceID, &u.UsedSlots, &u.ReportedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan instance usage: %w", err)
+		}
+		usage = append(usage, &u)
+	}
+	return usage, rows.Err()
+}
+
+// RecalculateUsedSlots sums the usage of instances that reported since activeSince
+// and stores the result in licenses.used_slots
+func (s *Storage) RecalculateUsedSlots(ctx context.Context, inn string, activeSince time.Time) (int, error) {
+	usage, err := s.GetInstanceUsage(ctx, inn)
+	if err != nil {
+		return 0, err
+	}
+
+	// Filtered in Go: reported_at is compared as a time, not as a string
+	used := 0
+	for _, u := range usage {
+		if !u.ReportedAt.Before(activeSince) {
+			used += u.UsedSlots
+		}
+	}
+
+	// Written only when the total changed, so heartbeats do not rewrite the license row each time
+	if _, err := s.db.ExecContext(ctx, `UPDATE licenses SET used_slots = ? WHERE inn = ? AND used_slots != ?`, used, inn, used); err != nil {
+		return 0, fmt.Errorf("failed to update used slots: %w", err)
+	}
+	return used, nil
+}
+
+// UpdateLicenseDetails updates the organization and max slots of a license.
+// More slots for a partner's license come out of the partner's pool.
 func (s *Storage) UpdateLicenseDetails(ctx context.Context, inn, org string, maxSlots int) error {
-	query := `UPDATE licenses SET organization = ?, max_slots = ? WHERE inn = ?`
-	_, err := s.db.ExecContext(ctx, query, org, maxSlots, inn)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	lic, err := lockLicense(ctx, tx, inn)
 	if err != nil {
+		return err
+	}
+	if lic != nil && lic.PartnerID != nil && lic.Status != "revoked" {
+		p, err := lockPartner(ctx, tx, *lic.PartnerID)
+		if err != nil {
+			return err
+		}
+		if err := storage.CheckSlotPool(p, maxSlots-lic.MaxSlots); err != nil {
+			return err
+		}
+	}
+
+	query := `UPDATE licenses SET organization = ?, max_slots = ? WHERE inn = ?`
+	if _, err := tx.ExecContext(ctx, query, org, maxSlots, inn); err != nil {
 		return fmt.Errorf("failed to update license details: %w", err)
 	}
-	return nil
+	return tx.Commit()
 }
@@ -0,0 +1,20 @@
+package sqlite_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/deymonster/lic-server/internal/storage/sqlite"
+	"github.com/deymonster/lic-server/internal/storage/storagetest"
+)
+
+func TestStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storagetest.Store {
+		s, err := sqlite.NewStorage(filepath.Join(t.TempDir(), "lic.db"))
+		if err != nil {
+			t.Fatalf("Failed to create storage: %v", err)
+		}
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
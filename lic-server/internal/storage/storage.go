@@ -0,0 +1,78 @@
+// Package storage holds the records and refusal errors shared by the storage backends.
+//
+// The sqlite and postgres packages both read and write these types, and the license
+// service and API depend only on them, so neither backend has to import the other.
+package storage
+
+import "time"
+
+// License is a customer license identified by the INN
+type License struct {
+	ID             int64
+	INN            string
+	Organization   string
+	MaxSlots       int
+	UsedSlots      int
+	RemainingSlots int
+	Status         string
+	ExpiresAt      time.Time
+	IsTrial        bool
+	GraceDays      int
+	TermStatus     string // computed by the license service: active, trial, grace, expired
+	PartnerID      *int64 // reseller that manages the license, nil for the operator's own
+	CreatedAt      time.Time
+}
+
+// InstanceUsage is the last agent count reported by one licd instance of a license
+type InstanceUsage struct {
+	INN        string
+	InstanceID string // serial of the instance's client certificate
+	UsedSlots  int
+	ReportedAt time.Time
+}
+
+// AuditEvent is one entry of the append-only audit log
+type AuditEvent struct {
+	ID        int64
+	Action    string
+	INN       string
+	IPAddress string
+	Details   string
+	Actor     string // admin key that triggered the event, empty for licd requests
+	CreatedAt time.Time
+	PrevHash  string // hash of the preceding event, empty for the first one
+	Hash      string // hash over this event's contents and PrevHash
+}
+
+// ClientCertBinding ties a licd client certificate to a license
+type ClientCertBinding struct {
+	ID                    int64
+	INN                   string
+	CertSerial            string
+	CertFingerprintSHA256 string
+	SubjectCN             string
+	IssuedAt              time.Time
+	ExpiresAt             time.Time
+	Status                string
+	RevokedAt             *time.Time
+	RevocationReason      string
+	HardwareFingerprint   string // machine the instance is bound to, empty until its first activation
+	ReplacesID            int64  // binding this renewal supersedes once the new certificate is first used, 0 if none
+	CreatedAt             time.Time
+}
+
+// AuditFilter selects audit events. Zero values mean "no filter".
+type AuditFilter struct {
+	INN    string
+	Action string
+	IP     string
+	Actor  string
+	From   time.Time // inclusive
+	To     time.Time // exclusive
+	Before int64     // cursor: only events with a smaller ID
+	After  int64     // cursor: only events with a larger ID
+	Limit  int       // 0 means no limit
+
+	PartnerID   int64 // only events of the licenses of this partner
+	OldestFirst bool  // order by ascending ID instead of newest first
+}
@@ -0,0 +1,986 @@
+// Package storagetest is a conformance suite for storage backends.
+//
+// Every backend runs the same tests, so the license service behaves the same
+// whichever database it is configured with.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/deymonster/lic-server/internal/core/license"
+	"github.com/deymonster/lic-server/internal/core/webhook"
+	"github.com/deymonster/lic-server/internal/storage"
+)
+
+// Store is the storage a backend has to provide
+type Store interface {
+	license.Repository
+	webhook.Store
+}
+
+// Run runs the suite. newStore must return an empty store that is closed when the test ends.
+func Run(t *testing.T, newStore func(t *testing.T) Store) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Store)
+	}{
+		{"Licenses", testLicenses},
+		{"EnrollmentTokens", testEnrollmentTokens},
+		{"ClientCertBindings", testClientCertBindings},
+		{"CertificateHold", testCertificateHold},
+		{"InstanceUsage", testInstanceUsage},
+		{"AuditLog", testAuditLog},
+		{"AuditChain", testAuditChain},
+		{"AdminAPIKeys", testAdminAPIKeys},
+		{"OfflineActivations", testOfflineActivations},
+		{"Webhooks", testWebhooks},
+		{"WebhookQueue", testWebhookQueue},
+		{"IssuedTokens", testIssuedTokens},
+		{"Entitlements", testEntitlements},
+		{"HardwareTransfers", testHardwareTransfers},
+		{"Partners", testPartners},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStore(t))
+		})
+	}
+}
+
+func testLicenses(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	if lic, err := s.GetLicenseByINN(ctx, "0000000000"); err != nil || lic != nil {
+		t.Fatalf("Expected nil for an unknown INN, got %+v, %v", lic, err)
+	}
+
+	expiresAt := time.Now().Add(30 * 24 * time.Hour).Truncate(time.Second)
+	if err := s.CreateLicenseWithTerm(ctx, "1111111111", "Trial Org", 5, expiresAt, true, 7); err != nil {
+		t.Fatalf("CreateLicenseWithTerm failed: %v", err)
+	}
+	if err := s.CreateLicense(ctx, "2222222222", "Full Org", 50); err != nil {
+		t.Fatalf("CreateLicense failed: %v", err)
+	}
+	// Creating an existing license is a no-op
+	if err := s.CreateLicense(ctx, "1111111111", "Other Org", 1); err != nil {
+		t.Fatalf("Duplicate CreateLicense failed: %v", err)
+	}
+
+	lic, err := s.GetLicenseByINN(ctx, "1111111111")
+	if err != nil || lic == nil {
+		t.Fatalf("GetLicenseByINN failed: %+v, %v", lic, err)
+	}
+	if lic.Organization != "Trial Org" || lic.MaxSlots != 5 || lic.RemainingSlots != 5 || lic.Status != "active" ||
+		!lic.IsTrial || lic.GraceDays != 7 || !lic.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Unexpected license: %+v", lic)
+	}
+
+	newExpiry := expiresAt.AddDate(1, 0, 0)
+	if err := s.UpdateLicenseTerm(ctx, "1111111111", newExpiry, false, 0); err != nil {
+		t.Fatalf("UpdateLicenseTerm failed: %v", err)
+	}
+	if err := s.UpdateLicenseTerm(ctx, "0000000000", newExpiry, false, 0); err == nil {
+		t.Error("Expected UpdateLicenseTerm to fail for an unknown INN")
+	}
+	if err := s.UpdateLicenseDetails(ctx, "1111111111", "Renamed Org", 15); err != nil {
+		t.Fatalf("UpdateLicenseDetails failed: %v", err)
+	}
+	if err := s.UpdateLicenseStatus(ctx, "1111111111", "suspended"); err != nil {
+		t.Fatalf("UpdateLicenseStatus failed: %v", err)
+	}
+	lic, _ = s.GetLicenseByINN(ctx, "1111111111")
+	if lic.Organization != "Renamed Org" || lic.MaxSlots != 15 || lic.Status != "suspended" || lic.IsTrial || !lic.ExpiresAt.Equal(newExpiry) {
+		t.Errorf("Updates not applied: %+v", lic)
+	}
+
+	all, err := s.GetAllLicenses(ctx)
+	if err != nil || len(all) != 2 {
+		t.Fatalf("Expected 2 licenses, got %d, %v", len(all), err)
+	}
+}
+
+func testEnrollmentTokens(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	token, err := s.CreateEnrollmentToken(ctx, "hash-1", "lse_1", "1111111111", 1, time.Now().Add(time.Hour), "admin")
+	if err != nil || token == nil || token.ID == 0 || token.TokenPrefix != "lse_1" || token.CreatedBy != "admin" {
+		t.Fatalf("CreateEnrollmentToken failed: %+v, %v", token, err)
+	}
+	if _, err := s.CreateEnrollmentToken(ctx, "hash-1", "lse_1", "1111111111", 1, time.Now().Add(time.Hour), "admin"); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected a duplicate hash to be refused, got %v", err)
+	}
+	_, _ = s.CreateEnrollmentToken(ctx, "hash-expired", "", "1111111111", 1, time.Now().Add(-time.Hour), "admin")
+	fleet, _ := s.CreateEnrollmentToken(ctx, "hash-fleet", "", "1111111111", 2, time.Now().Add(time.Hour), "admin")
+	static, _ := s.CreateEnrollmentToken(ctx, "hash-static", "", "", 0, time.Now().Add(time.Hour), "config")
+	revoked, _ := s.CreateEnrollmentToken(ctx, "hash-revoked", "", "1111111111", 5, time.Now().Add(time.Hour), "admin")
+	if err := s.RevokeEnrollmentToken(ctx, revoked.ID); err != nil {
+		t.Fatalf("RevokeEnrollmentToken failed: %v", err)
+	}
+	if err := s.RevokeEnrollmentToken(ctx, revoked.ID); err == nil {
+		t.Error("Expected revoking twice to fail")
+	}
+
+	cases := []struct {
+		hash, inn, errPart string
+	}{
+		{"unknown", "1111111111", "invalid"},
+		{"hash-1", "2222222222", "does not match"},
+		{"hash-expired", "1111111111", "expired"},
+		{"hash-revoked", "1111111111", "revoked"},
+		{"hash-1", "1111111111", ""},
+		{"hash-1", "1111111111", "already used"},
+		{"hash-fleet", "1111111111", ""},
+		{"hash-fleet", "1111111111", ""},
+		{"hash-fleet", "1111111111", "already used"},
+		{"hash-static", "1111111111", ""},
+		{"hash-static", "2222222222", ""},
+	}
+	for _, c := range cases {
+		consumed, err := s.ConsumeEnrollmentToken(ctx, c.hash, c.inn)
+		if c.errPart == "" && (err != nil || consumed == nil) {
+			t.Errorf("Expected %s/%s to be accepted, got %v", c.hash, c.inn, err)
+		}
+		if c.errPart != "" && (err == nil || !strings.Contains(err.Error(), c.errPart)) {
+			t.Errorf("Expected %q error for %s/%s, got %v", c.errPart, c.hash, c.inn, err)
+		}
+	}
+
+	got, err := s.GetEnrollmentToken(ctx, fleet.ID)
+	if err != nil || got.UseCount != 2 || got.MaxUses != 2 {
+		t.Errorf("Expected the fleet token to be used 2 of 2 times, got %+v, %v", got, err)
+	}
+	got, err = s.GetEnrollmentTokenByHash(ctx, "hash-static")
+	if err != nil || got == nil || got.ID != static.ID || got.UseCount != 2 {
+		t.Errorf("GetEnrollmentTokenByHash: %+v, %v", got, err)
+	}
+	if got, err := s.GetEnrollmentTokenByHash(ctx, "unknown"); got != nil || err != nil {
+		t.Errorf("Expected nil for an unknown hash, got %+v, %v", got, err)
+	}
+
+	expiresAt := time.Now().Add(48 * time.Hour).Truncate(time.Second)
+	if err := s.UpdateEnrollmentTokenLimits(ctx, static.ID, 10, expiresAt); err != nil {
+		t.Fatalf("UpdateEnrollmentTokenLimits failed: %v", err)
+	}
+	got, _ = s.GetEnrollmentToken(ctx, static.ID)
+	if got.MaxUses != 10 || !got.ExpiresAt.Equal(expiresAt) {
+		t.Errorf("Expected updated limits, got %+v", got)
+	}
+
+	if err := s.RecordEnrollmentTokenUse(ctx, &storage.EnrollmentTokenUse{TokenID: fleet.ID, INN: "1111111111", CertSerial: "42", IPAddress: "10.0.0.1"}); err != nil {
+		t.Fatalf("RecordEnrollmentTokenUse failed: %v", err)
+	}
+	uses, err := s.GetEnrollmentTokenUses(ctx, fleet.ID)
+	if err != nil || len(uses) != 1 || uses[0].CertSerial != "42" || uses[0].IPAddress != "10.0.0.1" {
+		t.Errorf("GetEnrollmentTokenUses: %+v, %v", uses, err)
+	}
+
+	all, err := s.GetAllEnrollmentTokens(ctx)
+	if err != nil || len(all) != 5 {
+		t.Fatalf("Expected 5 tokens, got %d, %v", len(all), err)
+	}
+	byINN, err := s.GetEnrollmentTokensByINN(ctx, "1111111111")
+	if err != nil || len(byINN) != 4 {
+		t.Fatalf("Expected 4 tokens for the INN, got %d, %v", len(byINN), err)
+	}
+	for _, tok := range byINN {
+		if tok.ID == revoked.ID && tok.RevokedAt == nil {
+			t.Error("Expected the revoked token to have revoked_at set")
+		}
+	}
+}
+
+func newBinding(inn, serial string, expiresAt time.Time) *storage.ClientCertBinding {
+	return &storage.ClientCertBinding{
+		INN:                   inn,
+		CertSerial:            serial,
+		CertFingerprintSHA256: "fp-" + serial,
+		SubjectCN:             inn,
+		IssuedAt:              time.Now().Truncate(time.Second),
+		ExpiresAt:             expiresAt.Truncate(time.Second),
+		Status:                "active",
+	}
+}
+
+func testClientCertBindings(t *testing.T, s Store) {
+	ctx := context.Background()
+	inAYear := time.Now().AddDate(1, 0, 0)
+
+	if err := s.SaveClientCertBinding(ctx, newBinding("1111111111", "1001", inAYear)); err != nil {
+		t.Fatalf("SaveClientCertBinding failed: %v", err)
+	}
+	b, err := s.GetClientCertBinding(ctx, "fp-1001")
+	if err != nil || b == nil || b.INN != "1111111111" || b.Status != "active" || b.RevokedAt != nil {
+		t.Fatalf("Unexpected binding: %+v, %v", b, err)
+	}
+	if b2, err := s.GetClientCertBindingBySerial(ctx, "1001"); err != nil || b2 == nil || b2.ID != b.ID {
+		t.Errorf("GetClientCertBindingBySerial failed: %+v, %v", b2, err)
+	}
+	if b2, err := s.GetClientCertBinding(ctx, "fp-unknown"); err != nil || b2 != nil {
+		t.Errorf("Expected nil for an unknown fingerprint, got %+v, %v", b2, err)
+	}
+
+	// A renewal whose certificate never arrived: the old binding stays active
+	if err := s.ReplaceClientCertBinding(ctx, b.ID, newBinding("1111111111", "1002", inAYear)); err != nil {
+		t.Fatalf("ReplaceClientCertBinding failed: %v", err)
+	}
+	if old, _ := s.GetClientCertBinding(ctx, "fp-1001"); old.Status != "active" {
+		t.Errorf("Expected the old binding to stay active until the renewal is used, got %+v", old)
+	}
+	if err := s.ReplaceClientCertBinding(ctx, b.ID, newBinding("1111111111", "1003", inAYear)); err != nil {
+		t.Fatalf("Retrying the renewal failed: %v", err)
+	}
+
+	// The first use of the retried renewal supersedes the old binding and the lost renewal
+	retried, _ := s.GetClientCertBinding(ctx, "fp-1003")
+	if retried == nil || retried.ReplacesID != b.ID {
+		t.Fatalf("Expected the renewal to replace binding %d, got %+v", b.ID, retried)
+	}
+	if err := s.ConfirmClientCertBinding(ctx, retried.ID); err != nil {
+		t.Fatalf("ConfirmClientCertBinding failed: %v", err)
+	}
+	if err := s.ConfirmClientCertBinding(ctx, retried.ID); err != nil {
+		t.Errorf("Confirming a confirmed binding must be a no-op: %v", err)
+	}
+	for _, fp := range []string{"fp-1001", "fp-1002"} {
+		old, _ := s.GetClientCertBinding(ctx, fp)
+		if old.Status != "superseded" || old.RevokedAt == nil || old.RevocationReason != "superseded" {
+			t.Errorf("Expected %s to be superseded, got %+v", fp, old)
+		}
+	}
+	if confirmed, _ := s.GetClientCertBinding(ctx, "fp-1003"); confirmed.Status != "active" || confirmed.ReplacesID != 0 {
+		t.Errorf("Expected the confirmed binding to be active, got %+v", confirmed)
+	}
+	if err := s.ReplaceClientCertBinding(ctx, b.ID, newBinding("1111111111", "1009", inAYear)); err == nil {
+		t.Error("Expected replacing a superseded binding to fail")
+	}
+
+	current, _ := s.GetClientCertBinding(ctx, "fp-1003")
+	if err := s.RevokeClientCertBinding(ctx, current.ID, "key_compromise"); err != nil {
+		t.Fatalf("RevokeClientCertBinding failed: %v", err)
+	}
+	if err := s.RevokeClientCertBinding(ctx, 999999, "key_compromise"); err == nil {
+		t.Error("Expected revoking an unknown binding to fail")
+	}
+
+	// Revoked certificates that already expired are left out of the CRL
+	expiredBinding := newBinding("1111111111", "1004", time.Now().Add(-time.Hour))
+	_ = s.SaveClientCertBinding(ctx, expiredBinding)
+	expired, _ := s.GetClientCertBinding(ctx, "fp-1004")
+	_ = s.RevokeClientCertBinding(ctx, expired.ID, "superseded")
+
+	revoked, err := s.GetRevokedClientCertBindings(ctx)
+	if err != nil {
+		t.Fatalf("GetRevokedClientCertBindings failed: %v", err)
+	}
+	var serials []string
+	for _, r := range revoked {
+		serials = append(serials, r.CertSerial)
+	}
+	if strings.Join(serials, ",") != "1001,1002,1003" {
+		t.Errorf("Expected serials 1001,1002,1003 in the CRL, got %v", serials)
+	}
+}
+
+func testCertificateHold(t *testing.T, s Store) {
+	ctx := context.Background()
+	inAYear := time.Now().AddDate(1, 0, 0)
+	_ = s.SaveClientCertBinding(ctx, newBinding("1111111111", "3001", inAYear))
+	_ = s.SaveClientCertBinding(ctx, newBinding("1111111111", "3002", inAYear))
+	_ = s.SaveClientCertBinding(ctx, newBinding("2222222222", "3003", inAYear))
+
+	bindings, err := s.GetClientCertBindingsByINN(ctx, "1111111111")
+	if err != nil || len(bindings) != 2 || bindings[0].CertSerial != "3002" {
+		t.Fatalf("Expected 2 bindings, newest first, got %d, %v", len(bindings), err)
+	}
+	if none, err := s.GetClientCertBindingsByINN(ctx, "0000000000"); err != nil || len(none) != 0 {
+		t.Errorf("Expected no bindings for an unknown INN, got %d, %v", len(none), err)
+	}
+
+	b := bindings[1]
+	if err := s.ReactivateClientCertBinding(ctx, b.ID); err == nil {
+		t.Error("Expected reactivating an active binding to fail")
+	}
+	if err := s.SuspendClientCertBinding(ctx, b.ID); err != nil {
+		t.Fatalf("SuspendClientCertBinding failed: %v", err)
+	}
+	if err := s.SuspendClientCertBinding(ctx, b.ID); err == nil {
+		t.Error("Expected suspending a suspended binding to fail")
+	}
+	held, _ := s.GetClientCertBinding(ctx, "fp-3001")
+	if held.Status != "suspended" || held.RevokedAt == nil || held.RevocationReason != "certificate_hold" {
+		t.Errorf("Expected the binding on hold, got %+v", held)
+	}
+	if revoked, _ := s.GetRevokedClientCertBindings(ctx); len(revoked) != 1 || revoked[0].CertSerial != "3001" {
+		t.Errorf("Expected the suspended binding among the revoked ones, got %d", len(revoked))
+	}
+
+	if err := s.ReactivateClientCertBinding(ctx, b.ID); err != nil {
+		t.Fatalf("ReactivateClientCertBinding failed: %v", err)
+	}
+	active, _ := s.GetClientCertBinding(ctx, "fp-3001")
+	if active.Status != "active" || active.RevokedAt != nil || active.RevocationReason != "" {
+		t.Errorf("Expected the binding active again, got %+v", active)
+	}
+
+	_ = s.RevokeClientCertBinding(ctx, b.ID, "key_compromise")
+	if err := s.ReactivateClientCertBinding(ctx, b.ID); err == nil {
+		t.Error("Expected reactivating a revoked binding to fail")
+	}
+}
+
+func testInstanceUsage(t *testing.T, s Store) {
+	ctx := context.Background()
+	_ = s.CreateLicense(ctx, "1111111111", "Usage Org", 10)
+	start := time.Now().Add(-time.Minute)
+
+	_ = s.SaveInstanceUsage(ctx, "1111111111", "host-a", 3)
+	_ = s.SaveInstanceUsage(ctx, "1111111111", "host-b", 4)
+	if err := s.SaveInstanceUsage(ctx, "1111111111", "host-a", 2); err != nil {
+		t.Fatalf("SaveInstanceUsage failed: %v", err)
+	}
+
+	usage, err := s.GetInstanceUsage(ctx, "1111111111")
+	if err != nil || len(usage) != 2 {
+		t.Fatalf("Expected 2 instances, got %d, %v", len(usage), err)
+	}
+
+	used, err := s.RecalculateUsedSlots(ctx, "1111111111", start)
+	if err != nil || used != 6 {
+		t.Fatalf("Expected 6 used slots, got %d, %v", used, err)
+	}
+	lic, _ := s.GetLicenseByINN(ctx, "1111111111")
+	if lic.UsedSlots != 6 || lic.RemainingSlots != 4 {
+		t.Errorf("Expected used slots to be stored, got %+v", lic)
+	}
+
+	if err := s.DeleteInstanceUsage(ctx, "1111111111", "host-b"); err != nil {
+		t.Fatalf("DeleteInstanceUsage failed: %v", err)
+	}
+	if used, _ := s.RecalculateUsedSlots(ctx, "1111111111", start); used != 2 {
+		t.Errorf("Expected 2 used slots without host-b, got %d", used)
+	}
+
+	// Instances that stopped reporting no longer count
+	used, _ = s.RecalculateUsedSlots(ctx, "1111111111", time.Now().Add(time.Minute))
+	if used != 0 {
+		t.Errorf("Expected stale instances to be ignored, got %d", used)
+	}
+}
+
+func testAuditLog(t *testing.T, s Store) {
+	ctx := context.Background()
+	before := time.Now().Add(-time.Minute)
+
+	_ = s.LogAudit(ctx, "license_created", "1111111111", "10.0.0.1", "first")
+	_ = s.LogAudit(ctx, "activate_attempt", "1111111111", "10.0.0.2", "second")
+	_ = s.LogAudit(ctx, "license_created", "2222222222", "10.0.0.1", "third")
+
+	all, err := s.QueryAuditEvents(ctx, storage.AuditFilter{})
+	if err != nil || len(all) != 3 {
+		t.Fatalf("Expected 3 events, got %d, %v", len(all), err)
+	}
+	if all[0].Details != "third" || all[2].Details != "first" {
+		t.Errorf("Expected newest first, got %s..%s", all[0].Details, all[2].Details)
+	}
+
+	filters := []struct {
+		name   string
+		filter storage.AuditFilter
+		want   int
+	}{
+		{"inn", storage.AuditFilter{INN: "1111111111"}, 2},
+		{"action", storage.AuditFilter{Action: "license_created"}, 2},
+		{"ip", storage.AuditFilter{IP: "10.0.0.2"}, 1},
+		{"from", storage.AuditFilter{From: before}, 3},
+		{"to", storage.AuditFilter{To: before}, 0},
+		{"before", storage.AuditFilter{Before: all[0].ID}, 2},
+		{"after", storage.AuditFilter{After: all[2].ID}, 2},
+		{"limit", storage.AuditFilter{Limit: 1}, 1},
+	}
+	for _, f := range filters {
+		events, err := s.QueryAuditEvents(ctx, f.filter)
+		if err != nil || len(events) != f.want {
+			t.Errorf("Filter %s: expected %d events, got %d, %v", f.name, f.want, len(events), err)
+		}
+	}
+
+	oldest, _ := s.QueryAuditEvents(ctx, storage.AuditFilter{OldestFirst: true, Limit: 1})
+	if len(oldest) != 1 || oldest[0].Details != "first" {
+		t.Errorf("Expected the oldest event first, got %+v", oldest)
+	}
+
+	var streamed int
+	err = s.StreamAuditEvents(ctx, storage.AuditFilter{INN: "1111111111"}, func(*storage.AuditEvent) error {
+		streamed++
+		return nil
+	})
+	if err != nil || streamed != 2 {
+		t.Errorf("Expected 2 streamed events, got %d, %v", streamed, err)
+	}
+}
+
+func testAuditChain(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	status, err := s.VerifyAuditChain(ctx)
+	if err != nil || !status.Valid || status.Checked != 0 {
+		t.Fatalf("Expected an empty valid chain, got %+v, %v", status, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		_ = s.LogAudit(ctx, "activate_attempt", "1111111111", "10.0.0.1", "n")
+	}
+	status, err = s.VerifyAuditChain(ctx)
+	if err != nil || !status.Valid || status.Checked != 5 {
+		t.Fatalf("Expected a valid chain of 5 events, got %+v, %v", status, err)
+	}
+
+	hash, err := s.GetAuditEventHash(ctx, status.HeadID)
+	if err != nil || hash != status.HeadHash {
+		t.Errorf("Expected head hash %s, got %s, %v", status.HeadHash, hash, err)
+	}
+	if hash, err := s.GetAuditEventHash(ctx, 999999); err != nil || hash != "" {
+		t.Errorf("Expected no hash for an unknown event, got %q, %v", hash, err)
+	}
+
+	events, _ := s.QueryAuditEvents(ctx, storage.AuditFilter{OldestFirst: true})
+	for i, e := range events {
+		if i > 0 && e.PrevHash != events[i-1].Hash {
+			t.Errorf("Event %d is not linked to event %d", e.ID, events[i-1].ID)
+		}
+	}
+}
+
+func testAdminAPIKeys(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	k, err := s.CreateAdminAPIKey(ctx, "ops", "hash-1", "lsk_abcd", []string{"read-only", "audit-read"}, 0, "bootstrap")
+	if err != nil || k.ID == 0 || k.Name != "ops" || len(k.Scopes) != 2 || k.CreatedBy != "bootstrap" {
+		t.Fatalf("CreateAdminAPIKey failed: %+v, %v", k, err)
+	}
+	if _, err := s.CreateAdminAPIKey(ctx, "ops", "hash-2", "lsk_efgh", []string{"admin"}, 0, ""); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected an already exists error, got %v", err)
+	}
+
+	found, err := s.GetAdminAPIKeyByHash(ctx, "hash-1")
+	if err != nil || found == nil || found.ID != k.ID || found.LastUsedAt == nil {
+		t.Fatalf("GetAdminAPIKeyByHash failed: %+v, %v", found, err)
+	}
+	stored, _ := s.GetAdminAPIKey(ctx, k.ID)
+	if stored.LastUsedAt == nil {
+		t.Error("Expected last use to be stored")
+	}
+	// A recent last use is not rewritten on every request
+	again, _ := s.GetAdminAPIKeyByHash(ctx, "hash-1")
+	if again == nil || again.LastUsedAt == nil || !again.LastUsedAt.Equal(*stored.LastUsedAt) {
+		t.Errorf("Expected last use to stay at %v, got %+v", stored.LastUsedAt, again)
+	}
+
+	if err := s.RevokeAdminAPIKey(ctx, k.ID); err != nil {
+		t.Fatalf("RevokeAdminAPIKey failed: %v", err)
+	}
+	if err := s.RevokeAdminAPIKey(ctx, k.ID); err == nil {
+		t.Error("Expected revoking twice to fail")
+	}
+	if found, _ := s.GetAdminAPIKeyByHash(ctx, "hash-1"); found != nil {
+		t.Error("Expected a revoked key not to be found by hash")
+	}
+
+	keys, err := s.GetAllAdminAPIKeys(ctx)
+	if err != nil || len(keys) != 1 || keys[0].RevokedAt == nil {
+		t.Errorf("Expected one revoked key, got %+v, %v", keys, err)
+	}
+	if missing, err := s.GetAdminAPIKey(ctx, 999999); err != nil || missing != nil {
+		t.Errorf("Expected nil for an unknown key, got %+v, %v", missing, err)
+	}
+}
+
+func testOfflineActivations(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	a := &storage.OfflineActivation{INN: "1111111111", Fingerprint: "host-a", Nonce: "nonce-1", KeyFingerprint: "kfp", UsedSlots: 3, TokenID: "jti-1"}
+	if err := s.SaveOfflineActivation(ctx, a); err != nil || a.ID == 0 {
+		t.Fatalf("SaveOfflineActivation failed: %+v, %v", a, err)
+	}
+	reused := &storage.OfflineActivation{INN: "1111111111", Fingerprint: "host-a", Nonce: "nonce-1", KeyFingerprint: "kfp"}
+	if err := s.SaveOfflineActivation(ctx, reused); err == nil || !strings.Contains(err.Error(), "already used") {
+		t.Errorf("Expected nonce reuse to fail, got %v", err)
+	}
+
+	activations, err := s.GetOfflineActivations(ctx, "1111111111")
+	if err != nil || len(activations) != 1 || activations[0].TokenID != "jti-1" || activations[0].UsedSlots != 3 {
+		t.Errorf("Unexpected offline activations: %+v, %v", activations, err)
+	}
+
+	// A nonce is reserved before the token is issued and released if the activation is refused
+	pending := &storage.OfflineActivation{INN: "1111111111", Fingerprint: "host-b", Nonce: "nonce-2", KeyFingerprint: "kfp"}
+	if err := s.SaveOfflineActivation(ctx, pending); err != nil {
+		t.Fatalf("SaveOfflineActivation failed: %v", err)
+	}
+	if err := s.SetOfflineActivationToken(ctx, pending.ID, "jti-2"); err != nil {
+		t.Fatalf("SetOfflineActivationToken failed: %v", err)
+	}
+	if err := s.SetOfflineActivationToken(ctx, 999999, "jti-x"); err == nil {
+		t.Error("Expected updating an unknown activation to fail")
+	}
+	if err := s.DeleteOfflineActivation(ctx, pending.ID); err != nil {
+		t.Fatalf("DeleteOfflineActivation failed: %v", err)
+	}
+	retried := &storage.OfflineActivation{INN: "1111111111", Fingerprint: "host-b", Nonce: "nonce-2", KeyFingerprint: "kfp"}
+	if err := s.SaveOfflineActivation(ctx, retried); err != nil {
+		t.Errorf("Expected a released nonce to be usable again, got %v", err)
+	}
+}
+
+func testWebhooks(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	w := &storage.Webhook{Name: "crm", URL: "https://example.com/hook", Secret: "whsec_x", EventTypes: []string{"license_*", "token_refreshed"}, Active: true}
+	if err := s.CreateWebhook(ctx, w); err != nil || w.ID == 0 {
+		t.Fatalf("CreateWebhook failed: %+v, %v", w, err)
+	}
+	dup := &storage.Webhook{Name: "crm", URL: "https://example.com/other", Secret: "whsec_y", EventTypes: []string{"*"}}
+	if err := s.CreateWebhook(ctx, dup); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Errorf("Expected an already exists error, got %v", err)
+	}
+
+	w.URL = "https://example.com/new"
+	w.EventTypes = []string{"*"}
+	w.Active = false
+	if err := s.UpdateWebhook(ctx, w); err != nil {
+		t.Fatalf("UpdateWebhook failed: %v", err)
+	}
+	got, err := s.GetWebhook(ctx, w.ID)
+	if err != nil || got.URL != w.URL || got.Active || len(got.EventTypes) != 1 || got.Secret != "whsec_x" {
+		t.Errorf("Unexpected webhook after update: %+v, %v", got, err)
+	}
+
+	all, _ := s.GetAllWebhooks(ctx)
+	if len(all) != 1 {
+		t.Errorf("Expected 1 webhook, got %d", len(all))
+	}
+
+	if err := s.DeleteWebhook(ctx, w.ID); err != nil {
+		t.Fatalf("DeleteWebhook failed: %v", err)
+	}
+	if got, err := s.GetWebhook(ctx, w.ID); err != nil || got != nil {
+		t.Errorf("Expected nil after delete, got %+v, %v", got, err)
+	}
+}
+
+func testWebhookQueue(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	// Events from before the first webhook are not replayed
+	_ = s.LogAudit(ctx, "license_created", "1111111111", "", "old")
+	w := &storage.Webhook{Name: "crm", URL: "https://example.com/hook", Secret: "whsec_x", EventTypes: []string{"*"}, Active: true}
+	if err := s.CreateWebhook(ctx, w); err != nil {
+		t.Fatalf("CreateWebhook failed: %v", err)
+	}
+	_ = s.LogAudit(ctx, "license_extended", "1111111111", "", "new")
+
+	cursor, err := s.GetWebhookCursor(ctx)
+	if err != nil {
+		t.Fatalf("GetWebhookCursor failed: %v", err)
+	}
+	events, _ := s.QueryAuditEvents(ctx, storage.AuditFilter{After: cursor, OldestFirst: true})
+	if len(events) != 1 || events[0].Details != "new" {
+		t.Fatalf("Expected only the new event after the cursor, got %+v", events)
+	}
+
+	now := time.Now().Truncate(time.Second)
+	deliveries := []*storage.WebhookDelivery{
+		{WebhookID: w.ID, EventID: events[0].ID, EventType: events[0].Action, Payload: `{"id":1}`, NextAttemptAt: now},
+	}
+	if err := s.EnqueueWebhookDeliveries(ctx, deliveries, events[0].ID); err != nil {
+		t.Fatalf("EnqueueWebhookDeliveries failed: %v", err)
+	}
+	if cursor, _ := s.GetWebhookCursor(ctx); cursor != events[0].ID {
+		t.Errorf("Expected the cursor to advance to %d, got %d", events[0].ID, cursor)
+	}
+
+	if due, err := s.GetDueWebhookDeliveries(ctx, now.Add(-time.Minute), 10); err != nil || len(due) != 0 {
+		t.Errorf("Expected nothing due before the first attempt, got %d, %v", len(due), err)
+	}
+	due, err := s.GetDueWebhookDeliveries(ctx, now.Add(time.Second), 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("Expected one due delivery, got %d, %v", len(due), err)
+	}
+	d := due[0]
+	if d.Status != storage.WebhookPending || d.Payload != `{"id":1}` || d.EventType != "license_extended" {
+		t.Errorf("Unexpected delivery: %+v", d)
+	}
+
+	d.Attempts = 1
+	d.LastStatusCode = 503
+	d.LastError = "unavailable"
+	d.NextAttemptAt = now.Add(time.Hour)
+	if err := s.RecordWebhookAttempt(ctx, d, &storage.WebhookAttempt{Attempt: 1, StatusCode: 503, Error: "unavailable", DurationMS: 12}); err != nil {
+		t.Fatalf("RecordWebhookAttempt failed: %v", err)
+	}
+	stored, _ := s.GetWebhookDelivery(ctx, d.ID)
+	if stored.Attempts != 1 || stored.LastStatusCode != 503 || !stored.NextAttemptAt.Equal(d.NextAttemptAt) || stored.DeliveredAt != nil {
+		t.Errorf("Attempt not stored: %+v", stored)
+	}
+	if due, _ := s.GetDueWebhookDeliveries(ctx, now.Add(time.Minute), 10); len(due) != 0 {
+		t.Errorf("Expected the retry not to be due yet, got %d", len(due))
+	}
+
+	deliveredAt := now.Add(time.Minute)
+	d.Attempts = 2
+	d.Status = storage.WebhookDelivered
+	d.LastStatusCode = 204
+	d.LastError = ""
+	d.DeliveredAt = &deliveredAt
+	_ = s.RecordWebhookAttempt(ctx, d, &storage.WebhookAttempt{Attempt: 2, StatusCode: 204})
+
+	attempts, err := s.GetWebhookAttempts(ctx, d.ID)
+	if err != nil || len(attempts) != 2 || attempts[0].StatusCode != 503 || attempts[1].StatusCode != 204 {
+		t.Errorf("Unexpected delivery log: %+v, %v", attempts, err)
+	}
+	list, _ := s.GetWebhookDeliveries(ctx, w.ID, 10)
+	if len(list) != 1 || list[0].Status != storage.WebhookDelivered || list[0].DeliveredAt == nil {
+		t.Errorf("Unexpected deliveries: %+v", list)
+	}
+
+	if err := s.RequeueWebhookDelivery(ctx, d.ID); err != nil {
+		t.Fatalf("RequeueWebhookDelivery failed: %v", err)
+	}
+	if due, _ := s.GetDueWebhookDeliveries(ctx, time.Now().Add(time.Second), 10); len(due) != 1 || due[0].ID != d.ID {
+		t.Errorf("Expected the requeued delivery to be due, got %+v", due)
+	}
+
+	if err := s.DeleteWebhook(ctx, w.ID); err != nil {
+		t.Fatalf("DeleteWebhook failed: %v", err)
+	}
+	if got, _ := s.GetWebhookDelivery(ctx, d.ID); got != nil {
+		t.Errorf("Expected deliveries to be deleted with the webhook, got %+v", got)
+	}
+}
+
+func testIssuedTokens(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	issuedAt := time.Now().Truncate(time.Second)
+	tok := &storage.IssuedToken{ID: "jti-1", INN: "1111111111", Fingerprint: "host-a", KeyVersion: 2, StateHash: "abc", IssuedAt: issuedAt, ExpiresAt: issuedAt.Add(24 * time.Hour)}
+	if err := s.SaveIssuedToken(ctx, tok); err != nil {
+		t.Fatalf("SaveIssuedToken failed: %v", err)
+	}
+	got, err := s.GetIssuedToken(ctx, "jti-1")
+	if err != nil || got == nil || got.INN != tok.INN || got.KeyVersion != 2 || got.StateHash != "abc" || !got.IssuedAt.Equal(issuedAt) {
+		t.Fatalf("Unexpected issued token: %+v, %v", got, err)
+	}
+	if got, err := s.GetIssuedToken(ctx, "unknown"); err != nil || got != nil {
+		t.Errorf("Expected nil for an unknown jti, got %+v, %v", got, err)
+	}
+}
+
+func testEntitlements(t *testing.T, s Store) {
+	ctx := context.Background()
+	inn := "1111111111"
+
+	if ents, err := s.GetEntitlements(ctx, inn); err != nil || len(ents) != 0 {
+		t.Fatalf("Expected no entitlements, got %v, %v", ents, err)
+	}
+
+	if err := s.ReplaceEntitlements(ctx, inn, map[string]string{"network_scanner": "true", "retention_days": "90"}); err != nil {
+		t.Fatalf("ReplaceEntitlements failed: %v", err)
+	}
+	if err := s.SetEntitlement(ctx, inn, "retention_days", "365"); err != nil {
+		t.Fatalf("SetEntitlement failed: %v", err)
+	}
+	if err := s.SetEntitlement(ctx, inn, "inventory_reports", "false"); err != nil {
+		t.Fatalf("SetEntitlement failed: %v", err)
+	}
+	if err := s.SetEntitlement(ctx, "2222222222", "network_scanner", "true"); err != nil {
+		t.Fatalf("SetEntitlement failed: %v", err)
+	}
+
+	ents, err := s.GetEntitlements(ctx, inn)
+	if err != nil || len(ents) != 3 || ents["retention_days"] != "365" || ents["network_scanner"] != "true" || ents["inventory_reports"] != "false" {
+		t.Fatalf("Unexpected entitlements: %v, %v", ents, err)
+	}
+
+	if err := s.DeleteEntitlement(ctx, inn, "inventory_reports"); err != nil {
+		t.Fatalf("DeleteEntitlement failed: %v", err)
+	}
+	if err := s.DeleteEntitlement(ctx, inn, "inventory_reports"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("Expected not found on second delete, got %v", err)
+	}
+
+	if err := s.ReplaceEntitlements(ctx, inn, map[string]string{"retention_days": "30"}); err != nil {
+		t.Fatalf("ReplaceEntitlements failed: %v", err)
+	}
+	if ents, _ := s.GetEntitlements(ctx, inn); len(ents) != 1 || ents["retention_days"] != "30" {
+		t.Errorf("Expected replace to drop other keys, got %v", ents)
+	}
+	if ents, _ := s.GetEntitlements(ctx, "2222222222"); len(ents) != 1 {
+		t.Errorf("Entitlements of another license must be kept, got %v", ents)
+	}
+}
+
+func testHardwareTransfers(t *testing.T, s Store) {
+	ctx := context.Background()
+	inn := "1111111111"
+	inAYear := time.Now().AddDate(1, 0, 0)
+	_ = s.SaveClientCertBinding(ctx, newBinding(inn, "2001", inAYear))
+	b, _ := s.GetClientCertBinding(ctx, "fp-2001")
+	if b.HardwareFingerprint != "" {
+		t.Fatalf("Expected a new binding without hardware, got %q", b.HardwareFingerprint)
+	}
+
+	if err := s.BindHardwareFingerprint(ctx, b.ID, "hw-old"); err != nil {
+		t.Fatalf("BindHardwareFingerprint failed: %v", err)
+	}
+	if err := s.BindHardwareFingerprint(ctx, b.ID, "hw-other"); err == nil {
+		t.Error("Expected binding an already bound instance to fail")
+	}
+
+	// Renewal carries the hardware over to the new certificate
+	renewed := newBinding(inn, "2002", inAYear)
+	renewed.HardwareFingerprint = "hw-old"
+	if err := s.ReplaceClientCertBinding(ctx, b.ID, renewed); err != nil {
+		t.Fatalf("ReplaceClientCertBinding failed: %v", err)
+	}
+	renewedBinding, _ := s.GetClientCertBinding(ctx, "fp-2002")
+	if err := s.ConfirmClientCertBinding(ctx, renewedBinding.ID); err != nil {
+		t.Fatalf("ConfirmClientCertBinding failed: %v", err)
+	}
+
+	tr := &storage.HardwareTransfer{INN: inn, CertSerial: "2002", OldFingerprint: "hw-old", NewFingerprint: "hw-new", Reason: "motherboard replaced", IPAddress: "10.0.0.1"}
+	if err := s.CreateHardwareTransfer(ctx, tr); err != nil || tr.ID == 0 {
+		t.Fatalf("CreateHardwareTransfer failed: %+v, %v", tr, err)
+	}
+	rejected := &storage.HardwareTransfer{INN: inn, CertSerial: "2002", OldFingerprint: "hw-old", NewFingerprint: "hw-stolen"}
+	_ = s.CreateHardwareTransfer(ctx, rejected)
+
+	got, err := s.GetHardwareTransfer(ctx, tr.ID)
+	if err != nil || got == nil || got.Status != "pending" || got.Reason != "motherboard replaced" || got.DecidedAt != nil {
+		t.Fatalf("Unexpected transfer: %+v, %v", got, err)
+	}
+	if got, err := s.GetHardwareTransfer(ctx, 999999); err != nil || got != nil {
+		t.Errorf("Expected nil for an unknown transfer, got %+v, %v", got, err)
+	}
+
+	if err := s.RejectHardwareTransfer(ctx, rejected.ID, "admin", "not our customer"); err != nil {
+		t.Fatalf("RejectHardwareTransfer failed: %v", err)
+	}
+	since := time.Now().Add(-time.Hour)
+	if n, err := s.CountApprovedHardwareTransfers(ctx, inn, since); err != nil || n != 0 {
+		t.Errorf("Expected no approved transfers, got %d, %v", n, err)
+	}
+
+	if err := s.ApproveHardwareTransfer(ctx, tr.ID, "auto", "within quota", true); err != nil {
+		t.Fatalf("ApproveHardwareTransfer failed: %v", err)
+	}
+	if err := s.ApproveHardwareTransfer(ctx, tr.ID, "admin", "", false); err == nil {
+		t.Error("Expected approving a decided transfer to fail")
+	}
+	if err := s.RejectHardwareTransfer(ctx, rejected.ID, "admin", ""); err == nil {
+		t.Error("Expected rejecting a decided transfer to fail")
+	}
+	if b, _ := s.GetClientCertBinding(ctx, "fp-2002"); b.HardwareFingerprint != "hw-new" {
+		t.Errorf("Expected the instance to move to hw-new, got %q", b.HardwareFingerprint)
+	}
+	if old, _ := s.GetClientCertBinding(ctx, "fp-2001"); old.HardwareFingerprint != "hw-old" {
+		t.Errorf("Superseded bindings must keep their hardware, got %q", old.HardwareFingerprint)
+	}
+	if n, _ := s.CountApprovedHardwareTransfers(ctx, inn, since); n != 1 {
+		t.Errorf("Expected 1 approved transfer, got %d", n)
+	}
+	if n, _ := s.CountApprovedHardwareTransfers(ctx, inn, time.Now().Add(time.Hour)); n != 0 {
+		t.Errorf("Expected no transfers approved in the future, got %d", n)
+	}
+
+	// Nothing is bound to the old machine anymore
+	stale := &storage.HardwareTransfer{INN: inn, CertSerial: "2002", OldFingerprint: "hw-old", NewFingerprint: "hw-third"}
+	_ = s.CreateHardwareTransfer(ctx, stale)
+	if err := s.ApproveHardwareTransfer(ctx, stale.ID, "admin", "", false); err == nil {
+		t.Error("Expected approving a transfer from an unbound machine to fail")
+	}
+
+	all, err := s.GetHardwareTransfers(ctx, storage.HardwareTransferFilter{INN: inn})
+	if err != nil || len(all) != 3 || all[0].ID != stale.ID {
+		t.Fatalf("Expected 3 transfers, newest first, got %d, %v", len(all), err)
+	}
+	approved, _ := s.GetHardwareTransfers(ctx, storage.HardwareTransferFilter{Status: "approved"})
+	if len(approved) != 1 || !approved[0].AutoApproved || approved[0].DecidedBy != "auto" || approved[0].DecidedAt == nil {
+		t.Errorf("Unexpected approved transfers: %+v", approved)
+	}
+	if other, _ := s.GetHardwareTransfers(ctx, storage.HardwareTransferFilter{INN: "2222222222"}); len(other) != 0 {
+		t.Errorf("Expected no transfers for another license, got %d", len(other))
+	}
+}
+
+func testPartners(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	p, err := s.CreatePartner(ctx, "Reseller", 10, "ops")
+	if err != nil || p.ID == 0 || p.Name != "Reseller" || p.SlotPool != 10 || p.AllocatedSlots != 0 || p.CreatedBy != "ops" {
+		t.Fatalf("CreatePartner failed: %+v, %v", p, err)
+	}
+	if _, err := s.CreatePartner(ctx, "Reseller", 5, ""); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("Expected a conflict for a duplicate name, got %v", err)
+	}
+	if missing, err := s.GetPartner(ctx, 999999); err != nil || missing != nil {
+		t.Errorf("Expected nil for an unknown partner, got %+v, %v", missing, err)
+	}
+
+	expiresAt := time.Now().AddDate(1, 0, 0)
+	if err := s.CreatePartnerLicense(ctx, p.ID, "1111111111", "Customer A", 3, expiresAt, false, 0); err != nil {
+		t.Fatalf("CreatePartnerLicense failed: %v", err)
+	}
+	if err := s.CreatePartnerLicense(ctx, p.ID, "2222222222", "Customer B", 4, expiresAt, false, 0); err != nil {
+		t.Fatalf("CreatePartnerLicense failed: %v", err)
+	}
+	if err := s.CreateLicense(ctx, "3333333333", "Direct", 50); err != nil {
+		t.Fatalf("CreateLicense failed: %v", err)
+	}
+	// A partner never takes over an existing license
+	if err := s.CreatePartnerLicense(ctx, p.ID, "3333333333", "Stolen", 1, expiresAt, false, 0); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("Expected a conflict for an existing license, got %v", err)
+	}
+
+	lic, _ := s.GetLicenseByINN(ctx, "1111111111")
+	if lic.PartnerID == nil || *lic.PartnerID != p.ID {
+		t.Errorf("Expected the license to belong to the partner, got %+v", lic.PartnerID)
+	}
+	if direct, _ := s.GetLicenseByINN(ctx, "3333333333"); direct.PartnerID != nil {
+		t.Errorf("Expected no partner for a direct license, got %d", *direct.PartnerID)
+	}
+	licenses, err := s.GetLicensesByPartner(ctx, p.ID)
+	if err != nil || len(licenses) != 2 {
+		t.Fatalf("Expected two partner licenses, got %d, %v", len(licenses), err)
+	}
+
+	// Revoked licenses give their slots back to the pool
+	if err := s.UpdateLicenseStatus(ctx, "2222222222", "revoked"); err != nil {
+		t.Fatalf("UpdateLicenseStatus failed: %v", err)
+	}
+	if p, _ = s.GetPartner(ctx, p.ID); p.AllocatedSlots != 3 {
+		t.Errorf("Expected 3 allocated slots, got %d", p.AllocatedSlots)
+	}
+
+	// A license too big for what is left of the pool is refused
+	if err := s.SetLicensePartner(ctx, "3333333333", p.ID); !errors.Is(err, storage.ErrSlotPoolExceeded) {
+		t.Errorf("Expected the slot pool to be exceeded, got %v", err)
+	}
+	if err := s.UpdatePartner(ctx, p.ID, "Reseller", 60); err != nil {
+		t.Fatalf("UpdatePartner failed: %v", err)
+	}
+	if err := s.SetLicensePartner(ctx, "3333333333", p.ID); err != nil {
+		t.Fatalf("SetLicensePartner failed: %v", err)
+	}
+	if p, _ = s.GetPartner(ctx, p.ID); p.AllocatedSlots != 53 {
+		t.Errorf("Expected 53 allocated slots, got %d", p.AllocatedSlots)
+	}
+	if err := s.SetLicensePartner(ctx, "3333333333", 0); err != nil {
+		t.Fatalf("SetLicensePartner failed: %v", err)
+	}
+	if direct, _ := s.GetLicenseByINN(ctx, "3333333333"); direct.PartnerID != nil {
+		t.Error("Expected the license to be back with the operator")
+	}
+	if err := s.SetLicensePartner(ctx, "0000000000", p.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected not found for an unknown license, got %v", err)
+	}
+
+	if err := s.UpdatePartner(ctx, p.ID, "Reseller Ltd", 20); err != nil {
+		t.Fatalf("UpdatePartner failed: %v", err)
+	}
+	// Every change that takes slots is checked against the pool
+	if err := s.UpdatePartner(ctx, p.ID, "Reseller Ltd", 2); !errors.Is(err, storage.ErrSlotPoolExceeded) {
+		t.Errorf("Expected a pool below the allocated slots to be refused, got %v", err)
+	}
+	if err := s.CreatePartnerLicense(ctx, p.ID, "4444444444", "Customer D", 18, expiresAt, false, 0); !errors.Is(err, storage.ErrSlotPoolExceeded) {
+		t.Errorf("Expected a license beyond the pool to be refused, got %v", err)
+	}
+	if err := s.UpdateLicenseDetails(ctx, "1111111111", "Customer A", 21); !errors.Is(err, storage.ErrSlotPoolExceeded) {
+		t.Errorf("Expected more slots beyond the pool to be refused, got %v", err)
+	}
+	if err := s.UpdateLicenseDetails(ctx, "1111111111", "Customer A", 17); err != nil {
+		t.Fatalf("UpdateLicenseDetails failed: %v", err)
+	}
+	if err := s.UpdateLicenseStatus(ctx, "2222222222", "active"); !errors.Is(err, storage.ErrSlotPoolExceeded) {
+		t.Errorf("Expected bringing back a revoked license beyond the pool to be refused, got %v", err)
+	}
+	if err := s.UpdateLicenseDetails(ctx, "1111111111", "Customer A", 3); err != nil {
+		t.Fatalf("UpdateLicenseDetails failed: %v", err)
+	}
+	if err := s.UpdateLicenseStatus(ctx, "2222222222", "active"); err != nil {
+		t.Fatalf("UpdateLicenseStatus failed: %v", err)
+	}
+	if err := s.UpdateLicenseStatus(ctx, "2222222222", "revoked"); err != nil {
+		t.Fatalf("UpdateLicenseStatus failed: %v", err)
+	}
+	// Licenses of the operator have no pool
+	if err := s.UpdateLicenseDetails(ctx, "3333333333", "Direct", 100); err != nil {
+		t.Fatalf("UpdateLicenseDetails failed: %v", err)
+	}
+	if err := s.UpdatePartner(ctx, 999999, "Nobody", 1); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected not found for an unknown partner, got %v", err)
+	}
+	other, err := s.CreatePartner(ctx, "Another", 0, "")
+	if err != nil {
+		t.Fatalf("CreatePartner failed: %v", err)
+	}
+	if err := s.UpdatePartner(ctx, other.ID, "Reseller Ltd", 0); !errors.Is(err, storage.ErrConflict) {
+		t.Errorf("Expected a conflict when renaming to a taken name, got %v", err)
+	}
+	partners, err := s.GetAllPartners(ctx)
+	if err != nil || len(partners) != 2 || partners[0].Name != "Another" || partners[1].SlotPool != 20 {
+		t.Errorf("Unexpected partners: %+v, %v", partners, err)
+	}
+
+	// Partner keys remember their partner
+	k, err := s.CreateAdminAPIKey(ctx, "reseller-ops", "hash-p", "lsa_abcd", []string{"read-only"}, p.ID, "ops")
+	if err != nil || k.PartnerID == nil || *k.PartnerID != p.ID {
+		t.Fatalf("CreateAdminAPIKey failed: %+v, %v", k, err)
+	}
+	if found, _ := s.GetAdminAPIKeyByHash(ctx, "hash-p"); found == nil || found.PartnerID == nil || *found.PartnerID != p.ID {
+		t.Errorf("Expected the partner of the key to be stored, got %+v", found)
+	}
+
+	// Concurrent allocations never overrun the pool
+	busy, err := s.CreatePartner(ctx, "Busy", 5, "")
+	if err != nil {
+		t.Fatalf("CreatePartner failed: %v", err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- s.CreatePartnerLicense(ctx, busy.ID, fmt.Sprintf("77777777%02d", i), "Rush", 1, expiresAt, false, 0)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	created := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, storage.ErrSlotPoolExceeded):
+			t.Errorf("Unexpected error of a concurrent allocation: %v", err)
+		}
+	}
+	if busy, _ = s.GetPartner(ctx, busy.ID); created != 5 || busy.AllocatedSlots != 5 {
+		t.Errorf("Expected 5 of 10 concurrent licenses within a pool of 5, got %d and %d allocated slots", created, busy.AllocatedSlots)
+	}
+
+	// Audit events filtered by partner are those of its licenses
+	_ = s.LogAudit(ctx, "license_created", "1111111111", "", "")
+	_ = s.LogAudit(ctx, "license_created", "3333333333", "", "")
+	_ = s.LogAudit(ctx, "partner_created", "", "", "")
+	events, err := s.QueryAuditEvents(ctx, storage.AuditFilter{PartnerID: p.ID})
+	if err != nil || len(events) != 1 || events[0].INN != "1111111111" {
+		t.Errorf("Expected the event of the partner license only, got %+v, %v", events, err)
+	}
+}
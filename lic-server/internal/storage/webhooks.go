@@ -0,0 +1,49 @@
+package storage
+
+import "time"
+
+// Webhook is an admin-managed subscription to audit events
+type Webhook struct {
+	ID         int64
+	Name       string
+	URL        string
+	Secret     string   `json:"-"` // HMAC key, shown once on creation
+	EventTypes []string // audit actions, "prefix_*" patterns or "*"
+	Active     bool
+	CreatedBy  string
+	CreatedAt  time.Time
+}
+
+// Webhook delivery states
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed" // gave up after the maximum number of attempts
+)
+
+// WebhookDelivery is one audit event queued for one webhook
+type WebhookDelivery struct {
+	ID             int64
+	WebhookID      int64
+	EventID        int64
+	EventType      string
+	Payload        string
+	Status         string
+	Attempts       int
+	NextAttemptAt  time.Time
+	LastStatusCode int
+	LastError      string
+	CreatedAt      time.Time
+	DeliveredAt    *time.Time
+}
+
+// WebhookAttempt is one entry of the delivery log
+type WebhookAttempt struct {
+	ID          int64
+	DeliveryID  int64
+	Attempt     int
+	StatusCode  int
+	Error       string
+	DurationMS  int64
+	AttemptedAt time.Time
+}
@@ -108,6 +108,10 @@ func main() {
 			log.Printf("WARN: Failed to initialize token service: %v. Token validation disabled.", err)
 		} else {
 			log.Println("Token service initialized successfully")
+			// Ключи, загруженные с сервера раньше, заменяют встроенный: отозванный ключ не вернётся после рестарта
+			if err := tokenService.UseKeySetFile(cfg.KeySetPath); err != nil {
+				log.Printf("WARN: Failed to load saved signing key set: %v", err)
+			}
 		}
 	} else {
 		log.Println("WARN: LICENSE_PUBLIC_KEY is empty and no embedded key found. Token validation disabled.")
@@ -231,6 +235,20 @@ func main() {
 		}
 	}()
 
+	// 7.6) Background signing key set refresh, so keys retired on the server stop verifying
+	go func() {
+		ticker := time.NewTicker(cfg.KeySetRefreshInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+			if err := deviceUseCase.RefreshSigningKeys(ctx); err != nil {
+				log.Printf("WARN: Scheduled signing key refresh failed: %v", err)
+			}
+			cancel()
+		}
+	}()
+
 	// 8) HTTP-сервер
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.Port),
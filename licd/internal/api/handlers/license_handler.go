@@ -2,11 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
 
 	"github.com/deymonster/licd/internal/application/usecases"
+	"github.com/deymonster/licd/internal/domain/entities"
+	"github.com/deymonster/licd/internal/infrastructure/client"
 )
 
 // LicenseHandler обрабатывает HTTP запросы для лицензий
@@ -259,15 +262,20 @@ func (h *LicenseHandler) RegisterInstance(w http.ResponseWriter, r *http.Request
 		errorMsg := err.Error()
 		errorCode := "UNKNOWN_ERROR"
 
-		if strings.Contains(errorMsg, "license not found") {
+		switch {
+		case errors.Is(err, client.ErrLicenseNotFound):
 			statusCode = http.StatusNotFound
 			errorCode = "LICENSE_NOT_FOUND"
-		} else if strings.Contains(errorMsg, "unavailable") {
+		case errors.Is(err, client.ErrServerUnavailable):
 			statusCode = http.StatusServiceUnavailable
 			errorCode = "LICENSE_SERVER_UNAVAILABLE"
-		} else if strings.Contains(errorMsg, "inn and csr are required") {
+		case errors.Is(err, client.ErrEnrollmentTokenInvalid):
+			statusCode = http.StatusForbidden
+			errorCode = "ENROLLMENT_TOKEN_INVALID"
+		case errors.Is(err, client.ErrInvalidRequest):
+			// INN format, CSR or anything else the server refused; message has the details
 			statusCode = http.StatusBadRequest
-			errorCode = "INN_REQUIRED"
+			errorCode = "INVALID_REQUEST"
 		}
 
 		w.WriteHeader(statusCode)
@@ -303,7 +311,7 @@ func (h *LicenseHandler) UpdateLicense(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.deviceUseCase.UpdateLicense(r.Context(), req.Token, ""); err != nil {
-		if strings.Contains(err.Error(), "invalid token") || strings.Contains(err.Error(), "fingerprint mismatch") {
+		if errors.Is(err, usecases.ErrInvalidToken) || errors.Is(err, usecases.ErrFingerprintMismatch) {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 		} else {
 			http.Error(w, "Failed to update license: "+err.Error(), http.StatusInternalServerError)
@@ -319,7 +327,7 @@ func (h *LicenseHandler) UpdateLicense(w http.ResponseWriter, r *http.Request) {
 // POST /license/refresh
 func (h *LicenseHandler) RefreshLicense(w http.ResponseWriter, r *http.Request) {
 	if err := h.deviceUseCase.RefreshLicense(r.Context()); err != nil {
-		if strings.Contains(err.Error(), "no active license") {
+		if errors.Is(err, client.ErrLicenseInactive) || errors.Is(err, client.ErrLicenseNotFound) {
 			http.Error(w, "No active license to refresh", http.StatusNotFound)
 			return
 		}
@@ -334,3 +342,56 @@ func (h *LicenseHandler) RefreshLicense(w http.ResponseWriter, r *http.Request)
 		"message": "License updated from server",
 	})
 }
+
+// CreateOfflineRequest выдаёт подписанный файл запроса офлайн-активации
+// POST /license/offline/request
+func (h *LicenseHandler) CreateOfflineRequest(w http.ResponseWriter, r *http.Request) {
+	type Request struct {
+		INN string `json:"inn"`
+	}
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+	if req.INN == "" {
+		http.Error(w, "Missing INN", http.StatusBadRequest)
+		return
+	}
+
+	file, err := h.deviceUseCase.CreateOfflineActivationRequest(r.Context(), req.INN)
+	if err != nil {
+		log.Printf("ERROR: Failed to create offline activation request: %v", err)
+		http.Error(w, "Failed to create activation request: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="activation-request.json"`)
+	_ = json.NewEncoder(w).Encode(file)
+}
+
+// ImportOfflineResponse импортирует файл ответа офлайн-активации, полученный от lic-server
+// POST /license/offline/import
+func (h *LicenseHandler) ImportOfflineResponse(w http.ResponseWriter, r *http.Request) {
+	var resp entities.OfflineActivationResponse
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.deviceUseCase.ImportOfflineActivation(r.Context(), &resp); err != nil {
+		if errors.Is(err, usecases.ErrInvalidToken) || errors.Is(err, usecases.ErrFingerprintMismatch) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			http.Error(w, "Failed to import license: "+err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"status":  "activated",
+		"message": "Offline license imported successfully",
+	})
+}
@@ -53,6 +53,8 @@ func (r *Router) SetupLicenseRoutes(licenseHandler *handlers.LicenseHandler) {
 	// API v1 routes
 	r.mux.HandleFunc("GET /api/v1/license/status", licenseHandler.GetLicenseStatus)
 	r.mux.HandleFunc("POST /api/v1/license/register", licenseHandler.RegisterInstance)
+	r.mux.HandleFunc("POST /api/v1/license/offline/request", licenseHandler.CreateOfflineRequest)
+	r.mux.HandleFunc("POST /api/v1/license/offline/import", licenseHandler.ImportOfflineResponse)
 
 	// Frontend compatibility routes (without /api/v1 prefix)
 	r.mux.HandleFunc("GET /license/status", licenseHandler.GetLicenseStatus)
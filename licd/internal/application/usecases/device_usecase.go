@@ -3,9 +3,9 @@ package usecases
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"strings"
 	"time"
 
 	"github.com/deymonster/licd/internal/domain/entities"
@@ -16,6 +16,15 @@ import (
 	"github.com/deymonster/licd/internal/storage/sqlite"
 )
 
+// certRenewBefore — за сколько до истечения клиентского сертификата запрашивать новый
+const certRenewBefore = 30 * 24 * time.Hour
+
+// Ошибки проверки лицензионного токена: токен не принят, и повтор запроса не поможет
+var (
+	ErrInvalidToken        = errors.New("invalid token")
+	ErrFingerprintMismatch = errors.New("fingerprint mismatch")
+)
+
 // DeviceUseCase содержит бизнес-логику для работы с устройствами
 type DeviceUseCase struct {
 	activationRepo  *sqlite.ActivationRepository
@@ -82,11 +91,8 @@ func (uc *DeviceUseCase) RegisterInstance(ctx context.Context, inn, token string
 		len(resp.Certificate), len(resp.CACertificate), len(resp.PublicKey))
 
 	// 3. Save Certs
-	if err = uc.keyManager.SaveKey(keyPEM); err != nil {
-		return fmt.Errorf("failed to save key: %w", err)
-	}
-	if err = uc.keyManager.SaveCert([]byte(resp.Certificate)); err != nil {
-		return fmt.Errorf("failed to save cert: %w", err)
+	if err = uc.keyManager.SaveKeyAndCert(keyPEM, []byte(resp.Certificate)); err != nil {
+		return err
 	}
 	// CAPath removed from KeyManager, assuming we trust embedded CA or system CA
 	// if resp.CACertificate != "" && uc.keyManager.CAPath != "" {
@@ -134,7 +140,12 @@ func (uc *DeviceUseCase) RegisterInstance(ctx context.Context, inn, token string
 		return fmt.Errorf("failed to generate fingerprint: %w", err)
 	}
 
-	actResp, err := uc.licenseClient.Activate(ctx, inn, fp)
+	usedSlots, err := uc.GetDeviceStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	actResp, err := uc.licenseClient.Activate(ctx, inn, fp, usedSlots)
 	if err != nil {
 		return fmt.Errorf("activation failed: %w", err)
 	}
@@ -147,6 +158,61 @@ func (uc *DeviceUseCase) RegisterInstance(ctx context.Context, inn, token string
 	return nil
 }
 
+// RenewCertificate exchanges the current client certificate for a fresh one (POST /v1/renew).
+// Unlike RegisterInstance it does not consume an enrollment token.
+func (uc *DeviceUseCase) RenewCertificate(ctx context.Context) error {
+	if uc.keyManager == nil {
+		return fmt.Errorf("key manager not configured")
+	}
+	if uc.licenseClient == nil {
+		return fmt.Errorf("license client not initialized")
+	}
+
+	keyPEM, csrPEM, err := uc.keyManager.GenerateKeyAndCSR("licd-client")
+	if err != nil {
+		return fmt.Errorf("failed to generate key/CSR: %w", err)
+	}
+
+	resp, err := uc.licenseClient.Renew(ctx, csrPEM)
+	if err != nil {
+		return fmt.Errorf("renewal failed: %w", err)
+	}
+
+	// The server keeps the old certificate valid until the new one is first used,
+	// so if saving fails the instance carries on with the old pair and retries later
+	if err = uc.keyManager.SaveKeyAndCert(keyPEM, []byte(resp.Certificate)); err != nil {
+		return err
+	}
+
+	if err = uc.licenseClient.Reload(uc.keyManager.CertPath, uc.keyManager.KeyPath); err != nil {
+		return fmt.Errorf("failed to reload client: %w", err)
+	}
+
+	log.Printf("INFO: Client certificate renewed")
+	return nil
+}
+
+// renewCertificateIfNeeded renews the client certificate when it is close to expiry
+func (uc *DeviceUseCase) renewCertificateIfNeeded(ctx context.Context) {
+	if uc.keyManager == nil || uc.licenseClient == nil || !uc.keyManager.HasCert() {
+		return
+	}
+
+	notAfter, err := uc.keyManager.CertExpiry()
+	if err != nil {
+		log.Printf("WARN: Failed to read client certificate expiry: %v", err)
+		return
+	}
+	if time.Until(notAfter) > certRenewBefore {
+		return
+	}
+
+	log.Printf("INFO: Client certificate expires at %s, renewing...", notAfter.Format(time.RFC3339))
+	if err := uc.RenewCertificate(ctx); err != nil {
+		log.Printf("WARN: Certificate renewal failed: %v", err)
+	}
+}
+
 // activationToDevice преобразует Activation в Device
 func (uc *DeviceUseCase) activationToDevice(activation *sqlite.Activation) *entities.Device {
 	// Парсим labels для получения порта
@@ -303,9 +369,32 @@ func (uc *DeviceUseCase) GetLicenseStatus(ctx context.Context) (*entities.Licens
 		OrgName:        ls.OrgName,
 		INN:            ls.INN,
 		ActivationDate: ls.ActivationDate,
+		Entitlements:   uc.entitlements(ctx),
 	}, nil
 }
 
+// entitlements — модули из текущего токена. Берутся только из токена с проверенной подписью,
+// чтобы правка БД не открывала неоплаченные функции.
+func (uc *DeviceUseCase) entitlements(ctx context.Context) entities.Entitlements {
+	ents := entities.Entitlements{}
+	if uc.activationRepo == nil || uc.tokenService == nil {
+		return ents
+	}
+	tokenString, err := uc.activationRepo.GetActiveToken(ctx)
+	if err != nil || tokenString == "" {
+		return ents
+	}
+	claims, err := uc.tokenService.VerifyToken(tokenString)
+	if err != nil {
+		log.Printf("License token not usable for entitlements: %v", err)
+		return ents
+	}
+	for k, v := range claims.Entitlements {
+		ents[k] = v
+	}
+	return ents
+}
+
 // UpdateDeviceStatus — no-op (совместимость)
 func (uc *DeviceUseCase) UpdateDeviceStatus(ctx context.Context, deviceID string, status entities.Status) error {
 	return nil
@@ -373,8 +462,16 @@ func (uc *DeviceUseCase) UpdateLicense(ctx context.Context, tokenString string,
 
 	// 1. Verify signature and get claims
 	claims, err := uc.tokenService.VerifyToken(tokenString)
+	if errors.Is(err, services.ErrUnknownSigningKey) && uc.licenseClient != nil {
+		// The server has rotated its signing key since we last saw it
+		if refreshErr := uc.RefreshSigningKeys(ctx); refreshErr != nil {
+			log.Printf("WARN: Failed to refresh signing keys: %v", refreshErr)
+		} else {
+			claims, err = uc.tokenService.VerifyToken(tokenString)
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("invalid token: %w", err)
+		return fmt.Errorf("%w: %w", ErrInvalidToken, err)
 	}
 
 	// 2. Verify fingerprint
@@ -384,7 +481,7 @@ func (uc *DeviceUseCase) UpdateLicense(ctx context.Context, tokenString string,
 	}
 
 	if claims.FingerprintHash != currentFP {
-		return fmt.Errorf("fingerprint mismatch: system=%s token=%s", currentFP, claims.FingerprintHash)
+		return fmt.Errorf("%w: system=%s token=%s", ErrFingerprintMismatch, currentFP, claims.FingerprintHash)
 	}
 
 	// 3. Save to DB
@@ -410,6 +507,23 @@ func (uc *DeviceUseCase) UpdateLicense(ctx context.Context, tokenString string,
 	return uc.activationRepo.UpdateLicense(ctx, tokenString, currentFP, claims.MaxAgents, claims.Status, expiresAt, claims.OrgName, claims.INN, activationDate, inn)
 }
 
+// RefreshSigningKeys загружает актуальный набор ключей подписи (JWKS) с сервера лицензий.
+// Ключи, которых нет в наборе, перестают приниматься.
+func (uc *DeviceUseCase) RefreshSigningKeys(ctx context.Context) error {
+	if uc.tokenService == nil || uc.licenseClient == nil {
+		return fmt.Errorf("token service or license client not configured")
+	}
+	jwks, err := uc.licenseClient.GetJWKS(ctx)
+	if err != nil {
+		return err
+	}
+	if err := uc.tokenService.UpdateKeySet(jwks); err != nil {
+		return fmt.Errorf("invalid key set: %w", err)
+	}
+	log.Printf("INFO: Loaded %d license signing key(s) from server", len(jwks.Keys))
+	return nil
+}
+
 // GetDeviceStats — просто счётчик
 func (uc *DeviceUseCase) GetDeviceStats(ctx context.Context) (int, error) {
 	acts, err := uc.activationRepo.GetActivations(ctx)
@@ -419,6 +533,44 @@ func (uc *DeviceUseCase) GetDeviceStats(ctx context.Context) (int, error) {
 	return len(acts), nil
 }
 
+// transferHardware запрашивает перенос лицензии со старого оборудования на текущее.
+// Возвращает nil, когда перенос одобрен и можно активироваться с новым отпечатком.
+func (uc *DeviceUseCase) transferHardware(ctx context.Context, inn, oldFP, newFP string) error {
+	log.Printf("WARN: Hardware fingerprint changed, requesting license transfer to this machine")
+	resp, err := uc.licenseClient.RequestTransfer(ctx, client.TransferRequest{
+		OldFingerprint: oldFP,
+		NewFingerprint: newFP,
+		Reason:         "hardware fingerprint changed",
+	})
+	if err != nil {
+		return fmt.Errorf("hardware transfer request failed: %w", err)
+	}
+
+	// В audit_log результат только success/failed/denied, статус сервера уходит в details
+	result := "success"
+	if resp.Status != client.TransferApproved && resp.Status != client.TransferPending {
+		result = "denied"
+	}
+	if err := uc.activationRepo.LogLicenseAction(ctx, "hardware_transfer", result, map[string]interface{}{
+		"inn":             inn,
+		"transfer_id":     resp.ID,
+		"status":          resp.Status,
+		"old_fingerprint": oldFP,
+		"new_fingerprint": newFP,
+		"auto_approved":   resp.AutoApproved,
+	}); err != nil {
+		log.Printf("WARN: Failed to log hardware transfer %d: %v", resp.ID, err)
+	}
+	switch resp.Status {
+	case client.TransferApproved:
+		log.Printf("INFO: License transfer %d approved", resp.ID)
+		return nil
+	case client.TransferPending:
+		return fmt.Errorf("hardware transfer %d is waiting for approval by the license administrator", resp.ID)
+	}
+	return fmt.Errorf("hardware transfer %d was rejected: %s", resp.ID, resp.Note)
+}
+
 // RefreshLicense checks with the server for any license updates
 func (uc *DeviceUseCase) RefreshLicense(ctx context.Context) error {
 	// 1. Get current active token
@@ -444,61 +596,106 @@ func (uc *DeviceUseCase) RefreshLicense(ctx context.Context) error {
 		return fmt.Errorf("license client not initialized")
 	}
 
-	// 3. Try Heartbeat if token is valid and not expiring soon
-	if claims != nil {
-		_ = claims.GetExpiresAt() // Keep for future use or compatibility
-		// Always attempt full activation if we want to sync details immediately,
-		// or conditionally based on heartbeat.
-		// For now, let's keep heartbeat but if we need immediate sync, we can skip it.
-		// Actually, heartbeat only returns 200 OK. It doesn't sync new MaxSlots/OrgName.
-		// To force sync of new data (OrgName, MaxSlots), we should either:
-		// a) Have heartbeat return the new token
-		// b) Call Activate periodically to get a fresh token.
-		// Let's modify the condition: we only use heartbeat if we don't care about updates.
-		// To get updates, we MUST call Activate.
-		// Let's force Activate every time for now, or maybe heartbeat is fine and we just call Activate if heartbeat fails.
-		// But user asked how to sync these changes to licd. The answer is: licd needs to call Activate to get a new token with updated claims.
-		// Let's change the logic here to always call Activate to refresh the token claims (OrgName, MaxSlots).
-		// We'll comment out the heartbeat shortcut to ensure it always syncs.
-
-		/*
-			if expiry.IsZero() || time.Until(expiry) > 24*time.Hour {
-				if hbErr := uc.licenseClient.Heartbeat(ctx); hbErr == nil {
-					// Heartbeat successful, license is active and binding is valid.
-					return nil
-				} else {
-					// If heartbeat failed, log and fall through to Activate
-					log.Printf("WARN: Heartbeat failed, attempting full activation: %v", hbErr)
-				}
-			}
-		*/
-	}
+	// Renew the mTLS certificate before it expires, otherwise every call below starts failing
+	uc.renewCertificateIfNeeded(ctx)
 
-	// 4. Get LicenseKey
+	// 3. Get LicenseKey
 	inn, err := uc.activationRepo.GetActiveLicenseKey(ctx)
 	if err != nil || inn == "" {
 		return fmt.Errorf("failed to get active license key: %w", err)
 	}
 
-	// 5. Get system fingerprint
+	// 4. Get system fingerprint
 	fp, err := uc.GetSystemFingerprint()
 	if err != nil {
 		return fmt.Errorf("failed to generate fingerprint: %w", err)
 	}
 
-	// 6. Call server
-	resp, err := uc.licenseClient.Activate(ctx, inn, fp)
+	// 4.1 The license is bound to the machine it was activated on: after a hardware change
+	// the server has to move it before this machine can be activated
+	oldFP := ""
+	if claims != nil {
+		oldFP = claims.FingerprintHash
+	} else if installID, idErr := uc.activationRepo.GetActiveInstallID(ctx); idErr == nil {
+		oldFP = installID
+	}
+	if oldFP != "" && oldFP != fp {
+		if err := uc.transferHardware(ctx, inn, oldFP, fp); err != nil {
+			return err
+		}
+		// The held token names the old machine, so skip the heartbeat
+		claims = nil
+	}
+
+	// 5. Count agents served by this instance
+	usedSlots, err := uc.GetDeviceStats(ctx)
+	if err != nil {
+		return err
+	}
+
+	// 6. With a valid token a heartbeat is enough: the server returns a new token only when
+	// the license or the signing key changed. Full activation is the fallback.
+	if claims != nil && claims.ID != "" {
+		hb, hbErr := uc.licenseClient.Heartbeat(ctx, client.HeartbeatRequest{
+			Fingerprint: fp,
+			UsedSlots:   usedSlots,
+			JTI:         claims.ID,
+			Version:     claims.KeyVersion,
+		})
+		if hbErr == nil {
+			switch hb.Status {
+			case client.HeartbeatUnchanged:
+				return uc.markHeartbeat(ctx, inn)
+			case client.HeartbeatUpdated:
+				if err := uc.UpdateLicense(ctx, hb.Token, inn); err != nil {
+					return err
+				}
+				log.Printf("INFO: License token refreshed by heartbeat")
+				return uc.markHeartbeat(ctx, inn)
+			case client.HeartbeatRevoked:
+				if hb.Reason == "expired" {
+					log.Printf("WARN: License term has expired on server. Updating local status to expired.")
+					_ = uc.activationRepo.MarkLicenseExpired(ctx, inn)
+				} else {
+					log.Printf("WARN: License was revoked or inactive on server (%s). Updating local status to revoked.", hb.Reason)
+					_ = uc.activationRepo.MarkLicenseRevoked(ctx, inn)
+				}
+				return fmt.Errorf("license is no longer valid on server: %s", hb.Reason)
+			}
+			hbErr = fmt.Errorf("unexpected heartbeat status %q", hb.Status)
+		}
+		log.Printf("WARN: Heartbeat failed, attempting full activation: %v", hbErr)
+	}
+
+	// 7. Call server (reporting how many agents this instance serves)
+	resp, err := uc.licenseClient.Activate(ctx, inn, fp, usedSlots)
 	if err != nil {
-		errMsg := err.Error()
 		// If the server explicitly rejected the license because it's not active/revoked, update local status
-		if strings.Contains(errMsg, "no active license found") || strings.Contains(errMsg, "license is not active") {
-			log.Printf("WARN: License was revoked or inactive on server. Updating local status to revoked.")
+		if errors.Is(err, client.ErrLicenseInactive) || errors.Is(err, client.ErrLicenseNotFound) {
+			log.Printf("WARN: License was revoked, deleted or inactive on server. Updating local status to revoked.")
 			// We can mark it as revoked by saving a dummy token or updating the DB directly
 			_ = uc.activationRepo.MarkLicenseRevoked(ctx, inn)
+		} else if errors.Is(err, client.ErrLicenseExpired) {
+			log.Printf("WARN: License term has expired on server. Updating local status to expired.")
+			_ = uc.activationRepo.MarkLicenseExpired(ctx, inn)
 		}
 		return fmt.Errorf("failed to refresh license via server: %w", err)
 	}
 
-	// 7. Update license in DB
-	return uc.UpdateLicense(ctx, resp.Token, inn)
+	// 8. Update license in DB
+	if err := uc.UpdateLicense(ctx, resp.Token, inn); err != nil {
+		return err
+	}
+	return uc.markHeartbeat(ctx, inn)
+}
+
+// markHeartbeat отмечает успешную связь с сервером и заодно обновляет ключи подписи,
+// чтобы отозванный на сервере ключ перестал приниматься без ожидания токена с новым kid
+func (uc *DeviceUseCase) markHeartbeat(ctx context.Context, inn string) error {
+	if uc.tokenService != nil {
+		if err := uc.RefreshSigningKeys(ctx); err != nil {
+			log.Printf("WARN: Failed to refresh signing keys: %v", err)
+		}
+	}
+	return uc.activationRepo.MarkLicenseHeartbeat(ctx, inn)
 }
@@ -2,10 +2,31 @@ package usecases_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/deymonster/licd/internal/application/usecases"
+	"github.com/deymonster/licd/internal/infrastructure/client"
+	"github.com/deymonster/licd/internal/infrastructure/crypto"
+	"github.com/deymonster/licd/internal/storage/sqlite"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
 )
 
 func TestDeviceUseCase_RequestLicense(t *testing.T) {
@@ -41,3 +62,229 @@ func TestDeviceUseCase_GetSystemFingerprint(t *testing.T) {
 		t.Errorf("Fingerprint length expected 64, got %d", len(fp))
 	}
 }
+
+// newMigratedRepo открывает временную БД со всеми миграциями licd
+func newMigratedRepo(t *testing.T) (*sql.DB, *sqlite.ActivationRepository) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "licd.db"))
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	wd, _ := os.Getwd()
+	driver, _ := sqlite3.WithInstance(db, &sqlite3.Config{})
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.Join(wd, "../../../migrations"), "sqlite3", driver)
+	if err != nil {
+		t.Fatalf("Failed to create migrate instance: %v", err)
+	}
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+	return db, sqlite.NewActivationRepository(db)
+}
+
+// newFakeServer поднимает сервер лицензий, отвечающий на запросы handlers по пути
+func newFakeServer(t *testing.T, handlers map[string]http.HandlerFunc) *client.LicenseClient {
+	t.Helper()
+	mux := http.NewServeMux()
+	for path, h := range handlers {
+		mux.HandleFunc(path, h)
+	}
+	ts := httptest.NewTLSServer(mux)
+	t.Cleanup(ts.Close)
+
+	lc, err := client.NewLicenseClient(ts.URL, "", "", true)
+	if err != nil {
+		t.Fatalf("Failed to create license client: %v", err)
+	}
+	return lc
+}
+
+func TestDeviceUseCase_RefreshLicense_LogsHardwareTransfer(t *testing.T) {
+	ctx := context.Background()
+	db, repo := newMigratedRepo(t)
+	inn := "1234567890"
+
+	// Лицензия сохранена для другого оборудования
+	if err := repo.UpdateLicense(ctx, "token", "old-fingerprint", 10, "active", time.Now().Add(24*time.Hour), "Org", inn, time.Now(), inn); err != nil {
+		t.Fatalf("Failed to store license: %v", err)
+	}
+
+	lc := newFakeServer(t, map[string]http.HandlerFunc{
+		"/v1/transfer": func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(client.TransferResponse{ID: 7, Status: client.TransferRejected, Note: "not this one"})
+		},
+	})
+	uc := usecases.NewDeviceUseCase(repo, nil, lc, nil, 10, "test-job", "test-salt", "")
+
+	if err := uc.RefreshLicense(ctx); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Fatalf("Expected rejected transfer error, got %v", err)
+	}
+
+	var result, details string
+	err := db.QueryRowContext(ctx, `SELECT result, details FROM audit_log WHERE action = 'hardware_transfer'`).Scan(&result, &details)
+	if err != nil {
+		t.Fatalf("Hardware transfer was not logged: %v", err)
+	}
+	if result != "denied" {
+		t.Errorf("Expected result denied, got %q", result)
+	}
+	var d map[string]interface{}
+	if err := json.Unmarshal([]byte(details), &d); err != nil {
+		t.Fatalf("Failed to decode details: %v", err)
+	}
+	if d["status"] != client.TransferRejected || d["old_fingerprint"] != "old-fingerprint" {
+		t.Errorf("Unexpected details: %v", d)
+	}
+}
+
+func TestDeviceUseCase_RefreshLicense_LicenseNotFoundRevokes(t *testing.T) {
+	ctx := context.Background()
+	db, repo := newMigratedRepo(t)
+	inn := "1234567890"
+
+	lc := newFakeServer(t, map[string]http.HandlerFunc{
+		"/v1/activate": func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "license not found", "code": client.CodeLicenseNotFound})
+		},
+	})
+	uc := usecases.NewDeviceUseCase(repo, nil, lc, nil, 10, "test-job", "test-salt", "")
+
+	fp, err := uc.GetSystemFingerprint()
+	if err != nil {
+		t.Fatalf("Failed to generate fingerprint: %v", err)
+	}
+	if err := repo.UpdateLicense(ctx, "token", fp, 10, "active", time.Now().Add(24*time.Hour), "Org", inn, time.Now(), inn); err != nil {
+		t.Fatalf("Failed to store license: %v", err)
+	}
+
+	if err := uc.RefreshLicense(ctx); !errors.Is(err, client.ErrLicenseNotFound) {
+		t.Fatalf("Expected license not found error, got %v", err)
+	}
+
+	var status string
+	if err := db.QueryRowContext(ctx, `SELECT status FROM license_info WHERE inn = ?`, inn).Scan(&status); err != nil {
+		t.Fatalf("Failed to read license: %v", err)
+	}
+	if status != "revoked" {
+		t.Errorf("Expected license deleted on server to be revoked locally, got %q", status)
+	}
+}
+
+// newTestCA создаёт CA, подписывающий CSR в обработчике /v1/renew фейкового сервера
+func newTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(der)
+	return ca, key
+}
+
+// signCSR выпускает клиентский сертификат по PEM CSR
+func signCSR(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, csrPEM []byte, serial int64) []byte {
+	t.Helper()
+	block, _ := pem.Decode(csrPEM)
+	if block == nil {
+		t.Fatal("Failed to decode CSR")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse CSR: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      csr.Subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, csr.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to sign CSR: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestDeviceUseCase_RenewCertificate(t *testing.T) {
+	ctx := context.Background()
+	_, repo := newMigratedRepo(t)
+	ca, caKey := newTestCA(t)
+	dir := t.TempDir()
+	km := crypto.NewKeyManager(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), "")
+
+	// Текущая пара ключ/сертификат экземпляра
+	oldKey, oldCSR, err := km.GenerateKeyAndCSR("licd-client")
+	if err != nil {
+		t.Fatalf("Failed to generate key/CSR: %v", err)
+	}
+	oldCert := signCSR(t, ca, caKey, oldCSR, 2)
+	if err := km.SaveKeyAndCert(oldKey, oldCert); err != nil {
+		t.Fatalf("Failed to save the initial pair: %v", err)
+	}
+
+	var serial int64 = 2
+	issue := true
+	lc := newFakeServer(t, map[string]http.HandlerFunc{
+		"/v1/renew": func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				CSR string `json:"csr"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			serial++
+			cert := string(signCSR(t, ca, caKey, []byte(req.CSR), serial))
+			if !issue {
+				// Ответ с сертификатом, не подходящим к новому ключу
+				cert = string(oldCert)
+			}
+			json.NewEncoder(w).Encode(client.RegisterResponse{Certificate: cert})
+		},
+	})
+	uc := usecases.NewDeviceUseCase(repo, nil, lc, km, 10, "test-job", "test-salt", "")
+
+	t.Run("Failed save keeps the old pair", func(t *testing.T) {
+		issue = false
+		if err := uc.RenewCertificate(ctx); err == nil {
+			t.Fatal("Expected renewal with a mismatched certificate to fail")
+		}
+		key, _ := os.ReadFile(km.KeyPath)
+		cert, _ := os.ReadFile(km.CertPath)
+		if string(key) != string(oldKey) || string(cert) != string(oldCert) {
+			t.Error("Expected the old key and certificate to stay in place")
+		}
+		if leftovers, _ := filepath.Glob(filepath.Join(dir, "*.tmp-*")); len(leftovers) != 0 {
+			t.Errorf("Expected no temporary files, got %v", leftovers)
+		}
+	})
+
+	t.Run("Renewed pair replaces the old one", func(t *testing.T) {
+		issue = true
+		if err := uc.RenewCertificate(ctx); err != nil {
+			t.Fatalf("RenewCertificate failed: %v", err)
+		}
+		pair, err := tls.LoadX509KeyPair(km.CertPath, km.KeyPath)
+		if err != nil {
+			t.Fatalf("Saved key and certificate do not match: %v", err)
+		}
+		leaf, _ := x509.ParseCertificate(pair.Certificate[0])
+		if leaf.SerialNumber.Int64() != serial {
+			t.Errorf("Expected the renewed certificate %d, got %d", serial, leaf.SerialNumber.Int64())
+		}
+	})
+}
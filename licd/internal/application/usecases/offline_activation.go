@@ -0,0 +1,112 @@
+package usecases
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/deymonster/licd/internal/domain/entities"
+	"github.com/deymonster/licd/internal/version"
+)
+
+// CreateOfflineActivationRequest готовит подписанный файл запроса активации для площадок
+// без доступа к lic-server. Администратор загружает его в lic-server и привозит обратно
+// файл ответа, который импортируется через ImportOfflineActivation.
+func (uc *DeviceUseCase) CreateOfflineActivationRequest(ctx context.Context, inn string) (*entities.OfflineActivationRequest, error) {
+	if inn == "" {
+		return nil, fmt.Errorf("inn is required")
+	}
+	if uc.keyManager == nil {
+		return nil, fmt.Errorf("key manager not configured")
+	}
+
+	fp, err := uc.GetSystemFingerprint()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate fingerprint: %w", err)
+	}
+	usedSlots, err := uc.GetDeviceStats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	payload, err := json.Marshal(entities.OfflineActivationPayload{
+		INN:         inn,
+		Fingerprint: fp,
+		Version:     version.Version,
+		UsedSlots:   usedSlots,
+		Nonce:       hex.EncodeToString(nonce),
+		CreatedAt:   time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Запрос подписывается ключом экземпляра, чтобы его нельзя было изменить по дороге
+	key, err := uc.keyManager.InstanceKey()
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(payload)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign activation request: %w", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal public key: %w", err)
+	}
+
+	_ = uc.activationRepo.LogLicenseAction(ctx, "offline_request", "success", map[string]interface{}{
+		"inn":         inn,
+		"fingerprint": fp,
+	})
+	log.Printf("INFO: Created offline activation request for INN %s", inn)
+
+	return &entities.OfflineActivationRequest{
+		Format:    entities.OfflineRequestFormat,
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		PublicKey: string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})),
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	}, nil
+}
+
+// ImportOfflineActivation сохраняет лицензию из файла ответа lic-server.
+// Подпись токена и привязка к оборудованию проверяются в UpdateLicense.
+func (uc *DeviceUseCase) ImportOfflineActivation(ctx context.Context, resp *entities.OfflineActivationResponse) error {
+	if resp.Format != entities.OfflineResponseFormat {
+		return fmt.Errorf("%w: unsupported response format %q", ErrInvalidToken, resp.Format)
+	}
+	if resp.Token == "" {
+		return fmt.Errorf("%w: response contains no token", ErrInvalidToken)
+	}
+
+	if err := uc.UpdateLicense(ctx, resp.Token, resp.INN); err != nil {
+		_ = uc.activationRepo.LogLicenseAction(ctx, "offline_import", "failed", map[string]interface{}{
+			"inn":   resp.INN,
+			"nonce": resp.Nonce,
+			"error": err.Error(),
+		})
+		return err
+	}
+
+	_ = uc.activationRepo.LogLicenseAction(ctx, "offline_import", "success", map[string]interface{}{
+		"inn":       resp.INN,
+		"nonce":     resp.Nonce,
+		"issued_at": resp.IssuedAt,
+	})
+	log.Printf("INFO: Imported offline license for INN %s", resp.INN)
+	return nil
+}
@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"path/filepath"
 	"strconv"
 	"time"
 )
@@ -24,6 +25,10 @@ type Config struct {
 	SkipTLSVerify    bool   `json:"skip_tls_verify"`
 
 	HeartbeatInterval time.Duration `json:"heartbeat_interval"`
+
+	// Ключи подписи лицензий, опубликованные сервером (JWKS)
+	KeySetPath            string        `json:"key_set_path"`
+	KeySetRefreshInterval time.Duration `json:"key_set_refresh_interval"`
 }
 
 // Load загружает конфигурацию из переменных окружения
@@ -112,5 +117,19 @@ func Load() (*Config, error) {
 		cfg.HeartbeatInterval = 24 * time.Hour
 	}
 
+	if v := os.Getenv("KEY_SET_PATH"); v != "" {
+		cfg.KeySetPath = v
+	} else {
+		cfg.KeySetPath = filepath.Join(filepath.Dir(cfg.StoragePath), "jwks.json")
+	}
+	if v := os.Getenv("KEY_SET_REFRESH_INTERVAL"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.KeySetRefreshInterval = d
+		}
+	}
+	if cfg.KeySetRefreshInterval == 0 {
+		cfg.KeySetRefreshInterval = 6 * time.Hour
+	}
+
 	return cfg, nil
 }
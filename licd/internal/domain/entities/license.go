@@ -44,6 +44,8 @@ type LicenseStatus struct {
 	OrgName        string     `json:"org_name"`
 	INN            string     `json:"inn"`
 	ActivationDate *time.Time `json:"activation_date,omitempty"`
+	// Entitlements из проверенного токена; пустые, если токена нет или он недействителен
+	Entitlements Entitlements `json:"entitlements"`
 }
 
 // IsValid проверяет валидность лицензии
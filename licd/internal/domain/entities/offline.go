@@ -0,0 +1,37 @@
+package entities
+
+import "time"
+
+// Форматы файлов офлайн-активации. licd пишет запрос, lic-server отвечает файлом ответа.
+const (
+	OfflineRequestFormat  = "hw-offline-activation-request/v1"
+	OfflineResponseFormat = "hw-offline-activation-response/v1"
+)
+
+// OfflineActivationRequest — файл запроса активации для площадок без доступа к lic-server.
+// Payload подписывается ровно в том виде, в котором передаётся.
+type OfflineActivationRequest struct {
+	Format    string `json:"format"`
+	Payload   string `json:"payload"`    // base64 от JSON OfflineActivationPayload
+	PublicKey string `json:"public_key"` // PEM, ECDSA P-256 ключ экземпляра licd
+	Signature string `json:"signature"`  // base64 ASN.1 подпись ECDSA над SHA-256 байтов payload
+}
+
+// OfflineActivationPayload описывает экземпляр licd, запрашивающий лицензию
+type OfflineActivationPayload struct {
+	INN         string    `json:"inn"`
+	Fingerprint string    `json:"fingerprint"`
+	Version     string    `json:"version"`
+	UsedSlots   int       `json:"used_slots"`
+	Nonce       string    `json:"nonce"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OfflineActivationResponse — файл ответа lic-server; Token — обычный подписанный токен лицензии
+type OfflineActivationResponse struct {
+	Format   string    `json:"format"`
+	INN      string    `json:"inn"`
+	Nonce    string    `json:"nonce"`
+	Token    string    `json:"token"`
+	IssuedAt time.Time `json:"issued_at"`
+}
@@ -11,19 +11,39 @@ type LicenseClaims struct {
 	jwt.RegisteredClaims
 
 	// Custom claims matching the API contract
-	LicenseID       string `json:"lid"`
-	INN             string `json:"inn"`
-	OrgName         string `json:"org"` // Organization Name
-	MaxAgents       int    `json:"max"`
-	FingerprintHash string `json:"fph"`
-	ActivationDate  string `json:"act"` // ISO8601
-	KeyVersion      int    `json:"ver"`
-	Status          string `json:"sts"` // active, trial, expired, revoked
+	LicenseID       string       `json:"lid"`
+	INN             string       `json:"inn"`
+	OrgName         string       `json:"org"` // Organization Name
+	MaxAgents       int          `json:"max"`
+	FingerprintHash string       `json:"fph"`
+	ActivationDate  string       `json:"act"` // ISO8601
+	KeyVersion      int          `json:"ver"`
+	Status          string       `json:"sts"`           // active, trial, grace, expired, revoked
+	Entitlements    Entitlements `json:"ent,omitempty"` // licensed modules: flags and limits
 }
 
-// IsActive checks if the license status is active
+// Entitlements are the separately licensed modules of a license: feature flags (bool)
+// and limits (integers), e.g. {"network_scanner": true, "retention_days": 365}
+type Entitlements map[string]interface{}
+
+// Enabled reports whether the feature flag key is granted
+func (e Entitlements) Enabled(key string) bool {
+	v, _ := e[key].(bool)
+	return v
+}
+
+// Limit returns the limit key, and false if the license sets none
+func (e Entitlements) Limit(key string) (int64, bool) {
+	v, ok := e[key].(float64)
+	if !ok {
+		return 0, false
+	}
+	return int64(v), true
+}
+
+// IsActive checks if the license is usable: active, trial or in its grace period
 func (c *LicenseClaims) IsActive() bool {
-	return c.Status == "active"
+	return c.Status == "active" || c.Status == "trial" || c.Status == "grace"
 }
 
 // GetExpiresAt returns the expiration time
@@ -33,3 +53,20 @@ func (c *LicenseClaims) GetExpiresAt() time.Time {
 	}
 	return c.ExpiresAt.Time
 }
+
+// JWK is a public token signing key published by the license server (GET /v1/jwks)
+type JWK struct {
+	Kty    string `json:"kty"`
+	Crv    string `json:"crv"`
+	X      string `json:"x"` // base64url-encoded Ed25519 public key
+	Kid    string `json:"kid"`
+	Alg    string `json:"alg"`
+	Use    string `json:"use"`
+	Ver    int    `json:"ver"`
+	Status string `json:"status"` // active or verify-only
+}
+
+// JWKS is the set of keys the license server currently signs or has signed tokens with
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
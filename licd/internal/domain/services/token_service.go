@@ -2,68 +2,144 @@ package services
 
 import (
 	"crypto/ed25519"
+	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
 
 	"github.com/deymonster/licd/internal/domain/entities"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// ErrUnknownSigningKey is returned when a token names a signing key (kid) that is not known yet.
+// The caller should refresh the key set from the license server and retry.
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
 // TokenService handles license token operations
 type TokenService struct {
-	publicKey ed25519.PublicKey
+	mu         sync.RWMutex
+	publicKey  ed25519.PublicKey // used for tokens that carry neither a known kid nor a known ver; nil if none
+	byKid      map[string]ed25519.PublicKey
+	byVersion  map[int]ed25519.PublicKey
+	keySetPath string // where the last key set from the server is kept across restarts
 }
 
 // NewTokenService creates a new token service with the given public key
 func NewTokenService(pemPublicKey string) (*TokenService, error) {
-	if pemPublicKey == "" {
-		return nil, errors.New("public key is empty")
+	edPub, err := parsePublicKeyPEM(pemPublicKey)
+	if err != nil {
+		return nil, err
 	}
 
-	block, _ := pem.Decode([]byte(pemPublicKey))
-	if block == nil || block.Type != "PUBLIC KEY" {
-		return nil, errors.New("failed to decode PEM block containing public key")
+	s := &TokenService{
+		byKid:     make(map[string]ed25519.PublicKey),
+		byVersion: make(map[int]ed25519.PublicKey),
 	}
+	s.setPublicKey(edPub)
+	return s, nil
+}
 
-	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+// UpdatePublicKey updates the public key used for token verification
+func (s *TokenService) UpdatePublicKey(pemPublicKey string) error {
+	edPub, err := parsePublicKeyPEM(pemPublicKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse public key: %w", err)
-	}
-
-	edPub, ok := pub.(ed25519.PublicKey)
-	if !ok {
-		return nil, errors.New("public key is not of type Ed25519")
+		return err
 	}
 
-	return &TokenService{
-		publicKey: edPub,
-	}, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setPublicKey(edPub)
+	return nil
 }
 
-// UpdatePublicKey updates the public key used for token verification
-func (s *TokenService) UpdatePublicKey(pemPublicKey string) error {
-	if pemPublicKey == "" {
-		return errors.New("public key is empty")
+// UseKeySetFile keeps the key sets loaded from the server at path and loads the one
+// saved there before, if any. Until a key set is loaded, only the configured key is trusted.
+func (s *TokenService) UseKeySetFile(path string) error {
+	s.mu.Lock()
+	s.keySetPath = path
+	s.mu.Unlock()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read key set: %w", err)
 	}
+	var set entities.JWKS
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("failed to parse key set %s: %w", path, err)
+	}
+	return s.applyKeySet(&set)
+}
 
-	block, _ := pem.Decode([]byte(pemPublicKey))
-	if block == nil || block.Type != "PUBLIC KEY" {
-		return errors.New("failed to decode PEM block containing public key")
+// UpdateKeySet replaces the known signing keys with the set published by the license server
+// and saves it for the next start. Keys the set no longer lists, the configured one included,
+// stop verifying: this is how a retired (e.g. leaked) key is revoked.
+func (s *TokenService) UpdateKeySet(set *entities.JWKS) error {
+	if err := s.applyKeySet(set); err != nil {
+		return err
 	}
 
-	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	s.mu.RLock()
+	path := s.keySetPath
+	s.mu.RUnlock()
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(set)
 	if err != nil {
-		return fmt.Errorf("failed to parse public key: %w", err)
+		return fmt.Errorf("failed to encode key set: %w", err)
 	}
+	return writeFileAtomic(path, data)
+}
 
-	edPub, ok := pub.(ed25519.PublicKey)
-	if !ok {
-		return errors.New("public key is not of type Ed25519")
+// applyKeySet makes set the known signing keys. The default key for tokens without a kid
+// is kept only while the set lists it, otherwise the active key of the set takes its place.
+func (s *TokenService) applyKeySet(set *entities.JWKS) error {
+	byKid := make(map[string]ed25519.PublicKey)
+	byVersion := make(map[int]ed25519.PublicKey)
+	var active ed25519.PublicKey
+
+	for _, k := range set.Keys {
+		if k.Kty != "OKP" || k.Crv != "Ed25519" {
+			continue
+		}
+		raw, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(raw) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid key %s in key set", k.Kid)
+		}
+		pub := ed25519.PublicKey(raw)
+
+		kid := k.Kid
+		if kid == "" {
+			kid = thumbprint(pub)
+		}
+		byKid[kid] = pub
+		if k.Ver > 0 {
+			byVersion[k.Ver] = pub
+		}
+		if k.Status == "active" {
+			active = pub
+		}
+	}
+	if len(byKid) == 0 {
+		return errors.New("key set contains no Ed25519 keys")
 	}
 
-	s.publicKey = edPub
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byKid = byKid
+	s.byVersion = byVersion
+	if s.publicKey == nil || byKid[thumbprint(s.publicKey)] == nil {
+		s.publicKey = active
+	}
 	return nil
 }
 
@@ -77,7 +153,12 @@ func (s *TokenService) VerifyToken(tokenString string) (*entities.LicenseClaims,
 		if _, ok := token.Method.(*jwt.SigningMethodEd25519); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return s.publicKey, nil
+		kid, _ := token.Header["kid"].(string)
+		ver := 0
+		if claims, ok := token.Claims.(*entities.LicenseClaims); ok {
+			ver = claims.KeyVersion
+		}
+		return s.verificationKey(kid, ver)
 	})
 
 	if err != nil {
@@ -86,7 +167,7 @@ func (s *TokenService) VerifyToken(tokenString string) (*entities.LicenseClaims,
 
 	if claims, ok := token.Claims.(*entities.LicenseClaims); ok && token.Valid {
 		// Additional checks
-		if claims.Status != "active" {
+		if !claims.IsActive() {
 			return nil, fmt.Errorf("license is not active: %s", claims.Status)
 		}
 		return claims, nil
@@ -94,3 +175,79 @@ func (s *TokenService) VerifyToken(tokenString string) (*entities.LicenseClaims,
 
 	return nil, errors.New("invalid token claims")
 }
+
+// verificationKey picks the key by kid first, then by key version.
+// Tokens issued before key rotation carry no kid and fall back to the default key.
+func (s *TokenService) verificationKey(kid string, ver int) (ed25519.PublicKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if kid != "" {
+		if pub, ok := s.byKid[kid]; ok {
+			return pub, nil
+		}
+		return nil, fmt.Errorf("%w: kid=%s", ErrUnknownSigningKey, kid)
+	}
+	if pub, ok := s.byVersion[ver]; ok {
+		return pub, nil
+	}
+	if s.publicKey == nil {
+		return nil, fmt.Errorf("%w: token names no key", ErrUnknownSigningKey)
+	}
+	return s.publicKey, nil
+}
+
+// setPublicKey sets the default key and registers it under its kid; the caller holds mu
+func (s *TokenService) setPublicKey(pub ed25519.PublicKey) {
+	s.publicKey = pub
+	s.byKid[thumbprint(pub)] = pub
+}
+
+// writeFileAtomic replaces path with data, so a crash never leaves a truncated file behind
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func parsePublicKeyPEM(pemPublicKey string) (ed25519.PublicKey, error) {
+	if pemPublicKey == "" {
+		return nil, errors.New("public key is empty")
+	}
+
+	block, _ := pem.Decode([]byte(pemPublicKey))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("failed to decode PEM block containing public key")
+	}
+
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	edPub, ok := pub.(ed25519.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not of type Ed25519")
+	}
+	return edPub, nil
+}
+
+// thumbprint computes the RFC 7638 JWK thumbprint, which lic-server uses as kid
+func thumbprint(pub ed25519.PublicKey) string {
+	x := base64.RawURLEncoding.EncodeToString(pub)
+	sum := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + x + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
@@ -0,0 +1,86 @@
+package services_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deymonster/licd/internal/domain/entities"
+	"github.com/deymonster/licd/internal/domain/services"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func kidOf(pub ed25519.PublicKey) string {
+	x := base64.RawURLEncoding.EncodeToString(pub)
+	sum := sha256.Sum256([]byte(`{"crv":"Ed25519","kty":"OKP","x":"` + x + `"}`))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func signToken(t *testing.T, priv ed25519.PrivateKey, kid string) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, &entities.LicenseClaims{
+		RegisteredClaims: jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))},
+		INN:              "1234567890",
+		Status:           "active",
+	})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	s, err := token.SignedString(priv)
+	if err != nil {
+		t.Fatalf("Failed to sign token: %v", err)
+	}
+	return s
+}
+
+func TestTokenService_RetiredKeyStopsVerifying(t *testing.T) {
+	oldPub, oldPriv, _ := ed25519.GenerateKey(rand.Reader)
+	newPub, newPriv, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(oldPub)
+	embedded := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	keySetPath := filepath.Join(t.TempDir(), "jwks.json")
+
+	ts, err := services.NewTokenService(embedded)
+	if err != nil {
+		t.Fatalf("Failed to create token service: %v", err)
+	}
+	if err := ts.UseKeySetFile(keySetPath); err != nil {
+		t.Fatalf("UseKeySetFile failed: %v", err)
+	}
+	if _, err := ts.VerifyToken(signToken(t, oldPriv, kidOf(oldPub))); err != nil {
+		t.Fatalf("Token of the embedded key must verify before a key set is loaded: %v", err)
+	}
+
+	// The server retired the embedded key: the set lists only the new one
+	set := &entities.JWKS{Keys: []entities.JWK{{
+		Kty: "OKP", Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(newPub),
+		Kid: kidOf(newPub), Ver: 2, Status: "active",
+	}}}
+	if err := ts.UpdateKeySet(set); err != nil {
+		t.Fatalf("UpdateKeySet failed: %v", err)
+	}
+
+	// After a restart the saved set still overrides the embedded key
+	restarted, _ := services.NewTokenService(embedded)
+	if err := restarted.UseKeySetFile(keySetPath); err != nil {
+		t.Fatalf("UseKeySetFile failed: %v", err)
+	}
+
+	for name, svc := range map[string]*services.TokenService{"running": ts, "restarted": restarted} {
+		if _, err := svc.VerifyToken(signToken(t, oldPriv, kidOf(oldPub))); err == nil {
+			t.Errorf("%s: token of the retired key must not verify by kid", name)
+		}
+		if _, err := svc.VerifyToken(signToken(t, oldPriv, "")); err == nil {
+			t.Errorf("%s: token of the retired key must not verify as a token without kid", name)
+		}
+		if _, err := svc.VerifyToken(signToken(t, newPriv, kidOf(newPub))); err != nil {
+			t.Errorf("%s: token of the active key must verify: %v", name, err)
+		}
+	}
+}
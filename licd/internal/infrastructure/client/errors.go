@@ -0,0 +1,113 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Error codes sent by the license server in the "code" field of error responses.
+// Branch on them with errors.Is and the Err* values below, never on messages:
+// the server may reword a message at any time, but not its code.
+const (
+	CodeInvalidRequest         = "invalid_request"
+	CodeUnauthorized           = "unauthorized"
+	CodeForbidden              = "forbidden"
+	CodeNotFound               = "not_found"
+	CodeConflict               = "conflict"
+	CodeRateLimited            = "rate_limited"
+	CodeInternal               = "internal_error"
+	CodeLicenseNotFound        = "license_not_found"
+	CodeLicenseInactive        = "license_inactive" // revoked or suspended by an admin
+	CodeLicenseExpired         = "license_expired"  // past its term and grace period
+	CodeSlotLimitExceeded      = "slot_limit_exceeded"
+	CodeEnrollmentTokenInvalid = "enrollment_token_invalid"
+	CodeCertificateRequired    = "certificate_required"
+	CodeCertificateNotBound    = "certificate_not_bound"
+	CodeCertificateINNMismatch = "certificate_inn_mismatch"
+	CodeCertificateInactive    = "certificate_inactive" // revoked, suspended or superseded
+	CodeHardwareMismatch       = "hardware_mismatch"
+
+	// CodeServerUnavailable is set by the client itself when the server cannot be reached
+	// or a proxy in front of it answers 502, 503 or 504
+	CodeServerUnavailable = "server_unavailable"
+)
+
+// APIError is an error answered by the license server
+type APIError struct {
+	Status  int    // HTTP status, 0 when the server was not reached
+	Code    string // one of the Code* constants, empty for servers that predate codes
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("license server returned status %d", e.Status)
+	}
+	return e.Message
+}
+
+// Is reports errors of the same code as equal, so errors.Is(err, ErrLicenseExpired)
+// holds for every expired license whatever the server's message says
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// Sentinels to test client errors against with errors.Is
+var (
+	ErrInvalidRequest         = &APIError{Code: CodeInvalidRequest, Message: "invalid request"}
+	ErrConflict               = &APIError{Code: CodeConflict, Message: "conflict"}
+	ErrRateLimited            = &APIError{Code: CodeRateLimited, Message: "too many requests"}
+	ErrLicenseNotFound        = &APIError{Code: CodeLicenseNotFound, Message: "license not found for this INN"}
+	ErrLicenseInactive        = &APIError{Code: CodeLicenseInactive, Message: "license is not active"}
+	ErrLicenseExpired         = &APIError{Code: CodeLicenseExpired, Message: "license expired"}
+	ErrSlotLimitExceeded      = &APIError{Code: CodeSlotLimitExceeded, Message: "slot limit exceeded"}
+	ErrEnrollmentTokenInvalid = &APIError{Code: CodeEnrollmentTokenInvalid, Message: "invalid enrollment token"}
+	ErrCertificateNotBound    = &APIError{Code: CodeCertificateNotBound, Message: "client certificate not bound to any license"}
+	ErrCertificateInactive    = &APIError{Code: CodeCertificateInactive, Message: "client certificate binding is not active"}
+	ErrHardwareMismatch       = &APIError{Code: CodeHardwareMismatch, Message: "hardware fingerprint mismatch"}
+	ErrServerUnavailable      = &APIError{Code: CodeServerUnavailable, Message: "license server unavailable"}
+)
+
+// unavailable wraps a transport error, so that it matches ErrServerUnavailable
+func unavailable(err error) error {
+	return fmt.Errorf("%w: %v", ErrServerUnavailable, err)
+}
+
+// decodeError reads the {"error", "code"} body of a failed response. Answers of a proxy
+// in front of the server carry no code and are reported as ErrServerUnavailable.
+func decodeError(resp *http.Response) *APIError {
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	apiErr := &APIError{Status: resp.StatusCode, Message: string(bodyBytes)}
+
+	var errResp struct {
+		Error string `json:"error"`
+		Code  string `json:"code"`
+	}
+	if json.Unmarshal(bodyBytes, &errResp) == nil && errResp.Error != "" {
+		apiErr.Message = errResp.Error
+		apiErr.Code = errResp.Code
+	}
+
+	if apiErr.Code == "" {
+		switch resp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			apiErr.Code = CodeServerUnavailable
+			apiErr.Message = fmt.Sprintf("license server unavailable (%d)", resp.StatusCode)
+		case http.StatusTooManyRequests:
+			apiErr.Code = CodeRateLimited
+		}
+	}
+	return apiErr
+}
+
+// legacyNotFound gives a 404 of a server that predates error codes the meaning it always had
+// on register and activate: no license for the INN
+func legacyNotFound(apiErr *APIError) *APIError {
+	if apiErr.Code == "" && apiErr.Status == http.StatusNotFound {
+		apiErr.Code = CodeLicenseNotFound
+	}
+	return apiErr
+}
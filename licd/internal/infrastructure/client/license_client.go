@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/deymonster/licd/internal/domain/entities"
 	"github.com/deymonster/licd/internal/embedded"
 )
 
@@ -32,8 +33,32 @@ type ActivateRequest struct {
 	INN         string `json:"inn"`
 	Fingerprint string `json:"fingerprint"`
 	Version     string `json:"version"`
+	UsedSlots   int    `json:"used_slots"` // agents currently registered on this instance
 }
 
+// HeartbeatRequest reports usage and identifies the token the instance currently holds
+type HeartbeatRequest struct {
+	Fingerprint string `json:"fingerprint"`
+	UsedSlots   int    `json:"used_slots"`
+	JTI         string `json:"jti"`     // jti of the current license token
+	Version     int    `json:"version"` // ver claim of the current license token
+}
+
+// HeartbeatResponse tells whether the current token is still up to date
+type HeartbeatResponse struct {
+	Status        string `json:"status"` // unchanged, updated or revoked
+	LicenseStatus string `json:"license_status"`
+	Token         string `json:"token"`  // new token when status is updated
+	Reason        string `json:"reason"` // revoked, suspended, expired or certificate_revoked
+}
+
+// Heartbeat outcomes reported by the license server
+const (
+	HeartbeatUnchanged = "unchanged"
+	HeartbeatUpdated   = "updated"
+	HeartbeatRevoked   = "revoked"
+)
+
 // RegisterRequest represents the request body for registration
 type RegisterRequest struct {
 	INN string `json:"inn"`
@@ -137,30 +162,14 @@ func (c *LicenseClient) Register(ctx context.Context, inn, token string, csrPEM
 	if err != nil {
 		log.Printf("ERROR: Failed to send request: %v", err)
 		// Check for common network errors to provide user-friendly message
-		return nil, fmt.Errorf("license server unavailable: %w", err)
+		return nil, unavailable(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		errorMsg := string(bodyBytes)
-
-		// Try to parse JSON error
-		var errResp map[string]string
-		if json.Unmarshal(bodyBytes, &errResp) == nil && errResp["error"] != "" {
-			errorMsg = errResp["error"]
-		}
-
-		log.Printf("ERROR: Server returned %d: %s", resp.StatusCode, errorMsg)
-
-		if resp.StatusCode == http.StatusNotFound {
-			return nil, fmt.Errorf("license not found for this INN")
-		}
-		if resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable {
-			return nil, fmt.Errorf("license server unavailable (502/503)")
-		}
-
-		return nil, fmt.Errorf("%s", errorMsg)
+		apiErr := decodeError(resp)
+		log.Printf("ERROR: Server returned %d (%s): %s", resp.StatusCode, apiErr.Code, apiErr.Message)
+		return nil, legacyNotFound(apiErr)
 	}
 
 	bodyBytes, _ := io.ReadAll(resp.Body)
@@ -175,36 +184,112 @@ func (c *LicenseClient) Register(ctx context.Context, inn, token string, csrPEM
 	return &result, nil
 }
 
-// Heartbeat checks if the license and certificate are still valid
-func (c *LicenseClient) Heartbeat(ctx context.Context) error {
-	url := fmt.Sprintf("%s/v1/heartbeat", c.baseURL)
+// Renew exchanges a new CSR for a fresh client certificate.
+// The request is authenticated with the current client certificate (mTLS).
+func (c *LicenseClient) Renew(ctx context.Context, csrPEM []byte) (*RegisterResponse, error) {
+	body, err := json.Marshal(map[string]string{"csr": string(csrPEM)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/renew", c.baseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		apiErr := decodeError(resp)
+		log.Printf("ERROR: Certificate renewal failed. Server returned %d (%s): %s", resp.StatusCode, apiErr.Code, apiErr.Message)
+		return nil, apiErr
+	}
+
+	var result RegisterResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if result.Certificate == "" {
+		return nil, fmt.Errorf("server returned empty certificate")
+	}
+
+	return &result, nil
+}
+
+// GetJWKS fetches the public keys the license server signs tokens with
+func (c *LicenseClient) GetJWKS(ctx context.Context) (*entities.JWKS, error) {
+	url := fmt.Sprintf("%s/v1/jwks", c.baseURL)
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return fmt.Errorf("heartbeat failed: %w", err)
+		return nil, unavailable(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("heartbeat failed with status %d: %s", resp.StatusCode, string(bodyBytes))
+		return nil, fmt.Errorf("failed to fetch key set, status %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
-	return nil
+	var result entities.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode key set: %w", err)
+	}
+	return &result, nil
 }
 
-// Activate sends an activation request to the license server
-func (c *LicenseClient) Activate(ctx context.Context, inn, fingerprint string) (*LicenseResponse, error) {
-	log.Printf("DEBUG: Activate called. INN: %s, Fingerprint: %s", inn, fingerprint)
+// Heartbeat checks the license and certificate and compares the held token with the server state.
+// The server answers unchanged, a new token, or a revocation notice.
+func (c *LicenseClient) Heartbeat(ctx context.Context, hb HeartbeatRequest) (*HeartbeatResponse, error) {
+	body, err := json.Marshal(hb)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/heartbeat", c.baseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("heartbeat failed: %w", unavailable(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("heartbeat failed with status %d: %w", resp.StatusCode, decodeError(resp))
+	}
+
+	var result HeartbeatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return &result, nil
+}
+
+// Activate sends an activation request to the license server.
+// usedSlots is reported so the server can account seats across all instances of the license.
+func (c *LicenseClient) Activate(ctx context.Context, inn, fingerprint string, usedSlots int) (*LicenseResponse, error) {
+	log.Printf("DEBUG: Activate called. INN: %s, Fingerprint: %s, UsedSlots: %d", inn, fingerprint, usedSlots)
 
 	reqBody := ActivateRequest{
 		INN:         inn,
 		Fingerprint: fingerprint,
 		Version:     "1.0.0", // TODO: Inject version
+		UsedSlots:   usedSlots,
 	}
 
 	body, err := json.Marshal(reqBody)
@@ -223,36 +308,74 @@ func (c *LicenseClient) Activate(ctx context.Context, inn, fingerprint string) (
 	resp, err := c.client.Do(req)
 	if err != nil {
 		log.Printf("ERROR: Failed to send activation request: %v", err)
-		return nil, fmt.Errorf("license server unavailable: %w", err)
+		return nil, unavailable(err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		errorMsg := string(bodyBytes)
+		apiErr := decodeError(resp)
+		log.Printf("ERROR: Activation failed. Server returned %d (%s): %s", resp.StatusCode, apiErr.Code, apiErr.Message)
+		return nil, legacyNotFound(apiErr)
+	}
 
-		// Try to parse JSON error
-		var errResp map[string]string
-		if json.Unmarshal(bodyBytes, &errResp) == nil && errResp["error"] != "" {
-			errorMsg = errResp["error"]
-		}
+	var result LicenseResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
 
-		log.Printf("ERROR: Activation failed. Server returned %d: %s", resp.StatusCode, errorMsg)
+	return &result, nil
+}
 
-		if resp.StatusCode == http.StatusNotFound {
-			return nil, fmt.Errorf("license not found for this INN")
-		}
-		if resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable {
-			return nil, fmt.Errorf("license server unavailable (502/503)")
-		}
+// TransferRequest asks the server to move this instance to new hardware
+type TransferRequest struct {
+	OldFingerprint string `json:"old_fingerprint"`
+	NewFingerprint string `json:"new_fingerprint"`
+	Reason         string `json:"reason,omitempty"`
+}
 
-		return nil, fmt.Errorf("%s", errorMsg)
+// Hardware transfer decisions reported by the license server
+const (
+	TransferPending  = "pending"
+	TransferApproved = "approved"
+	TransferRejected = "rejected"
+)
+
+// TransferResponse is the decision on a hardware transfer
+type TransferResponse struct {
+	ID           int64  `json:"id"`
+	Status       string `json:"status"` // pending, approved or rejected
+	AutoApproved bool   `json:"auto_approved"`
+	Note         string `json:"note"`
+}
+
+// RequestTransfer asks to move the instance from oldFingerprint to newFingerprint.
+// Sending the same request again returns the current decision.
+func (c *LicenseClient) RequestTransfer(ctx context.Context, tr TransferRequest) (*TransferResponse, error) {
+	body, err := json.Marshal(tr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	var result LicenseResponse
+	url := fmt.Sprintf("%s/v1/transfer", c.baseURL)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, unavailable(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("transfer request failed with status %d: %w", resp.StatusCode, decodeError(resp))
+	}
+
+	var result TransferResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
-
 	return &result, nil
 }
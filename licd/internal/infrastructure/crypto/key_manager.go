@@ -4,11 +4,14 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
 	"os"
+	"path/filepath"
+	"time"
 )
 
 // KeyManager handles cryptographic operations
@@ -68,16 +71,102 @@ func (km *KeyManager) GenerateKeyAndCSR(commonName string) ([]byte, []byte, erro
 	return keyPEM, csrPEM, nil
 }
 
+// InstanceKey returns the private key of this licd instance, generating and saving one
+// if none exists yet. It is the mTLS client key once the instance is registered online.
+func (km *KeyManager) InstanceKey() (*ecdsa.PrivateKey, error) {
+	keyPEM, err := os.ReadFile(km.KeyPath)
+	if os.IsNotExist(err) {
+		privateKey, genErr := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if genErr != nil {
+			return nil, fmt.Errorf("failed to generate private key: %w", genErr)
+		}
+		der, genErr := x509.MarshalECPrivateKey(privateKey)
+		if genErr != nil {
+			return nil, fmt.Errorf("failed to marshal private key: %w", genErr)
+		}
+		if genErr = km.SaveKey(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})); genErr != nil {
+			return nil, fmt.Errorf("failed to save key: %w", genErr)
+		}
+		return privateKey, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode key PEM")
+	}
+	privateKey, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return privateKey, nil
+}
+
 // SaveKey saves the private key to disk
 func (km *KeyManager) SaveKey(keyPEM []byte) error {
 	return os.WriteFile(km.KeyPath, keyPEM, 0600)
 }
 
-// SaveCert saves the certificate to disk
+// SaveCert saves the certificate to disk. The PEM holds the chain issued by the server,
+// the client certificate first, and is presented whole in the TLS handshake.
 func (km *KeyManager) SaveCert(certPEM []byte) error {
 	return os.WriteFile(km.CertPath, certPEM, 0644)
 }
 
+// SaveKeyAndCert replaces the private key and the certificate together. Both are written to
+// temporary files first and renamed over the old ones only after both writes succeeded and the
+// pair matches, so a failed write never leaves a key that does not belong to the certificate.
+func (km *KeyManager) SaveKeyAndCert(keyPEM, certPEM []byte) error {
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("certificate does not match key: %w", err)
+	}
+
+	keyTmp, err := writeTemp(km.KeyPath, keyPEM, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to save key: %w", err)
+	}
+	defer os.Remove(keyTmp)
+	certTmp, err := writeTemp(km.CertPath, certPEM, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to save cert: %w", err)
+	}
+	defer os.Remove(certTmp)
+
+	if err := os.Rename(keyTmp, km.KeyPath); err != nil {
+		return fmt.Errorf("failed to replace key: %w", err)
+	}
+	if err := os.Rename(certTmp, km.CertPath); err != nil {
+		return fmt.Errorf("failed to replace cert: %w", err)
+	}
+	return nil
+}
+
+// writeTemp writes content to a new temporary file next to path and returns its name
+func writeTemp(path string, content []byte, perm os.FileMode) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(name, perm)
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", err
+	}
+	return name, nil
+}
+
 // SaveCA saves the CA certificate to disk (optional)
 func (km *KeyManager) SaveCA(caPath string, caPEM []byte) error {
 	return os.WriteFile(caPath, caPEM, 0644)
@@ -93,3 +182,20 @@ func (km *KeyManager) HasCert() bool {
 	}
 	return true
 }
+
+// CertExpiry returns the NotAfter time of the saved client certificate
+func (km *KeyManager) CertExpiry() (time.Time, error) {
+	certPEM, err := os.ReadFile(km.CertPath)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read certificate: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return time.Time{}, fmt.Errorf("failed to decode certificate PEM")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return cert.NotAfter, nil
+}
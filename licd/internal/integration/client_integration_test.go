@@ -12,6 +12,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
+	"fmt"
 	"math/big"
 	"net"
 	"net/http"
@@ -40,6 +42,13 @@ type mockServer struct {
 	serverCert tls.Certificate
 	tokenKey   ed25519.PrivateKey
 	revoked    bool
+	refusal    string // code the server refuses activations with, e.g. license_inactive
+
+	heartbeat   string // heartbeat answer; empty behaves like a server without token-aware heartbeats
+	orgName     string
+	activations int
+	transfer    string // decision on hardware transfers
+	transfers   int
 }
 
 func newMockServer() *mockServer {
@@ -80,9 +89,29 @@ func newMockServer() *mockServer {
 		caKey:      caKey,
 		serverCert: serverTLSCert,
 		tokenKey:   tokenKey,
+		orgName:    "Test Org",
 	}
 }
 
+// token signs a license token like lic-server does
+func (s *mockServer) token(inn, fingerprint string) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+		"jti": fmt.Sprintf("%d", time.Now().UnixNano()),
+		"inn": inn,
+		"fph": fingerprint,
+		"sts": "active",
+		"max": 10,
+		"lid": "test-license-id",
+		"org": s.orgName,
+		"act": time.Now().Format(time.RFC3339),
+		"ver": 1,
+		"exp": time.Now().Add(1 * time.Hour).Unix(),
+		"ent": map[string]interface{}{"network_scanner": true, "retention_days": 365},
+	})
+	tokenString, _ := token.SignedString(s.tokenKey)
+	return tokenString
+}
+
 func (s *mockServer) handler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path == "/v1/register" {
 		// Register Logic
@@ -128,7 +157,12 @@ func (s *mockServer) handler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if s.revoked {
-			http.Error(w, `{"error": "client certificate bound to different INN"}`, http.StatusForbidden)
+			http.Error(w, `{"error": "client certificate bound to different INN", "code": "certificate_inn_mismatch"}`, http.StatusForbidden)
+			return
+		}
+		if s.refusal != "" {
+			// Messages are worded unlike the real server on purpose: licd must only rely on the code
+			http.Error(w, fmt.Sprintf(`{"error": "refused by the vendor (%s)", "code": %q}`, s.refusal, s.refusal), http.StatusForbidden)
 			return
 		}
 
@@ -138,22 +172,43 @@ func (s *mockServer) handler(w http.ResponseWriter, r *http.Request) {
 			Fingerprint string `json:"fingerprint"`
 		}
 		json.NewDecoder(r.Body).Decode(&req)
-
-		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
-			"inn": req.INN,
-			"fph": req.Fingerprint,
-			"sts": "active",
-			"max": 10,
-			"lid": "test-license-id",
-			"org": "Test Org",
-			"act": time.Now().Format(time.RFC3339),
-			"ver": 1,
-			"exp": time.Now().Add(1 * time.Hour).Unix(),
-		})
-		tokenString, _ := token.SignedString(s.tokenKey)
+		s.activations++
 
 		resp := map[string]string{
-			"token": tokenString,
+			"token": s.token(req.INN, req.Fingerprint),
+		}
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	if r.URL.Path == "/v1/transfer" {
+		var req struct {
+			OldFingerprint string `json:"old_fingerprint"`
+			NewFingerprint string `json:"new_fingerprint"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.OldFingerprint == "" || req.NewFingerprint == "" {
+			http.Error(w, `{"error": "invalid transfer request"}`, http.StatusBadRequest)
+			return
+		}
+		s.transfers++
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 1, "status": s.transfer})
+		return
+	}
+
+	if r.URL.Path == "/v1/heartbeat" && s.heartbeat != "" {
+		var req struct {
+			Fingerprint string `json:"fingerprint"`
+			JTI         string `json:"jti"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		resp := map[string]string{"status": s.heartbeat}
+		switch s.heartbeat {
+		case "updated":
+			resp["token"] = s.token("1234567890", req.Fingerprint)
+		case "revoked":
+			resp["reason"] = "suspended"
 		}
 		json.NewEncoder(w).Encode(resp)
 		return
@@ -325,9 +380,61 @@ func TestLicdClientIntegration(t *testing.T) {
 		if status.Status != "active" {
 			t.Errorf("Expected status active, got %s", status.Status)
 		}
+		if !status.Entitlements.Enabled("network_scanner") || status.Entitlements.Enabled("inventory_reports") {
+			t.Errorf("Unexpected flags: %v", status.Entitlements)
+		}
+		if days, ok := status.Entitlements.Limit("retention_days"); !ok || days != 365 {
+			t.Errorf("Expected retention_days 365, got %d, %v", days, ok)
+		}
 	})
 
-	// Test 4: Revoked Binding
+	// Test 4: Heartbeat replaces full activation while the token is valid
+	t.Run("Heartbeat Refresh", func(t *testing.T) {
+		client4, _ := client.NewLicenseClient(ts.URL, certPath, keyPath, true)
+		pubKeyBytes, _ := os.ReadFile(licenseKeyPath)
+		tokenSvc, _ := services.NewTokenService(string(pubKeyBytes))
+		uc4 := usecases.NewDeviceUseCase(repo, tokenSvc, client4, km, 10, "test-job", "salt", "test-token")
+
+		ms.heartbeat = "unchanged"
+		activations := ms.activations
+		if err := uc4.RefreshLicense(ctx); err != nil {
+			t.Fatalf("RefreshLicense failed: %v", err)
+		}
+		if ms.activations != activations {
+			t.Error("Expected no activation when the token is unchanged")
+		}
+		status, _ := uc4.GetLicenseStatus(ctx)
+		if status.LastHeartbeat == nil {
+			t.Error("Expected the heartbeat time to be recorded")
+		}
+
+		ms.heartbeat = "updated"
+		ms.orgName = "Renamed Org"
+		if err := uc4.RefreshLicense(ctx); err != nil {
+			t.Fatalf("RefreshLicense failed: %v", err)
+		}
+		status, _ = uc4.GetLicenseStatus(ctx)
+		if ms.activations != activations || status.OrgName != "Renamed Org" {
+			t.Errorf("Expected the heartbeat token to be stored without activation, got org %q", status.OrgName)
+		}
+
+		ms.heartbeat = "revoked"
+		if err := uc4.RefreshLicense(ctx); err == nil {
+			t.Fatal("Expected RefreshLicense to fail after a revocation notice")
+		}
+		status, _ = uc4.GetLicenseStatus(ctx)
+		if status.Status == "active" {
+			t.Error("Expected the license to be marked revoked")
+		}
+
+		// Back to an active license for the next test
+		ms.heartbeat = ""
+		if err := uc4.RequestLicense(ctx, inn); err != nil {
+			t.Fatalf("RequestLicense failed: %v", err)
+		}
+	})
+
+	// Test 5: Revoked Binding
 	t.Run("Revoked Binding", func(t *testing.T) {
 		ms.revoked = true
 
@@ -344,4 +451,81 @@ func TestLicdClientIntegration(t *testing.T) {
 			t.Logf("Got expected error: %v", err)
 		}
 	})
+
+	// Test 6: Refusals are recognised by their code, whatever the message says
+	t.Run("Refusal Codes", func(t *testing.T) {
+		ms.revoked = false
+		client5, _ := client.NewLicenseClient(ts.URL, certPath, keyPath, true)
+		pubKeyBytes, _ := os.ReadFile(licenseKeyPath)
+		tokenSvc, _ := services.NewTokenService(string(pubKeyBytes))
+		uc5 := usecases.NewDeviceUseCase(repo, tokenSvc, client5, km, 10, "test-job", "salt", "test-token")
+
+		for _, tc := range []struct {
+			code   string
+			target error
+			status string
+		}{
+			{client.CodeLicenseInactive, client.ErrLicenseInactive, "inactive"},
+			{client.CodeLicenseExpired, client.ErrLicenseExpired, "expired"},
+		} {
+			ms.refusal = ""
+			if err := uc5.RequestLicense(ctx, inn); err != nil {
+				t.Fatalf("RequestLicense failed: %v", err)
+			}
+
+			ms.refusal = tc.code
+			err := uc5.RefreshLicense(ctx)
+			if !errors.Is(err, tc.target) {
+				t.Fatalf("Expected %s, got %v", tc.code, err)
+			}
+			status, _ := uc5.GetLicenseStatus(ctx)
+			if status.Status != tc.status {
+				t.Errorf("Expected status %s after %s, got %s", tc.status, tc.code, status.Status)
+			}
+		}
+		ms.refusal = ""
+	})
+
+	// Test 7: The same instance on new hardware asks for a transfer before activating
+	t.Run("Hardware Transfer", func(t *testing.T) {
+		ms.revoked = false
+		if err := uc.RequestLicense(ctx, inn); err != nil {
+			t.Fatalf("RequestLicense failed: %v", err)
+		}
+
+		client6, _ := client.NewLicenseClient(ts.URL, certPath, keyPath, true)
+		pubKeyBytes, _ := os.ReadFile(licenseKeyPath)
+		tokenSvc, _ := services.NewTokenService(string(pubKeyBytes))
+		// Another salt gives another fingerprint, as if the machine had changed
+		uc6 := usecases.NewDeviceUseCase(repo, tokenSvc, client6, km, 10, "test-job", "new-hardware", "test-token")
+
+		ms.transfer = "pending"
+		activations := ms.activations
+		if err := uc6.RefreshLicense(ctx); err == nil {
+			t.Fatal("Expected RefreshLicense to fail while the transfer is pending")
+		}
+		if ms.transfers != 1 || ms.activations != activations {
+			t.Errorf("Expected a transfer request and no activation, got %d transfers, %d activations", ms.transfers, ms.activations-activations)
+		}
+
+		ms.transfer = "approved"
+		if err := uc6.RefreshLicense(ctx); err != nil {
+			t.Fatalf("RefreshLicense failed after approval: %v", err)
+		}
+		if ms.activations != activations+1 {
+			t.Error("Expected an activation on the new hardware")
+		}
+		status, _ := uc6.GetLicenseStatus(ctx)
+		if status.Status != "active" {
+			t.Errorf("Expected status active, got %s", status.Status)
+		}
+
+		// The license now belongs to this machine
+		if err := uc6.RefreshLicense(ctx); err != nil {
+			t.Fatalf("RefreshLicense failed: %v", err)
+		}
+		if ms.transfers != 2 {
+			t.Errorf("Expected no further transfer requests, got %d", ms.transfers)
+		}
+	})
 }
@@ -0,0 +1,141 @@
+package integration_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/deymonster/licd/internal/application/usecases"
+	"github.com/deymonster/licd/internal/domain/entities"
+	"github.com/deymonster/licd/internal/domain/services"
+	"github.com/deymonster/licd/internal/infrastructure/crypto"
+	"github.com/deymonster/licd/internal/storage/sqlite"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite3"
+)
+
+func TestOfflineActivationFlow(t *testing.T) {
+	tempDir := t.TempDir()
+	db, err := sql.Open("sqlite3", filepath.Join(tempDir, "licd.db"))
+	if err != nil {
+		t.Fatalf("Failed to open DB: %v", err)
+	}
+	defer db.Close()
+
+	wd, _ := os.Getwd()
+	driver, _ := sqlite3.WithInstance(db, &sqlite3.Config{})
+	m, err := migrate.NewWithDatabaseInstance("file://"+filepath.Join(wd, "../../migrations"), "sqlite3", driver)
+	if err != nil {
+		t.Fatalf("Failed to create migrate instance: %v", err)
+	}
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		t.Fatalf("Failed to migrate: %v", err)
+	}
+
+	// The license server signing key licd already trusts (embedded or configured)
+	_, tokenKey, _ := ed25519.GenerateKey(rand.Reader)
+	pubDER, _ := x509.MarshalPKIXPublicKey(tokenKey.Public())
+	tokenSvc, err := services.NewTokenService(string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})))
+	if err != nil {
+		t.Fatalf("Failed to create token service: %v", err)
+	}
+
+	repo := sqlite.NewActivationRepository(db)
+	km := crypto.NewKeyManager(filepath.Join(tempDir, "client.crt"), filepath.Join(tempDir, "client.key"), "")
+	uc := usecases.NewDeviceUseCase(repo, tokenSvc, nil, km, 10, "test-job", "salt", "")
+	ctx := context.Background()
+	inn := "1234567890"
+
+	var payload entities.OfflineActivationPayload
+
+	t.Run("Request is signed by the instance key", func(t *testing.T) {
+		req, err := uc.CreateOfflineActivationRequest(ctx, inn)
+		if err != nil {
+			t.Fatalf("CreateOfflineActivationRequest failed: %v", err)
+		}
+		if req.Format != entities.OfflineRequestFormat {
+			t.Errorf("Unexpected format %q", req.Format)
+		}
+
+		payloadBytes, _ := base64.StdEncoding.DecodeString(req.Payload)
+		sig, _ := base64.StdEncoding.DecodeString(req.Signature)
+		block, _ := pem.Decode([]byte(req.PublicKey))
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatalf("Invalid public key: %v", err)
+		}
+		digest := sha256.Sum256(payloadBytes)
+		if !ecdsa.VerifyASN1(pub.(*ecdsa.PublicKey), digest[:], sig) {
+			t.Fatal("Request signature does not verify")
+		}
+
+		_ = json.Unmarshal(payloadBytes, &payload)
+		fp, _ := uc.GetSystemFingerprint()
+		if payload.INN != inn || payload.Fingerprint != fp || payload.Nonce == "" {
+			t.Errorf("Unexpected payload: %+v", payload)
+		}
+	})
+
+	// respond signs a license token for the request, as lic-server does
+	respond := func(fingerprint string) *entities.OfflineActivationResponse {
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{
+			"inn": inn,
+			"fph": fingerprint,
+			"sts": "active",
+			"max": 25,
+			"org": "Offline Org",
+			"act": time.Now().Format(time.RFC3339),
+			"ver": 1,
+			"exp": time.Now().Add(24 * time.Hour).Unix(),
+		})
+		tokenString, _ := token.SignedString(tokenKey)
+		return &entities.OfflineActivationResponse{
+			Format:   entities.OfflineResponseFormat,
+			INN:      inn,
+			Nonce:    payload.Nonce,
+			Token:    tokenString,
+			IssuedAt: time.Now(),
+		}
+	}
+
+	t.Run("Response for other hardware is refused", func(t *testing.T) {
+		if err := uc.ImportOfflineActivation(ctx, respond("other-host")); err == nil {
+			t.Fatal("Expected fingerprint mismatch")
+		}
+	})
+
+	t.Run("Response is imported", func(t *testing.T) {
+		if err := uc.ImportOfflineActivation(ctx, respond(payload.Fingerprint)); err != nil {
+			t.Fatalf("ImportOfflineActivation failed: %v", err)
+		}
+		status, err := uc.GetLicenseStatus(ctx)
+		if err != nil {
+			t.Fatalf("GetLicenseStatus failed: %v", err)
+		}
+		if status.Status != "active" || status.MaxSlots != 25 || status.INN != inn {
+			t.Errorf("Unexpected license status: %+v", status)
+		}
+	})
+
+	t.Run("Both steps are audited", func(t *testing.T) {
+		var requests, imports, failed int
+		_ = db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE action = 'offline_request'`).Scan(&requests)
+		_ = db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE action = 'offline_import' AND result = 'success'`).Scan(&imports)
+		_ = db.QueryRow(`SELECT COUNT(*) FROM audit_log WHERE action = 'offline_import' AND result = 'failed'`).Scan(&failed)
+		if requests != 1 || imports != 1 || failed != 1 {
+			t.Errorf("Expected 1 request, 1 import and 1 failed import, got %d/%d/%d", requests, imports, failed)
+		}
+	})
+}
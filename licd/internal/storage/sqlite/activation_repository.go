@@ -172,6 +172,35 @@ func (r *ActivationRepository) MarkLicenseRevoked(ctx context.Context, inn strin
 	return nil
 }
 
+// MarkLicenseHeartbeat запоминает время последней успешной сверки лицензии с сервером
+func (r *ActivationRepository) MarkLicenseHeartbeat(ctx context.Context, inn string) error {
+	query := `
+		UPDATE license_info 
+		SET last_heartbeat_at = ?
+		WHERE inn = ? AND status = 'active'
+	`
+	_, err := r.db.ExecContext(ctx, query, time.Now().UTC(), inn)
+	if err != nil {
+		return fmt.Errorf("failed to update heartbeat time: %w", err)
+	}
+	return nil
+}
+
+// MarkLicenseExpired помечает срок активной лицензии как истёкший.
+// Сама запись остаётся active, чтобы RefreshLicense подхватил продление на сервере.
+func (r *ActivationRepository) MarkLicenseExpired(ctx context.Context, inn string) error {
+	query := `
+		UPDATE license_info 
+		SET term_status = 'expired'
+		WHERE inn = ? AND status = 'active'
+	`
+	_, err := r.db.ExecContext(ctx, query, inn)
+	if err != nil {
+		return fmt.Errorf("failed to mark license expired: %w", err)
+	}
+	return nil
+}
+
 // GetActivations возвращает все активации для Prometheus SD
 func (r *ActivationRepository) GetActivations(ctx context.Context) ([]Activation, error) {
 	rows, err := r.db.QueryContext(ctx, `
@@ -221,7 +250,7 @@ func (r *ActivationRepository) GetLicenseStatus(ctx context.Context) (*LicenseSt
 
 	// Получаем информацию о лицензии
 	err = r.db.QueryRowContext(ctx, `
-	    SELECT COALESCE(max_agents, 0), status, expires_at, last_heartbeat_at, org_name, inn, activation_date
+	    SELECT COALESCE(max_agents, 0), COALESCE(term_status, status), expires_at, last_heartbeat_at, org_name, inn, activation_date
 	    FROM license_info 
 	    WHERE status = 'active'
 	    ORDER BY created_at DESC 
@@ -262,8 +291,15 @@ func (r *ActivationRepository) GetLicenseStatus(ctx context.Context) (*LicenseSt
 	}, nil
 }
 
-// UpdateLicense обновляет лицензию в БД
+// UpdateLicense обновляет лицензию в БД.
+// status — статус из токена (active, trial, grace); сохраняется в term_status,
+// а сама запись становится active.
 func (r *ActivationRepository) UpdateLicense(ctx context.Context, token string, installID string, maxAgents int, status string, expiresAt time.Time, orgName, inn string, activationDate time.Time, licenseKey string) error {
+	termStatus := status
+	if status == "trial" || status == "grace" {
+		status = "active"
+	}
+
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to begin transaction: %w", err)
@@ -279,19 +315,20 @@ func (r *ActivationRepository) UpdateLicense(ctx context.Context, token string,
 	// Вставляем новую или обновляем существующую
 	now := time.Now().UTC()
 	_, err = tx.ExecContext(ctx, `
-		INSERT INTO license_info (token, install_id, max_agents, status, expires_at, created_at, updated_at, org_name, inn, activation_date, license_key)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO license_info (token, install_id, max_agents, status, term_status, expires_at, created_at, updated_at, org_name, inn, activation_date, license_key)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(install_id) DO UPDATE SET
 			token=excluded.token,
 			max_agents=excluded.max_agents,
 			status=excluded.status,
+			term_status=excluded.term_status,
 			expires_at=excluded.expires_at,
 			updated_at=excluded.updated_at,
 			org_name=excluded.org_name,
 			inn=excluded.inn,
 			activation_date=excluded.activation_date,
 			license_key=excluded.license_key
-	`, token, installID, maxAgents, status, expiresAt, now, now, orgName, inn, activationDate, licenseKey)
+	`, token, installID, maxAgents, status, termStatus, expiresAt, now, now, orgName, inn, activationDate, licenseKey)
 	if err != nil {
 		return fmt.Errorf("failed to upsert license: %w", err)
 	}
@@ -331,6 +368,36 @@ func (r *ActivationRepository) GetActiveToken(ctx context.Context) (string, erro
 	return token, nil
 }
 
+// GetActiveInstallID возвращает отпечаток оборудования, для которого сохранена активная лицензия
+func (r *ActivationRepository) GetActiveInstallID(ctx context.Context) (string, error) {
+	var installID string
+	err := r.db.QueryRowContext(ctx, `
+		SELECT install_id
+		FROM license_info
+		WHERE status = 'active'
+		ORDER BY created_at DESC
+		LIMIT 1
+	`).Scan(&installID)
+	if err != nil {
+		return "", err
+	}
+	return installID, nil
+}
+
+// LogLicenseAction записывает в аудит лог действие с лицензией (например, офлайн-активацию)
+func (r *ActivationRepository) LogLicenseAction(ctx context.Context, action, result string, details map[string]interface{}) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := r.logAction(ctx, tx, action, "", "", result, details); err != nil {
+		return fmt.Errorf("failed to log %s: %w", action, err)
+	}
+	return tx.Commit()
+}
+
 // logAction записывает действие в аудит лог
 func (r *ActivationRepository) logAction(ctx context.Context, tx *sql.Tx, action, agentKey, ip, result string, details map[string]interface{}) error {
 	detailsJSON, _ := json.Marshal(details)
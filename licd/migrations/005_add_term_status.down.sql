@@ -0,0 +1 @@
+ALTER TABLE license_info DROP COLUMN term_status;
@@ -0,0 +1 @@
+ALTER TABLE license_info ADD COLUMN term_status TEXT;
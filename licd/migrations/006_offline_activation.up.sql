@@ -0,0 +1,21 @@
+-- SQLite не умеет менять CHECK, поэтому audit_log пересоздаётся с действиями офлайн-активации
+CREATE TABLE audit_log_new (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action TEXT NOT NULL CHECK (action IN ('activate', 'deactivate', 'heartbeat', 'validate', 'license_check', 'offline_request', 'offline_import')),
+    agent_key TEXT,
+    ip TEXT,
+    user_agent TEXT,
+    result TEXT NOT NULL CHECK (result IN ('success', 'failed', 'denied')),
+    details JSON,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO audit_log_new (id, action, agent_key, ip, user_agent, result, details, created_at)
+SELECT id, action, agent_key, ip, user_agent, result, details, created_at FROM audit_log;
+
+DROP TABLE audit_log;
+ALTER TABLE audit_log_new RENAME TO audit_log;
+
+CREATE INDEX idx_audit_log_created_at ON audit_log(created_at);
+CREATE INDEX idx_audit_log_action ON audit_log(action);
+CREATE INDEX idx_audit_log_agent_key ON audit_log(agent_key);